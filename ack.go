@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+const (
+	snoozeDuration = 24 * time.Hour
+	// maxReportMessages bounds how many posted report messages we remember
+	// the problems of.
+	maxReportMessages = 100
+)
+
+// acknowledgement records that a user has seen a problem.  Acknowledged
+// problems are left out of the main report until they resolve; snoozed
+// problems are left out until the snooze expires.
+type acknowledgement struct {
+	User string    `json:"user"`
+	Time time.Time `json:"time"`
+	// Until is only set for snoozes.
+	Until time.Time `json:"until,omitempty"`
+}
+
+var (
+	ackMutex = &sync.Mutex{}
+	acks     = make(map[string]acknowledgement)
+	// reportMessages maps the ts of posted report messages to the keys of the
+	// problems they reported, so reactions to them can acknowledge those
+	// problems.
+	reportMessages     = make(map[string][]string)
+	reportMessageOrder = []string{}
+)
+
+func problemKey(stream string, kind releasewatch.ProblemKind) string {
+	return stream + "/" + string(kind)
+}
+
+func (o *options) acknowledge(key, user string, snooze time.Duration) {
+	ack := acknowledgement{User: user, Time: time.Now()}
+	if snooze > 0 {
+		ack.Until = ack.Time.Add(snooze)
+	}
+	ackMutex.Lock()
+	defer ackMutex.Unlock()
+	acks[key] = ack
+	o.saveAcks()
+}
+
+// applyAcks moves acknowledged and silenced problems out of each stream's
+// problems and into its acknowledged problems.  Acknowledgements for problems
+// that are no longer reported, and expired snoozes, are forgotten.
+func (o *options) applyAcks(report *releasewatch.Report) {
+	ackMutex.Lock()
+	defer ackMutex.Unlock()
+	stateMutex.Lock()
+	silences := append(append([]Silence{}, state.Silences...), configSilences...)
+	stateMutex.Unlock()
+
+	now := time.Now()
+	seen := map[string]struct{}{}
+	for i := range report.Streams {
+		stream := &report.Streams[i]
+		active := []releasewatch.Problem{}
+		for _, p := range stream.Problems {
+			key := problemKey(stream.Name, p.Kind)
+			seen[key] = struct{}{}
+			if silence, ok := findSilence(silences, stream.Name, p.Kind, now); ok {
+				stream.Acknowledged = append(stream.Acknowledged, releasewatch.AcknowledgedProblem{Problem: p, User: silence.CreatedBy, Until: silence.Until, Reason: silence.Reason})
+				continue
+			}
+			ack, ok := acks[key]
+			if !ok || (!ack.Until.IsZero() && now.After(ack.Until)) {
+				active = append(active, p)
+				continue
+			}
+			stream.Acknowledged = append(stream.Acknowledged, releasewatch.AcknowledgedProblem{Problem: p, User: ack.User, Until: ack.Until})
+		}
+		stream.Problems = active
+	}
+
+	forgotten := false
+	for key, ack := range acks {
+		_, reported := seen[key]
+		expired := !ack.Until.IsZero() && now.After(ack.Until)
+		if expired || (!reported && ack.Until.IsZero()) {
+			delete(acks, key)
+			forgotten = true
+		}
+	}
+	if forgotten {
+		o.saveAcks()
+	}
+}
+
+// saveAcks persists the acknowledgements in the state file.  Callers must
+// hold the ack mutex.
+func (o *options) saveAcks() {
+	saved := make(map[string]acknowledgement, len(acks))
+	for key, ack := range acks {
+		saved[key] = ack
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state.Acks = saved
+	o.saveState()
+}
+
+// Silence suppresses the problems of a stream, or only one kind of problem,
+// until it expires.
+type Silence struct {
+	Stream    string                   `json:"stream"`
+	Kind      releasewatch.ProblemKind `json:"kind,omitempty"`
+	Until     time.Time                `json:"until"`
+	Reason    string                   `json:"reason"`
+	CreatedBy string                   `json:"createdBy,omitempty"`
+}
+
+func validateSilences(silences []Silence) error {
+	for i, silence := range silences {
+		if silence.Stream == "" || silence.Until.IsZero() {
+			return fmt.Errorf("silence %d must have a stream and an until time", i)
+		}
+	}
+	return nil
+}
+
+func findSilence(silences []Silence, stream string, kind releasewatch.ProblemKind, now time.Time) (Silence, bool) {
+	for _, silence := range silences {
+		if silence.Stream == stream && (silence.Kind == "" || silence.Kind == kind) && now.Before(silence.Until) {
+			return silence, true
+		}
+	}
+	return Silence{}, false
+}
+
+func rememberReportMessage(ts string, report *releasewatch.Report) {
+	keys := []string{}
+	for _, stream := range report.Streams {
+		for _, p := range stream.Problems {
+			keys = append(keys, problemKey(stream.Name, p.Kind))
+		}
+	}
+
+	ackMutex.Lock()
+	defer ackMutex.Unlock()
+	if _, ok := reportMessages[ts]; !ok {
+		reportMessageOrder = append(reportMessageOrder, ts)
+	}
+	reportMessages[ts] = keys
+	for len(reportMessageOrder) > maxReportMessages {
+		delete(reportMessages, reportMessageOrder[0])
+		reportMessageOrder = reportMessageOrder[1:]
+	}
+}
+
+// handleReaction acknowledges the problems of a report message when a user
+// reacts to it with one of the acknowledgement reactions.
+func (o *options) handleReaction(event Event) {
+	if event.User == botUserID || event.Item.Type != "message" || !o.isAckReaction(event.Reaction) {
+		return
+	}
+	ackMutex.Lock()
+	keys, ok := reportMessages[event.Item.TS]
+	ackMutex.Unlock()
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		o.acknowledge(key, event.User, 0)
+	}
+	klog.V(2).Infof("user %s acknowledged %v with a :%s: reaction", event.User, keys, event.Reaction)
+}
+
+func (o *options) isAckReaction(reaction string) bool {
+	for _, r := range o.ackReactions {
+		if strings.Trim(r, ":") == reaction {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// AdminConfig is the body of the admin config endpoint.  Settings are keyed by
+// their flag names, e.g. {"settings": {"accepted-staleness-limit": "36h"}}.
+// On PUT only the given settings are changed, and the silences and
+// maintenance windows are replaced if they are given.
+type AdminConfig struct {
+	Settings           map[string]string   `json:"settings,omitempty"`
+	Silences           []Silence           `json:"silences,omitempty"`
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+}
+
+type AdminError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// createAdminConfigHandler serves GET and PUT /admin/config.  Requests must
+// carry the admin token as a bearer token; the endpoint is disabled when no
+// token is configured.
+func (o *options) createAdminConfigHandler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, AdminError{Error: "unauthorized"})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			update := AdminConfig{}
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				writeJSON(w, http.StatusBadRequest, AdminError{Error: fmt.Sprintf("error decoding config: %v", err)})
+				return
+			}
+			if err := validateSilences(update.Silences); err != nil {
+				writeJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+				return
+			}
+			if err := validateMaintenanceWindows(update.MaintenanceWindows); err != nil {
+				writeJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+				return
+			}
+			if len(update.Settings) > 0 {
+				if errors := o.updateSettings(update.Settings); len(errors) > 0 {
+					writeJSON(w, http.StatusBadRequest, AdminError{Error: "invalid settings", Fields: errors})
+					return
+				}
+			}
+			if update.Silences != nil {
+				stateMutex.Lock()
+				state.Silences = update.Silences
+				o.saveState()
+				stateMutex.Unlock()
+			}
+			if update.MaintenanceWindows != nil {
+				stateMutex.Lock()
+				state.MaintenanceWindows = update.MaintenanceWindows
+				o.saveState()
+				stateMutex.Unlock()
+				notifyMaintenanceChanged()
+			}
+			klog.V(2).Infof("configuration changed through the admin api: %#v", update)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeJSON(w, http.StatusMethodNotAllowed, AdminError{Error: "method not allowed"})
+			return
+		}
+
+		stateMutex.Lock()
+		silences := append([]Silence{}, state.Silences...)
+		windows := append([]MaintenanceWindow{}, state.MaintenanceWindows...)
+		stateMutex.Unlock()
+		writeJSON(w, http.StatusOK, AdminConfig{Settings: o.currentSettings(), Silences: silences, MaintenanceWindows: windows})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
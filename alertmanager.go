@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+var (
+	alertmanagerClient = &http.Client{Timeout: 30 * time.Second}
+	// alertmanagerAlerts are the alerts we fired, by problem key, so they
+	// can be resolved once their problems go away.  Only used by the report
+	// loop.
+	alertmanagerAlerts = map[string]alertmanagerAlert{}
+)
+
+// alertmanagerAlert is an alert of the Alertmanager v2 api.
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt,omitempty"`
+	EndsAt       time.Time         `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// sendAlertmanagerAlerts fires an alert at the --alertmanager-url for each
+// unacknowledged problem, labelled with its stream, minor, arch, severity and
+// kind, and resolves the alerts of the problems that went away.  The alerts
+// expire on their own after two report intervals, in case the bot stops
+// reporting them.
+func (o *options) sendAlertmanagerAlerts(report *releasewatch.Report) {
+	if o.alertmanagerURL == "" {
+		return
+	}
+	now := time.Now()
+	alerts := []alertmanagerAlert{}
+	firing := map[string]alertmanagerAlert{}
+	for _, stream := range report.Streams {
+		for _, p := range stream.Problems {
+			alert := alertmanagerAlert{
+				Labels: map[string]string{
+					"alertname": "ReleaseStream" + string(p.Kind),
+					"stream":    stream.Name,
+					"minor":     fmt.Sprintf("4.%d", stream.Minor),
+					"arch":      stream.Arch,
+					"severity":  string(p.Severity),
+					"kind":      string(p.Kind),
+				},
+				Annotations: map[string]string{
+					"summary":     fmt.Sprintf("%s: %s", stream.Name, p.Kind.Description()),
+					"description": p.Message,
+				},
+				StartsAt:     now,
+				EndsAt:       now.Add(2 * o.reportInterval),
+				GeneratorURL: stream.URL(),
+			}
+			key := problemKey(stream.Name, p.Kind)
+			if previous, ok := alertmanagerAlerts[key]; ok {
+				alert.StartsAt = previous.StartsAt
+			}
+			firing[key] = alert
+			alerts = append(alerts, alert)
+		}
+	}
+	for key, alert := range alertmanagerAlerts {
+		if _, ok := firing[key]; !ok {
+			alert.EndsAt = now
+			alerts = append(alerts, alert)
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	if err := o.postAlerts(alerts); err != nil {
+		klog.Errorf("error sending alerts to Alertmanager: %v", err)
+		return
+	}
+	alertmanagerAlerts = firing
+}
+
+func (o *options) postAlerts(alerts []alertmanagerAlert) error {
+	if slackDryRun {
+		klog.Infof("dry run, not sending %d alerts to Alertmanager", len(alerts))
+		return nil
+	}
+	body, _ := json.Marshal(alerts)
+	resp, err := alertmanagerClient.Post(strings.TrimSuffix(o.alertmanagerURL, "/")+"/api/v2/alerts", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"sigs.k8s.io/yaml"
+)
+
+// The formats "generate alert-rules" renders the rules in.
+const (
+	alertRulesPrometheusRule = "PrometheusRule"
+	alertRulesFile           = "rules"
+)
+
+// alertRuleGroup is a group of a Prometheus rules file or of the spec of a
+// PrometheusRule.
+type alertRuleGroup struct {
+	Name  string      `json:"name"`
+	Rules []alertRule `json:"rules"`
+}
+
+// alertRule is a Prometheus alerting rule.
+type alertRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// alertRules returns the alerting rules on the metrics of the report that
+// match the configured staleness limits: a stream's newest accepted or built
+// payload being older than its limit, a stream having critical problems and
+// the reports stopping for longer than --missing-report-limit.  Like the
+// report, each stream gets the limits of the first of the stream-limits of
+// the config file that match it.
+func (o *options) alertRules() alertRuleGroup {
+	group := alertRuleGroup{Name: o.alertRulesName}
+	defaults := releasewatch.Limits{AcceptedStaleness: o.acceptedStalenessLimit, BuiltStaleness: o.builtStalenessLimit}
+	// the regular expressions of the earlier stream limits, which take
+	// precedence
+	earlier := []string{}
+	for _, limits := range append(currentStreamLimits(), releasewatch.StreamLimits{}) {
+		matchers := []string{}
+		if limits.Match != nil {
+			// the report matches anywhere in the name, Prometheus matches
+			// the whole of it
+			matchers = append(matchers, "stream=~"+strconv.Quote(".*(?:"+limits.Match.String()+").*"))
+		}
+		for _, match := range earlier {
+			matchers = append(matchers, "stream!~"+strconv.Quote(match))
+		}
+		accepted, built := defaults.AcceptedStaleness, defaults.BuiltStaleness
+		if limits.AcceptedStaleness > 0 {
+			accepted = limits.AcceptedStaleness
+		}
+		if limits.BuiltStaleness > 0 {
+			built = limits.BuiltStaleness
+		}
+		group.Rules = append(group.Rules,
+			stalenessRule("ReleaseStreamStaleAcceptedPayload", acceptedAgeMetric, matchers, accepted, releasewatch.SeverityWarning,
+				"The newest accepted payload of {{ $labels.stream }} was built {{ $value | humanizeDuration }} ago"),
+			stalenessRule("ReleaseStreamStaleBuiltPayload", builtAgeMetric, matchers, built, releasewatch.SeverityInfo,
+				"The newest payload of {{ $labels.stream }} was built {{ $value | humanizeDuration }} ago"),
+		)
+		if limits.Match != nil {
+			earlier = append(earlier, ".*(?:"+limits.Match.String()+").*")
+		}
+	}
+	group.Rules = append(group.Rules,
+		alertRule{
+			Alert:  "ReleaseStreamCriticalProblems",
+			Expr:   fmt.Sprintf(`%s{severity="%s"} > 0`, problemsMetric, releasewatch.SeverityCritical),
+			Labels: map[string]string{"severity": string(releasewatch.SeverityCritical)},
+			Annotations: map[string]string{
+				"summary": "{{ $labels.stream }} has {{ $value }} critical problems",
+			},
+		},
+		alertRule{
+			Alert:  "ReleaseWatcherReportMissing",
+			Expr:   fmt.Sprintf("time() - %s > %g", lastReportMetric, o.missingReportLimit.Seconds()),
+			Labels: map[string]string{"severity": string(releasewatch.SeverityWarning)},
+			Annotations: map[string]string{
+				"summary": "No release report has been generated for {{ $value | humanizeDuration }}",
+			},
+		},
+	)
+	return group
+}
+
+// stalenessRule returns a rule that fires when the age metric of the streams
+// the matchers select exceeds the limit.
+func stalenessRule(name, metric string, matchers []string, limit time.Duration, severity releasewatch.Severity, summary string) alertRule {
+	return alertRule{
+		Alert:  name,
+		Expr:   fmt.Sprintf("%s{%s} > %g", metric, strings.Join(matchers, ","), limit.Seconds()),
+		Labels: map[string]string{"severity": string(severity)},
+		Annotations: map[string]string{
+			"summary":     summary,
+			"description": fmt.Sprintf("The limit is %s", limit),
+		},
+	}
+}
+
+// renderAlertRules renders the alerting rules as a PrometheusRule for the
+// Prometheus Operator, or as a plain Prometheus rules file.
+func (o *options) renderAlertRules() (string, error) {
+	groups := []alertRuleGroup{o.alertRules()}
+	var doc interface{} = map[string]interface{}{"groups": groups}
+	if o.alertRulesFormat == alertRulesPrometheusRule {
+		doc = map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"metadata":   map[string]string{"name": o.alertRulesName},
+			"spec":       map[string]interface{}{"groups": groups},
+		}
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("error rendering alert rules: %v", err)
+	}
+	return string(data), nil
+}
+
+// runGenerateAlertRules prints the alerting rules.
+func (o *options) runGenerateAlertRules() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.alertRulesFormat != alertRulesPrometheusRule && o.alertRulesFormat != alertRulesFile {
+		return fmt.Errorf("invalid format %q, expected %s or %s", o.alertRulesFormat, alertRulesPrometheusRule, alertRulesFile)
+	}
+	if o.missingReportLimit <= 0 {
+		return fmt.Errorf("invalid missing-report-limit %s: must be positive", o.missingReportLimit)
+	}
+	output, err := o.renderAlertRules()
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"github.com/gorilla/websocket"
+)
+
+// openHistory opens the --history-dir, if one is given.
+func (o *options) openHistory() error {
+	if o.historyDir == "" {
+		if o.weekOverWeek {
+			return fmt.Errorf("week-over-week needs a history-dir")
+		}
+		return nil
+	}
+	history, err := releasewatch.OpenHistory(o.historyDir, o.historyRetention)
+	if err != nil {
+		return err
+	}
+	o.history = history
+	return nil
+}
+
+// newWatcher returns a watcher configured by the api options.
+func (o *options) newWatcher() (*releasewatch.Watcher, error) {
+	if o.apiToken != "" && o.apiTokenFile != "" {
+		return nil, fmt.Errorf("only one of api-token and api-token-file can be given")
+	}
+	if o.recordDir != "" && o.replayDir != "" {
+		return nil, fmt.Errorf("only one of record-dir and replay-dir can be given")
+	}
+	if o.apiConcurrency < 1 {
+		return nil, fmt.Errorf("api-concurrency must be at least 1")
+	}
+	if o.verbose && o.verbosePayloads < 1 {
+		return nil, fmt.Errorf("verbose-payloads must be at least 1")
+	}
+	releaseAPIURLs, err := o.configuredReleaseAPIURLs()
+	if err != nil {
+		return nil, err
+	}
+	if len(releaseAPIURLs) == 0 {
+		return nil, fmt.Errorf("a release-api-url must be given")
+	}
+	return o.newWatcherForURL(releaseAPIURLs[0], releaseAPIURLs[1:]...)
+}
+
+// configuredReleaseAPIURLs returns the urls of the release controllers the
+// bot watches: that of the --arch, or the --release-api-urls.
+func (o *options) configuredReleaseAPIURLs() ([]string, error) {
+	if o.arch == "" {
+		return o.releaseAPIURLs, nil
+	}
+	if o.flags != nil && o.flags.Changed("release-api-url") {
+		return nil, fmt.Errorf("only one of arch and release-api-url can be given")
+	}
+	releaseAPIURL, err := releasewatch.ReleaseAPIURLForArch(o.arch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid arch: %v", err)
+	}
+	return []string{releaseAPIURL}, nil
+}
+
+// newWatcherForURL returns a watcher of the release controller at the url,
+// and of the others whose streams are merged into its reports, configured by
+// the other api options.
+func (o *options) newWatcherForURL(releaseAPIURL string, others ...string) (*releasewatch.Watcher, error) {
+	options, err := o.watcherOptions(releaseAPIURL, others...)
+	if err != nil {
+		return nil, err
+	}
+	return releasewatch.New(options)
+}
+
+// watcherOptions returns the options of a watcher of the release controller
+// at the url, and of the others.
+func (o *options) watcherOptions(releaseAPIURL string, others ...string) (releasewatch.Options, error) {
+	proxy, err := o.proxy()
+	if err != nil {
+		return releasewatch.Options{}, err
+	}
+	recentPayloads := 0
+	if o.verbose {
+		recentPayloads = o.verbosePayloads
+	}
+	// the metrics need the payloads counted by phase, over the last 7 days
+	// unless another window is set, and when the newest accepted payloads
+	// were accepted
+	metrics := o.pushgatewayURL != "" || o.serveMetrics
+	phaseWindow := o.phaseWindow
+	if metrics && phaseWindow == 0 {
+		phaseWindow = metricsPhaseWindow
+	}
+	return releasewatch.Options{
+		ReleaseAPIURL:         releaseAPIURL,
+		ReleaseAPIURLs:        others,
+		Limits:                o.limits(),
+		Timeout:               o.apiTimeout,
+		Proxy:                 proxy,
+		CAFile:                o.caFile,
+		InsecureSkipTLSVerify: o.insecureSkipTLSVerify,
+		Token:                 o.apiToken,
+		TokenFile:             o.apiTokenFile,
+		CacheDir:              o.cacheDir,
+		Concurrency:           o.apiConcurrency,
+		RequestRate:           o.apiRate,
+		RecordDir:             o.recordDir,
+		ReplayDir:             o.replayDir,
+		RecentPayloads:        recentPayloads,
+		SippyURL:              o.sippyURL,
+		TopRisks:              o.topRisks,
+		ComponentReadiness:    o.componentReadiness,
+		IncidentFeedURL:       o.incidentFeedURL,
+		JobArtifactsURL:       o.jobArtifactsURL,
+		ListPullRequests:      o.blame,
+		GitHubAPIURL:          o.gitHubAPIURL,
+		GitHubToken:           os.Getenv(gitHubTokenEnv),
+		MergeCheckRepos:       o.mergeCheckRepos,
+		CincinnatiURL:         o.cincinnatiURL,
+		ReleasePaths:          o.releasePaths,
+		AcceptanceWindow:      o.acceptanceWindow,
+		UpgradeWindow:         o.upgradeWindow,
+		PhaseWindow:           phaseWindow,
+		// the metrics cover every stream
+		PhaseCountsForAllStreams: metrics,
+		TagDetails:               o.tagDetails || metrics,
+	}, nil
+}
+
+// configureHTTPClients sends the requests to the services other than the
+// release controllers, such as Slack, PagerDuty, GitHub and the report
+// uploads, through the proxy, and turns the changes they would make into log
+// messages with --dry-run.
+func (o *options) configureHTTPClients() error {
+	transport, err := o.newTransport()
+	if err != nil {
+		return err
+	}
+	proxied := func(client *http.Client) *http.Client {
+		return &http.Client{Transport: transport, Timeout: client.Timeout}
+	}
+	slackClient = proxied(slackClient)
+	pagerDutyClient = proxied(pagerDutyClient)
+	uploadClient = proxied(uploadClient)
+	gitHubClient = proxied(gitHubClient)
+	jiraClient = proxied(jiraClient)
+	alertmanagerClient = proxied(alertmanagerClient)
+	gangwayClient = proxied(gangwayClient)
+	pushgatewayClient = proxied(pushgatewayClient)
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = transport.Proxy
+	socketModeDialer = &dialer
+	slackDryRun = o.dryRun
+	return nil
+}
+
+// newTransport returns a transport for outbound requests that goes through
+// the proxy.
+func (o *options) newTransport() (*http.Transport, error) {
+	proxy, err := o.proxy()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+// proxy returns the proxy function for outbound requests: the --proxy-url if
+// one is given, otherwise the proxy set by the HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY environment variables.
+func (o *options) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if o.proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(o.proxyURL)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy-url %q: must be a url such as http://proxy.example.com:3128", o.proxyURL)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// archiveTimeout bounds cloning, committing to and pushing the archive repo.
+const archiveTimeout = 2 * time.Minute
+
+// archiveReport commits the report, as report.json and report.md, to the
+// --archive-repo, so the history of the repo is a diffable history of the
+// reports.  The branch is cloned afresh for every report, so nothing is kept
+// between reports and a push can't conflict with an earlier failed one.
+func (o *options) archiveReport(ctx context.Context, report *releasewatch.Report) {
+	if o.archiveRepo == "" {
+		return
+	}
+	if slackDryRun {
+		klog.Infof("dry run, not archiving the report to %s", o.redactedArchiveRepo())
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, archiveTimeout)
+	defer cancel()
+	if err := o.commitReport(ctx, report); err != nil {
+		klog.Errorf("error archiving the report: %v", err)
+		return
+	}
+	klog.V(2).Infof("archived the report to %s", o.redactedArchiveRepo())
+}
+
+// redactedArchiveRepo returns the --archive-repo with the password of its url,
+// which may be a token to push with, redacted, to log it and the output of
+// git.
+func (o *options) redactedArchiveRepo() string {
+	u, err := url.Parse(o.archiveRepo)
+	// scp-like addresses, e.g. git@github.com:example/reports.git, aren't
+	// urls and hold no password
+	if err != nil || u.User == nil {
+		return o.archiveRepo
+	}
+	return u.Redacted()
+}
+
+// archiveDir returns the directory of the checkout to write the reports to,
+// or an error if the --archive-path leads out of it, e.g. with "..".
+func archiveDir(checkout, path string) (string, error) {
+	dir := filepath.Join(checkout, filepath.Clean(filepath.FromSlash(path)))
+	if dir != checkout && !strings.HasPrefix(dir, checkout+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive-path %s is outside of the archive repo", path)
+	}
+	return dir, nil
+}
+
+func (o *options) commitReport(ctx context.Context, report *releasewatch.Report) error {
+	// the author is validated with the other flags
+	author, _ := mail.ParseAddress(o.archiveAuthor)
+	checkout, err := ioutil.TempDir("", "release-watcher-archive-")
+	if err != nil {
+		return fmt.Errorf("error creating a checkout of the archive repo: %v", err)
+	}
+	defer os.RemoveAll(checkout)
+
+	git := func(command string, args ...string) error {
+		args = append([]string{"-C", checkout, "-c", "user.name=" + author.Name, "-c", "user.email=" + author.Address, command}, args...)
+		output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+		if err != nil {
+			output = bytes.ReplaceAll(output, []byte(o.archiveRepo), []byte(o.redactedArchiveRepo()))
+			return fmt.Errorf("error running git %s: %v: %s", command, err, bytes.TrimSpace(output))
+		}
+		return nil
+	}
+	if err := git("clone", "--quiet", "--depth", "1", "--branch", o.archiveBranch, o.archiveRepo, "."); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the report: %v", err)
+	}
+	dir, err := archiveDir(checkout, o.archivePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating %s in the archive repo: %v", o.archivePath, err)
+	}
+	files := map[string][]byte{
+		"report.json": append(data, '\n'),
+		"report.md":   []byte(reportMarkdown(report)),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return fmt.Errorf("error writing %s to the archive repo: %v", name, err)
+		}
+	}
+	if err := git("add", "--all", "."); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Report of %s\n\n%s\n", report.GeneratedAt.UTC().Format("2006-01-02 15:04 MST"), report.Summary())
+	if err := git("commit", "--quiet", "--message", message); err != nil {
+		return err
+	}
+	return git("push", "--quiet", "origin", "HEAD:"+o.archiveBranch)
+}
+
+// reportMarkdown renders the report as markdown, with a section for each
+// stream with problems.
+func reportMarkdown(report *releasewatch.Report) string {
+	output := "# OCP Payload Report\n\n"
+	output += fmt.Sprintf("Generated at %s: %s.\n\n", report.FormatTime(report.GeneratedAt), report.Summary())
+	if warning := report.CacheWarning(); warning != "" {
+		output += "**Warning:** " + warning + "\n\n"
+	}
+	for _, unavailable := range report.Unavailable {
+		output += "**Warning:** " + unavailable.String() + "\n\n"
+	}
+	if lines := report.CommonJobFailureLines(); len(lines) > 0 {
+		output += "## Jobs failing in several streams\n\n- " + strings.Join(lines, "\n- ") + "\n\n"
+	}
+	for _, stream := range report.Streams {
+		if len(stream.Problems) == 0 && len(stream.Acknowledged) == 0 {
+			continue
+		}
+		output += fmt.Sprintf("## [%s](%s)\n\n%s.\n\n", stream.Name, stream.URL(), stream.HealthString())
+		for _, p := range stream.Problems {
+			output += fmt.Sprintf("- **%s** %s\n", p.Severity, p.Message)
+			for _, failure := range p.BlockingJobFailures {
+				output += "  - " + failure.String() + "\n"
+			}
+			if p.RejectionCauses != nil {
+				output += "  - rejection causes: " + p.RejectionCauses.String() + "\n"
+			}
+			for _, risk := range p.Risks {
+				output += "  - risk: " + risk.String() + "\n"
+			}
+			if p.ComponentRegressions != nil {
+				output += fmt.Sprintf("  - [component readiness](%s): %s\n", p.ComponentRegressions.URL, p.ComponentRegressions.String())
+			}
+		}
+		for _, p := range stream.Acknowledged {
+			output += fmt.Sprintf("- acknowledged: %s\n", p.Message)
+		}
+		output += "\n"
+	}
+	output += fmt.Sprintf("Ignored releases older than 4.%d.z and newer than 4.%d.z\n", report.OldestMinor, report.NewestMinor)
+	for _, line := range report.IgnoredLines() {
+		output += "\n" + line + "\n"
+	}
+	return strings.TrimSuffix(output, "\n") + "\n"
+}
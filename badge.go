@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+// badgeCharWidth approximates the width of a character of the badges' 11px
+// Verdana, which is close enough for the names of streams.
+const badgeCharWidth = 7
+
+// badgeStatus is the status a badge shows for a stream.
+type badgeStatus struct {
+	message string
+	// color is the name of the color, as the shields.io endpoint takes it,
+	// and hex its value, for the SVG.
+	color string
+	hex   string
+}
+
+// streamBadgeStatus returns the status of the stream: green if it has no
+// problems, red if it has a critical one and yellow otherwise.
+func streamBadgeStatus(stream releasewatch.StreamReport) badgeStatus {
+	switch severity := stream.Severity(); severity {
+	case "":
+		return badgeStatus{message: "healthy", color: "green", hex: "#4c1"}
+	case releasewatch.SeverityCritical:
+		return badgeStatus{message: string(severity), color: "red", hex: "#e05d44"}
+	default:
+		return badgeStatus{message: string(severity), color: "yellow", hex: "#dfb317"}
+	}
+}
+
+// shieldsEndpoint is the JSON a shields.io endpoint badge is made from.
+type shieldsEndpoint struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// badgeSVG renders a flat badge with the label on the left and the message on
+// the right, in the style of shields.io.
+func badgeSVG(label string, status badgeStatus) string {
+	labelWidth := len(label)*badgeCharWidth + 10
+	messageWidth := len(status.message)*badgeCharWidth + 10
+	width := labelWidth + messageWidth
+	label, message := html.EscapeString(label), html.EscapeString(status.message)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<title>%s: %s</title>`+
+		`<rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`,
+		width, label, message, label, message,
+		labelWidth, labelWidth, messageWidth, status.hex,
+		labelWidth/2, label, labelWidth+messageWidth/2, message)
+}
+
+// createBadgeHandler serves the status of a stream as a badge for READMEs and
+// wikis to embed: /badge/4.16.0-0.nightly.svg as an SVG, and
+// /badge/4.16.0-0.nightly.json as a shields.io endpoint, e.g.
+// https://img.shields.io/endpoint?url=https://release-watcher/badge/4.16.0-0.nightly.json.
+// They are served from the cached report, as the metrics are.
+func (o *options) createBadgeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/badge/")
+		format := ""
+		for _, suffix := range []string{".svg", ".json"} {
+			if strings.HasSuffix(name, suffix) {
+				name, format = strings.TrimSuffix(name, suffix), suffix
+			}
+		}
+		if format == "" {
+			http.Error(w, "the badge must be /badge/<stream>.svg or /badge/<stream>.json", http.StatusNotFound)
+			return
+		}
+		// the badges are embedded in pages anyone can view, so they never
+		// crawl the release controllers themselves
+		reportCacheMutex.Lock()
+		report := cachedReport
+		reportCacheMutex.Unlock()
+		if report == nil {
+			http.Error(w, "no report yet", http.StatusServiceUnavailable)
+			return
+		}
+		for _, stream := range report.Streams {
+			if stream.Name != name {
+				continue
+			}
+			status := streamBadgeStatus(stream)
+			// the badges are embedded in pages that are cached for long
+			w.Header().Set("Cache-Control", "max-age=300")
+			if format == ".json" {
+				writeJSON(w, http.StatusOK, shieldsEndpoint{SchemaVersion: 1, Label: stream.Name, Message: status.message, Color: status.color})
+				return
+			}
+			w.Header().Set("Content-Type", "image/svg+xml")
+			fmt.Fprint(w, badgeSVG(stream.Name, status))
+			return
+		}
+		http.Error(w, fmt.Sprintf("stream %s is not monitored", name), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+// Block is a Slack Block Kit layout block.  Only the fields needed for the
+// block types we generate (header, section, context, actions, divider) are
+// modeled.
+type Block struct {
+	Type     string        `json:"type"`
+	BlockID  string        `json:"block_id,omitempty"`
+	Text     *TextObject   `json:"text,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+
+	// input block fields
+	Label   *TextObject `json:"label,omitempty"`
+	Element interface{} `json:"element,omitempty"`
+	Hint    *TextObject `json:"hint,omitempty"`
+}
+
+type TextObject struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+type ButtonElement struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text"`
+	URL      string      `json:"url,omitempty"`
+	ActionID string      `json:"action_id,omitempty"`
+	Value    string      `json:"value,omitempty"`
+}
+
+type InputElement struct {
+	Type         string `json:"type"`
+	ActionID     string `json:"action_id"`
+	InitialValue string `json:"initial_value,omitempty"`
+}
+
+func actionButton(text, actionID, value string) *ButtonElement {
+	return &ButtonElement{
+		Type:     "button",
+		Text:     plainText(text),
+		ActionID: actionID,
+		Value:    value,
+	}
+}
+
+func plainText(text string) *TextObject {
+	return &TextObject{Type: "plain_text", Text: text, Emoji: true}
+}
+
+func markdownText(text string) *TextObject {
+	return &TextObject{Type: "mrkdwn", Text: text}
+}
+
+func linkButton(text, url, actionID string) *ButtonElement {
+	return &ButtonElement{
+		Type:     "button",
+		Text:     plainText(text),
+		URL:      url,
+		ActionID: actionID,
+	}
+}
+
+// maxSectionLength is the most characters Slack allows in the text of a
+// section block.
+const maxSectionLength = 3000
+
+// problemLabels are short descriptions of each kind of problem, used to
+// label buttons.
+var problemLabels = map[releasewatch.ProblemKind]string{
+	releasewatch.ProblemNoAcceptedPayloads:    "no accepted",
+	releasewatch.ProblemStaleAcceptedPayload:  "stale accepted",
+	releasewatch.ProblemNoBuiltPayloads:       "no builds",
+	releasewatch.ProblemStaleBuiltPayload:     "stale builds",
+	releasewatch.ProblemNoPatchUpgrade:        "patch upgrade",
+	releasewatch.ProblemNoMinorUpgrade:        "minor upgrade",
+	releasewatch.ProblemLowAcceptanceRate:     "acceptance rate",
+	releasewatch.ProblemBuildCadenceDrop:      "build cadence",
+	releasewatch.ProblemStuckVerification:     "stuck verification",
+	releasewatch.ProblemNoPathUpgrade:         "upgrade path",
+	releasewatch.ProblemLowUpgradeSuccessRate: "upgrade success",
+	releasewatch.ProblemArchSkew:              "arch skew",
+	releasewatch.ProblemSlowingAcceptance:     "accept trend",
+	releasewatch.ProblemUnpublishedPayload:    "unpublished",
+	releasewatch.ProblemPromotionLag:          "promotion",
+	releasewatch.ProblemStaleDevPreview:       "dev-preview",
+	releasewatch.ProblemLostUpgradeSource:     "upgrade source",
+	releasewatch.ProblemRejectionStreak:       "rejections",
+}
+
+// severityEmoji marks each problem with its severity.
+var severityEmoji = map[releasewatch.Severity]string{
+	releasewatch.SeverityInfo:     ":information_source:",
+	releasewatch.SeverityWarning:  ":warning:",
+	releasewatch.SeverityCritical: ":red_circle:",
+}
+
+// reportBlocks renders the report as Slack blocks: a header, a summary, one
+// section per minor version that has problems (or a single section if the
+// report is sorted otherwise) and a trailing section listing acknowledged
+// problems.  Reports covering more than one architecture also summarize each
+// architecture and have the sections of each under its own header.
+func reportBlocks(report *releasewatch.Report, mentions string) []Block {
+	blocks := []Block{
+		{Type: "header", Text: plainText("OCP Payload Report")},
+		{Type: "section", Text: markdownText(reportSummary(report, mentions))},
+	}
+	if warning := report.CacheWarning(); warning != "" {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{markdownText(":warning: " + warning)},
+		})
+	}
+	for _, unavailable := range report.Unavailable {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{markdownText(":warning: " + unavailable.String())},
+		})
+	}
+
+	if arches := report.Arches(); len(arches) > 1 {
+		lines := []string{}
+		for _, arch := range arches {
+			lines = append(lines, fmt.Sprintf("*%s*: %s", arch, report.ForArch(arch).Summary()))
+		}
+		if problems := report.CrossArchProblems(); len(problems) > 0 {
+			lines = append(lines, "*In every architecture*: "+strings.Join(problems, ", "))
+		}
+		blocks = append(blocks, Block{Type: "section", Text: markdownText(strings.Join(lines, "\n"))})
+		for _, arch := range arches {
+			if archReport := report.ForArch(arch); archReport.HasProblems() {
+				blocks = append(blocks, Block{Type: "header", Text: plainText(arch)})
+				blocks = append(blocks, problemBlocks(archReport)...)
+			}
+		}
+	} else {
+		blocks = append(blocks, problemBlocks(report)...)
+	}
+	if lines := report.CommonJobFailureLines(); len(lines) > 0 {
+		blocks = append(blocks, Block{
+			Type: "section",
+			Text: markdownText("*Jobs failing in several streams*\n• " + strings.Join(lines, "\n• ")),
+		})
+	}
+
+	acked := []string{}
+	for _, stream := range report.Streams {
+		for _, p := range stream.Acknowledged {
+			until := report.FormatTimeAgo(p.Until)
+			var line string
+			switch {
+			case p.Reason != "":
+				line = fmt.Sprintf("• %s: %s (silenced until %s: %s)", stream.Name, p.Message, until, p.Reason)
+			case !p.Until.IsZero():
+				line = fmt.Sprintf("• %s: %s (acknowledged by <@%s>, snoozed until %s)", stream.Name, p.Message, p.User, until)
+			default:
+				line = fmt.Sprintf("• %s: %s (acknowledged by <@%s>)", stream.Name, p.Message, p.User)
+			}
+			acked = append(acked, line)
+		}
+	}
+
+	if len(acked) > 0 {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText("*Acknowledged problems*\n" + strings.Join(acked, "\n"))},
+			},
+		)
+	}
+
+	if lines := report.AcceptanceLines(); len(lines) > 0 {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText(fmt.Sprintf("*%s*\n• %s", report.AcceptanceHeading(), strings.Join(lines, "\n• ")))},
+			},
+		)
+	}
+
+	if lines := report.UpgradeLines(); len(lines) > 0 {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText(fmt.Sprintf("*%s*\n• %s", report.UpgradeHeading(), strings.Join(lines, "\n• ")))},
+			},
+		)
+	}
+	if lines := report.ReleasePathLines(); len(lines) > 0 {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText("*Release path*\n• " + strings.Join(lines, "\n• "))},
+			},
+		)
+	}
+	if lines := report.SLOLines(); len(lines) > 0 {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText("*Service level objectives*\n• " + strings.Join(lines, "\n• "))},
+			},
+		)
+	}
+	if report.Comparison != nil {
+		blocks = append(blocks,
+			Block{Type: "divider"},
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText(fmt.Sprintf("*%s*\n• %s", report.ComparisonHeading(), strings.Join(report.Comparison.Lines(), "\n• ")))},
+			},
+		)
+	}
+
+	return append(blocks, footerBlock(report))
+}
+
+// footerBlock notes the releases and streams the report leaves out.
+func footerBlock(report *releasewatch.Report) Block {
+	return Block{
+		Type: "context",
+		Elements: []interface{}{
+			markdownText(strings.Join(append([]string{fmt.Sprintf("Ignored releases older than 4.%d.z and newer than 4.%d.z", report.OldestMinor, report.NewestMinor)}, report.IgnoredLines()...), "\n")),
+		},
+	}
+}
+
+// digestBlocks renders the report as a single compact message: a header, the
+// summary with the problem counts by severity and one line per kind of
+// problem linking the streams that have it, so a registry outage that breaks
+// every stream doesn't flood the channel with per-stream sections.
+func digestBlocks(report *releasewatch.Report, mentions string) []Block {
+	summary := reportSummary(report, mentions)
+	if counts := report.SeverityCounts(); counts != "" {
+		summary += "\n*Problems*: " + counts
+	}
+	blocks := []Block{
+		{Type: "header", Text: plainText("OCP Payload Report")},
+		{Type: "section", Text: markdownText(summary)},
+	}
+	if warning := report.CacheWarning(); warning != "" {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{markdownText(":warning: " + warning)},
+		})
+	}
+	for _, unavailable := range report.Unavailable {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{markdownText(":warning: " + unavailable.String())},
+		})
+	}
+
+	lines := []string{}
+	for _, group := range report.ProblemGroups() {
+		line := fmt.Sprintf("%s *%s*: ", severityEmoji[group.Severity], group.Heading())
+		for i, stream := range group.Streams {
+			link := fmt.Sprintf("<%s|%s>", stream.URL(), stream.Name)
+			if len(line)+len(link) > maxSectionLength-len(" and 1000 more") {
+				line += fmt.Sprintf(" and %d more", len(group.Streams)-i)
+				break
+			}
+			if i > 0 {
+				line += ", "
+			}
+			line += link
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		blocks = append(blocks, Block{Type: "divider"})
+	}
+	// the lines are packed into as few sections as fit them
+	text := ""
+	for _, line := range lines {
+		if text != "" && len(text)+len(line)+1 > maxSectionLength {
+			blocks = append(blocks, Block{Type: "section", Text: markdownText(text)})
+			text = ""
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+	}
+	if text != "" {
+		blocks = append(blocks, Block{Type: "section", Text: markdownText(text)})
+	}
+
+	acked := 0
+	for _, stream := range report.Streams {
+		acked += len(stream.Acknowledged)
+	}
+	if acked > 0 {
+		blocks = append(blocks, Block{
+			Type:     "context",
+			Elements: []interface{}{markdownText(fmt.Sprintf("%d acknowledged problems not shown", acked))},
+		})
+	}
+	return append(blocks, footerBlock(report))
+}
+
+// problemBlocks renders the streams of the report that have problems.
+func problemBlocks(report *releasewatch.Report) []Block {
+	streams := []releasewatch.StreamReport{}
+	for _, stream := range report.Streams {
+		if len(stream.Problems) > 0 {
+			streams = append(streams, stream)
+		}
+	}
+
+	// when streams are sorted by minor, consecutive streams with the same
+	// minor belong to the same section.  Otherwise they are all in one
+	// section, to keep their order.
+	blocks := []Block{}
+	switch {
+	case len(streams) == 0:
+	case !report.GroupedByMinor():
+		blocks = append(blocks, streamBlocks(fmt.Sprintf("*Streams by %s*", report.SortOrder), streams)...)
+	default:
+		for i := 0; i < len(streams); {
+			minor := streams[i].Minor
+			j := i
+			for j < len(streams) && streams[j].Minor == minor {
+				j++
+			}
+			blocks = append(blocks, streamBlocks(fmt.Sprintf("*4.%d*", minor), streams[i:j])...)
+			i = j
+		}
+	}
+	return blocks
+}
+
+// streamBlocks renders a section of the report, listing the problems of each
+// of the streams.
+func streamBlocks(title string, streams []releasewatch.StreamReport) []Block {
+	blocks := []Block{
+		{Type: "divider"},
+		{Type: "section", Text: markdownText(title)},
+	}
+	for _, stream := range streams {
+		name := fmt.Sprintf("*%s* (%s", stream.Name, stream.HealthString())
+		if stream.PhaseCounts != nil {
+			name += ", " + stream.PhaseCounts.String()
+		}
+		name += ")"
+		lines := []string{name}
+		buttons := []interface{}{
+			linkButton(stream.Name, stream.URL(), "open-stream"),
+		}
+		for _, problem := range stream.Problems {
+			lines = append(lines, severityEmoji[problem.Severity]+" "+problem.Message)
+			if problem.Changelog != nil {
+				lines = append(lines, "    Unaccepted changes: "+problem.Changelog.String())
+			}
+			if len(problem.BlockingJobFailures) > 0 {
+				lines = append(lines, "    Failing blocking jobs: "+jobFailuresText(problem.BlockingJobFailures))
+			}
+			if len(problem.InformingJobFailures) > 0 {
+				lines = append(lines, "    Failing informing jobs, which did not block acceptance: "+jobFailuresText(problem.InformingJobFailures))
+			}
+			if problem.RejectionCauses != nil {
+				lines = append(lines, "    Rejection causes: "+problem.RejectionCauses.String())
+			}
+			for _, risk := range problem.Risks {
+				lines = append(lines, "    Risk: "+risk.String())
+			}
+			if problem.ComponentRegressions != nil {
+				lines = append(lines, fmt.Sprintf("    <%s|Component readiness>: %s", problem.ComponentRegressions.URL, problem.ComponentRegressions.String()))
+			}
+			if rejected, more := problem.ListedRejectedPayloads(); len(rejected) > 0 {
+				links := []string{}
+				for _, payload := range rejected {
+					links = append(links, fmt.Sprintf("<%s|%s>", stream.PayloadURL(payload), payload))
+				}
+				if more > 0 {
+					links = append(links, fmt.Sprintf("and %d more", more))
+				}
+				lines = append(lines, "    Rejected since: "+strings.Join(links, ", "))
+			}
+			key := problemKey(stream.Name, problem.Kind)
+			label := problemLabels[problem.Kind]
+			buttons = append(buttons,
+				actionButton("Ack "+label, ackActionPrefix+string(problem.Kind), key),
+				actionButton("Snooze "+label+" 24h", snoozeActionPrefix+string(problem.Kind), key),
+			)
+		}
+		for _, c := range stream.Constituents {
+			lines = append(lines, "    Built from "+c.String())
+		}
+		if button := nightlyButton(stream); button != nil {
+			buttons = append(buttons, button)
+		}
+		blocks = append(blocks,
+			Block{
+				Type:     "context",
+				Elements: []interface{}{markdownText(strings.Join(lines, "\n"))},
+			},
+			Block{Type: "actions", Elements: buttons},
+		)
+	}
+	return blocks
+}
+
+// reportSummary returns the report summary, tagging the mentions only when
+// there are problems nobody has acknowledged yet.
+func reportSummary(report *releasewatch.Report, mentions string) string {
+	if mentions != "" && report.HasProblems() {
+		return mentions + " " + report.Summary()
+	}
+	return report.Summary()
+}
+
+func jobFailuresText(failures []releasewatch.JobFailure) string {
+	texts := []string{}
+	for _, failure := range failures {
+		text := failure.String()
+		if failure.RunURL != "" {
+			text = fmt.Sprintf("<%s|%s> %s", failure.RunURL, failure.Job, failure.Description())
+		}
+		if failure.TestGridURL != "" {
+			text += fmt.Sprintf(" (<%s|TestGrid>)", failure.TestGridURL)
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, ", ")
+}
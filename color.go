@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+// colorOutput returns true if the report is printed in color: unless
+// --no-color or the NO_COLOR environment variable turn it off, when it is
+// printed to a terminal.
+func (o *options) colorOutput() bool {
+	if o.noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	_, _, err := terminalSize(int(os.Stdout.Fd()))
+	return err == nil
+}
+
+// colorBySeverity colors the lines of the printed report as the tui does: red
+// for critical and yellow for warnings, and the healthy streams green, so the
+// one critical stream stands out among many healthy ones.
+func colorBySeverity(severity releasewatch.Severity, text string) string {
+	return severityColors[severity] + text + styleReset
+}
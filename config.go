@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// configSilences are the silences from the config file.  Unlike the silences
+// set through the admin api they are not persisted in the state file.
+// Guarded by stateMutex.
+var configSilences = []Silence{}
+
+// severityRoutes are the severity routes from the config file.  Guarded by
+// stateMutex.
+var severityRoutes = []SeverityRoute{}
+
+// configIgnores are the ignores from the config file, which are applied along
+// with the --ignore ones.  Guarded by stateMutex.
+var configIgnores = []releasewatch.Ignore{}
+
+// configStreamLimits are the stream-limits of the config file.  Guarded by
+// stateMutex.
+var configStreamLimits = []releasewatch.StreamLimits{}
+
+// configWorkspaces are the other Slack workspaces of the config file.
+// Guarded by stateMutex.
+var configWorkspaces = []Workspace{}
+
+// configTenants are the channels of the config file that get reports of their
+// own.  Guarded by stateMutex.
+var configTenants = []Tenant{}
+
+// configKnownIssues are the known-issues of the config file.  Guarded by
+// stateMutex.
+var configKnownIssues = []releasewatch.KnownIssue{}
+
+// configFile is the contents of a config file.
+type configFile struct {
+	// values are the settings, formatted as they would be on the command
+	// line.
+	values         map[string]string
+	silences       []Silence
+	severityRoutes []SeverityRoute
+	ignores        []releasewatch.Ignore
+	streamLimits   []releasewatch.StreamLimits
+	workspaces     []Workspace
+	tenants        []Tenant
+	knownIssues    []releasewatch.KnownIssue
+	escalations    []Escalation
+	// slos are the --slo objectives, each of which may contain commas.
+	slos []string
+}
+
+// streamLimitsRule is an entry of the stream-limits of the config file.
+type streamLimitsRule struct {
+	Match                  string `json:"match"`
+	AcceptedStalenessLimit string `json:"accepted-staleness-limit,omitempty"`
+	BuiltStalenessLimit    string `json:"built-staleness-limit,omitempty"`
+	UpgradeStalenessLimit  string `json:"upgrade-staleness-limit,omitempty"`
+}
+
+// parseStreamLimits parses the stream-limits of the config file.
+func parseStreamLimits(rules []streamLimitsRule) ([]releasewatch.StreamLimits, error) {
+	limits := []releasewatch.StreamLimits{}
+	for i, rule := range rules {
+		if rule.Match == "" {
+			return nil, fmt.Errorf("stream limits %d must have a match", i)
+		}
+		match, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match of stream limits %d: %v", i, err)
+		}
+		l := releasewatch.StreamLimits{Match: match}
+		if l.AcceptedStaleness, err = parseLimit(rule.AcceptedStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid accepted-staleness-limit of stream limits %d: %v", i, err)
+		}
+		if l.BuiltStaleness, err = parseLimit(rule.BuiltStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid built-staleness-limit of stream limits %d: %v", i, err)
+		}
+		if l.UpgradeStaleness, err = parseLimit(rule.UpgradeStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid upgrade-staleness-limit of stream limits %d: %v", i, err)
+		}
+		limits = append(limits, l)
+	}
+	return limits, nil
+}
+
+// parseLimit parses a stream limit, which is zero if it isn't set.
+func parseLimit(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := time.ParseDuration(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("%q must be a positive duration such as 72h", value)
+	}
+	return limit, nil
+}
+
+// readConfigFile reads a YAML config file whose keys are flag names, e.g.
+//
+//	accepted-staleness-limit: 36h
+//	oldest-minor: 10
+//	channel-map:
+//	  "4.16": "#forum-416-payloads"
+//	silences:
+//	- stream: 4.16.0-0.nightly
+//	  until: 2024-06-01T00:00:00Z
+//	  reason: mirror outage
+//	severity-routes:
+//	- severity: critical
+//	  channels: ["#release-alerts"]
+//	ignore:
+//	- 4.13.0-0.ci until=2025-09-01 reason="CI infra migration"
+//	stream-limits:
+//	- match: "4\\.1[0-3]\\..*nightly"
+//	  accepted-staleness-limit: 72h
+//	workspaces:
+//	- name: partner
+//	  token-env: PARTNER_SLACK_TOKEN
+//	  channels: ["#ocp-payloads"]
+//	tenants:
+//	- channel: C0123ABCD
+//	  oldest-minor: 14
+//	  stream-types: [nightly]
+//	known-issues:
+//	- job: aws-ovn-upgrade
+//	  bug: OCPBUGS-12345
+//	escalations:
+//	- after: 48h
+//	  channels: ["#release-alerts"]
+//	  mention: "<!subteam^S0123>"
+func readConfigFile(path string) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	silences := []Silence{}
+	if data, ok := raw["silences"]; ok {
+		delete(raw, "silences")
+		if err := json.Unmarshal(data, &silences); err != nil {
+			return nil, fmt.Errorf("error parsing silences in config file %s: %v", path, err)
+		}
+		if err := validateSilences(silences); err != nil {
+			return nil, fmt.Errorf("invalid silences in config file %s: %v", path, err)
+		}
+	}
+
+	routes := []SeverityRoute{}
+	if data, ok := raw["severity-routes"]; ok {
+		delete(raw, "severity-routes")
+		if err := json.Unmarshal(data, &routes); err != nil {
+			return nil, fmt.Errorf("error parsing severity-routes in config file %s: %v", path, err)
+		}
+		if err := validateSeverityRoutes(routes); err != nil {
+			return nil, fmt.Errorf("invalid severity-routes in config file %s: %v", path, err)
+		}
+	}
+
+	ignores := []releasewatch.Ignore{}
+	if data, ok := raw["ignore"]; ok {
+		delete(raw, "ignore")
+		values := []string{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("error parsing ignore in config file %s: %v", path, err)
+		}
+		for _, value := range values {
+			ignore, err := releasewatch.ParseIgnore(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore in config file %s: %v", path, err)
+			}
+			ignores = append(ignores, ignore)
+		}
+	}
+
+	slos := []string{}
+	if data, ok := raw["slo"]; ok {
+		delete(raw, "slo")
+		if err := json.Unmarshal(data, &slos); err != nil {
+			return nil, fmt.Errorf("error parsing slo in config file %s: %v", path, err)
+		}
+		for _, value := range slos {
+			if _, err := releasewatch.ParseSLO(value); err != nil {
+				return nil, fmt.Errorf("invalid slo in config file %s: %v", path, err)
+			}
+		}
+	}
+
+	streamLimits := []releasewatch.StreamLimits{}
+	if data, ok := raw["stream-limits"]; ok {
+		delete(raw, "stream-limits")
+		rules := []streamLimitsRule{}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("error parsing stream-limits in config file %s: %v", path, err)
+		}
+		if streamLimits, err = parseStreamLimits(rules); err != nil {
+			return nil, fmt.Errorf("invalid stream-limits in config file %s: %v", path, err)
+		}
+	}
+
+	workspaces := []Workspace{}
+	if data, ok := raw["workspaces"]; ok {
+		delete(raw, "workspaces")
+		if err := json.Unmarshal(data, &workspaces); err != nil {
+			return nil, fmt.Errorf("error parsing workspaces in config file %s: %v", path, err)
+		}
+		if err := validateWorkspaces(workspaces); err != nil {
+			return nil, fmt.Errorf("invalid workspaces in config file %s: %v", path, err)
+		}
+	}
+
+	tenants := []Tenant{}
+	if data, ok := raw["tenants"]; ok {
+		delete(raw, "tenants")
+		rules := []tenantRule{}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("error parsing tenants in config file %s: %v", path, err)
+		}
+		if tenants, err = parseTenants(rules); err != nil {
+			return nil, fmt.Errorf("invalid tenants in config file %s: %v", path, err)
+		}
+	}
+
+	knownIssues := []releasewatch.KnownIssue{}
+	if data, ok := raw["known-issues"]; ok {
+		delete(raw, "known-issues")
+		if err := json.Unmarshal(data, &knownIssues); err != nil {
+			return nil, fmt.Errorf("error parsing known-issues in config file %s: %v", path, err)
+		}
+		for _, issue := range knownIssues {
+			if err := issue.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid known-issues in config file %s: %v", path, err)
+			}
+		}
+	}
+
+	escalations := []Escalation{}
+	if data, ok := raw["escalations"]; ok {
+		delete(raw, "escalations")
+		rules := []escalationRule{}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("error parsing escalations in config file %s: %v", path, err)
+		}
+		if escalations, err = parseEscalations(rules); err != nil {
+			return nil, fmt.Errorf("invalid escalations in config file %s: %v", path, err)
+		}
+	}
+
+	values := map[string]string{}
+	for name, data := range raw {
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// keep numbers as they were written, rather than as floats
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("error parsing %s in config file %s: %v", name, path, err)
+		}
+		values[name] = flagValue(value)
+	}
+	return &configFile{values: values, silences: silences, severityRoutes: routes, ignores: ignores, streamLimits: streamLimits, workspaces: workspaces, tenants: tenants, knownIssues: knownIssues, escalations: escalations, slos: slos}, nil
+}
+
+// flagValue formats a config file value as a flag value: lists are comma
+// separated and mappings are formatted as "k1=v1,k2=v2".
+func flagValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, flagValue(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		pairs := []string{}
+		for key, item := range v {
+			pairs = append(pairs, key+"="+flagValue(item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// envPrefix prefixes the environment variables that set flags, e.g.
+// RELEASE_WATCHER_OLDEST_MINOR for --oldest-minor.
+const envPrefix = "RELEASE_WATCHER_"
+
+// envName returns the environment variable that sets a flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// loadEnv applies the RELEASE_WATCHER_* environment variables to every flag
+// that was not set on the command line.  The flags they set count as explicit,
+// so they take precedence over the config file.
+func (o *options) loadEnv(flagset *pflag.FlagSet) error {
+	var err error
+	flagset.VisitAll(func(f *pflag.Flag) {
+		if err != nil || o.explicitFlags[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flagset.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value for %s in environment variable %s: %v", f.Name, envName(f.Name), setErr)
+			return
+		}
+		o.explicitFlags[f.Name] = true
+	})
+	return err
+}
+
+// loadConfig applies the environment variables and then the config file, if
+// one is given, to every flag that was not set on the command line, so flags
+// take precedence over the environment, which takes precedence over the file.
+func (o *options) loadConfig(flagset *pflag.FlagSet) error {
+	o.flags = flagset
+	o.explicitFlags = map[string]bool{}
+	flagset.Visit(func(f *pflag.Flag) {
+		o.explicitFlags[f.Name] = true
+	})
+	if err := o.loadEnv(flagset); err != nil {
+		return err
+	}
+	// remember the settings without the config file, so settings that are
+	// removed from it revert when it is reloaded.
+	o.baseSettings = o.currentSettings()
+
+	if o.configFile == "" {
+		return nil
+	}
+	config, err := readConfigFile(o.configFile)
+	if err != nil {
+		return err
+	}
+	stateMutex.Lock()
+	configSilences = config.silences
+	severityRoutes = config.severityRoutes
+	configIgnores = config.ignores
+	configStreamLimits = config.streamLimits
+	configWorkspaces = config.workspaces
+	configTenants = config.tenants
+	configKnownIssues = config.knownIssues
+	configEscalations = config.escalations
+	stateMutex.Unlock()
+	for name, value := range config.values {
+		// the same file may be shared by the report and bot commands, which
+		// don't have the same flags.
+		if flagset.Lookup(name) == nil {
+			klog.Warningf("ignoring unknown setting %q in config file %s", name, o.configFile)
+			continue
+		}
+		if o.explicitFlags[name] {
+			continue
+		}
+		if err := flagset.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %v", name, o.configFile, err)
+		}
+	}
+	// each objective is set on its own, as it would be repeated on the
+	// command line
+	if o.hasFlag("slo") && !o.explicitFlags["slo"] {
+		for _, value := range config.slos {
+			if err := flagset.Set("slo", value); err != nil {
+				return fmt.Errorf("invalid value for slo in config file %s: %v", o.configFile, err)
+			}
+		}
+	}
+	return o.validate()
+}
+
+// hasFlag returns true if the command the options were loaded for has the
+// flag, e.g. only the bot has a report-interval.
+func (o *options) hasFlag(name string) bool {
+	return o.flags != nil && o.flags.Lookup(name) != nil
+}
+
+// validate checks the settings that can't be checked by parsing each flag on
+// its own.
+func (o *options) validate() error {
+	if o.hasFlag("report-interval") && o.reportInterval <= 0 {
+		return fmt.Errorf("report-interval must be positive")
+	}
+	if o.hasFlag("poll-interval") && o.pollInterval <= 0 {
+		return fmt.Errorf("poll-interval must be positive")
+	}
+	if _, err := parseChannelMap(o.channelMap); err != nil {
+		return fmt.Errorf("invalid channel-map: %v", err)
+	}
+	if err := validateAliasMap(o.slackAliasMap); err != nil {
+		return fmt.Errorf("invalid slack-alias-map: %v", err)
+	}
+	if err := validateNightlyBuildJobs(o.nightlyBuildJobs); err != nil {
+		return err
+	}
+	if o.uploadURL != "" {
+		if _, err := parseUploadURL(o.uploadURL, o.uploadEndpoint, o.uploadRegion); err != nil {
+			return err
+		}
+	}
+	if o.pushgatewayURL != "" {
+		if u, err := url.Parse(o.pushgatewayURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid pushgateway-url %q: must be a url such as http://pushgateway:9091", o.pushgatewayURL)
+		}
+	}
+	if o.serveMetrics && o.reportCacheInterval <= 0 {
+		return fmt.Errorf("report-cache-interval must be positive: the /metrics are served from the cached report")
+	}
+	if o.messageTemplate != "" {
+		if _, err := parseMessageTemplate(o.messageTemplate); err != nil {
+			return err
+		}
+	}
+	if o.slackWebhookURL != "" {
+		if u, err := url.Parse(o.slackWebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid slack-webhook-url: must be a url such as https://hooks.slack.com/services/T000/B000/XXXX")
+		}
+		// a webhook can only post new messages to its own channel
+		for name, set := range map[string]bool{
+			"report-channel":  o.reportChannel != "",
+			"channel-map":     len(o.channelMap) > 0,
+			"update-in-place": o.updateInPlace,
+			"pin-report":      o.pinReport,
+			"ack-reactions":   len(o.ackReactions) > 0,
+			"socket-mode":     o.socketMode,
+		} {
+			if set {
+				return fmt.Errorf("slack-webhook-url can't be used with %s, which needs a bot token", name)
+			}
+		}
+	}
+	if o.socketMode && !strings.HasPrefix(os.Getenv(slackAppTokenEnv), "xapp-") {
+		return fmt.Errorf("socket-mode needs an app-level token, starting with xapp-, in %s", slackAppTokenEnv)
+	}
+	if _, err := parseAlertCooldowns(o.alertCooldowns); err != nil {
+		return fmt.Errorf("invalid alert-cooldowns: %v", err)
+	}
+	if o.quietHours != "" {
+		if _, err := parseQuietHours(o.quietHours); err != nil {
+			return fmt.Errorf("invalid quiet-hours: %v", err)
+		}
+	}
+	if _, err := time.LoadLocation(o.quietHoursTimezone); err != nil {
+		return fmt.Errorf("invalid quiet-hours-timezone: %v", err)
+	}
+	if _, err := time.LoadLocation(o.displayTimezone); err != nil {
+		return fmt.Errorf("invalid display-timezone: %v", err)
+	}
+	if o.onlyChanges && o.historyDir == "" {
+		return fmt.Errorf("only-changes needs a history-dir")
+	}
+	if o.onlyChanges && o.weekOverWeek {
+		return fmt.Errorf("only-changes and week-over-week can't be used together")
+	}
+	views := []string{}
+	if o.output != "" && o.output != "text" && o.output != "table" {
+		return fmt.Errorf("invalid output %q, must be text or table", o.output)
+	}
+	for view, set := range map[string]bool{"digest": o.digest, "stats": o.stats, "summary": o.summary, "output table": o.output == "table"} {
+		if set {
+			views = append(views, view)
+		}
+	}
+	if len(views) > 1 {
+		sort.Strings(views)
+		return fmt.Errorf("only one of %s can be used", strings.Join(views, ", "))
+	}
+	if o.gitHubIssuesRepo != "" && os.Getenv(gitHubTokenEnv) == "" {
+		return fmt.Errorf("github-issues-repo issues are filed with the token in %s, which is not set", gitHubTokenEnv)
+	}
+	if o.jiraURL != "" && o.jiraProject == "" {
+		return fmt.Errorf("jira-url needs a jira-project")
+	}
+	if o.jiraURL != "" && os.Getenv(jiraTokenEnv) == "" {
+		return fmt.Errorf("jira-project tickets are created with the token in %s, which is not set", jiraTokenEnv)
+	}
+	if o.archiveRepo != "" {
+		if _, err := mail.ParseAddress(o.archiveAuthor); err != nil {
+			return fmt.Errorf("invalid archive-author %q: %v", o.archiveAuthor, err)
+		}
+		// any checkout will do to check the path stays inside of it
+		if _, err := archiveDir("archive", o.archivePath); err != nil {
+			return err
+		}
+	}
+	if _, err := releasewatch.ParseSortOrder(o.sortOrder); err != nil {
+		return fmt.Errorf("invalid sort: %v", err)
+	}
+	for _, path := range o.watchedUpgradePaths {
+		if _, err := releasewatch.ParseUpgradePath(path); err != nil {
+			return fmt.Errorf("invalid upgrade-paths: %v", err)
+		}
+	}
+	for _, value := range o.ignore {
+		if _, err := releasewatch.ParseIgnore(value); err != nil {
+			return err
+		}
+	}
+	for _, value := range o.slo {
+		slo, err := releasewatch.ParseSLO(value)
+		if err != nil {
+			return err
+		}
+		if o.historyDir == "" {
+			return fmt.Errorf("slo needs a history-dir to measure it with")
+		}
+		if o.historyRetention > 0 && o.historyRetention < slo.Period.MaxLength() {
+			return fmt.Errorf("history-retention must be 0 or at least %s to measure slo %q over a %s", slo.Period.MaxLength(), value, slo.Period)
+		}
+	}
+	for _, pattern := range o.streams {
+		if _, err := releasewatch.ParseStreamPattern(pattern); err != nil {
+			return fmt.Errorf("invalid streams: %v", err)
+		}
+	}
+	for _, pattern := range o.excludeStreams {
+		if _, err := releasewatch.ParseStreamPattern(pattern); err != nil {
+			return fmt.Errorf("invalid exclude-streams: %v", err)
+		}
+	}
+	for _, t := range o.streamTypes {
+		if !isStreamType(t) {
+			return fmt.Errorf("invalid stream-types: %q is not one of %s", t, strings.Join(releasewatch.StreamTypes, ", "))
+		}
+	}
+	if _, err := releasewatch.ParsePromotionLimits(o.promotionLimits); err != nil {
+		return fmt.Errorf("invalid promotion-limits: %v", err)
+	}
+	if o.buildCadenceFactor != 0 && o.buildCadenceFactor <= 1 {
+		return fmt.Errorf("build-cadence-factor must be more than 1")
+	}
+	if o.minUpgradeSuccessRate < 0 || o.minUpgradeSuccessRate > 1 {
+		return fmt.Errorf("min-upgrade-success-rate must be between 0 and 1")
+	}
+	if o.minUpgradeSuccessRate > 0 && o.upgradeWindow <= 0 {
+		return fmt.Errorf("min-upgrade-success-rate needs an upgrade-window")
+	}
+	if o.minAcceptanceRate < 0 || o.minAcceptanceRate > 1 {
+		return fmt.Errorf("min-acceptance-rate must be between 0 and 1")
+	}
+	if o.minAcceptanceRate > 0 && o.acceptanceWindow <= 0 {
+		return fmt.Errorf("min-acceptance-rate needs an acceptance-window")
+	}
+	if o.acceptanceTrendLimit < 0 {
+		return fmt.Errorf("acceptance-trend-limit must not be negative")
+	}
+	if o.topRisks < 0 {
+		return fmt.Errorf("top-risks must not be negative")
+	}
+	if o.acceptanceTrendLimit > 0 && (o.acceptanceWindow <= 0 || o.historyDir == "") {
+		return fmt.Errorf("acceptance-trend-limit needs an acceptance-window and a history-dir")
+	}
+	if o.oldestMinor > o.newestMinor {
+		return fmt.Errorf("oldest-minor 4.%d is newer than newest-minor 4.%d", o.oldestMinor, o.newestMinor)
+	}
+	return nil
+}
+
+func isStreamType(value string) bool {
+	for _, t := range releasewatch.StreamTypes {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfig re-reads the config file and applies what of it can be changed
+// at runtime:
+//   - the settings that can also be changed with the config slash command
+//   - the silences and the ignored streams
+//   - the severity routes and the escalations
+//   - the per-stream limits and the known issues
+//   - the workspaces and the tenants
+//   - the --nightly-build-jobs
+//
+// Nothing is applied unless the whole file is valid.  Flags set on the command
+// line or the environment and settings changed at runtime still take
+// precedence over the file.  Other settings only take effect on restart.
+func (o *options) reloadConfig() error {
+	config, err := readConfigFile(o.configFile)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]string{}
+	for _, setting := range configSettings {
+		if o.explicitFlags[setting.id] {
+			continue
+		}
+		if value, ok := config.values[setting.id]; ok {
+			settings[setting.id] = value
+		} else {
+			settings[setting.id] = o.baseSettings[setting.id]
+		}
+	}
+	stateMutex.Lock()
+	for id, value := range state.Settings {
+		settings[id] = value
+	}
+	stateMutex.Unlock()
+
+	jobs, err := o.reloadedNightlyBuildJobs(config)
+	if err != nil {
+		return fmt.Errorf("error reloading config file %s: %v", o.configFile, err)
+	}
+
+	o.settingsLock.Lock()
+	defer o.settingsLock.Unlock()
+	if errors := o.setSettings(settings); len(errors) > 0 {
+		return fmt.Errorf("invalid settings in config file %s: %v", o.configFile, errors)
+	}
+	stateMutex.Lock()
+	configSilences = config.silences
+	severityRoutes = config.severityRoutes
+	configIgnores = config.ignores
+	configStreamLimits = config.streamLimits
+	configWorkspaces = config.workspaces
+	configTenants = config.tenants
+	configKnownIssues = config.knownIssues
+	configEscalations = config.escalations
+	nightlyBuildJobs = jobs
+	stateMutex.Unlock()
+	invalidateReportCache()
+	return nil
+}
+
+// reloadConfigOnSignal reloads the config file whenever the process receives
+// SIGHUP.
+func (o *options) reloadConfigOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := o.reloadConfig(); err != nil {
+			klog.Errorf("not reloading the configuration: %v", err)
+			continue
+		}
+		klog.V(2).Infof("reloaded the configuration from %s", o.configFile)
+	}
+}
+
+// watchConfigFile reloads the config file whenever it changes.  The directory
+// holding the file is watched rather than the file itself, because a mounted
+// ConfigMap is updated by atomically swapping the symlinks the file is
+// reached through, which replaces the file rather than writing to it.
+func (o *options) watchConfigFile() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("not watching the config file: %v", err)
+		return
+	}
+	defer watcher.Close()
+	configFile := filepath.Clean(o.configFile)
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		klog.Errorf("not watching the config file: error watching %s: %v", filepath.Dir(configFile), err)
+		return
+	}
+
+	realPath, _ := filepath.EvalSymlinks(configFile)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			current, _ := filepath.EvalSymlinks(configFile)
+			written := filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+			if !written && (current == "" || current == realPath) {
+				continue
+			}
+			realPath = current
+			if err := o.reloadConfig(); err != nil {
+				klog.Errorf("not reloading the configuration: %v", err)
+				continue
+			}
+			klog.V(2).Infof("reloaded the configuration from %s after it changed", o.configFile)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Errorf("error watching the config file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// alertCooldown holds the alerts of the streams matching a pattern, or of the
+// problems of a severity, for a while after one fires.
+type alertCooldown struct {
+	stream   *releasewatch.StreamPattern
+	severity releasewatch.Severity
+	cooldown time.Duration
+}
+
+// parseAlertCooldowns parses the --alert-cooldowns, which are by stream
+// pattern or severity, e.g. "critical=30m,4.16.0-0.nightly=4h".
+func parseAlertCooldowns(values map[string]string) ([]alertCooldown, error) {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cooldowns := []alertCooldown{}
+	for _, key := range keys {
+		cooldown, err := time.ParseDuration(values[key])
+		if err != nil || cooldown <= 0 {
+			return nil, fmt.Errorf("the cooldown of %s must be a positive duration such as 2h, not %q", key, values[key])
+		}
+		if severity, err := releasewatch.ParseSeverity(key); err == nil {
+			cooldowns = append(cooldowns, alertCooldown{severity: severity, cooldown: cooldown})
+			continue
+		}
+		pattern, err := releasewatch.ParseStreamPattern(key)
+		if err != nil {
+			return nil, err
+		}
+		cooldowns = append(cooldowns, alertCooldown{stream: &pattern, cooldown: cooldown})
+	}
+	return cooldowns, nil
+}
+
+// alertCooldownFor returns how long the alerts of the stream are held for
+// after one fires: the longest cooldown of the patterns it matches, or else
+// that of the severity of the alert.  Zero means they aren't held.
+func (o *options) alertCooldownFor(stream string, severity releasewatch.Severity) time.Duration {
+	// validated with the other flags
+	cooldowns, _ := parseAlertCooldowns(o.alertCooldowns)
+	var byStream, bySeverity time.Duration
+	for _, c := range cooldowns {
+		switch {
+		case c.stream != nil && c.stream.Match(stream) && c.cooldown > byStream:
+			byStream = c.cooldown
+		case c.stream == nil && c.severity == severity:
+			bySeverity = c.cooldown
+		}
+	}
+	if byStream > 0 {
+		return byStream
+	}
+	return bySeverity
+}
+
+// alertSeverity is the severity of an alert about how the stream changed: the
+// most urgent of that of its problems before and after, so a recovery is as
+// urgent as the problem that went away.
+func alertSeverity(before, after releasewatch.StreamReport) releasewatch.Severity {
+	severity := after.Severity()
+	if s := before.Severity(); s != "" && (severity == "" || s.AtLeast(severity)) {
+		severity = s
+	}
+	return severity
+}
+
+// alertHeld returns true if the stream was alerted about within its cooldown,
+// in which case the alert about how it changed is held.  Its state before is
+// kept, so the changes that are still there when the cooldown ends are
+// alerted about then, and those it flapped back from are not alerted about
+// at all.
+func (o *options) alertHeld(stream string, before, after releasewatch.StreamReport, now time.Time) bool {
+	cooldown := o.alertCooldownFor(stream, alertSeverity(before, after))
+	if cooldown <= 0 {
+		return false
+	}
+	stateMutex.Lock()
+	alertedAt, ok := state.AlertedAt[stream]
+	stateMutex.Unlock()
+	if !ok || !now.Before(alertedAt.Add(cooldown)) {
+		return false
+	}
+	klog.V(2).Infof("holding the alert about %s until %s, as it was alerted about at %s", stream, alertedAt.Add(cooldown).Format(time.RFC3339), alertedAt.Format(time.RFC3339))
+	return true
+}
+
+// recordAlerts records that the streams were alerted about, which starts
+// their cooldowns.  The alerts whose cooldowns are over are forgotten.
+func (o *options) recordAlerts(streams []string, now time.Time) {
+	if len(o.alertCooldowns) == 0 || len(streams) == 0 {
+		return
+	}
+	var longest time.Duration
+	cooldowns, _ := parseAlertCooldowns(o.alertCooldowns)
+	for _, c := range cooldowns {
+		if c.cooldown > longest {
+			longest = c.cooldown
+		}
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if state.AlertedAt == nil {
+		state.AlertedAt = map[string]time.Time{}
+	}
+	for stream, alertedAt := range state.AlertedAt {
+		if !now.Before(alertedAt.Add(longest)) {
+			delete(state.AlertedAt, stream)
+		}
+	}
+	for _, stream := range streams {
+		state.AlertedAt[stream] = now
+	}
+	o.saveState()
+}
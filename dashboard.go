@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// dashboardSelector selects the series of the minors, arches and streams
+// picked in the dashboard's variables.
+const dashboardSelector = `minor=~"$minor",arch=~"$arch",stream=~"$stream"`
+
+// dashboardPanel is a Grafana panel with its query, laid out at the given
+// position of the 24 column grid.
+func dashboardPanel(id int, kind, title, expr, legend, unit string, x, y, w, h int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         id,
+		"type":       kind,
+		"title":      title,
+		"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+		"gridPos":    map[string]int{"x": x, "y": y, "w": w, "h": h},
+		"targets": []map[string]string{{
+			"refId":        "A",
+			"expr":         expr,
+			"legendFormat": legend,
+		}},
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{"unit": unit},
+		},
+	}
+}
+
+// withThreshold marks the limit on the panel, in red from the limit up.
+func withThreshold(panel map[string]interface{}, limit float64) map[string]interface{} {
+	defaults := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	defaults["thresholds"] = map[string]interface{}{
+		"mode": "absolute",
+		"steps": []map[string]interface{}{
+			{"color": "green", "value": nil},
+			{"color": "red", "value": limit},
+		},
+	}
+	defaults["custom"] = map[string]interface{}{"thresholdsStyle": map[string]string{"mode": "line"}}
+	return panel
+}
+
+// dashboardVariable is a variable of the dashboard that selects values of a
+// label of the series the selector selects, all of them by default, so e.g.
+// only the streams of the picked minors are offered.
+func dashboardVariable(name, label, selector string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       name,
+		"label":      label,
+		"type":       "query",
+		"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+		"query":      fmt.Sprintf("label_values(%s{%s}, %s)", acceptedAgeMetric, selector, name),
+		"refresh":    2,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+		"sort":       1,
+	}
+}
+
+// dashboard returns a Grafana dashboard of the report's metrics: how many
+// problems of each severity the streams have, the age of each stream's newest
+// accepted and built payloads against the staleness limits, a panel of each
+// per stream, and the streams' acceptance rates.  The minors to pick from are
+// the configured minor range, and the arches and streams are those of the
+// metrics.
+func (o *options) dashboard() map[string]interface{} {
+	minors := []string{}
+	for minor := o.newestMinor; minor >= o.oldestMinor; minor-- {
+		minors = append(minors, fmt.Sprintf("4.%d", minor))
+	}
+	minorOptions := []map[string]interface{}{}
+	for _, minor := range minors {
+		minorOptions = append(minorOptions, map[string]interface{}{"text": minor, "value": minor, "selected": false})
+	}
+	minorVariable := map[string]interface{}{
+		"name":       "minor",
+		"label":      "Minor",
+		"type":       "custom",
+		"query":      strings.Join(minors, ","),
+		"options":    minorOptions,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+
+	panels := []map[string]interface{}{}
+	for i, severity := range severities {
+		panels = append(panels, dashboardPanel(i+1, "stat", fmt.Sprintf("Problems (%s)", severity),
+			fmt.Sprintf(`sum(%s{%s,severity="%s"})`, problemsMetric, dashboardSelector, severity), "", "none", i*6, 0, 6, 4))
+	}
+	panels = append(panels,
+		withThreshold(dashboardPanel(4, "stat", "Since the last report",
+			fmt.Sprintf("time() - max(%s)", lastReportMetric), "", "s", 18, 0, 6, 4), o.missingReportLimit.Seconds()),
+		withThreshold(dashboardPanel(5, "timeseries", "Newest accepted payload age",
+			fmt.Sprintf("%s{%s}", acceptedAgeMetric, dashboardSelector), "{{stream}}", "s", 0, 4, 12, 8), o.acceptedStalenessLimit.Seconds()),
+		withThreshold(dashboardPanel(6, "timeseries", "Newest built payload age",
+			fmt.Sprintf("%s{%s}", builtAgeMetric, dashboardSelector), "{{stream}}", "s", 12, 4, 12, 8), o.builtStalenessLimit.Seconds()),
+		dashboardPanel(7, "timeseries", "Acceptance rate",
+			fmt.Sprintf("%s{%s}", acceptanceMetric, dashboardSelector), "{{stream}}", "percentunit", 0, 12, 24, 8),
+	)
+	// one row of staleness panels per stream
+	stream := withThreshold(dashboardPanel(8, "timeseries", "$stream",
+		fmt.Sprintf(`%s{stream="$stream"}`, acceptedAgeMetric), "accepted", "s", 0, 21, 12, 6), o.acceptedStalenessLimit.Seconds())
+	stream["targets"] = append(stream["targets"].([]map[string]string), map[string]string{
+		"refId":        "B",
+		"expr":         fmt.Sprintf(`%s{stream="$stream"}`, builtAgeMetric),
+		"legendFormat": "built",
+	})
+	stream["repeat"] = "stream"
+	stream["repeatDirection"] = "h"
+	stream["maxPerRow"] = 2
+	panels = append(panels,
+		map[string]interface{}{
+			"id":        9,
+			"type":      "row",
+			"title":     "Streams",
+			"collapsed": false,
+			"gridPos":   map[string]int{"x": 0, "y": 20, "w": 24, "h": 1},
+			"panels":    []interface{}{},
+		},
+		stream,
+	)
+
+	return map[string]interface{}{
+		"title":         o.dashboardTitle,
+		"uid":           o.dashboardUID,
+		"tags":          []string{"release-watcher"},
+		"timezone":      "utc",
+		"schemaVersion": 39,
+		"refresh":       "5m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				minorVariable,
+				dashboardVariable("arch", "Arch", `minor=~"$minor"`),
+				dashboardVariable("stream", "Stream", `minor=~"$minor",arch=~"$arch"`),
+			},
+		},
+		"panels": panels,
+	}
+}
+
+// runGenerateDashboard prints the dashboard as JSON, for importing into
+// Grafana or provisioning from a file.
+func (o *options) runGenerateDashboard() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.missingReportLimit <= 0 {
+		return fmt.Errorf("invalid missing-report-limit %s: must be positive", o.missingReportLimit)
+	}
+	data, err := json.MarshalIndent(o.dashboard(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error rendering dashboard: %v", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
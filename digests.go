@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// digestCheckInterval is how often the bot checks for personal digests that
+// are due.
+const digestCheckInterval = time.Minute
+
+// userDigest is when a user gets the status of their subscribed streams in a
+// direct message every day.
+type userDigest struct {
+	// Time is the time of day, e.g. 08:30, in Timezone, e.g. Europe/Berlin.
+	Time     string `json:"time"`
+	Timezone string `json:"timezone"`
+	// LastSent is when the digest was last sent, or set up.
+	LastSent time.Time `json:"lastSent"`
+}
+
+// lastDue returns the most recent time at or before now that the digest was
+// due.
+func (d userDigest) lastDue(now time.Time) time.Time {
+	location, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	clock, err := time.Parse("15:04", d.Time)
+	if err != nil {
+		return time.Time{}
+	}
+	local := now.In(location)
+	due := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+	return due
+}
+
+// handleDigestCommand sets up the user's daily digest, e.g. "digest 08:30
+// Europe/Berlin", stops it with "digest off", or shows it.
+func (o *options) handleDigestCommand(user string, args []string) string {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if len(args) == 0 {
+		digest, ok := state.Digests[user]
+		if !ok {
+			return "You don't get a daily digest.  Use digest <HH:MM> [timezone], e.g. digest 08:30 Europe/Berlin, to get one."
+		}
+		return fmt.Sprintf("You get a daily digest of your subscribed streams at %s %s", digest.Time, digest.Timezone)
+	}
+	if args[0] == "off" {
+		delete(state.Digests, user)
+		o.saveState()
+		return "You will no longer get a daily digest"
+	}
+	if len(args) > 2 {
+		return "Usage: digest <HH:MM> [timezone], e.g. digest 08:30 Europe/Berlin, or digest off"
+	}
+	if _, err := time.Parse("15:04", args[0]); err != nil {
+		return fmt.Sprintf("Sorry, %q is not a time of day such as 08:30", args[0])
+	}
+	timezone := "UTC"
+	if len(args) == 2 {
+		timezone = args[1]
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Sprintf("Sorry, %q is not a time zone such as Europe/Berlin", timezone)
+	}
+	if state.Digests == nil {
+		state.Digests = map[string]userDigest{}
+	}
+	// the digest starts at the next occurrence of the time, not right away
+	state.Digests[user] = userDigest{Time: args[0], Timezone: timezone, LastSent: time.Now()}
+	o.saveState()
+	reply := fmt.Sprintf("You will get a daily digest of your subscribed streams at %s %s", args[0], timezone)
+	if len(subscribedStreams(user)) == 0 {
+		reply += ", once you subscribe to some"
+	}
+	return reply
+}
+
+// subscribedStreams returns the streams the user is subscribed to, sorted.
+// The caller must hold stateMutex.
+func subscribedStreams(user string) []string {
+	streams := []string{}
+	for stream, users := range state.Subscriptions {
+		if containsString(users, user) {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// runDigestLoop sends the users their daily digests when they are due.  The
+// digests that fall in a quiet period are sent when it ends.
+func (o *options) runDigestLoop(ctx context.Context) {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		if _, _, quiet := o.quietPeriod(time.Now()); !quiet && o.isLeader() && startWork() {
+			o.sendDueDigests(ctx)
+			finishWork()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDueDigests sends the digests that are due, generating the report only
+// if there are any.
+func (o *options) sendDueDigests(ctx context.Context) {
+	now := time.Now()
+	stateMutex.Lock()
+	due := map[string][]string{}
+	for user, digest := range state.Digests {
+		if digest.LastSent.Before(digest.lastDue(now)) {
+			due[user] = subscribedStreams(user)
+		}
+	}
+	stateMutex.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	report, err := o.cachedOrCurrentReport(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			klog.Errorf("error generating report for digests: %v", err)
+		}
+		return
+	}
+	o.applyAcks(report)
+	streams := map[string]releasewatch.StreamReport{}
+	for _, stream := range report.Streams {
+		streams[stream.Name] = stream
+	}
+	for user, subscribed := range due {
+		if len(subscribed) > 0 {
+			// posting to a user ID delivers a direct message from the bot
+			if _, err := postMessage(PostMessage{Channel: user, Text: digestText(report, streams, subscribed)}); err != nil {
+				klog.Errorf("error sending digest to %s: %v", user, err)
+				continue
+			}
+		}
+		stateMutex.Lock()
+		if digest, ok := state.Digests[user]; ok {
+			digest.LastSent = now
+			state.Digests[user] = digest
+		}
+		stateMutex.Unlock()
+	}
+	stateMutex.Lock()
+	o.saveState()
+	stateMutex.Unlock()
+}
+
+// digestText describes the status of each of the subscribed streams.
+func digestText(report *releasewatch.Report, streams map[string]releasewatch.StreamReport, subscribed []string) string {
+	lines := []string{"*Your daily release stream digest*"}
+	for _, name := range subscribed {
+		stream, ok := streams[name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("• %s is not monitored", name))
+			continue
+		}
+		accepted := "no accepted payloads"
+		if stream.LatestAccepted != "" {
+			accepted = "latest accepted " + stream.LatestAccepted
+			if built := stream.LatestAcceptedTime(); !built.IsZero() {
+				accepted += ", built " + report.FormatTimeAgo(built)
+			}
+		}
+		if len(stream.Problems) == 0 {
+			lines = append(lines, fmt.Sprintf("• <%s|%s> is healthy, %s", stream.URL(), name, accepted))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("• <%s|%s>, %s:", stream.URL(), name, accepted))
+		for _, problem := range stream.Problems {
+			lines = append(lines, fmt.Sprintf("    %s %s", severityEmoji[problem.Severity], problem.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// escalationDedupPrefix sets the PagerDuty incidents of the escalations apart
+// from those of the severity routes, which are resolved on their own.
+const escalationDedupPrefix = "escalation/"
+
+// configEscalations are the escalations of the config file, sorted by how long
+// a problem has to last for them.  Guarded by stateMutex.
+var configEscalations = []Escalation{}
+
+// Escalation is a step of the escalation policy: once a problem has lasted
+// After, it is posted to the channels, tagging Mention, and paged for, so a
+// stream that stays broken doesn't just get the same report every day.
+type Escalation struct {
+	After time.Duration
+	// Severity is the least severe problem escalated.  All of them are if it
+	// is empty.
+	Severity releasewatch.Severity
+	Channels []string
+	Mention  string
+	// PagerDuty triggers an incident for the problem, using the routing key
+	// in the PAGERDUTY_ROUTING_KEY environment variable, which is resolved
+	// once the problem goes away.
+	PagerDuty bool
+}
+
+// escalationRule is an entry of the escalations of the config file.
+type escalationRule struct {
+	After     string   `json:"after"`
+	Severity  string   `json:"severity,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
+	Mention   string   `json:"mention,omitempty"`
+	PagerDuty bool     `json:"pagerduty,omitempty"`
+}
+
+// problemEscalation is how far a problem has been escalated.
+type problemEscalation struct {
+	// Since is when the problem was first reported.
+	Since time.Time `json:"since"`
+	// Escalated is the After of the last escalation that was taken, so the
+	// steps aren't taken again, even if the policy is reordered.  Posted is
+	// the After of the last escalation posted to its channels, which is
+	// ahead of Escalated while its page is retried.
+	Escalated time.Duration `json:"escalated,omitempty"`
+	Posted    time.Duration `json:"posted,omitempty"`
+	Paged     bool          `json:"paged,omitempty"`
+}
+
+// parseEscalations parses the escalations of the config file.
+func parseEscalations(rules []escalationRule) ([]Escalation, error) {
+	escalations := []Escalation{}
+	for i, rule := range rules {
+		after, err := parseLimit(rule.After)
+		if err != nil || after == 0 {
+			return nil, fmt.Errorf("escalation %d must have an after such as 48h", i)
+		}
+		e := Escalation{After: after, Channels: rule.Channels, Mention: rule.Mention, PagerDuty: rule.PagerDuty}
+		if rule.Severity != "" {
+			if e.Severity, err = releasewatch.ParseSeverity(rule.Severity); err != nil {
+				return nil, fmt.Errorf("escalation %d: %v", i, err)
+			}
+		}
+		if len(e.Channels) == 0 && !e.PagerDuty {
+			return nil, fmt.Errorf("escalation %d must have channels or pagerduty", i)
+		}
+		if e.Mention != "" && len(e.Channels) == 0 {
+			return nil, fmt.Errorf("escalation %d has a mention, but no channels to tag it in", i)
+		}
+		if e.PagerDuty && os.Getenv(pagerDutyRoutingKeyEnv) == "" {
+			return nil, fmt.Errorf("escalation %d uses pagerduty, but %s is not set", i, pagerDutyRoutingKeyEnv)
+		}
+		escalations = append(escalations, e)
+	}
+	sort.SliceStable(escalations, func(i, j int) bool { return escalations[i].After < escalations[j].After })
+	return escalations, nil
+}
+
+// currentEscalations returns a copy of the escalations.
+func currentEscalations() []Escalation {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]Escalation{}, configEscalations...)
+}
+
+// escalateProblems takes the escalations that are due for each problem in the
+// report, by how long ago it was first reported.  Acknowledged problems keep
+// lasting, but aren't escalated.  The problems that went away are forgotten,
+// and their incidents resolved.
+func (o *options) escalateProblems(report *releasewatch.Report) {
+	escalations := currentEscalations()
+	stateMutex.Lock()
+	if len(escalations) == 0 && len(state.Escalations) == 0 {
+		stateMutex.Unlock()
+		return
+	}
+	problems := map[string]problemEscalation{}
+	for key, e := range state.Escalations {
+		problems[key] = e
+	}
+	stateMutex.Unlock()
+
+	now := time.Now()
+	present := map[string]bool{}
+	for _, stream := range report.Streams {
+		for _, p := range stream.Acknowledged {
+			key := problemKey(stream.Name, p.Kind)
+			present[key] = true
+			if _, ok := problems[key]; !ok {
+				problems[key] = problemEscalation{Since: now}
+			}
+		}
+		for _, p := range stream.Problems {
+			key := problemKey(stream.Name, p.Kind)
+			present[key] = true
+			e, ok := problems[key]
+			if !ok {
+				e = problemEscalation{Since: now}
+			}
+			for _, step := range escalations {
+				if step.After <= e.Escalated || now.Sub(e.Since) < step.After || !p.Severity.AtLeast(step.Severity) {
+					continue
+				}
+				// the later steps wait for a step whose page failed
+				if !o.escalate(report, stream, p, &e, step) {
+					break
+				}
+				e.Escalated = step.After
+			}
+			problems[key] = e
+		}
+	}
+
+	for key, e := range problems {
+		if present[key] {
+			continue
+		}
+		if e.Paged {
+			if err := sendPagerDutyEvent(PagerDutyEvent{EventAction: "resolve", DedupKey: escalationDedupPrefix + key}); err != nil {
+				klog.Errorf("error resolving the escalated incident for %s: %v", key, err)
+				continue
+			}
+		}
+		delete(problems, key)
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state.Escalations = problems
+	o.saveState()
+}
+
+// escalate takes a step of the escalation policy for the problem, and returns
+// false if the step pages but PagerDuty couldn't be reached, so the page is
+// retried with the next report.  The step is only posted to its channels
+// once.
+func (o *options) escalate(report *releasewatch.Report, stream releasewatch.StreamReport, p releasewatch.Problem, e *problemEscalation, step Escalation) bool {
+	key := problemKey(stream.Name, p.Kind)
+	klog.V(2).Infof("escalating %s, which was first reported %s", key, report.FormatTimeAgo(e.Since))
+	text := fmt.Sprintf(":rotating_light: <%s|%s> has had this %s problem for %s, since %s: %s", stream.URL(), stream.Name, p.Severity, report.FormatAge(e.Since), report.FormatTime(e.Since), p.Message)
+	if step.Mention != "" {
+		text = step.Mention + " " + text
+	}
+	if step.After > e.Posted {
+		for _, channel := range step.Channels {
+			if _, err := postMessage(PostMessage{Channel: channel, Text: text}); err != nil {
+				klog.Errorf("error escalating %s to %s: %v", key, channel, err)
+			}
+		}
+		e.Posted = step.After
+	}
+	if !step.PagerDuty || e.Paged {
+		return true
+	}
+	err := sendPagerDutyEvent(PagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    escalationDedupPrefix + key,
+		Payload: &PagerDutyPayload{
+			Summary:  fmt.Sprintf("%s has had a %s problem for %s: %s", stream.Name, p.Severity, report.FormatAge(e.Since), p.Message),
+			Source:   stream.URL(),
+			Severity: string(p.Severity),
+		},
+	})
+	if err != nil {
+		klog.Errorf("error paging for the escalation of %s: %v", key, err)
+		return false
+	}
+	e.Paged = true
+	return true
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// mergeCheckInterval is how often the bot checks for merges that are due
+	// to have been built.
+	mergeCheckInterval = time.Minute
+	// maxGitHubEventBytes bounds the events read, before their signature is
+	// checked; GitHub caps the events it sends at 25MB.
+	maxGitHubEventBytes = 25 << 20
+)
+
+// releaseBranchRegex matches the release branches of a minor, e.g.
+// release-4.16, capturing the minor.
+var releaseBranchRegex = regexp.MustCompile(`^(?:release|openshift)-4\.([1-9][0-9]*)$`)
+
+// mergeCheck is a change merged to a release branch that the streams of its
+// minor should build a payload after within --merge-build-limit.
+type mergeCheck struct {
+	Repo     string    `json:"repo"`
+	Minor    int       `json:"minor"`
+	MergedAt time.Time `json:"mergedAt"`
+	// Change names the change, e.g. openshift/origin#123, and URL links to
+	// it.
+	Change string `json:"change"`
+	URL    string `json:"url"`
+}
+
+// gitHubPullRequestEvent is the part of a GitHub pull_request event the bot
+// uses.
+type gitHubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number   int       `json:"number"`
+		HTMLURL  string    `json:"html_url"`
+		Merged   bool      `json:"merged"`
+		MergedAt time.Time `json:"merged_at"`
+		Base     struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// gitHubPushEvent is the part of a GitHub push event the bot uses.
+type gitHubPushEvent struct {
+	Ref        string `json:"ref"`
+	Compare    string `json:"compare"`
+	After      string `json:"after"`
+	HeadCommit *struct {
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"head_commit"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// validGitHubSignature returns true if the X-Hub-Signature-256 header is the
+// HMAC of the body with the secret.
+func validGitHubSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// parseGitHubMerge returns the merge to a release branch that a GitHub event
+// is about, or false if it isn't about one.
+func parseGitHubMerge(eventType string, body []byte) (mergeCheck, bool, error) {
+	switch eventType {
+	case "pull_request":
+		event := gitHubPullRequestEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return mergeCheck{}, false, err
+		}
+		m := releaseBranchRegex.FindStringSubmatch(event.PullRequest.Base.Ref)
+		if event.Action != "closed" || !event.PullRequest.Merged || m == nil {
+			return mergeCheck{}, false, nil
+		}
+		minor, _ := strconv.Atoi(m[1])
+		return mergeCheck{
+			Repo:     event.Repository.FullName,
+			Minor:    minor,
+			MergedAt: event.PullRequest.MergedAt,
+			Change:   fmt.Sprintf("%s#%d", event.Repository.FullName, event.PullRequest.Number),
+			URL:      event.PullRequest.HTMLURL,
+		}, true, nil
+	case "push":
+		event := gitHubPushEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return mergeCheck{}, false, err
+		}
+		m := releaseBranchRegex.FindStringSubmatch(strings.TrimPrefix(event.Ref, "refs/heads/"))
+		// branch deletions have no head commit
+		if m == nil || event.HeadCommit == nil {
+			return mergeCheck{}, false, nil
+		}
+		minor, _ := strconv.Atoi(m[1])
+		after := event.After
+		if len(after) > 7 {
+			after = after[:7]
+		}
+		return mergeCheck{
+			Repo:     event.Repository.FullName,
+			Minor:    minor,
+			MergedAt: event.HeadCommit.Timestamp,
+			Change:   fmt.Sprintf("%s@%s", event.Repository.FullName, after),
+			URL:      event.Compare,
+		}, true, nil
+	}
+	return mergeCheck{}, false, nil
+}
+
+// createGitHubWebhookHandler serves POST /github/webhook, which GitHub calls
+// with the pull_request and push events of the repos it is set up for.  Each
+// merge to a release branch, e.g. release-4.16, is checked to have been
+// followed by a payload of each stream of the minor within
+// --merge-build-limit.  The requests must be signed with the webhook secret;
+// the endpoint is disabled when no secret is configured.
+func (o *options) createGitHubWebhookHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" || o.mergeBuildLimit <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, AdminError{Error: "method not allowed"})
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGitHubEventBytes))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, AdminError{Error: fmt.Sprintf("error reading event: %v", err)})
+			return
+		}
+		if !validGitHubSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			writeJSON(w, http.StatusUnauthorized, AdminError{Error: "invalid signature"})
+			return
+		}
+		check, ok, err := parseGitHubMerge(r.Header.Get("X-GitHub-Event"), body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, AdminError{Error: fmt.Sprintf("error decoding event: %v", err)})
+			return
+		}
+		if ok {
+			klog.V(2).Infof("%s was merged to the release branch of 4.%d at %s, checking it is built by %s", check.Change, check.Minor, check.MergedAt.Format(time.RFC3339), check.MergedAt.Add(o.mergeBuildLimit).Format(time.RFC3339))
+			stateMutex.Lock()
+			state.MergeChecks = append(state.MergeChecks, check)
+			o.saveState()
+			stateMutex.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// runMergeCheckLoop checks the merges that are due to have been built.  The
+// checks that fall in a quiet period are made when it ends.
+func (o *options) runMergeCheckLoop(ctx context.Context) {
+	ticker := time.NewTicker(mergeCheckInterval)
+	defer ticker.Stop()
+	for {
+		if _, _, quiet := o.quietPeriod(time.Now()); !quiet && o.isLeader() && startWork() {
+			o.checkDueMerges(ctx)
+			finishWork()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDueMerges checks that the streams of the minor of each merge that is
+// due have built a payload since it merged.  If some haven't, the channels
+// the minor's reports are routed to are told that the build system may not be
+// picking up merges, and the other pending merges of the minor are dropped,
+// so a stalled build system is reported once rather than once per merge.
+func (o *options) checkDueMerges(ctx context.Context) {
+	now := time.Now()
+	stateMutex.Lock()
+	due := []mergeCheck{}
+	for _, check := range state.MergeChecks {
+		if !check.MergedAt.Add(o.mergeBuildLimit).After(now) {
+			due = append(due, check)
+		}
+	}
+	stateMutex.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].MergedAt.Before(due[j].MergedAt)
+	})
+
+	report, err := o.cachedOrCurrentReport(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			klog.Errorf("error generating report for merge checks: %v", err)
+		}
+		return
+	}
+	report.Location = o.displayLocation()
+	resolved := map[mergeCheck]bool{}
+	stalled := map[int]bool{}
+	for _, check := range due {
+		resolved[check] = true
+		if stalled[check.Minor] {
+			continue
+		}
+		minorReport := report.ForMinor(check.Minor)
+		unbuilt := []string{}
+		for _, stream := range minorReport.Streams {
+			if !stream.LatestBuiltTime().After(check.MergedAt) {
+				unbuilt = append(unbuilt, fmt.Sprintf("<%s|%s>", stream.URL(), stream.Name))
+			}
+		}
+		if len(unbuilt) == 0 {
+			continue
+		}
+		stalled[check.Minor] = true
+		text := fmt.Sprintf(":construction: <%s|%s> merged to the release branch of 4.%d %s, but %s built no payload since.  The build system may not be picking up merges.", check.URL, check.Change, check.Minor, report.FormatTimeAgo(check.MergedAt), strings.Join(unbuilt, ", "))
+		for channel, routed := range o.routeReport(minorReport) {
+			if len(routed.Streams) == 0 {
+				continue
+			}
+			if _, err := postMessage(PostMessage{Channel: channel, Text: text}); err != nil {
+				klog.Errorf("error posting the merge check of %s to %s: %v", check.Change, channel, err)
+			}
+		}
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	pending := []mergeCheck{}
+	for _, check := range state.MergeChecks {
+		if !resolved[check] && !stalled[check.Minor] {
+			pending = append(pending, check)
+		}
+	}
+	state.MergeChecks = pending
+	o.saveState()
+}
@@ -3,11 +3,16 @@ module github.com/bparees/release-watcher
 go 1.19
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
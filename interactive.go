@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	ackActionPrefix    = "ack/"
+	snoozeActionPrefix = "snooze/"
+)
+
+// InteractionPayload is the subset of a Slack block_actions payload we use.
+type InteractionPayload struct {
+	Type    string         `json:"type"`
+	User    SlackObject    `json:"user"`
+	Channel SlackObject    `json:"channel"`
+	Message SlackMessage   `json:"message"`
+	Actions []ActionResult `json:"actions"`
+	View    *View          `json:"view"`
+}
+
+type SlackObject struct {
+	ID string `json:"id"`
+}
+
+type SlackMessage struct {
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+type ActionResult struct {
+	ActionID string `json:"action_id"`
+	Value    string `json:"value"`
+}
+
+// createInteractionHandler handles button clicks on report messages.  Slack
+// posts interactions as a form with the JSON payload in the payload field.
+func (o *options) createInteractionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := InteractionPayload{}
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+			klog.Errorf("error decoding interaction payload: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := o.handleInteraction(payload)
+		if response == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handleInteraction processes an interaction, whether it was delivered over
+// HTTP or Socket Mode, and returns the response to send to Slack, if any.
+// Slack expects a response within a few seconds, so anything slow is done in
+// the background.
+func (o *options) handleInteraction(payload InteractionPayload) interface{} {
+	klog.V(4).Infof("saw interaction: %#v\n", payload)
+	switch {
+	case payload.Type == "view_submission" && payload.View != nil && payload.View.CallbackID == configCallbackID:
+		return o.applyConfigSubmission(payload)
+	case payload.Type == "block_actions":
+		goWork(func() { o.handleBlockActions(payload) })
+	}
+	return nil
+}
+
+func (o *options) handleBlockActions(payload InteractionPayload) {
+	thread := payload.Message.ThreadTS
+	if thread == "" {
+		thread = payload.Message.TS
+	}
+	for _, action := range payload.Actions {
+		var text string
+		switch {
+		case strings.HasPrefix(action.ActionID, ackActionPrefix):
+			o.acknowledge(action.Value, payload.User.ID, 0)
+			text = fmt.Sprintf("<@%s> acknowledged %s", payload.User.ID, action.Value)
+		case strings.HasPrefix(action.ActionID, snoozeActionPrefix):
+			o.acknowledge(action.Value, payload.User.ID, snoozeDuration)
+			text = fmt.Sprintf("<@%s> snoozed %s for %s", payload.User.ID, action.Value, snoozeDuration)
+		case action.ActionID == nightlyActionID:
+			text = o.requestNightly(action.Value, payload.User.ID, payload.Channel.ID)
+			if text == "" {
+				continue
+			}
+		default:
+			// link buttons also send an interaction, there is nothing to do for them.
+			continue
+		}
+		if _, err := postMessage(PostMessage{Channel: payload.Channel.ID, ThreadTS: thread, Text: text}); err != nil {
+			klog.Errorf("error posting acknowledgement: %v", err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// gitHubTokenEnv holds the token to file --github-issues-repo issues with.
+const gitHubTokenEnv = "GITHUB_TOKEN"
+
+var gitHubClient = &http.Client{Timeout: 30 * time.Second}
+
+// gitHubIssue is the part of a GitHub issue we send and read back.
+type gitHubIssue struct {
+	Number int      `json:"number,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Body   string   `json:"body,omitempty"`
+	State  string   `json:"state,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// fileGitHubIssues opens an issue in the --github-issues-repo for each stream
+// that has had a critical problem, acknowledged or not, for longer than
+// --github-issues-after, updates the issues of the streams that still do, and
+// closes the issues of the streams that recovered.
+func (o *options) fileGitHubIssues(report *releasewatch.Report) {
+	if o.gitHubIssuesRepo == "" {
+		return
+	}
+	stateMutex.Lock()
+	criticalSince := map[string]time.Time{}
+	for stream, since := range state.CriticalSince {
+		criticalSince[stream] = since
+	}
+	issues := map[string]int{}
+	for stream, number := range state.GitHubIssues {
+		issues[stream] = number
+	}
+	stateMutex.Unlock()
+
+	now := time.Now()
+	critical := map[string]struct{}{}
+	for _, stream := range report.Streams {
+		problems := criticalProblems(stream)
+		if len(problems) == 0 {
+			continue
+		}
+		critical[stream.Name] = struct{}{}
+		since, ok := criticalSince[stream.Name]
+		if !ok {
+			since = now
+			criticalSince[stream.Name] = since
+		}
+		if now.Sub(since) < o.gitHubIssuesAfter {
+			continue
+		}
+		issue := gitHubIssue{
+			Title: fmt.Sprintf("%s is critical", stream.Name),
+			Body:  issueBody(stream, problems, since, now),
+		}
+		if number, ok := issues[stream.Name]; ok {
+			if _, err := o.callGitHub(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", o.gitHubIssuesRepo, number), issue); err != nil {
+				klog.Errorf("error updating the issue for %s: %v", stream.Name, err)
+			}
+			continue
+		}
+		issue.Labels = o.gitHubIssuesLabels
+		created, err := o.callGitHub(http.MethodPost, fmt.Sprintf("/repos/%s/issues", o.gitHubIssuesRepo), issue)
+		if err != nil {
+			klog.Errorf("error opening an issue for %s: %v", stream.Name, err)
+			continue
+		}
+		if created.Number == 0 {
+			// a dry run opens no issue to update or close later
+			continue
+		}
+		klog.V(2).Infof("opened issue %d for %s", created.Number, stream.Name)
+		issues[stream.Name] = created.Number
+	}
+
+	for stream := range criticalSince {
+		if _, ok := critical[stream]; !ok {
+			delete(criticalSince, stream)
+		}
+	}
+	for stream, number := range issues {
+		if _, ok := critical[stream]; ok {
+			continue
+		}
+		comment := gitHubIssue{Body: fmt.Sprintf("%s recovered at %s.", stream, now.UTC().Format(time.RFC822))}
+		if _, err := o.callGitHub(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", o.gitHubIssuesRepo, number), comment); err != nil {
+			klog.Errorf("error commenting on the issue for %s: %v", stream, err)
+			continue
+		}
+		if _, err := o.callGitHub(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", o.gitHubIssuesRepo, number), gitHubIssue{State: "closed"}); err != nil {
+			klog.Errorf("error closing the issue for %s: %v", stream, err)
+			continue
+		}
+		klog.V(2).Infof("closed issue %d for %s", number, stream)
+		delete(issues, stream)
+	}
+
+	stateMutex.Lock()
+	state.CriticalSince = criticalSince
+	state.GitHubIssues = issues
+	stateMutex.Unlock()
+}
+
+// criticalProblems returns the stream's critical problems, including the
+// acknowledged ones, since acknowledging a problem doesn't resolve it.
+func criticalProblems(stream releasewatch.StreamReport) []releasewatch.Problem {
+	problems := []releasewatch.Problem{}
+	for _, p := range stream.Problems {
+		if p.Severity == releasewatch.SeverityCritical {
+			problems = append(problems, p)
+		}
+	}
+	for _, p := range stream.Acknowledged {
+		if p.Severity == releasewatch.SeverityCritical {
+			problems = append(problems, p.Problem)
+		}
+	}
+	return problems
+}
+
+// issueBody describes the critical problems of a stream in markdown.
+func issueBody(stream releasewatch.StreamReport, problems []releasewatch.Problem, since, now time.Time) string {
+	body := fmt.Sprintf("[%s](%s) has been critical since %s.\n\n", stream.Name, stream.URL(), since.UTC().Format(time.RFC822))
+	for _, p := range problems {
+		body += "- " + p.Message + "\n"
+		for _, failure := range p.BlockingJobFailures {
+			body += "  - " + failure.String() + "\n"
+		}
+		if p.RejectionCauses != nil {
+			body += "  - rejection causes: " + p.RejectionCauses.String() + "\n"
+		}
+		for _, risk := range p.Risks {
+			body += "  - risk: " + risk.String() + "\n"
+		}
+		if p.ComponentRegressions != nil {
+			body += fmt.Sprintf("  - [component readiness](%s): %s\n", p.ComponentRegressions.URL, p.ComponentRegressions.String())
+		}
+		if p.Changelog != nil {
+			body += "  - unaccepted changes: " + p.Changelog.String() + "\n"
+		}
+	}
+	body += fmt.Sprintf("\nUpdated by release-watcher at %s.  The issue is closed when the stream recovers.\n", now.UTC().Format(time.RFC822))
+	return body
+}
+
+// callGitHub sends the issue to the GitHub api and returns the issue it
+// responds with.
+func (o *options) callGitHub(method, path string, issue gitHubIssue) (*gitHubIssue, error) {
+	if slackDryRun {
+		klog.Infof("dry run, not calling GitHub: %s %s", method, path)
+		return &gitHubIssue{}, nil
+	}
+	body, _ := json.Marshal(issue)
+	req, err := http.NewRequest(method, o.gitHubAPIURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv(gitHubTokenEnv))
+	resp, err := gitHubClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	result := &gitHubIssue{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("error decoding the response to %s %s: %v", method, path, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+const (
+	// jiraTokenEnv holds the token to create and transition --jira-project
+	// tickets with.  It is sent as a bearer token, which is how Jira Data
+	// Center takes personal access tokens, unless jiraUserEnv is set, in
+	// which case the user and the token are sent with basic auth, which is
+	// how Jira Cloud takes api tokens.
+	jiraTokenEnv = "JIRA_TOKEN"
+	jiraUserEnv  = "JIRA_USER"
+)
+
+var jiraClient = &http.Client{Timeout: 30 * time.Second}
+
+// fileJiraTickets creates a ticket in the --jira-project for each stream that
+// is building payloads but has accepted none of them, and transitions the
+// tickets of the streams that recovered with --jira-resolve-transition.
+func (o *options) fileJiraTickets(report *releasewatch.Report) {
+	if o.jiraURL == "" {
+		return
+	}
+	stateMutex.Lock()
+	tickets := map[string]string{}
+	for stream, key := range state.JiraTickets {
+		tickets[stream] = key
+	}
+	stateMutex.Unlock()
+
+	failing := map[string]struct{}{}
+	for _, stream := range report.Streams {
+		problem, ok := noAcceptedPayloadsProblem(stream)
+		if !ok {
+			continue
+		}
+		failing[stream.Name] = struct{}{}
+		if _, ok := tickets[stream.Name]; ok {
+			continue
+		}
+		fields := map[string]interface{}{
+			"project":     map[string]string{"key": o.jiraProject},
+			"issuetype":   map[string]string{"name": o.jiraIssueType},
+			"summary":     fmt.Sprintf("%s has no accepted payloads", stream.Name),
+			"description": ticketDescription(stream, problem),
+		}
+		created := struct {
+			Key string `json:"key"`
+		}{}
+		if err := o.callJira(http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+			klog.Errorf("error creating a ticket for %s: %v", stream.Name, err)
+			continue
+		}
+		if created.Key == "" {
+			// a dry run creates no ticket to transition later
+			continue
+		}
+		klog.V(2).Infof("created ticket %s for %s", created.Key, stream.Name)
+		tickets[stream.Name] = created.Key
+	}
+
+	for stream, key := range tickets {
+		if _, ok := failing[stream]; ok {
+			continue
+		}
+		if err := o.resolveJiraTicket(stream, key); err != nil {
+			klog.Errorf("error resolving ticket %s for %s: %v", key, stream, err)
+			continue
+		}
+		klog.V(2).Infof("resolved ticket %s for %s", key, stream)
+		delete(tickets, stream)
+	}
+
+	stateMutex.Lock()
+	state.JiraTickets = tickets
+	stateMutex.Unlock()
+}
+
+// noAcceptedPayloadsProblem returns the stream's critical, acknowledged or
+// not, problem of having built payloads but accepted none of them.
+func noAcceptedPayloadsProblem(stream releasewatch.StreamReport) (releasewatch.Problem, bool) {
+	for _, p := range criticalProblems(stream) {
+		if p.Kind == releasewatch.ProblemNoAcceptedPayloads {
+			return p, true
+		}
+	}
+	return releasewatch.Problem{}, false
+}
+
+// ticketDescription describes the problem, with the blocking jobs that
+// failed in the rejected payloads, in Jira's markup.
+func ticketDescription(stream releasewatch.StreamReport, problem releasewatch.Problem) string {
+	description := fmt.Sprintf("[%s|%s]: %s\n", stream.Name, stream.URL(), problem.Message)
+	if len(problem.BlockingJobFailures) > 0 {
+		description += "\nFailing blocking jobs:\n"
+		for _, failure := range problem.BlockingJobFailures {
+			description += "* " + failure.String()
+			if failure.RunURL != "" {
+				description += fmt.Sprintf(" ([latest failure|%s])", failure.RunURL)
+			}
+			description += "\n"
+		}
+	}
+	if problem.RejectionCauses != nil {
+		description += "\nRejection causes: " + problem.RejectionCauses.String() + "\n"
+	}
+	if len(problem.Risks) > 0 {
+		description += "\nAssessed risks:\n"
+		for _, risk := range problem.Risks {
+			description += "* " + risk.String() + "\n"
+		}
+	}
+	if problem.ComponentRegressions != nil {
+		description += fmt.Sprintf("\n[Component readiness|%s]: %s\n", problem.ComponentRegressions.URL, problem.ComponentRegressions.String())
+	}
+	rejected, more := problem.ListedRejectedPayloads()
+	if len(rejected) > 0 {
+		description += "\nRejected payloads:\n"
+		for _, payload := range rejected {
+			description += fmt.Sprintf("* [%s|%s]\n", payload, stream.PayloadURL(payload))
+		}
+		if more > 0 {
+			description += fmt.Sprintf("* and %d more\n", more)
+		}
+	}
+	return description + "\nCreated by release-watcher, which transitions the ticket when the stream accepts a payload.\n"
+}
+
+// resolveJiraTicket comments on the ticket that the stream recovered and
+// transitions it with --jira-resolve-transition, if the ticket has it.
+func (o *options) resolveJiraTicket(stream, key string) error {
+	comment := map[string]string{"body": fmt.Sprintf("%s recovered at %s.", stream, time.Now().UTC().Format(time.RFC822))}
+	if err := o.callJira(http.MethodPost, "/rest/api/2/issue/"+key+"/comment", comment, nil); err != nil {
+		return err
+	}
+	transitions := struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}{}
+	if err := o.callJira(http.MethodGet, "/rest/api/2/issue/"+key+"/transitions", nil, &transitions); err != nil {
+		return err
+	}
+	for _, t := range transitions.Transitions {
+		if t.Name == o.jiraResolveTransition {
+			return o.callJira(http.MethodPost, "/rest/api/2/issue/"+key+"/transitions", map[string]interface{}{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+	}
+	// the recovery was commented on, so the ticket is handled rather than
+	// commented on again with every report, and left for someone to close
+	klog.Errorf("ticket %s of %s has no %q transition, leaving it open", key, stream, o.jiraResolveTransition)
+	return nil
+}
+
+// callJira sends the payload, if any, to the Jira api and decodes the
+// response into result, if it is given.
+func (o *options) callJira(method, path string, payload, result interface{}) error {
+	if slackDryRun && method != http.MethodGet {
+		klog.Infof("dry run, not calling Jira: %s %s", method, path)
+		return nil
+	}
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, o.jiraURL+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user := os.Getenv(jiraUserEnv); user != "" {
+		req.SetBasicAuth(user, os.Getenv(jiraTokenEnv))
+	} else {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(jiraTokenEnv))
+	}
+	resp, err := jiraClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding the response to %s %s: %v", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// microTimeFormat is the format of the times in a Lease.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	// a standby takes over once the lease hasn't been renewed for
+	// leaseDuration.  The leader gives up if it can't renew the lease within
+	// renewDeadline, which leaves it time to stop posting before a standby
+	// can take over.
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// leading is set while this replica holds the leader lease.
+var leading int32
+
+// isLeader returns whether this replica should post to Slack.  Without leader
+// election every replica is the leader.
+func (o *options) isLeader() bool {
+	return !o.leaderElection || atomic.LoadInt32(&leading) == 1
+}
+
+// Lease holds the fields we use of a coordination.k8s.io/v1 Lease.
+type Lease struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       LeaseSpec  `json:"spec"`
+}
+
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type LeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// kubeClient makes requests to the Kubernetes API as the pod's service
+// account.
+type kubeClient struct {
+	host   string
+	client *http.Client
+}
+
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the cluster CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("error parsing the cluster CA")
+	}
+	return &kubeClient{
+		host: "https://" + host + ":" + port,
+		client: &http.Client{
+			Timeout:   renewDeadline / 2,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// do sends the request and decodes the response into out.  It returns the
+// response status code along with any error.
+func (c *kubeClient) do(method, path string, body, out interface{}) (int, error) {
+	data := []byte{}
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, c.host+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	// service account tokens are rotated, so the token is read on every request
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return 0, fmt.Errorf("error reading the service account token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error calling %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("%s %s failed: %s: %s", method, path, resp.Status, data)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding %s %s response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// leaderElector competes for a Lease with the other replicas.
+type leaderElector struct {
+	client    *kubeClient
+	path      string
+	namespace string
+	name      string
+	identity  string
+
+	// the lease as last seen, and when we saw it change.  Expiry is judged
+	// by our own clock from when the lease was last seen to change, rather
+	// than by the renew time in the lease, so clock skew between replicas
+	// doesn't matter.
+	observed     LeaseSpec
+	observedTime time.Time
+}
+
+// runLeaderElection blocks until this replica becomes the leader, calls
+// onStartedLeading, and then keeps renewing the lease.  The process exits if
+// the lease is lost, so it can restart as a standby.
+func (o *options) runLeaderElection(onStartedLeading func()) {
+	client, err := newInClusterClient()
+	if err != nil {
+		klog.Fatalf("error setting up leader election: %v", err)
+	}
+	namespace := o.leaderElectionNamespace
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			klog.Fatalf("error setting up leader election: no namespace given and error reading the pod namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("error setting up leader election: %v", err)
+	}
+	le := &leaderElector{
+		client:    client,
+		path:      fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", namespace),
+		namespace: namespace,
+		name:      o.leaderElectionLease,
+		identity:  identity,
+	}
+
+	klog.Infof("waiting to acquire the leader lease %s/%s as %s", namespace, le.name, identity)
+	for {
+		acquired, err := le.tryAcquireOrRenew()
+		if err != nil {
+			klog.Errorf("error acquiring the leader lease: %v", err)
+		}
+		if acquired {
+			break
+		}
+		time.Sleep(retryPeriod)
+	}
+	klog.Infof("acquired the leader lease %s/%s", namespace, le.name)
+	atomic.StoreInt32(&leading, 1)
+	onStartedLeading()
+
+	renewed := time.Now()
+	for {
+		time.Sleep(retryPeriod)
+		acquired, err := le.tryAcquireOrRenew()
+		if err != nil {
+			klog.Errorf("error renewing the leader lease: %v", err)
+		}
+		if acquired {
+			renewed = time.Now()
+			continue
+		}
+		if err == nil || time.Since(renewed) > renewDeadline {
+			atomic.StoreInt32(&leading, 0)
+			klog.Fatalf("lost the leader lease %s/%s", namespace, le.name)
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it is free or expired, or renews it if
+// we already hold it.  It returns whether we hold the lease.
+func (le *leaderElector) tryAcquireOrRenew() (bool, error) {
+	now := time.Now()
+	nowTime := now.UTC().Format(microTimeFormat)
+	lease := &Lease{}
+	status, err := le.client.do("GET", le.path+"/"+le.name, nil, lease)
+	if status == http.StatusNotFound {
+		lease = &Lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   ObjectMeta{Name: le.name, Namespace: le.namespace},
+			Spec: LeaseSpec{
+				HolderIdentity:       le.identity,
+				LeaseDurationSeconds: int(leaseDuration.Seconds()),
+				AcquireTime:          nowTime,
+				RenewTime:            nowTime,
+			},
+		}
+		status, err := le.client.do("POST", le.path, lease, lease)
+		if status == http.StatusConflict {
+			// another replica created it first
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		le.observed, le.observedTime = lease.Spec, now
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if lease.Spec != le.observed {
+		le.observed, le.observedTime = lease.Spec, now
+	}
+	held := lease.Spec.HolderIdentity == le.identity
+	duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	if !held && lease.Spec.HolderIdentity != "" && le.observedTime.Add(duration).After(now) {
+		return false, nil
+	}
+
+	if !held {
+		lease.Spec.HolderIdentity = le.identity
+		lease.Spec.AcquireTime = nowTime
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(leaseDuration.Seconds())
+	lease.Spec.RenewTime = nowTime
+	// the update carries the resource version we read, so it fails if
+	// another replica changed the lease in the meantime
+	status, err = le.client.do("PUT", le.path+"/"+le.name, lease, lease)
+	if status == http.StatusConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	le.observed, le.observedTime = lease.Spec, now
+	return true, nil
+}
@@ -1,40 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"regexp"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/bparees/release-watcher/pkg/releasewatch"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/klog"
 )
 
-const (
-	baseReleaseAPIUrl   = "https://amd64.ocp.releases.ci.openshift.org"
-	acceptedReleasePath = "/api/v1/releasestreams/accepted"
-	allReleasePath      = "/api/v1/releasestreams/all"
-	releaseStreamUrl    = "https://amd64.ocp.releases.ci.openshift.org/#%s"
-)
-
-var (
-	// match these two formats:
-	// 4.NNN.0-0.ci
-	// 4.NNN.0-0.nightly
-	zReleaseRegex     = regexp.MustCompile(`4\.([1-9][0-9]*)\.0-0\.(ci|nightly)`)
-	extractMinorRegex = regexp.MustCompile(`4\.([1-9][0-9]*)\.[0-9]+`)
-	// YYYY-MM-DD-HHMMSS
-	extractDateRegex = regexp.MustCompile(`([0-9]{4})-([0-9]{2})-([0-9]{2})-([0-9]{2})([0-9]{2})([0-9]{2})$`)
-)
-
 // TODO
 // add arguments:
 //   args:
 //     release stream api url
 //     oldest minor version to care about
 //     channel/alias to notify in report
-// Sort/format report with sections/headers and sort by release version?
 // What to do with the case: recent builds are newer than a week, but older than a day, so there
 //   will be no recently accepted payload expected, but it also won't be reported as a stale build stream
 // Just ignore them?  (If there are no accepted payloads period, it will still be flagged)
@@ -44,15 +32,162 @@ var (
 //   no accepted builds in the stream when builds exist in the stream - we are completely failing to accept payloads, DIRE
 //   no builds exist in the stream - either there have been no changes in the code(ok) or our build system is broken (not ok).  - ????
 //   no build newer than a week exists in the stream - either there have been no changes in the code(ok) or our build system is broken (not ok).  - ????
+//     with --merge-check-repos, changes merged to the release branch since the newest build mean the build system is broken
+//     with a GitHub webhook, a merge to the release branch that no stream builds within --merge-build-limit is reported as soon as it is due
 
 type options struct {
-	releaseAPIUrl          string
-	oldestMinor            int
-	newestMinor            int
-	slackAlias             string
-	acceptedStalenessLimit time.Duration
-	builtStalenessLimit    time.Duration
-	upgradeStalenessLimit  time.Duration
+	// settingsLock guards the settings that can be changed while the bot is
+	// running: the staleness limits and the minor range.
+	settingsLock sync.RWMutex
+
+	releaseAPIURLs             []string
+	arch                       string
+	sippyURL                   string
+	topRisks                   int
+	incidentFeedURL            string
+	componentReadiness         bool
+	jobArtifactsURL            string
+	acceptanceWindow           time.Duration
+	historyDir                 string
+	historyRetention           time.Duration
+	weekOverWeek               bool
+	minAcceptanceRate          float64
+	acceptanceTrendLimit       float64
+	buildCadenceFactor         float64
+	verificationStalenessLimit time.Duration
+	watchedUpgradePaths        []string
+	upgradeWindow              time.Duration
+	phaseWindow                time.Duration
+	tagDetails                 bool
+	rejectionStreakLimit       int
+	minUpgradeSuccessRate      float64
+	archSkewLimit              time.Duration
+	publishStalenessLimit      time.Duration
+	cincinnatiURL              string
+	promotionLimits            map[string]string
+	devPreviewStalenessLimit   time.Duration
+	releasePaths               bool
+	streams                    []string
+	excludeStreams             []string
+	streamTypes                []string
+	ignore                     []string
+	slo                        []string
+	statsdAddress              string
+	statsdPrefix               string
+	statsdTags                 bool
+	oldestMinor                int
+	newestMinor                int
+	slackAlias                 string
+	acceptedStalenessLimit     time.Duration
+	builtStalenessLimit        time.Duration
+	upgradeStalenessLimit      time.Duration
+	threadDetails              bool
+	sortOrder                  string
+	displayTimezone            string
+	summary                    bool
+	digest                     bool
+	onlyChanges                bool
+	stats                      bool
+	output                     string
+	noColor                    bool
+	printSchema                bool
+	outputFile                 string
+	outputFileCopies           int
+	pushgatewayURL             string
+	pushgatewayJob             string
+	serveMetrics               bool
+	alertRulesFormat           string
+	alertRulesName             string
+	missingReportLimit         time.Duration
+	dashboardTitle             string
+	dashboardUID               string
+	manifestsMode              string
+	manifestsName              string
+	manifestsNamespace         string
+	manifestsImage             string
+	manifestsSchedule          string
+	manifestsSecret            string
+	refreshInterval            time.Duration
+	diffFormat                 string
+	verbose                    bool
+	verbosePayloads            int
+	blame                      bool
+	gitHubAPIURL               string
+	mergeCheckRepos            []string
+	reportChannel              string
+	slackWebhookURL            string
+	messageTemplate            string
+	reportInterval             time.Duration
+	updateInPlace              bool
+	pinReport                  bool
+	ackReactions               []string
+	channelMap                 map[string]string
+	slackAliasMap              map[string]string
+	socketMode                 bool
+	stateFile                  string
+	pollInterval               time.Duration
+	alertCooldowns             map[string]string
+	reportCacheInterval        time.Duration
+	mergeBuildLimit            time.Duration
+	adminUsers                 []string
+	reportNowUsers             []string
+	nightlyBuildJobs           map[string]string
+	nightlyBuildUsers          []string
+	gangwayURL                 string
+	quietHours                 string
+	quietHoursTimezone         string
+	configFile                 string
+	leaderElection             bool
+	leaderElectionLease        string
+	releaseWatches             bool
+	releaseWatchNamespace      string
+	// leaderElectionNamespace defaults to the namespace of the pod.
+	leaderElectionNamespace string
+	// uploadURL is the bucket to upload the scheduled reports to.
+	uploadURL      string
+	uploadEndpoint string
+	uploadRegion   string
+	// archiveRepo is the git repo to commit the scheduled reports to.
+	archiveRepo   string
+	archiveBranch string
+	archivePath   string
+	archiveAuthor string
+	// gitHubIssuesRepo is the repo to file issues for critical streams in.
+	gitHubIssuesRepo   string
+	gitHubIssuesAfter  time.Duration
+	gitHubIssuesLabels []string
+	// jiraURL is the Jira to file tickets for streams with no accepted
+	// payloads in.
+	jiraURL               string
+	jiraProject           string
+	jiraIssueType         string
+	jiraResolveTransition string
+	alertmanagerURL       string
+	dryRun                bool
+	shutdownTimeout       time.Duration
+
+	apiTimeout time.Duration
+	proxyURL   string
+	// caFile and insecureSkipTLSVerify only apply to the release reporting
+	// api.
+	caFile                string
+	insecureSkipTLSVerify bool
+	apiToken              string
+	apiTokenFile          string
+	cacheDir              string
+	apiConcurrency        int
+	apiRate               float64
+	recordDir             string
+	replayDir             string
+	watcher               *releasewatch.Watcher
+	// history is where the reports are saved, if --history-dir is set.
+	history *releasewatch.History
+
+	// flags, explicitFlags and baseSettings record how the command line
+	// was parsed, so the config file can be reloaded.
+	flags         *pflag.FlagSet
+	explicitFlags map[string]bool
+	baseSettings  map[string]string
 }
 
 func main() {
@@ -60,6 +195,9 @@ func main() {
 	root.AddCommand(
 		newReportCommand(),
 		newBotCommand(),
+		newGenerateCommand(),
+		newTUICommand(),
+		newPayloadDiffCommand(),
 	)
 
 	original := flag.CommandLine
@@ -68,14 +206,25 @@ func main() {
 	original.Set("v", "2")
 
 	root.PersistentFlags().AddGoFlag(original.Lookup("v"))
-	if err := root.Execute(); err != nil {
+	// interrupting the process cancels the work in progress
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	if err := root.ExecuteContext(ctx); err != nil {
 		klog.Exitf("error: %v", err)
 	}
 }
 
+const (
+	// weekOverWeekAge is how long before a report the report it is compared
+	// with by --week-over-week was generated, give or take
+	// weekOverWeekTolerance.
+	weekOverWeekAge       = 7 * 24 * time.Hour
+	weekOverWeekTolerance = 24 * time.Hour
+)
+
 func newReportCommand() *cobra.Command {
 	o := &options{
-		releaseAPIUrl: baseReleaseAPIUrl,
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
 	}
 	cmd := &cobra.Command{
 		Use:   "report",
@@ -84,17 +233,203 @@ func newReportCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return o.runReport()
+			if o.printSchema {
+				return printReportSchema()
+			}
+			err := o.loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if o.watcher, err = o.newWatcher(); err != nil {
+				return err
+			}
+			if err := o.openHistory(); err != nil {
+				return err
+			}
+			return o.runReport(cmd.Context())
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.BoolVar(&o.verbose, "verbose", false, "List the newest payloads of each stream with problems, with their phase, when they were built and when they were accepted")
+	flagset.IntVar(&o.verbosePayloads, "verbose-payloads", 5, "How many payloads of each stream --verbose lists")
+	flagset.BoolVar(&o.blame, "blame", false, "List the pull requests, with their repos and authors, that went into the stale streams since their newest accepted payload, as candidates to revert")
+	flagset.StringVar(&o.gitHubAPIURL, "github-api-url", releasewatch.DefaultGitHubAPIURL, "GitHub api to look up the authors of the pull requests --blame lists in, with the token in the GITHUB_TOKEN environment variable.  Leave empty to list them without authors.")
+	flagset.StringVar(&o.outputFile, "output-file", "", "Write the report to this file instead of printing it.  The file is replaced atomically, so a reader such as a web server always sees a complete report.")
+	flagset.IntVar(&o.outputFileCopies, "output-file-copies", 0, "How many timestamped copies of --output-file, e.g. report.txt.20240501T101010Z, to keep next to it.  0 keeps none.")
+	flagset.StringVar(&o.slackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook to post the report to, e.g. https://hooks.slack.com/services/T000/B000/XXXX, as the bot would post it to --report-channel, for teams without a Slack app")
+	flagset.StringVar(&o.pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push the report's metrics to, e.g. http://pushgateway:9091, so a cron job feeds the same alerts as a long-running exporter")
+	flagset.StringVar(&o.pushgatewayJob, "pushgateway-job", "release-watcher", "Job to push the --pushgateway-url metrics as.  Each push replaces the job's previous metrics.")
+	flagset.BoolVar(&o.summary, "summary", false, "Print one line per minor version with a status glyph and the age of its newest accepted payload, e.g. for a Slack channel topic, instead of the full report")
+	flagset.BoolVar(&o.digest, "digest", false, "Print the problems grouped by kind, with the streams that have each and counts by severity, instead of the full report.  Reads better than the full report when many streams break at once, e.g. during a registry outage.")
+	flagset.BoolVar(&o.onlyChanges, "only-changes", false, "Only report the streams whose problems appeared, resolved or changed severity since the previous report saved in --history-dir, for interim updates between full reports")
+	flagset.BoolVar(&o.stats, "stats", false, "Print the number and the minimum, median and maximum age of each stream's payloads built since its newest accepted one that weren't accepted, with a histogram of their ages, instead of the full report")
+	flagset.StringVar(&o.output, "output", "text", "How to print the report: \"text\" lists the problems of each stream, and \"table\" prints a table with a row per stream, with its newest built, accepted and upgraded to payloads, their ages and its status.")
+	flagset.BoolVar(&o.noColor, "no-color", false, "Print the report without colors.  They are only used when printing to a terminal, and the NO_COLOR environment variable turns them off too.")
+	flagset.BoolVar(&o.printSchema, "print-schema", false, fmt.Sprintf("Print the JSON Schema, version %d, of the reports saved as JSON in --history-dir and by the bot's --archive-repo and --upload-url, instead of generating a report", releasewatch.ReportSchemaVersion))
+	addSharedFlags(flagset, o)
+	return cmd
+}
+
+// printReportSchema prints the JSON Schema of the JSON reports.
+func printReportSchema() error {
+	data, err := json.MarshalIndent(releasewatch.ReportSchema(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error rendering the report schema: %v", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func newGenerateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate configuration for other tools from the settings",
+	}
+	cmd.AddCommand(
+		newGenerateAlertRulesCommand(),
+		newGenerateDashboardCommand(),
+		newGenerateManifestsCommand(),
+	)
+	return cmd
+}
+
+func newGenerateAlertRulesCommand() *cobra.Command {
+	o := &options{
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
+	}
+	cmd := &cobra.Command{
+		Use:   "alert-rules",
+		Short: "Print Prometheus alerting rules on the report's metrics that match the configured staleness limits",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.loadConfig(cmd.Flags()); err != nil {
+				return err
+			}
+			return o.runGenerateAlertRules()
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&o.alertRulesFormat, "format", alertRulesPrometheusRule, fmt.Sprintf("How to render the rules: %q for a PrometheusRule of the Prometheus Operator or %q for a plain Prometheus rules file", alertRulesPrometheusRule, alertRulesFile))
+	flagset.StringVar(&o.alertRulesName, "name", "release-watcher", "Name of the PrometheusRule and of the rule group")
+	flagset.DurationVar(&o.missingReportLimit, "missing-report-limit", 6*time.Hour, "How long the metrics can go without a new report before alerting that the reports stopped, e.g. a few report intervals")
+	addSharedFlags(flagset, o)
+	return cmd
+}
+
+func newGenerateDashboardCommand() *cobra.Command {
+	o := &options{
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
+	}
+	cmd := &cobra.Command{
+		Use:   "dashboard",
+		Short: "Print a Grafana dashboard of the report's metrics for the configured minors and staleness limits",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.loadConfig(cmd.Flags()); err != nil {
+				return err
+			}
+			return o.runGenerateDashboard()
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&o.dashboardTitle, "title", "Release streams", "Title of the dashboard")
+	flagset.StringVar(&o.dashboardUID, "uid", "release-watcher", "Unique id of the dashboard, which importing it again overwrites")
+	flagset.DurationVar(&o.missingReportLimit, "missing-report-limit", 6*time.Hour, "How long the metrics can go without a new report before the time since the last report is shown in red, e.g. a few report intervals")
+	addSharedFlags(flagset, o)
+	return cmd
+}
+
+func newGenerateManifestsCommand() *cobra.Command {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:   "manifests [-- bot or report flags]",
+		Short: "Print Kubernetes manifests that deploy the bot or run the report on a schedule with the given config file and flags",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runGenerateManifests(args)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&o.manifestsMode, "mode", manifestsBot, fmt.Sprintf("What to deploy: %q for a Deployment of the bot with a Service and a ServiceMonitor scraping its /metrics, or %q for a CronJob running the report", manifestsBot, manifestsReport))
+	flagset.StringVar(&o.manifestsName, "name", "release-watcher", "Name of the objects")
+	flagset.StringVar(&o.manifestsNamespace, "namespace", "release-watcher", "Namespace of the objects")
+	flagset.StringVar(&o.manifestsImage, "image", "quay.io/bparees/release-watcher:latest", "Image to run")
+	flagset.StringVar(&o.manifestsSchedule, "schedule", "0 */6 * * *", "Cron schedule of the report's CronJob")
+	flagset.StringVar(&o.manifestsSecret, "secret", "release-watcher", "Secret whose keys are set as environment variables of the workload, e.g. TOKEN and WEBHOOK_TOKEN.  It isn't generated, so tokens don't end up in the manifests, and it is optional.")
+	flagset.StringVar(&o.configFile, "config-file", "", "Config file to put in a ConfigMap mounted into the workload")
+	return cmd
+}
+
+func newTUICommand() *cobra.Command {
+	o := &options{
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
+	}
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Show the streams in a live-updating terminal dashboard",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := o.loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if o.watcher, err = o.newWatcher(); err != nil {
+				return err
+			}
+			if err := o.openHistory(); err != nil {
+				return err
+			}
+			return o.runTUI(cmd.Context())
 		},
 	}
 	flagset := cmd.Flags()
+	flagset.DurationVar(&o.refreshInterval, "refresh-interval", 5*time.Minute, "How often to regenerate the report shown.  Press r to regenerate it right away.")
+	flagset.IntVar(&o.verbosePayloads, "payloads", 10, "How many of a stream's newest payloads to list in its details")
+	addSharedFlags(flagset, o)
+	return cmd
+}
+
+func newPayloadDiffCommand() *cobra.Command {
+	o := &options{
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
+	}
+	cmd := &cobra.Command{
+		Use:   "payload-diff <from-tag> <to-tag>",
+		Short: "Print the component versions, images and pull requests that changed between two payloads",
+		Args:  cobra.ExactArgs(2),
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := o.loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if o.watcher, err = o.newWatcher(); err != nil {
+				return err
+			}
+			return o.runPayloadDiff(cmd.Context(), args[0], args[1])
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&o.diffFormat, "format", diffFormatText, fmt.Sprintf("How to print the diff: %q, %q or %q", diffFormatText, diffFormatMarkdown, diffFormatJSON))
 	addSharedFlags(flagset, o)
 	return cmd
 }
 
 func newBotCommand() *cobra.Command {
 	o := &options{
-		releaseAPIUrl: baseReleaseAPIUrl,
+		releaseAPIURLs: []string{releasewatch.DefaultReleaseAPIURL},
+		// the bot serves the metrics of its cached report on /metrics
+		serveMetrics: true,
 	}
 	cmd := &cobra.Command{
 		Use:   "bot",
@@ -103,35 +438,410 @@ func newBotCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return o.runBot()
+			err := o.loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if o.watcher, err = o.newWatcher(); err != nil {
+				return err
+			}
+			if err := o.openHistory(); err != nil {
+				return err
+			}
+			return o.runBot(cmd.Context())
 		},
 	}
 
 	flagset := cmd.Flags()
-	flagset.StringVar(&o.slackAlias, "slack-alias", "", "Slack alias to tag in the generated report.  Leave empty to not tag anyone.")
+	flagset.StringVar(&o.slackAlias, "slack-alias", "", "Slack alias to tag in the generated report for streams that have no owner in --slack-alias-map.  Leave empty to not tag anyone.")
+	flagset.StringToStringVar(&o.slackAliasMap, "slack-alias-map", nil, "Slack group or user to tag for problems, by minor version or stream, e.g. \"4.14=<!subteam^S0123>,4.16.0-0.nightly=<@U0123>\"")
+	flagset.StringVar(&o.reportChannel, "report-channel", "", "Slack channel to periodically post the report to.  Leave empty to only report when asked.")
+	flagset.DurationVar(&o.reportInterval, "report-interval", 24*time.Hour, "How often to post the report to --report-channel")
+	flagset.StringVar(&o.slackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook to post the scheduled report to every --report-interval instead of --report-channel, e.g. https://hooks.slack.com/services/T000/B000/XXXX, for teams that can't get a Slack app with a bot token approved.  The report is posted as a single message that can't be threaded, updated, pinned or acknowledged, and the bot can't be talked to.")
+	flagset.StringToStringVar(&o.channelMap, "channel-map", nil, "Additional Slack channels to post the per-minor reports to, e.g. \"4.16=#forum-416-payloads,4.15=#forum-415-payloads\".  --report-channel still receives the report for every minor.")
+	flagset.BoolVar(&o.updateInPlace, "update-in-place", false, "Edit the previously posted report when the state changes instead of posting a new report each interval")
+	flagset.BoolVar(&o.pinReport, "pin-report", false, "Pin the most recently posted report in --report-channel and unpin the previous one")
+	flagset.StringSliceVar(&o.ackReactions, "ack-reactions", nil, "Reactions (e.g. \"eyes,white_check_mark\") that acknowledge the problems in a report message when a user adds them to it")
+	flagset.BoolVar(&o.socketMode, "socket-mode", false, "Receive Slack events, interactions and slash commands over a Socket Mode connection instead of HTTP.  The app-level token is read from the SLACK_APP_TOKEN environment variable.")
+	flagset.StringVar(&o.stateFile, "state-file", "", "File to persist the bot state, such as stream subscriptions, acknowledgements and the last posted report, in so it survives restarts.  Leave empty to keep the state in memory only.")
+	flagset.DurationVar(&o.pollInterval, "poll-interval", 15*time.Minute, "How often to check the release streams for changes to notify subscribers about")
+	flagset.StringToStringVar(&o.alertCooldowns, "alert-cooldowns", nil, "How long to hold the alerts about how a stream changed, sent to its subscribers and, on release controller webhooks, to its report channels, after one was sent, by stream or severity, e.g. \"critical=30m,warning=4h,4.12.0-0.ci=12h\", so a flapping stream isn't alerted about every poll.  A stream's own cooldown wins over that of the severity of the alert.  The changes still there when the cooldown ends are alerted about then.")
+	flagset.DurationVar(&o.reportCacheInterval, "report-cache-interval", 10*time.Minute, "How often to refresh the report the bot answers commands such as report, latest and stats and sends digests from, so they are answered right away instead of after crawling the release controllers.  Tenants' reports aren't cached.  The /metrics and the badges are served from it too.")
+	flagset.DurationVar(&o.mergeBuildLimit, "merge-build-limit", 6*time.Hour, "How soon after a change merges to a release branch, e.g. release-4.16, as reported by a GitHub webhook, every stream of the minor must have built a payload, before the report channels are told the build system may not be picking up merges.  0 disables the GitHub webhook.")
+	flagset.StringSliceVar(&o.adminUsers, "admin-users", nil, "Slack user IDs allowed to change the configuration at runtime with the config slash command")
+	flagset.StringSliceVar(&o.reportNowUsers, "report-now-users", nil, "Slack user IDs, or user group IDs such as S0123, allowed to post the scheduled report to all of its channels right away with report now, in addition to --admin-users")
+	flagset.StringToStringVar(&o.nightlyBuildJobs, "nightly-build-jobs", nil, "Prow jobs that build a new payload of the nightly streams, by minor version or stream, e.g. \"4.16=periodic-ci-openshift-release-nightly-4.16-build\".  The critical nightlies with one get a button to trigger it through --gangway-url, so kicking a new build doesn't mean leaving Slack.  Who pressed it is posted in the thread of the report.  The token is read from the GANGWAY_TOKEN environment variable.")
+	flagset.StringSliceVar(&o.nightlyBuildUsers, "nightly-build-users", nil, "Slack user IDs, or user group IDs such as S0123, allowed to request a new nightly with the --nightly-build-jobs buttons, in addition to --admin-users")
+	flagset.StringVar(&o.gangwayURL, "gangway-url", "https://gangway-ci.apps.ci.l2s4.p1.openshiftapps.com", "Gangway, Prow's api, to trigger the --nightly-build-jobs through")
+	flagset.StringVar(&o.quietHours, "quiet-hours", "", "Daily range of times, e.g. 22:00-07:00, during which the scheduled reports, alerts, tickets and subscription messages are held.  The problems found meanwhile are posted as a catch-up digest when it ends, followed by the report.  Admins can also hold them for a while with the maintenance command.")
+	flagset.StringVar(&o.quietHoursTimezone, "quiet-hours-timezone", "Local", "Time zone of --quiet-hours, e.g. Europe/Prague")
+	flagset.BoolVar(&o.threadDetails, "thread-details", true, "Post a one line summary to the channel and the per-stream details as a reply in its thread")
+	flagset.BoolVar(&o.digest, "digest", false, "Post the problems grouped by kind, with the streams that have each and counts by severity, as a single message instead of the per-stream report.  With --thread-details the per-stream details are posted as a reply in its thread.")
+	flagset.BoolVar(&o.dryRun, "dry-run", false, "Fetch, analyze and format the reports and notifications as usual, but log what would be posted to Slack instead of posting it.  The state file is not updated.")
+	flagset.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 25*time.Second, "How long to wait for in-flight requests and report posts to finish when the bot is stopped with SIGTERM or SIGINT")
+	flagset.BoolVar(&o.leaderElection, "leader-election", false, "Elect a leader among the bot replicas using a Kubernetes Lease.  Only the leader posts to Slack and handles Slack requests.")
+	flagset.StringVar(&o.leaderElectionLease, "leader-election-lease", "release-watcher", "Name of the Lease used for leader election")
+	flagset.StringVar(&o.leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the Lease used for leader election.  Defaults to the namespace the bot runs in.")
+	flagset.BoolVar(&o.releaseWatches, "release-watches", false, "Read ReleaseWatch custom resources from the cluster every minute, each of which gets a report of its own in its channel as the tenants of the config file do, so teams can manage their own watches with kubectl.  The CRD is in resources/releasewatch-crd.yaml.")
+	flagset.StringVar(&o.releaseWatchNamespace, "release-watch-namespace", "", "Namespace to read the --release-watches from.  Leave empty to read them from all namespaces.")
+	flagset.StringVar(&o.uploadURL, "upload-url", "", "Bucket to upload every scheduled report to, as text, JSON and HTML, e.g. s3://bucket/prefix or gs://bucket/prefix.  Each report is uploaded under a key named after when it was generated and as latest.txt, latest.json and latest.html.  The credentials are read from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, which hold an HMAC key for GCS.")
+	flagset.StringVar(&o.uploadEndpoint, "upload-endpoint", "", "S3 compatible api to upload to instead of the one of the --upload-url scheme, e.g. a MinIO server")
+	flagset.StringVar(&o.uploadRegion, "upload-region", "us-east-1", "Region of the --upload-url bucket")
+	flagset.StringVar(&o.archiveRepo, "archive-repo", "", "Git repo to commit every scheduled report to, as report.json and report.md, e.g. https://github.com/example/payload-reports.git or git@github.com:example/payload-reports.git.  The credentials are git's own, such as an ssh key or a token in the url.")
+	flagset.StringVar(&o.archiveBranch, "archive-branch", "main", "Existing branch of --archive-repo to commit the reports to")
+	flagset.StringVar(&o.archivePath, "archive-path", "", "Directory of --archive-repo to write the reports in, which must be inside of the repo.  Defaults to the top of the repo.")
+	flagset.StringVar(&o.archiveAuthor, "archive-author", "release-watcher <release-watcher@localhost>", "Author of the --archive-repo commits")
+	flagset.StringVar(&o.gitHubIssuesRepo, "github-issues-repo", "", "GitHub repo, e.g. example/payload-health, to open an issue in for each stream that has been critical for longer than --github-issues-after.  The issue is updated while the stream stays critical and closed when it recovers.  The token is read from the GITHUB_TOKEN environment variable.")
+	flagset.DurationVar(&o.gitHubIssuesAfter, "github-issues-after", 24*time.Hour, "How long a stream must be critical before an issue is opened for it")
+	flagset.StringSliceVar(&o.gitHubIssuesLabels, "github-issues-labels", nil, "Labels to add to the issues opened in --github-issues-repo")
+	flagset.StringVar(&o.gitHubAPIURL, "github-api-url", releasewatch.DefaultGitHubAPIURL, "GitHub api to open the --github-issues-repo issues in")
+	flagset.StringVar(&o.jiraURL, "jira-url", "", "Jira to create a ticket in, in --jira-project, for each stream that is building payloads but has accepted none of them, e.g. https://issues.example.com.  The ticket is transitioned with --jira-resolve-transition when the stream recovers.  The token is read from the JIRA_TOKEN environment variable, and JIRA_USER is set for Jira Cloud api tokens.")
+	flagset.StringVar(&o.jiraProject, "jira-project", "", "Key of the Jira project to create the tickets in")
+	flagset.StringVar(&o.jiraIssueType, "jira-issue-type", "Bug", "Issue type of the Jira tickets")
+	flagset.StringVar(&o.jiraResolveTransition, "jira-resolve-transition", "Done", "Name of the transition to resolve the Jira tickets of the streams that recovered with")
+	flagset.StringVar(&o.alertmanagerURL, "alertmanager-url", "", "Alertmanager to send an alert to for each unacknowledged problem, labelled with its stream, minor, arch, severity and kind, e.g. http://alertmanager:9093.  The alerts are resolved when the problems go away.")
 	addSharedFlags(flagset, o)
 	return cmd
 }
 
 func addSharedFlags(flagset *pflag.FlagSet, o *options) {
-	flagset.StringVar(&o.releaseAPIUrl, "release-api-url", o.releaseAPIUrl, "The url of the release reporting api")
-	flagset.IntVar(&o.oldestMinor, "oldest-minor", 9, "The oldest minor release to analyze.  Release streams older than this will be ignored.  Specify only the minor value (e.g. \"9\")")
-	flagset.IntVar(&o.newestMinor, "newest-minor", 12, "The newest minor release to analyze.  Release streams newer than this will be ignored.  Specify only the minor value (e.g. \"12\")")
-	flagset.DurationVar(&o.acceptedStalenessLimit, "accepted-staleness-limit", 24*time.Hour, "How old an accepted payload can be before it is considered stale")
-	flagset.DurationVar(&o.builtStalenessLimit, "built-staleness-limit", 72*time.Hour, "How old an built payload can be before it is considered stale")
-	flagset.DurationVar(&o.upgradeStalenessLimit, "upgrade-staleness-limit", 72*time.Hour, "How old a successful upgrade attempt can be before it's considered stale")
+	flagset.StringVar(&o.configFile, "config", "", "YAML file of settings, keyed by argument name.  Arguments given on the command line take precedence over the file.")
+	flagset.StringSliceVar(&o.releaseAPIURLs, "release-api-url", o.releaseAPIURLs, "The url of the release reporting api.  Given more than once, or as a list, the streams of all of the release controllers, e.g. those of several architectures or OKD's, are merged into one report.")
+	flagset.StringVar(&o.arch, "arch", "", fmt.Sprintf("Architecture whose release controller to analyze, out of %s, e.g. \"arm64\", instead of giving its url with --release-api-url", strings.Join(releasewatch.Arches, ", ")))
+	flagset.StringVar(&o.sippyURL, "sippy-url", "", fmt.Sprintf("Sippy api to look up the pass rate over the last 7 days of failing blocking jobs in, e.g. %q.  Leave empty to not look them up.", releasewatch.DefaultSippyURL))
+	flagset.StringVar(&o.incidentFeedURL, "incident-feed-url", "", "Feed of declared incidents, such as TRT's, to note next to the problems they overlap, e.g. 'overlaps declared incident \"AWS quota exhausted\"', so a known outage isn't investigated again.  The feed is a JSON list of incidents with a title, start, optional end and url, and optional streams they affect, given like --streams.  Leave empty to not correlate them.")
+	flagset.BoolVar(&o.componentReadiness, "component-readiness", false, "List the components that Component Readiness flags as regressed with the problems of the minors in development, ones without a z-stream release yet, that aren't accepting payloads.  Needs --sippy-url.")
+	flagset.IntVar(&o.topRisks, "top-risks", 0, "How many of the failed tests that Sippy's risk analysis of the failed blocking job runs of rejected payloads judged the most likely regressions, e.g. 3, to list with each problem, as a first hypothesis of why the payloads are being rejected.  Needs --sippy-url.  Each failed job run takes a request to Sippy.")
+	flagset.StringVar(&o.jobArtifactsURL, "job-artifacts-url", "", fmt.Sprintf("Storage of the Prow job artifacts to read the ci-operator results of the failed blocking jobs of rejected payloads from, e.g. %q, to classify them as install, infrastructure or test failures and tell whether the fix lies with CI infra or the product code.  Leave empty to not classify them.  Each failed job run takes a request.", releasewatch.DefaultJobArtifactsURL))
+	flagset.StringSliceVar(&o.mergeCheckRepos, "merge-check-repos", nil, "GitHub repos, e.g. \"openshift/origin,openshift/installer\", to check for changes merged to the release-4.N branch of each stream that hasn't built recently since its newest payload was built.  If any had some the stream is reported as critical, as its build system is probably broken, rather than just quiet.  The repos are looked up in --github-api-url, which allows 60 unauthenticated requests an hour, and each stale stream takes a request per repo.")
+	flagset.StringVar(&o.cacheDir, "cache-dir", "", "Directory to save the most recent release reporting api responses in.  When the api can't be reached, the report is generated from the saved responses and marked as stale.")
+	flagset.StringVar(&o.caFile, "ca-file", "", "PEM file of additional CA certificates to trust for the release reporting api, for release controllers with internal or self-signed certificates")
+	flagset.BoolVar(&o.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Don't verify the certificate of the release reporting api.  This is insecure and should only be used for testing.")
+	flagset.StringVar(&o.recordDir, "record-dir", "", "Directory to save the release reporting api responses in, so the report can be reproduced later with --replay-dir")
+	flagset.StringVar(&o.replayDir, "replay-dir", "", "Directory of responses saved with --record-dir to generate the report from instead of calling the release reporting api.  Staleness is judged as of when the responses were recorded.")
+	flagset.StringVar(&o.proxyURL, "proxy-url", "", "Proxy to send requests to the release reporting api and Slack through.  Defaults to the proxy set by the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.")
+	flagset.IntVar(&o.apiConcurrency, "api-concurrency", releasewatch.DefaultConcurrency, "How many requests to make to the release reporting api at once")
+	flagset.Float64Var(&o.apiRate, "api-rate", 10, "How many requests a second to make to each release reporting api at most, so the per-stream queries of a large report are spread out.  0 means no limit.  Retry-After responses hold off all of the requests regardless.")
+	flagset.StringVar(&o.apiToken, "api-token", "", "Bearer token for release reporting apis that require authentication.  Prefer --api-token-file, which keeps the token out of the process list.")
+	flagset.StringVar(&o.apiTokenFile, "api-token-file", "", "File holding the bearer token for release reporting apis that require authentication.  The file is re-read for every request, so the token can be rotated.")
+	flagset.DurationVar(&o.apiTimeout, "api-timeout", 30*time.Second, "How long to wait for each request to the release reporting api before giving up on it and retrying.  0 means no timeout.")
+	flagset.DurationVar(&o.acceptanceWindow, "acceptance-window", 0, "How far back to count how many of each stream's payloads were accepted and measure how long they took from being built to being accepted, e.g. 168h.  0 means it isn't measured.  Each accepted payload takes a request to the release reporting api.")
+	flagset.Float64Var(&o.minAcceptanceRate, "min-acceptance-rate", 0, "Warn about the streams that accepted less than this fraction, e.g. 0.5, of the payloads they built within --acceptance-window, even if their newest accepted payload isn't stale.  0 means any rate is fine.")
+	flagset.Float64Var(&o.acceptanceTrendLimit, "acceptance-trend-limit", 0, "Warn about the streams whose average time to accept, as measured with --acceptance-window by the reports saved in --history-dir over the last 30 days, rose by more than this fraction of itself, e.g. 0.5, before it hits a staleness limit.  0 means it isn't checked.")
+	flagset.Float64Var(&o.buildCadenceFactor, "build-cadence-factor", 0, "Warn about the streams that have gone this many times their typical interval between builds, e.g. 3, without building a payload.  0 means the build cadence isn't checked.  Each stream takes a request to the release reporting api.")
+	flagset.StringArrayVar(&o.slo, "slo", nil, "Report how the matching streams met a service level objective so far this quarter or month, and how much of its error budget is left, from the reports saved in --history-dir, e.g. '4.16.0-0.nightly every=24h target=95% period=quarter'.  The stream is given like --streams.  Repeat the argument for more objectives.")
+	flagset.StringVar(&o.historyDir, "history-dir", "", "Directory to save every generated report in, for comparing reports over time.  Leave empty to not save them.")
+	flagset.DurationVar(&o.historyRetention, "history-retention", 35*24*time.Hour, "How long to keep the reports saved in --history-dir.  0 keeps them forever.")
+	flagset.BoolVar(&o.weekOverWeek, "week-over-week", false, "End the report with the problems that appeared and resolved, and how acceptance latency changed, since the report saved in --history-dir a week earlier")
+	flagset.StringVar(&o.messageTemplate, "message-template", "", "File holding a Go template to render the reports posted to Slack, with --report-channel or --slack-webhook-url, instead of the built-in wording, e.g. to link to the team's runbook.  It is executed with the report as .Report, its one line summary as .Summary and the tagged aliases as .Mentions, and parsed again whenever it changes, so it can be changed without a restart.  If it fails the report is posted as usual.")
+	flagset.StringVar(&o.statsdAddress, "statsd-address", "", "StatsD or DogStatsD server to send gauges of the age of each stream's newest accepted and built payloads, of how many problems of each severity it has and of its health score, to after every report, e.g. localhost:8125")
+	flagset.StringVar(&o.statsdPrefix, "statsd-prefix", "release_watcher.", "Prefix of the --statsd-address metric names")
+	flagset.BoolVar(&o.statsdTags, "statsd-tags", true, "Tag the --statsd-address metrics with the stream, minor, arch and severity, as DogStatsD does.  Otherwise they are part of the metric names, for plain StatsD.")
+	flagset.StringVar(&o.sortOrder, "sort", string(releasewatch.SortByMinor), "How to order the streams in the report: \"minor\" sections them by minor version, newest first, \"severity\" puts the most urgent problems first and \"accepted-age\" puts the streams that have gone the longest without an accepted payload first")
+	flagset.StringVar(&o.displayTimezone, "display-timezone", "UTC", "Time zone to show the times in the reports in, e.g. America/New_York or Local, next to how long ago they were")
+	flagset.StringSliceVar(&o.streams, "streams", nil, "Only analyze these streams, given as exact names, globs such as \"4.*.0-0.nightly\" or regular expressions between slashes such as \"/^4\\.1[0-9]\\./\".  Defaults to every stream within the minor range.")
+	flagset.StringSliceVar(&o.excludeStreams, "exclude-streams", nil, "Streams not to analyze, e.g. an experiment that never accepts payloads, given like --streams.")
+	flagset.StringArrayVar(&o.ignore, "ignore", nil, "Leave a known broken stream out of the reports until a date, e.g. '4.13.0-0.ci until=2025-09-01 reason=\"CI infra migration\"'.  The stream is given like --streams and reports list the ignores in a footnote.  Repeat the argument to ignore more streams.")
+	flagset.StringSliceVar(&o.streamTypes, "stream-types", nil, fmt.Sprintf("Only analyze the streams of these types, out of %s, e.g. \"nightly\" for a team that doesn't own the health of the ci streams.  Defaults to every type.", strings.Join(releasewatch.StreamTypes, ", ")))
+	flagset.IntVar(&o.oldestMinor, "oldest-minor", releasewatch.DefaultLimits.OldestMinor, "The oldest minor release to analyze.  Release streams older than this will be ignored.  Specify only the minor value (e.g. \"9\")")
+	flagset.IntVar(&o.newestMinor, "newest-minor", releasewatch.DefaultLimits.NewestMinor, "The newest minor release to analyze.  Release streams newer than this will be ignored.  Specify only the minor value (e.g. \"12\")")
+	flagset.DurationVar(&o.acceptedStalenessLimit, "accepted-staleness-limit", releasewatch.DefaultLimits.AcceptedStaleness, "How old an accepted payload can be before it is considered stale")
+	flagset.DurationVar(&o.builtStalenessLimit, "built-staleness-limit", releasewatch.DefaultLimits.BuiltStaleness, "How old an built payload can be before it is considered stale")
+	flagset.StringSliceVar(&o.watchedUpgradePaths, "upgrade-paths", nil, "Upgrades between minors to watch, such as EUS upgrades, e.g. \"4.14:4.16\".  The streams of the newer minor must have had a successful upgrade from the older one within --upgrade-staleness-limit.")
+	flagset.DurationVar(&o.upgradeWindow, "upgrade-window", 0, "How far back to count the upgrade job runs to each stream's payloads, by the minor they upgraded from, e.g. 168h.  0 means they aren't counted.  Each payload takes a request to the release reporting api.")
+	flagset.Float64Var(&o.minUpgradeSuccessRate, "min-upgrade-success-rate", 0, "Warn about the upgrades from a minor to a stream that succeeded less than this fraction, e.g. 0.8, of the time within --upgrade-window.  0 means any rate is fine.")
+	flagset.DurationVar(&o.phaseWindow, "phase-window", 0, "How far back to count the payloads of each stream with problems by their phase, Accepted, Rejected, Ready or Failed, e.g. 168h, to list next to the stream.  0 means they aren't counted, unless the metrics are pushed or served, which count them over 168h.  Each stream takes a request to the release reporting api.")
+	flagset.BoolVar(&o.tagDetails, "tag-details", false, "Look up the details of each stream's newest payloads, to list how many were rejected in a row and how far the verification of the pending ones got, e.g. \"6 rejected in a row, 1 pending (4.16.0-0.nightly-2024-06-01-123456: 5/8 blocking jobs finished)\".  Each stream takes a request to the release reporting api, plus one per pending payload.")
+	flagset.IntVar(&o.rejectionStreakLimit, "rejection-streak-limit", 0, "Warn about the streams that rejected this many of their newest payloads in a row, e.g. 5, even if their newest accepted payload isn't stale yet.  0 means it isn't checked.  Needs --tag-details.")
+	flagset.DurationVar(&o.verificationStalenessLimit, "verification-staleness-limit", 0, "How long a payload can wait to be verified, in the Ready phase, before it is considered stuck, e.g. 12h.  0 means it isn't checked.  Each stream takes a request to the release reporting api.")
+	flagset.DurationVar(&o.archSkewLimit, "arch-skew-limit", 0, "How much older the most recently accepted payload of a stream can be than that of the same stream in another architecture, e.g. 48h.  0 means it isn't checked.")
+	flagset.DurationVar(&o.publishStalenessLimit, "publish-staleness-limit", 0, "How long a stable payload of a minor, e.g. 4.16.3, can go from being accepted into 4-stable to being published to its candidate channel, e.g. candidate-4.16, in --cincinnati-url, before the nightly stream of the minor is flagged, e.g. 72h.  0 means it isn't checked.  Each nightly stream takes a request to the update service.")
+	flagset.StringToStringVar(&o.promotionLimits, "promotion-limits", nil, "How long a stable payload of a minor can go from being accepted into 4-stable to being promoted to other channels of --cincinnati-url, by the channel name without the version, e.g. \"fast=168h,stable=336h\" for fast-4.16 and stable-4.16, before the nightly stream of the minor is flagged.  Each nightly stream takes a request to the update service per channel.")
+	flagset.DurationVar(&o.devPreviewStalenessLimit, "dev-preview-staleness-limit", 0, "How long ago the newest engineering candidate of a minor in development, e.g. 4.17.0-ec.2, can have been accepted into 4-dev-preview before the nightly stream of the minor is flagged, e.g. 720h.  0 means it isn't checked.")
+	flagset.BoolVar(&o.releasePaths, "release-paths", false, "List how far the releases of each nightly stream's minor have made it: the newest engineering candidate in 4-dev-preview, the newest release in 4-stable and the newest release in the candidate channel of --cincinnati-url.  Each nightly stream takes a request to the update service.")
+	flagset.StringVar(&o.cincinnatiURL, "cincinnati-url", releasewatch.DefaultCincinnatiURL, "Update service to look up the published releases in")
+	flagset.DurationVar(&o.upgradeStalenessLimit, "upgrade-staleness-limit", releasewatch.DefaultLimits.UpgradeStaleness, "How old a successful upgrade attempt can be before it's considered stale")
 }
 
-func (o *options) runReport() error {
-	report, err := generateReport(o.releaseAPIUrl, o.acceptedStalenessLimit, o.builtStalenessLimit, o.upgradeStalenessLimit, o.oldestMinor, o.newestMinor)
+func (o *options) runReport(ctx context.Context) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	report, err := o.currentReport(ctx)
 	if err != nil {
 		return err
 	}
-	fmt.Println(report)
+	if o.onlyChanges {
+		report = report.OnlyChanges()
+	}
+	var style releasewatch.Styler
+	if o.outputFile == "" && o.colorOutput() {
+		style = colorBySeverity
+	}
+	output := report.StyledString(style)
+	switch {
+	case o.summary:
+		output = report.CompactSummary()
+	case o.digest:
+		output = report.Digest()
+	case o.stats:
+		output = report.StatsText()
+	case o.output == "table":
+		output = report.StyledTableText(style)
+	}
+	if o.outputFile != "" {
+		if err := o.writeOutputFile(output+"\n", report.GeneratedAt); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println(output)
+	}
+	if err := o.configureHTTPClients(); err != nil {
+		return err
+	}
+	if o.slackWebhookURL != "" {
+		if err := o.postReportOutput(report, output); err != nil {
+			return err
+		}
+	}
+	if o.pushgatewayURL != "" {
+		return o.pushMetrics(report)
+	}
 	return nil
 }
 
-func (o *options) runBot() error {
-	o.serve()
+// currentReport generates a report using the current settings, for posting
+// or printing it: it is sent as StatsD metrics, compared with the previous
+// report for --only-changes and saved in the --history-dir.
+func (o *options) currentReport(ctx context.Context) (*releasewatch.Report, error) {
+	report, err := o.generateCurrentReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.sendStatsDMetrics(report)
+	if o.history != nil {
+		if o.onlyChanges {
+			o.compareWithPrevious(report)
+		}
+		if err := o.history.Save(report); err != nil {
+			klog.Errorf("error saving the report: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// generateCurrentReport generates a report using the current settings,
+// without recording it anywhere, e.g. for the cache or the subscriptions to
+// compare with.
+func (o *options) generateCurrentReport(ctx context.Context) (*releasewatch.Report, error) {
+	o.settingsLock.RLock()
+	limits := o.limits()
+	o.settingsLock.RUnlock()
+	report, err := o.watcher.GenerateReportWithLimits(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+	if o.history != nil {
+		if o.weekOverWeek {
+			o.compareWeekOverWeek(report)
+		}
+		if o.acceptanceWindow > 0 {
+			o.addAcceptanceTrends(report)
+		}
+		if len(o.slo) > 0 {
+			o.addSLOStatus(report)
+		}
+	}
+	// the order is validated with the other flags
+	order, _ := releasewatch.ParseSortOrder(o.sortOrder)
+	report.Sort(order)
+	report.Location = o.displayLocation()
+	return report, nil
+}
+
+// displayLocation returns the time zone to show the times in the reports in.
+// The flag is checked by validate.
+func (o *options) displayLocation() *time.Location {
+	location, err := time.LoadLocation(o.displayTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// compareWeekOverWeek compares the report with the saved report generated
+// closest to a week before it, if there is one within a day of that.
+func (o *options) compareWeekOverWeek(report *releasewatch.Report) {
+	earlier, err := o.history.Closest(report.GeneratedAt.Add(-weekOverWeekAge), weekOverWeekTolerance)
+	if err != nil {
+		klog.Errorf("error reading the report from a week ago: %v", err)
+		return
+	}
+	if earlier == nil {
+		klog.V(2).Infof("no report from a week ago to compare with")
+		return
+	}
+	report.Comparison = releasewatch.Compare(earlier, report)
+}
+
+// addAcceptanceTrends sets the time to accept trends of the streams from the
+// reports saved over the trend window, and flags the streams whose trend
+// worsened by more than --acceptance-trend-limit.
+func (o *options) addAcceptanceTrends(report *releasewatch.Report) {
+	earlier, err := o.history.Since(report.GeneratedAt.Add(-releasewatch.AcceptanceTrendWindow))
+	if err != nil {
+		klog.Errorf("error reading the reports to find the acceptance trends in: %v", err)
+		return
+	}
+	report.AddAcceptanceTrends(earlier)
+	report.CheckAcceptanceTrends(o.acceptanceTrendLimit)
+}
+
+// addSLOStatus measures how the streams met the --slo objectives from the
+// reports saved over their periods.
+func (o *options) addSLOStatus(report *releasewatch.Report) {
+	slos := o.slos()
+	var lookback time.Duration
+	for _, slo := range slos {
+		if length := slo.Period.MaxLength(); length > lookback {
+			lookback = length
+		}
+	}
+	earlier, err := o.history.Since(report.GeneratedAt.Add(-lookback))
+	if err != nil {
+		klog.Errorf("error reading the reports to measure the slos with: %v", err)
+		return
+	}
+	report.AddSLOStatus(slos, earlier)
+}
+
+// compareWithPrevious compares the report with the most recently saved
+// report, if there is one.
+func (o *options) compareWithPrevious(report *releasewatch.Report) {
+	previous, err := o.history.Latest()
+	if err != nil {
+		klog.Errorf("error reading the previous report: %v", err)
+		return
+	}
+	if previous == nil {
+		klog.V(2).Infof("no previous report to compare with, reporting every stream")
+		return
+	}
+	report.Comparison = releasewatch.Compare(previous, report)
+}
+
+// streamPatterns returns the patterns of --streams or --exclude-streams,
+// which are validated with the other flags.
+func streamPatterns(values []string) []releasewatch.StreamPattern {
+	patterns := []releasewatch.StreamPattern{}
+	for _, value := range values {
+		if pattern, err := releasewatch.ParseStreamPattern(value); err == nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// upgradePaths returns the --upgrade-paths, which are validated with the other
+// flags.
+func (o *options) upgradePaths() []releasewatch.UpgradePath {
+	paths := []releasewatch.UpgradePath{}
+	for _, value := range o.watchedUpgradePaths {
+		if path, err := releasewatch.ParseUpgradePath(value); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// slos returns the --slo objectives, which are validated with the other
+// flags.
+func (o *options) slos() []releasewatch.SLO {
+	slos := []releasewatch.SLO{}
+	for _, value := range o.slo {
+		if slo, err := releasewatch.ParseSLO(value); err == nil {
+			slos = append(slos, slo)
+		}
+	}
+	return slos
+}
+
+// parsedPromotionLimits returns the --promotion-limits, which are validated
+// with the other flags.
+func (o *options) parsedPromotionLimits() map[string]time.Duration {
+	limits, _ := releasewatch.ParsePromotionLimits(o.promotionLimits)
+	return limits
+}
+
+// ignores returns the --ignore ignores, which are validated with the other
+// flags, and those of the config file.
+func (o *options) ignores() []releasewatch.Ignore {
+	ignores := []releasewatch.Ignore{}
+	for _, value := range o.ignore {
+		if ignore, err := releasewatch.ParseIgnore(value); err == nil {
+			ignores = append(ignores, ignore)
+		}
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append(ignores, configIgnores...)
+}
+
+// limits returns the limits set by the flags.  Callers must hold the settings
+// lock when the bot is running.
+func (o *options) limits() releasewatch.Limits {
+	return releasewatch.Limits{
+		OldestMinor:           o.oldestMinor,
+		NewestMinor:           o.newestMinor,
+		AcceptedStaleness:     o.acceptedStalenessLimit,
+		BuiltStaleness:        o.builtStalenessLimit,
+		UpgradeStaleness:      o.upgradeStalenessLimit,
+		MinAcceptanceRate:     o.minAcceptanceRate,
+		BuildCadenceFactor:    o.buildCadenceFactor,
+		VerificationStaleness: o.verificationStalenessLimit,
+		UpgradePaths:          o.upgradePaths(),
+		MinUpgradeSuccessRate: o.minUpgradeSuccessRate,
+		ArchSkew:              o.archSkewLimit,
+		PublishStaleness:      o.publishStalenessLimit,
+		DevPreviewStaleness:   o.devPreviewStalenessLimit,
+		RejectionStreak:       o.rejectionStreakLimit,
+		PromotionLimits:       o.parsedPromotionLimits(),
+		Streams:               streamPatterns(o.streams),
+		ExcludeStreams:        streamPatterns(o.excludeStreams),
+		StreamTypes:           o.streamTypes,
+		Ignore:                o.ignores(),
+		StreamLimits:          currentStreamLimits(),
+		KnownIssues:           currentKnownIssues(),
+	}
+}
+
+// currentKnownIssues returns the known-issues of the config file.
+func currentKnownIssues() []releasewatch.KnownIssue {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]releasewatch.KnownIssue{}, configKnownIssues...)
+}
+
+// currentStreamLimits returns the stream-limits of the config file.
+func currentStreamLimits() []releasewatch.StreamLimits {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]releasewatch.StreamLimits{}, configStreamLimits...)
+}
+
+func (o *options) runBot(ctx context.Context) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if err := o.configureHTTPClients(); err != nil {
+		return err
+	}
+	stateMutex.Lock()
+	nightlyBuildJobs = o.nightlyBuildJobs
+	stateMutex.Unlock()
+	if err := o.loadState(); err != nil {
+		return err
+	}
+	o.serve(ctx)
 	return nil
 }
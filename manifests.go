@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// The workloads "generate manifests" deploys.
+const (
+	manifestsBot    = "bot"
+	manifestsReport = "report"
+)
+
+// manifestsConfigDir is where the ConfigMap with the config file is mounted.
+const manifestsConfigDir = "/etc/release-watcher"
+
+// manifestArgs returns the arguments of the workload: its command, the
+// config file mounted from the ConfigMap, and the flags given after "--",
+// which are checked to be the workload's.  It also returns the flags parsed,
+// to tell what else the workload needs.
+func (o *options) manifestArgs(flags []string) ([]string, *pflag.FlagSet, error) {
+	workload := newReportCommand()
+	if o.manifestsMode == manifestsBot {
+		workload = newBotCommand()
+	}
+	flagset := workload.Flags()
+	if err := flagset.Parse(flags); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s flags: %v", o.manifestsMode, err)
+	}
+	if len(flagset.Args()) > 0 {
+		return nil, nil, fmt.Errorf("unexpected %s arguments %v", o.manifestsMode, flagset.Args())
+	}
+	if flagset.Changed("config-file") {
+		return nil, nil, fmt.Errorf("the config file is given with --config-file before \"--\", to be put in the ConfigMap")
+	}
+	args := []string{o.manifestsMode}
+	if o.configFile != "" {
+		args = append(args, "--config-file="+manifestsConfigDir+"/config.yaml")
+	}
+	return append(args, flags...), flagset, nil
+}
+
+// boolFlag returns the value of a bool flag, false if the flagset doesn't
+// have it.
+func boolFlag(flagset *pflag.FlagSet, name string) bool {
+	value, err := flagset.GetBool(name)
+	return err == nil && value
+}
+
+// manifests returns the Kubernetes objects that deploy the bot, as a
+// Deployment with a Service and a ServiceMonitor scraping its metrics, or the
+// report, as a CronJob running it every --schedule, each with a ConfigMap
+// holding the --config-file.  The workload is given the flags after "--", and
+// a bot with --leader-election gets a second replica and the permissions to
+// manage its Lease, and one with --release-watches those to list them.  The tokens are read from the --secret, which is left to
+// be created by hand.
+func (o *options) manifests(flags []string) ([]interface{}, error) {
+	args, flagset, err := o.manifestArgs(flags)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"app": o.manifestsName}
+	metadata := map[string]interface{}{"name": o.manifestsName, "namespace": o.manifestsNamespace, "labels": labels}
+	container := map[string]interface{}{
+		"name":  "release-watcher",
+		"image": o.manifestsImage,
+		"args":  args,
+		"envFrom": []interface{}{
+			map[string]interface{}{"secretRef": map[string]interface{}{"name": o.manifestsSecret, "optional": true}},
+		},
+	}
+	pod := map[string]interface{}{"containers": []interface{}{container}}
+
+	objects := []interface{}{}
+	if o.configFile != "" {
+		data, err := ioutil.ReadFile(o.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file %s: %v", o.configFile, err)
+		}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   metadata,
+			"data":       map[string]string{"config.yaml": string(data)},
+		})
+		container["volumeMounts"] = []interface{}{map[string]interface{}{"name": "config", "mountPath": manifestsConfigDir}}
+		pod["volumes"] = []interface{}{map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": o.manifestsName}}}
+	}
+
+	if o.manifestsMode == manifestsReport {
+		pod["restartPolicy"] = "Never"
+		return append(objects, map[string]interface{}{
+			"apiVersion": "batch/v1",
+			"kind":       "CronJob",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"schedule":          o.manifestsSchedule,
+				"concurrencyPolicy": "Forbid",
+				"jobTemplate": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"backoffLimit": 0,
+						"template":     map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}, "spec": pod},
+					},
+				},
+			},
+		}), nil
+	}
+
+	container["ports"] = []interface{}{map[string]interface{}{"name": "http", "containerPort": 8080}}
+	container["readinessProbe"] = map[string]interface{}{"httpGet": map[string]interface{}{"path": "/readyz", "port": "http"}}
+	replicas := 1
+	if boolFlag(flagset, "release-watches") {
+		// the ReleaseWatches are read from a namespace or from all of them
+		kind, scope := "ClusterRole", ""
+		if namespace, _ := flagset.GetString("release-watch-namespace"); namespace != "" {
+			kind, scope = "Role", namespace
+		}
+		watchMetadata := map[string]interface{}{"name": o.manifestsName + "-releasewatches", "labels": labels}
+		if scope != "" {
+			watchMetadata["namespace"] = scope
+		}
+		pod["serviceAccountName"] = o.manifestsName
+		objects = append(objects,
+			map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       kind,
+				"metadata":   watchMetadata,
+				"rules": []interface{}{map[string]interface{}{
+					"apiGroups": []string{releaseWatchGroup},
+					"resources": []string{"releasewatches"},
+					"verbs":     []string{"list"},
+				}},
+			},
+			map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       kind + "Binding",
+				"metadata":   watchMetadata,
+				"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": kind, "name": o.manifestsName + "-releasewatches"},
+				"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": o.manifestsName, "namespace": o.manifestsNamespace}},
+			},
+		)
+	}
+	if boolFlag(flagset, "leader-election") {
+		// the replicas elect a leader through a Lease they need to be allowed
+		// to manage
+		replicas = 2
+		pod["serviceAccountName"] = o.manifestsName
+		objects = append(objects,
+			map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "Role",
+				"metadata":   metadata,
+				"rules": []interface{}{map[string]interface{}{
+					"apiGroups": []string{"coordination.k8s.io"},
+					"resources": []string{"leases"},
+					"verbs":     []string{"get", "create", "update"},
+				}},
+			},
+			map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   metadata,
+				"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "Role", "name": o.manifestsName},
+				"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": o.manifestsName, "namespace": o.manifestsNamespace}},
+			},
+		)
+	}
+	if pod["serviceAccountName"] != nil {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   metadata,
+		})
+	}
+	return append(objects,
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}, "spec": pod},
+			},
+		},
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"selector": labels,
+				"ports":    []interface{}{map[string]interface{}{"name": "http", "port": 80, "targetPort": "http"}},
+			},
+		},
+		map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "ServiceMonitor",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"selector":  map[string]interface{}{"matchLabels": labels},
+				"endpoints": []interface{}{map[string]interface{}{"port": "http", "path": "/metrics", "interval": "1m"}},
+			},
+		},
+	), nil
+}
+
+// runGenerateManifests prints the manifests as a multi-document YAML file.
+func (o *options) runGenerateManifests(flags []string) error {
+	if o.manifestsMode != manifestsBot && o.manifestsMode != manifestsReport {
+		return fmt.Errorf("invalid mode %q, expected %s or %s", o.manifestsMode, manifestsBot, manifestsReport)
+	}
+	if o.configFile != "" {
+		// the workload would fail to start with it
+		if _, err := readConfigFile(o.configFile); err != nil {
+			return err
+		}
+	}
+	objects, err := o.manifests(flags)
+	if err != nil {
+		return err
+	}
+	documents := []string{}
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return fmt.Errorf("error rendering manifests: %v", err)
+		}
+		documents = append(documents, string(data))
+	}
+	fmt.Print(strings.Join(documents, "---\n"))
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const configCallbackID = "config"
+
+// View is a Slack modal view.
+type View struct {
+	Type       string      `json:"type"`
+	CallbackID string      `json:"callback_id,omitempty"`
+	Title      *TextObject `json:"title,omitempty"`
+	Submit     *TextObject `json:"submit,omitempty"`
+	Close      *TextObject `json:"close,omitempty"`
+	Blocks     []Block     `json:"blocks,omitempty"`
+	State      *ViewState  `json:"state,omitempty"`
+}
+
+// ViewState holds the submitted input values, by block and action id.
+type ViewState struct {
+	Values map[string]map[string]ViewValue `json:"values"`
+}
+
+type ViewValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type OpenView struct {
+	TriggerID string `json:"trigger_id"`
+	View      View   `json:"view"`
+}
+
+// ViewSubmissionResponse reports validation errors back to the modal, by
+// block id.
+type ViewSubmissionResponse struct {
+	ResponseAction string            `json:"response_action"`
+	Errors         map[string]string `json:"errors"`
+}
+
+// configSetting is a setting that can be changed while the bot is running,
+// through the config modal, the admin API, or by reloading the config file.
+// The id is the name of the setting's flag.
+type configSetting struct {
+	id    string
+	label string
+	// modal is set for the settings shown in the config modal.
+	modal bool
+	get   func(o *options) string
+	set   func(o *options, value string) error
+}
+
+var configSettings = []configSetting{
+	durationSetting("accepted-staleness-limit", "Accepted staleness limit", func(o *options) *time.Duration { return &o.acceptedStalenessLimit }),
+	durationSetting("built-staleness-limit", "Built staleness limit", func(o *options) *time.Duration { return &o.builtStalenessLimit }),
+	durationSetting("upgrade-staleness-limit", "Upgrade staleness limit", func(o *options) *time.Duration { return &o.upgradeStalenessLimit }),
+	{
+		id:    "min-acceptance-rate",
+		label: "Minimum acceptance rate",
+		modal: true,
+		get:   func(o *options) string { return strconv.FormatFloat(o.minAcceptanceRate, 'g', -1, 64) },
+		set: func(o *options, value string) error {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return fmt.Errorf("expected a fraction between 0 and 1 like \"0.5\"")
+			}
+			o.minAcceptanceRate = rate
+			return nil
+		},
+	},
+	minorSetting("oldest-minor", "Oldest minor", func(o *options) *int { return &o.oldestMinor }),
+	minorSetting("newest-minor", "Newest minor", func(o *options) *int { return &o.newestMinor }),
+	{
+		id:  "slack-alias",
+		get: func(o *options) string { return o.slackAlias },
+		set: func(o *options, value string) error {
+			o.slackAlias = value
+			return nil
+		},
+	},
+	mapSetting("slack-alias-map", func(o *options) *map[string]string { return &o.slackAliasMap }, validateAliasMap),
+	mapSetting("channel-map", func(o *options) *map[string]string { return &o.channelMap }, func(m map[string]string) error {
+		_, err := parseChannelMap(m)
+		return err
+	}),
+}
+
+func durationSetting(id, label string, field func(o *options) *time.Duration) configSetting {
+	return configSetting{
+		id:    id,
+		label: label,
+		modal: true,
+		get:   func(o *options) string { return field(o).String() },
+		set: func(o *options, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("expected a positive duration like \"24h\"")
+			}
+			*field(o) = d
+			return nil
+		},
+	}
+}
+
+func minorSetting(id, label string, field func(o *options) *int) configSetting {
+	return configSetting{
+		id:    id,
+		label: label,
+		modal: true,
+		get:   func(o *options) string { return strconv.Itoa(*field(o)) },
+		set: func(o *options, value string) error {
+			minor, err := parseMinor(value)
+			if err != nil {
+				return fmt.Errorf("expected a minor version like \"16\"")
+			}
+			*field(o) = minor
+			return nil
+		},
+	}
+}
+
+// mapSetting is a mapping setting, formatted like the flag: "k1=v1,k2=v2".
+func mapSetting(id string, field func(o *options) *map[string]string, validate func(map[string]string) error) configSetting {
+	return configSetting{
+		id: id,
+		get: func(o *options) string {
+			pairs := []string{}
+			for k, v := range *field(o) {
+				pairs = append(pairs, k+"="+v)
+			}
+			sort.Strings(pairs)
+			return strings.Join(pairs, ",")
+		},
+		set: func(o *options, value string) error {
+			m := map[string]string{}
+			for _, pair := range strings.Split(value, ",") {
+				if pair == "" {
+					continue
+				}
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("%q must be formatted as key=value", pair)
+				}
+				m[kv[0]] = kv[1]
+			}
+			if err := validate(m); err != nil {
+				return err
+			}
+			*field(o) = m
+			return nil
+		},
+	}
+}
+
+func (o *options) isAdmin(user string) bool {
+	return containsString(o.adminUsers, user)
+}
+
+// openConfigModal opens a modal showing the current settings, which admins
+// can edit and submit.
+func (o *options) openConfigModal(command SlashCommand) error {
+	if !o.isAdmin(command.UserID) {
+		return postEphemeral(command.ChannelID, command.UserID, "Sorry, you are not allowed to change the configuration")
+	}
+
+	o.settingsLock.RLock()
+	blocks := []Block{}
+	for _, setting := range configSettings {
+		if !setting.modal {
+			continue
+		}
+		blocks = append(blocks, Block{
+			Type:    "input",
+			BlockID: setting.id,
+			Label:   plainText(setting.label),
+			Element: &InputElement{Type: "plain_text_input", ActionID: setting.id, InitialValue: setting.get(o)},
+		})
+	}
+	o.settingsLock.RUnlock()
+
+	_, err := callSlack("views.open", OpenView{
+		TriggerID: command.TriggerID,
+		View: View{
+			Type:       "modal",
+			CallbackID: configCallbackID,
+			Title:      plainText("Release Watcher"),
+			Submit:     plainText("Apply"),
+			Close:      plainText("Cancel"),
+			Blocks:     blocks,
+		},
+	})
+	return err
+}
+
+// applyConfigSubmission validates and applies the settings submitted from
+// the config modal.  If any value is invalid nothing is applied and the
+// returned response reports the errors.
+func (o *options) applyConfigSubmission(payload InteractionPayload) interface{} {
+	if !o.isAdmin(payload.User.ID) {
+		klog.Errorf("ignoring config submission from unauthorized user %s", payload.User.ID)
+		return nil
+	}
+
+	if payload.View.State == nil {
+		// errors can only be shown on the inputs of the modal
+		errors := map[string]string{}
+		for _, setting := range configSettings {
+			if setting.modal {
+				errors[setting.id] = "the submission had no values, please try again"
+			}
+		}
+		return &ViewSubmissionResponse{ResponseAction: "errors", Errors: errors}
+	}
+	values := map[string]string{}
+	for _, setting := range configSettings {
+		if setting.modal {
+			values[setting.id] = payload.View.State.Values[setting.id][setting.id].Value
+		}
+	}
+	if errors := o.updateSettings(values); len(errors) > 0 {
+		return &ViewSubmissionResponse{ResponseAction: "errors", Errors: errors}
+	}
+	klog.V(2).Infof("user %s changed the configuration: %v", payload.User.ID, values)
+	return nil
+}
+
+// currentSettings returns the runtime adjustable settings, by id.
+func (o *options) currentSettings() map[string]string {
+	o.settingsLock.RLock()
+	defer o.settingsLock.RUnlock()
+	values := map[string]string{}
+	for _, setting := range configSettings {
+		values[setting.id] = setting.get(o)
+	}
+	return values
+}
+
+// updateSettings validates and applies new values for the settings with the
+// given ids, and persists them so they are restored after a restart.  If any
+// value is invalid nothing is applied and the errors are returned by id.
+func (o *options) updateSettings(values map[string]string) map[string]string {
+	o.settingsLock.Lock()
+	defer o.settingsLock.Unlock()
+
+	errors := o.setSettings(values)
+	if len(errors) > 0 {
+		return errors
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if state.Settings == nil {
+		state.Settings = map[string]string{}
+	}
+	for id, value := range values {
+		state.Settings[id] = value
+	}
+	o.saveState()
+	invalidateReportCache()
+	return nil
+}
+
+// setSettings applies the values if they are all valid.  Callers must hold
+// the settings lock.
+func (o *options) setSettings(values map[string]string) map[string]string {
+	// validate against a copy so invalid values leave the settings alone
+	updated := &options{}
+	errors := map[string]string{}
+	for _, setting := range configSettings {
+		if err := setting.set(updated, setting.get(o)); err != nil {
+			errors[setting.id] = "invalid current value: " + err.Error()
+		}
+	}
+	for id, value := range values {
+		setting, ok := findSetting(id)
+		if !ok {
+			errors[id] = "unknown setting"
+			continue
+		}
+		if err := setting.set(updated, value); err != nil {
+			errors[id] = err.Error()
+		}
+	}
+	if updated.oldestMinor > updated.newestMinor {
+		errors["oldest-minor"] = "must not be newer than the newest minor"
+	}
+	// the acceptance window isn't a setting, so it is checked against the
+	// bot's own
+	if updated.minAcceptanceRate > 0 && o.acceptanceWindow <= 0 {
+		errors["min-acceptance-rate"] = "the acceptance-window must be set to check the acceptance rate"
+	}
+	if len(errors) > 0 {
+		return errors
+	}
+
+	for _, setting := range configSettings {
+		setting.set(o, setting.get(updated))
+	}
+	return nil
+}
+
+func findSetting(id string) (configSetting, bool) {
+	for _, setting := range configSettings {
+		if setting.id == id {
+			return setting, true
+		}
+	}
+	return configSetting{}, false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"github.com/spf13/pflag"
+	"k8s.io/klog"
+)
+
+const (
+	nightlyActionID = "request-nightly"
+	// gangwayTokenEnv holds the token to trigger the --nightly-build-jobs
+	// through Gangway, Prow's api, with.
+	gangwayTokenEnv = "GANGWAY_TOKEN"
+	// nightlyRequestCooldown is how long after a new nightly was requested
+	// for a stream the button does nothing, so clicks of several people
+	// looking at the same report don't start a build each.
+	nightlyRequestCooldown = time.Hour
+)
+
+var (
+	gangwayClient = &http.Client{Timeout: 30 * time.Second}
+	// nightlyBuildJobs are the --nightly-build-jobs of the bot, by stream or
+	// minor version, which the reports offer to trigger.  Guarded by
+	// stateMutex, as they are reloaded with the config file.
+	nightlyBuildJobs = map[string]string{}
+
+	nightlyRequestsLock = &sync.Mutex{}
+	// nightlyRequests are when a new nightly was last requested, by stream.
+	nightlyRequests = map[string]time.Time{}
+)
+
+// nightlyBuildJob returns the Prow job that builds a new payload of the
+// stream, e.g. 4.16.0-0.nightly, or "" if it isn't a nightly or none is
+// configured for it.
+func nightlyBuildJob(stream string) string {
+	if !strings.Contains(stream, ".nightly") {
+		return ""
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if job, ok := nightlyBuildJobs[stream]; ok {
+		return job
+	}
+	// the minor version is the start of the stream name
+	parts := strings.SplitN(stream, ".", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return nightlyBuildJobs[parts[0]+"."+parts[1]]
+}
+
+// validateNightlyBuildJobs checks the --nightly-build-jobs, and that there is
+// a token to trigger them with if there are any.
+func validateNightlyBuildJobs(jobs map[string]string) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	if err := validateAliasMap(jobs); err != nil {
+		return fmt.Errorf("invalid nightly-build-jobs: %v", err)
+	}
+	if os.Getenv(gangwayTokenEnv) == "" {
+		return fmt.Errorf("nightly-build-jobs are triggered with the token in %s, which is not set", gangwayTokenEnv)
+	}
+	return nil
+}
+
+// reloadedNightlyBuildJobs returns the --nightly-build-jobs as of the config
+// file, unless they were given on the command line or in the environment.
+func (o *options) reloadedNightlyBuildJobs(config *configFile) (map[string]string, error) {
+	if o.explicitFlags["nightly-build-jobs"] {
+		return o.nightlyBuildJobs, nil
+	}
+	value, ok := config.values["nightly-build-jobs"]
+	if !ok {
+		return nil, nil
+	}
+	flagset := pflag.NewFlagSet("nightly-build-jobs", pflag.ContinueOnError)
+	jobs := flagset.StringToString("nightly-build-jobs", nil, "")
+	if err := flagset.Set("nightly-build-jobs", value); err != nil {
+		return nil, fmt.Errorf("invalid nightly-build-jobs: %v", err)
+	}
+	if err := validateNightlyBuildJobs(*jobs); err != nil {
+		return nil, err
+	}
+	return *jobs, nil
+}
+
+// stillCritical returns true if the stream is critical in the cached report,
+// so the buttons of old reports don't request nightlies of streams that have
+// recovered since.
+func stillCritical(stream string) bool {
+	reportCacheMutex.Lock()
+	report := cachedReport
+	reportCacheMutex.Unlock()
+	if report == nil {
+		return false
+	}
+	for _, s := range report.Streams {
+		if s.Name == stream {
+			return s.Severity() == releasewatch.SeverityCritical
+		}
+	}
+	return false
+}
+
+// nightlyButton returns the button requesting a new nightly of the stream if
+// it is critical and has a build job, or nil.
+func nightlyButton(stream releasewatch.StreamReport) *ButtonElement {
+	if stream.Severity() != releasewatch.SeverityCritical || nightlyBuildJob(stream.Name) == "" {
+		return nil
+	}
+	return actionButton("Request a new nightly", nightlyActionID, stream.Name)
+}
+
+// gangwayExecution is the request to, and the response of, Gangway's
+// executions api.
+type gangwayExecution struct {
+	ID       string `json:"id,omitempty"`
+	JobName  string `json:"job_name"`
+	JobType  string `json:"job_execution_type,omitempty"`
+	JobState string `json:"job_status,omitempty"`
+}
+
+// triggerProwJob starts a run of the periodic job through Gangway and returns
+// its id.
+func (o *options) triggerProwJob(job string) (string, error) {
+	if slackDryRun {
+		klog.Infof("dry run, not triggering Prow job %s", job)
+		return "dry-run", nil
+	}
+	// 1 is a periodic job, which the nightly builds are
+	body, _ := json.Marshal(gangwayExecution{JobName: job, JobType: "1"})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(o.gangwayURL, "/")+"/v1/executions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv(gangwayTokenEnv))
+	resp, err := gangwayClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error triggering Prow job %s: %v", job, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("error triggering Prow job %s: %s", job, resp.Status)
+	}
+	execution := gangwayExecution{}
+	if err := json.NewDecoder(resp.Body).Decode(&execution); err != nil {
+		return "", fmt.Errorf("error decoding the execution of Prow job %s: %v", job, err)
+	}
+	return execution.ID, nil
+}
+
+// requestNightly triggers the build job of a stream on behalf of the user and
+// returns what to post in the thread of the report.  Only the admins and the
+// --nightly-build-users may, only while the stream is critical, and only once
+// every nightlyRequestCooldown.
+func (o *options) requestNightly(name, user, channel string) string {
+	if !o.isAllowed(user, o.nightlyBuildUsers) {
+		if err := postEphemeral(channel, user, "Sorry, you are not allowed to request new nightlies"); err != nil {
+			klog.Errorf("error telling %s they can't request a nightly: %v", user, err)
+		}
+		return ""
+	}
+	job := nightlyBuildJob(name)
+	if job == "" {
+		return fmt.Sprintf("<@%s> no build job is configured for %s", user, name)
+	}
+	if !stillCritical(name) {
+		return fmt.Sprintf("<@%s> %s isn't critical in the current report, so no new nightly was requested", user, name)
+	}
+
+	nightlyRequestsLock.Lock()
+	if last, ok := nightlyRequests[name]; ok && time.Since(last) < nightlyRequestCooldown {
+		nightlyRequestsLock.Unlock()
+		return fmt.Sprintf("<@%s> a new nightly of %s was already requested %s ago", user, name, time.Since(last).Round(time.Minute))
+	}
+	nightlyRequests[name] = time.Now()
+	nightlyRequestsLock.Unlock()
+
+	klog.V(2).Infof("user %s requested a new nightly of %s", user, name)
+	id, err := o.triggerProwJob(job)
+	if err != nil {
+		klog.Errorf("error requesting a new nightly of %s: %v", name, err)
+		nightlyRequestsLock.Lock()
+		delete(nightlyRequests, name)
+		nightlyRequestsLock.Unlock()
+		return fmt.Sprintf("<@%s> failed to request a new nightly of %s: %v", user, name, err)
+	}
+	return fmt.Sprintf("<@%s> requested a new nightly of %s: triggered %s (execution %s)", user, name, job, id)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// outputCopyTimeFormat suffixes the timestamped copies of the --output-file,
+// so they sort in the order the reports were generated in.
+const outputCopyTimeFormat = "20060102T150405Z"
+
+// writeOutputFile writes the rendered report to the --output-file.  It is
+// written to a temporary file in the same directory that is renamed over the
+// output file, so a reader such as a web server always sees a complete
+// report.  With --output-file-copies a copy suffixed with the time the report
+// was generated, e.g. report.txt.20240501T101010Z, is kept next to it, and the
+// oldest copies beyond that many are removed.
+func (o *options) writeOutputFile(output string, generatedAt time.Time) error {
+	if err := writeFileAtomically(o.outputFile, []byte(output)); err != nil {
+		return fmt.Errorf("error writing the report to %s: %v", o.outputFile, err)
+	}
+	if o.outputFileCopies < 1 {
+		return nil
+	}
+	copyName := o.outputFile + "." + generatedAt.UTC().Format(outputCopyTimeFormat)
+	if err := writeFileAtomically(copyName, []byte(output)); err != nil {
+		return fmt.Errorf("error writing a copy of the report to %s: %v", copyName, err)
+	}
+	return o.pruneOutputCopies()
+}
+
+// pruneOutputCopies removes the oldest copies of the --output-file beyond
+// --output-file-copies.
+func (o *options) pruneOutputCopies() error {
+	matches, err := filepath.Glob(o.outputFile + ".*")
+	if err != nil {
+		return fmt.Errorf("error listing the copies of %s: %v", o.outputFile, err)
+	}
+	copies := []string{}
+	for _, match := range matches {
+		if _, err := time.Parse(outputCopyTimeFormat, match[len(o.outputFile)+1:]); err == nil {
+			copies = append(copies, match)
+		}
+	}
+	sort.Strings(copies)
+	for len(copies) > o.outputFileCopies {
+		if err := os.Remove(copies[0]); err != nil {
+			return fmt.Errorf("error removing an old copy of the report: %v", err)
+		}
+		copies = copies[1:]
+	}
+	return nil
+}
+
+// writeFileAtomically replaces the file with the data through a temporary
+// file that is renamed over it.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// ioutil.TempFile creates the file readable only by its owner
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+const (
+	pagerDutyEventsURL     = "https://events.pagerduty.com/v2/enqueue"
+	pagerDutyRoutingKeyEnv = "PAGERDUTY_ROUTING_KEY"
+)
+
+var pagerDutyClient = &http.Client{Timeout: 30 * time.Second}
+
+// PagerDutyEvent is a PagerDuty Events API v2 event.
+type PagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *PagerDutyPayload `json:"payload,omitempty"`
+}
+
+type PagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+func sendPagerDutyEvent(event PagerDutyEvent) error {
+	if slackDryRun {
+		klog.Infof("dry run, not sending the PagerDuty %s event for %s", event.EventAction, event.DedupKey)
+		return nil
+	}
+	event.RoutingKey = os.Getenv(pagerDutyRoutingKeyEnv)
+	body, _ := json.Marshal(event)
+	resp, err := pagerDutyClient.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error sending PagerDuty event: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("error sending PagerDuty event: %s", resp.Status)
+	}
+	return nil
+}
+
+// sendSeverityAlerts logs and pages for the problems of the severity routes
+// that ask for it.  Incidents are resolved once their problems are no longer
+// reported or have been acknowledged.  The open incidents are kept in the
+// state file.
+func (o *options) sendSeverityAlerts(report *releasewatch.Report) {
+	stateMutex.Lock()
+	incidents := map[string]struct{}{}
+	for _, key := range state.PagerDutyIncidents {
+		incidents[key] = struct{}{}
+	}
+	stateMutex.Unlock()
+
+	paged := map[string]struct{}{}
+	for _, route := range currentSeverityRoutes() {
+		if !route.Log && !route.PagerDuty {
+			continue
+		}
+		for _, stream := range report.Streams {
+			for _, p := range stream.Problems {
+				if p.Severity != route.Severity {
+					continue
+				}
+				if route.Log {
+					klog.Infof("%s problem in %s: %s", p.Severity, stream.Name, p.Message)
+				}
+				key := problemKey(stream.Name, p.Kind)
+				if _, ok := paged[key]; !route.PagerDuty || ok {
+					continue
+				}
+				paged[key] = struct{}{}
+				// triggering an open incident again only updates it
+				err := sendPagerDutyEvent(PagerDutyEvent{
+					EventAction: "trigger",
+					DedupKey:    key,
+					Payload: &PagerDutyPayload{
+						Summary:  fmt.Sprintf("%s: %s", stream.Name, p.Message),
+						Source:   stream.URL(),
+						Severity: string(p.Severity),
+					},
+				})
+				if err != nil {
+					klog.Errorf("error paging for %s: %v", key, err)
+					continue
+				}
+				incidents[key] = struct{}{}
+			}
+		}
+	}
+
+	for key := range incidents {
+		if _, ok := paged[key]; ok {
+			continue
+		}
+		if err := sendPagerDutyEvent(PagerDutyEvent{EventAction: "resolve", DedupKey: key}); err != nil {
+			klog.Errorf("error resolving the incident for %s: %v", key, err)
+			continue
+		}
+		delete(incidents, key)
+	}
+
+	keys := []string{}
+	for key := range incidents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state.PagerDutyIncidents = keys
+	o.saveState()
+}
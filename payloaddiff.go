@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+// The formats payload-diff can print the diff in.
+const (
+	diffFormatText     = "text"
+	diffFormatMarkdown = "markdown"
+	diffFormatJSON     = "json"
+)
+
+// runPayloadDiff prints what changed between two payloads.
+func (o *options) runPayloadDiff(ctx context.Context, from, to string) error {
+	var render func(*releasewatch.PayloadDiff) (string, error)
+	switch o.diffFormat {
+	case diffFormatText:
+		render = func(diff *releasewatch.PayloadDiff) (string, error) { return diff.String(), nil }
+	case diffFormatMarkdown:
+		render = func(diff *releasewatch.PayloadDiff) (string, error) { return payloadDiffMarkdown(diff), nil }
+	case diffFormatJSON:
+		render = func(diff *releasewatch.PayloadDiff) (string, error) {
+			data, err := json.MarshalIndent(diff, "", "  ")
+			return string(data), err
+		}
+	default:
+		return fmt.Errorf("invalid format %q: must be %s, %s or %s", o.diffFormat, diffFormatText, diffFormatMarkdown, diffFormatJSON)
+	}
+	diff, err := o.watcher.PayloadDiff(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	output, err := render(diff)
+	if err != nil {
+		return fmt.Errorf("error rendering the diff: %v", err)
+	}
+	fmt.Println(strings.TrimSuffix(output, "\n"))
+	return nil
+}
+
+// payloadDiffMarkdown renders the diff as markdown, e.g. for a bug or a pull
+// request, with a table of the updated images and the pull requests linked.
+func payloadDiffMarkdown(diff *releasewatch.PayloadDiff) string {
+	output := fmt.Sprintf("# %s to %s\n\n%s.\n\n", diff.From, diff.To, diff.Summary())
+	if len(diff.Components) > 0 {
+		output += "## Components\n\n"
+		for _, component := range diff.Components {
+			output += fmt.Sprintf("- %s %s → %s\n", component.Name, component.From, component.To)
+		}
+		output += "\n"
+	}
+	if len(diff.UpdatedImages) > 0 {
+		output += "## Updated images\n\n| Image | Commit | Commits |\n| --- | --- | --- |\n"
+		for _, image := range diff.UpdatedImages {
+			name := image.Name
+			if image.Repo != "" {
+				name = fmt.Sprintf("[%s](%s)", image.Name, image.Repo)
+			}
+			output += fmt.Sprintf("| %s | `%s` | %d |\n", name, image.Commit, image.Commits)
+		}
+		output += "\n"
+	}
+	if len(diff.NewImages) > 0 {
+		output += "## New images\n\n" + strings.Join(diff.NewImages, ", ") + "\n\n"
+	}
+	if len(diff.RemovedImages) > 0 {
+		output += "## Removed images\n\n" + strings.Join(diff.RemovedImages, ", ") + "\n\n"
+	}
+	if len(diff.PullRequests) > 0 {
+		output += "## Pull requests\n\n"
+		for _, pull := range diff.PullRequests {
+			output += fmt.Sprintf("- [%s#%d](%s) %s (%s)\n", pull.Repo, pull.Number, pull.URL, pull.Title, pull.Component)
+		}
+	}
+	return strings.TrimSuffix(output, "\n") + "\n"
+}
@@ -0,0 +1,177 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AcceptanceStats summarizes how the payloads of a stream were accepted over
+// a trailing window.
+type AcceptanceStats struct {
+	Window time.Duration
+	// Accepted is how many of the Finished payloads built within the
+	// window, the ones that are no longer being verified, were accepted.
+	Accepted int
+	Finished int
+	// Measured is how many of the payloads built and accepted within the
+	// window the latency from being built to being accepted could be
+	// measured for, and AverageLatency and P90Latency are that latency.
+	Measured       int
+	AverageLatency time.Duration
+	P90Latency     time.Duration
+	// Trend is how the AverageLatency changed over the reports that
+	// measured it, if the caller added the trends and there were enough
+	// of them.
+	Trend *AcceptanceTrend
+}
+
+// String describes the stats, e.g. "3/14 accepted, 5h after being built on
+// average, 9h at p90, over 3 payloads".
+func (a *AcceptanceStats) String() string {
+	output := fmt.Sprintf("%d/%d accepted", a.Accepted, a.Finished)
+	if a.Measured == 0 {
+		return output
+	}
+	payloads := "payloads"
+	if a.Measured == 1 {
+		payloads = "payload"
+	}
+	output += fmt.Sprintf(", %s after being built on average, %s at p90, over %d %s", formatAge(a.AverageLatency), formatAge(a.P90Latency), a.Measured, payloads)
+	if a.Trend != nil {
+		output += ", " + a.Trend.String()
+	}
+	return output
+}
+
+// AcceptanceLines describes the acceptance of each stream that has
+// AcceptanceStats, e.g. "4.16.0-0.nightly: 3/14 accepted, 5h after ...".
+func (r *Report) AcceptanceLines() []string {
+	lines := []string{}
+	for _, stream := range r.Streams {
+		if stream.Acceptance != nil {
+			lines = append(lines, stream.Name+": "+stream.Acceptance.String())
+		}
+	}
+	return lines
+}
+
+// AcceptanceHeading is the heading of the AcceptanceLines, e.g.
+// "Acceptance over the last 7.0d".
+func (r *Report) AcceptanceHeading() string {
+	for _, stream := range r.Streams {
+		if stream.Acceptance != nil {
+			return "Acceptance over the last " + formatAge(stream.Acceptance.Window)
+		}
+	}
+	return ""
+}
+
+// addAcceptanceStats counts how many of the payloads of each stream that were
+// built within the window were accepted, and measures how long they took to
+// be accepted.  The release controller
+// doesn't record when a payload was accepted, so it is taken to be when the
+// last of its blocking jobs finished.  Measuring a payload takes a request.
+func addAcceptanceStats(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, window time.Duration) error {
+	since := r.GeneratedAt.Add(-window)
+	latencies := make([][]time.Duration, len(r.Streams))
+	finished := make([]int, len(r.Streams))
+	fetches := []func() error{}
+	for i := range r.Streams {
+		i, stream := i, r.Streams[i]
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			accepted := []Payload{}
+			for _, payload := range payloads {
+				if !payload.Created.After(since) {
+					continue
+				}
+				switch payload.Phase {
+				case "Accepted":
+					accepted = append(accepted, payload)
+					finished[i]++
+				case "Rejected", "Failed":
+					finished[i]++
+				}
+			}
+			// the payloads are measured one at a time, to not hold more than
+			// one of the parallel slots per stream
+			for _, payload := range accepted {
+				results, err := client.getJobResults(ctx, releaseAPIUrl, stream.Name, payload.Name)
+				if err != nil {
+					return err
+				}
+				latencies[i] = append(latencies[i], acceptedAt(results).Sub(payload.Created))
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error measuring acceptance latency: %v", err)
+	}
+	for i := range r.Streams {
+		r.Streams[i].Acceptance = acceptanceStats(latencies[i], window)
+		r.Streams[i].Acceptance.Accepted = len(latencies[i])
+		r.Streams[i].Acceptance.Finished = finished[i]
+	}
+	return nil
+}
+
+// acceptedAt returns when the last of the blocking jobs finished, zero if
+// none did.
+func acceptedAt(results *jobResults) time.Time {
+	last := time.Time{}
+	for _, result := range results.BlockingJobs {
+		if result.TransitionTime.After(last) {
+			last = result.TransitionTime
+		}
+	}
+	return last
+}
+
+// acceptanceStats summarizes the latencies.  Latencies that couldn't be
+// measured, which are negative, are skipped.
+func acceptanceStats(latencies []time.Duration, window time.Duration) *AcceptanceStats {
+	measured := []time.Duration{}
+	for _, latency := range latencies {
+		if latency > 0 {
+			measured = append(measured, latency)
+		}
+	}
+	stats := &AcceptanceStats{Window: window, Measured: len(measured)}
+	if len(measured) == 0 {
+		return stats
+	}
+	sort.Slice(measured, func(i, j int) bool { return measured[i] < measured[j] })
+	total := time.Duration(0)
+	for _, latency := range measured {
+		total += latency
+	}
+	stats.AverageLatency = total / time.Duration(len(measured))
+	// the nearest-rank p90
+	stats.P90Latency = measured[(len(measured)*9+9)/10-1]
+	return stats
+}
+
+// checkAcceptanceRates flags the streams that accepted less than the minimum
+// rate of their finished payloads within the acceptance window, even if
+// their newest accepted payload is fresh.
+func checkAcceptanceRates(r *Report, minRate float64) {
+	if minRate <= 0 {
+		return
+	}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		stats := stream.Acceptance
+		if stats == nil || stats.Finished == 0 {
+			continue
+		}
+		if rate := float64(stats.Accepted) / float64(stats.Finished); rate < minRate {
+			stream.Problems = append(stream.Problems, newProblem(ProblemLowAcceptanceRate, fmt.Sprintf("Accepted %d of the %d payloads built in the last %s (%.0f%%), below the minimum acceptance rate of %.0f%%", stats.Accepted, stats.Finished, formatAge(stats.Window), rate*100, minRate*100)))
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package releasewatch
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultArch is the architecture of the streams whose names have no
+	// architecture suffix.
+	DefaultArch = "amd64"
+	// MultiArch is the "architecture" of the heterogeneous streams of the
+	// multi release controller at MultiReleaseAPIURL, e.g.
+	// 4.16.0-0.nightly-multi.  Their payloads are assembled from the payloads
+	// of the single architecture streams with the same name.
+	MultiArch          = "multi"
+	MultiReleaseAPIURL = "https://multi.ocp.releases.ci.openshift.org"
+)
+
+// Arches are the architectures with a release controller of their own, whose
+// url ReleaseAPIURLForArch returns.
+var Arches = []string{DefaultArch, "arm64", MultiArch, "ppc64le", "s390x"}
+
+// ReleaseAPIURLForArch returns the url of the release controller of the
+// architecture, e.g. https://arm64.ocp.releases.ci.openshift.org for arm64.
+func ReleaseAPIURLForArch(arch string) (string, error) {
+	for _, known := range Arches {
+		if arch == known {
+			return fmt.Sprintf(releaseControllerURL, arch), nil
+		}
+	}
+	return "", fmt.Errorf("unknown architecture %q, must be one of %s", arch, strings.Join(Arches, ", "))
+}
+
+// releasePageURL returns the url of a page of the release controller the
+// streams are on, e.g. /#4.16.0-0.nightly.  Without the release controller's
+// url, as in the reports saved before it was recorded, that of the
+// architecture is used.
+func releasePageURL(releaseURL, arch, path string) string {
+	if releaseURL == "" {
+		releaseURL = fmt.Sprintf(releaseControllerURL, arch)
+	}
+	return strings.TrimSuffix(releaseURL, "/") + path
+}
+
+// the release controllers of the other architectures suffix their stream
+// names with the architecture, e.g. 4.16.0-0.nightly-arm64
+var streamArchRegex = regexp.MustCompile(`4\.[1-9][0-9]*\.0-0\.(?:ci|nightly)-([a-z0-9]+)$`)
+
+func streamArch(stream string) string {
+	if m := streamArchRegex.FindStringSubmatch(stream); m != nil {
+		return m[1]
+	}
+	return DefaultArch
+}
+
+var problemDescriptions = map[ProblemKind]string{
+	ProblemNoAcceptedPayloads:    "no accepted payloads",
+	ProblemStaleAcceptedPayload:  "stale accepted payload",
+	ProblemNoBuiltPayloads:       "no built payloads",
+	ProblemStaleBuiltPayload:     "stale built payload",
+	ProblemNoPatchUpgrade:        "no recent patch upgrade",
+	ProblemNoMinorUpgrade:        "no recent minor upgrade",
+	ProblemLowAcceptanceRate:     "low acceptance rate",
+	ProblemBuildCadenceDrop:      "build cadence drop",
+	ProblemStuckVerification:     "stuck verification",
+	ProblemNoPathUpgrade:         "no recent upgrade on a watched path",
+	ProblemLowUpgradeSuccessRate: "low upgrade success rate",
+	ProblemArchSkew:              "behind another architecture",
+	ProblemSlowingAcceptance:     "slowing acceptance",
+	ProblemUnpublishedPayload:    "unpublished stable payload",
+	ProblemPromotionLag:          "stalled channel promotion",
+	ProblemStaleDevPreview:       "stale engineering candidate",
+	ProblemLostUpgradeSource:     "lost upgrade source",
+	ProblemRejectionStreak:       "rejection streak",
+}
+
+// Description is a short description of the kind of problem, e.g. "stale
+// accepted payload".
+func (k ProblemKind) Description() string {
+	if description, ok := problemDescriptions[k]; ok {
+		return description
+	}
+	return string(k)
+}
+
+// URL is the link to the stream's page on its release controller.
+func (s *StreamReport) URL() string {
+	return releasePageURL(s.ReleaseURL, s.Arch, "/#"+s.Name)
+}
+
+// PayloadURL returns the url of the release controller page of one of the
+// stream's payloads.
+func (s *StreamReport) PayloadURL(payload string) string {
+	return releasePageURL(s.ReleaseURL, s.Arch, "/releasestream/"+s.Name+"/release/"+payload)
+}
+
+// Arches returns the architectures of the streams in the report, sorted.
+func (r *Report) Arches() []string {
+	seen := map[string]struct{}{}
+	arches := []string{}
+	for _, stream := range r.Streams {
+		if _, ok := seen[stream.Arch]; !ok {
+			seen[stream.Arch] = struct{}{}
+			arches = append(arches, stream.Arch)
+		}
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// ForArch returns a copy of the report that only includes the streams of the
+// architecture.
+func (r *Report) ForArch(arch string) *Report {
+	filtered := *r
+	filtered.Streams = nil
+	for _, stream := range r.Streams {
+		if stream.Arch == arch {
+			filtered.Streams = append(filtered.Streams, stream)
+		}
+	}
+	return &filtered
+}
+
+// CrossArchProblems describes the problems that affect the same minor
+// version in every architecture of the report, e.g. "4.16 no recent minor
+// upgrade".  Those are likely to have a common cause rather than an
+// architecture specific one.  It is empty unless the report covers more than
+// one architecture.
+func (r *Report) CrossArchProblems() []string {
+	arches := r.Arches()
+	if len(arches) < 2 {
+		return nil
+	}
+	// the multi streams are made of the others, so they don't count as an
+	// architecture of their own
+	for i, arch := range arches {
+		if arch == MultiArch {
+			arches = append(arches[:i:i], arches[i+1:]...)
+			break
+		}
+	}
+	if len(arches) < 2 {
+		return nil
+	}
+	type minorProblem struct {
+		minor int
+		kind  ProblemKind
+	}
+	affected := map[minorProblem]map[string]struct{}{}
+	order := []minorProblem{}
+	for _, stream := range r.Streams {
+		if stream.Arch == MultiArch {
+			continue
+		}
+		for _, p := range stream.Problems {
+			key := minorProblem{stream.Minor, p.Kind}
+			if affected[key] == nil {
+				affected[key] = map[string]struct{}{}
+				order = append(order, key)
+			}
+			affected[key][stream.Arch] = struct{}{}
+		}
+	}
+	problems := []string{}
+	for _, key := range order {
+		if len(affected[key]) == len(arches) {
+			problems = append(problems, fmt.Sprintf("4.%d %s", key.minor, key.kind.Description()))
+		}
+	}
+	return problems
+}
+
+// MergeReports combines the reports of several release controllers, such as
+// those of different architectures, into a single report sorted by minor
+// version.  The minor range covers the ranges of all of the reports, and the
+// report is marked as cached if any of them were.
+func MergeReports(reports ...*Report) *Report {
+	merged := &Report{}
+	for i, r := range reports {
+		merged.Streams = append(merged.Streams, r.Streams...)
+		if i == 0 || r.OldestMinor < merged.OldestMinor {
+			merged.OldestMinor = r.OldestMinor
+		}
+		if i == 0 || r.NewestMinor > merged.NewestMinor {
+			merged.NewestMinor = r.NewestMinor
+		}
+		if r.GeneratedAt.After(merged.GeneratedAt) {
+			merged.GeneratedAt = r.GeneratedAt
+		}
+		if r.UpgradeWindow > merged.UpgradeWindow {
+			merged.UpgradeWindow = r.UpgradeWindow
+		}
+		if !r.CachedAt.IsZero() && (merged.CachedAt.IsZero() || r.CachedAt.Before(merged.CachedAt)) {
+			merged.CachedAt = r.CachedAt
+		}
+		for _, ignore := range r.Ignored {
+			if !containsIgnore(merged.Ignored, ignore) {
+				merged.Ignored = append(merged.Ignored, ignore)
+			}
+		}
+		if merged.Location == nil {
+			merged.Location = r.Location
+		}
+		merged.SLOs = append(merged.SLOs, r.SLOs...)
+		merged.Unavailable = append(merged.Unavailable, r.Unavailable...)
+	}
+	merged.Sort(SortByMinor)
+	correlateMultiStreams(merged)
+	return merged
+}
+
+// Constituent is one of the single architecture streams that the payloads of
+// a multi stream are assembled from.
+type Constituent struct {
+	Name           string
+	Arch           string
+	LatestAccepted string
+	Problems       []ProblemKind
+}
+
+// String describes the constituent, e.g. "4.16.0-0.nightly-arm64 (arm64):
+// accepted 4.16.0-0.nightly-arm64-2024-05-01-101010, stale accepted
+// payload".
+func (c Constituent) String() string {
+	accepted := "no accepted payloads"
+	if c.LatestAccepted != "" {
+		accepted = "accepted " + c.LatestAccepted
+	}
+	problems := []string{}
+	for _, kind := range c.Problems {
+		problems = append(problems, kind.Description())
+	}
+	if len(problems) == 0 {
+		problems = append(problems, "healthy")
+	}
+	return fmt.Sprintf("%s (%s): %s, %s", c.Name, c.Arch, accepted, strings.Join(problems, ", "))
+}
+
+// correlateMultiStreams sets the Constituents of the multi streams of the
+// report to the single architecture streams of the report with the same
+// name, so a problem with a multi stream can be traced to the architecture
+// that causes it.
+func correlateMultiStreams(r *Report) {
+	for i := range r.Streams {
+		multi := &r.Streams[i]
+		if multi.Arch != MultiArch {
+			continue
+		}
+		multi.Constituents = nil
+		base := strings.TrimSuffix(multi.Name, "-"+MultiArch)
+		for _, stream := range r.Streams {
+			if stream.Arch == MultiArch || strings.TrimSuffix(stream.Name, "-"+stream.Arch) != base {
+				continue
+			}
+			c := Constituent{Name: stream.Name, Arch: stream.Arch, LatestAccepted: stream.LatestAccepted}
+			for _, p := range stream.Problems {
+				c.Problems = append(c.Problems, p.Kind)
+			}
+			multi.Constituents = append(multi.Constituents, c)
+		}
+		sort.Slice(multi.Constituents, func(i, j int) bool { return multi.Constituents[i].Arch < multi.Constituents[j].Arch })
+	}
+}
+
+// CheckArchSkew flags the streams whose newest accepted payload is more than
+// limit older than that of the same stream in another architecture, e.g.
+// 4.16.0-0.nightly-arm64 against 4.16.0-0.nightly.  That usually means an
+// architecture specific build or test problem.  The multi streams are left
+// out, as they are assembled from the others and so always lag behind them.
+// The Watcher checks its own reports when Limits.ArchSkew is set; callers
+// that merge the reports of several release controllers check the merged
+// report.
+func (r *Report) CheckArchSkew(limit time.Duration) {
+	// the streams of each architecture, by their name without the
+	// architecture suffix
+	type newest struct {
+		accepted time.Time
+		stream   *StreamReport
+	}
+	newestAccepted := map[string]newest{}
+	accepted := map[*StreamReport]time.Time{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if stream.LatestAccepted == "" || stream.Arch == MultiArch {
+			continue
+		}
+		t, err := getPayloadTimestamp(stream.LatestAccepted)
+		if err != nil {
+			continue
+		}
+		accepted[stream] = t
+		base := strings.TrimSuffix(stream.Name, "-"+stream.Arch)
+		if n, ok := newestAccepted[base]; !ok || t.After(n.accepted) {
+			newestAccepted[base] = newest{accepted: t, stream: stream}
+		}
+	}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		t, ok := accepted[stream]
+		if !ok {
+			continue
+		}
+		n := newestAccepted[strings.TrimSuffix(stream.Name, "-"+stream.Arch)]
+		if skew := n.accepted.Sub(t); skew > limit {
+			stream.Problems = append(stream.Problems, newProblem(ProblemArchSkew, fmt.Sprintf("Most recently accepted payload is %s older than the one of %s (%s)", formatAge(skew), n.stream.Name, n.stream.Arch)))
+		}
+	}
+}
+
+func containsIgnore(ignores []Ignore, ignore Ignore) bool {
+	for _, i := range ignores {
+		if i.Stream == ignore.Stream && i.Until.Equal(ignore.Until) && i.Reason == ignore.Reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package releasewatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog"
+)
+
+// diskCachedResponse is how a response is saved in the cache directory.  The
+// modification time of the file is when the response was last fetched or
+// confirmed to be unchanged.
+type diskCachedResponse struct {
+	URL          string          `json:"url"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"lastModified,omitempty"`
+	Body         json.RawMessage `json:"body"`
+}
+
+func (c *apiClient) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.cacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadCachedResponse returns the response saved for the url, or nil if there
+// isn't a usable one.
+func (c *apiClient) loadCachedResponse(url string, parse func(body []byte) (interface{}, error)) *cachedResponse {
+	path := c.cachePath(url)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		klog.Warningf("ignoring the cached response to %s: %v", url, err)
+		return nil
+	}
+	saved := diskCachedResponse{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		klog.Warningf("ignoring the cached response to %s: error decoding %s: %v", url, path, err)
+		return nil
+	}
+	value, err := parse(saved.Body)
+	if err != nil {
+		klog.Warningf("ignoring the cached response to %s: %v", url, err)
+		return nil
+	}
+	return &cachedResponse{etag: saved.ETag, lastModified: saved.LastModified, value: value, fetched: info.ModTime()}
+}
+
+// saveCachedResponse writes the response to the cache directory, or only
+// updates its modification time if body is nil.
+func (c *apiClient) saveCachedResponse(url string, cached *cachedResponse, body []byte) {
+	path := c.cachePath(url)
+	if body == nil {
+		if err := os.Chtimes(path, cached.fetched, cached.fetched); err != nil && !os.IsNotExist(err) {
+			klog.Errorf("error updating the cached response to %s: %v", url, err)
+		}
+		return
+	}
+	data, err := json.Marshal(diskCachedResponse{URL: url, ETag: cached.etag, LastModified: cached.lastModified, Body: body})
+	if err != nil {
+		klog.Errorf("error caching the response to %s: %v", url, err)
+		return
+	}
+	if err := os.MkdirAll(c.cacheDir, 0755); err != nil {
+		klog.Errorf("error caching the response to %s: %v", url, err)
+		return
+	}
+	// write to a temporary file that is renamed into place, so a crash
+	// never leaves a partial response behind
+	tmp, err := ioutil.TempFile(c.cacheDir, ".response-")
+	if err != nil {
+		klog.Errorf("error caching the response to %s: %v", url, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		klog.Errorf("error caching the response to %s: %v", url, err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		klog.Errorf("error caching the response to %s: %v", url, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		klog.Errorf("error caching the response to %s: %v", url, err)
+	}
+}
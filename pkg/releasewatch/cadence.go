@@ -0,0 +1,61 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// minCadenceIntervals is how many intervals between builds a stream needs
+// for its typical build interval to be judged.
+const minCadenceIntervals = 5
+
+// checkBuildCadence learns the typical interval between the builds of each
+// stream from the payloads the release controller still lists, and flags the
+// streams that have gone much longer than that without a build.  A stream
+// that builds rarely is usually just quiet, but one that normally builds
+// every few hours and stops is more likely to have a broken build system.
+// Each stream takes a request.
+func checkBuildCadence(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, factor float64) error {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			typical, ok := typicalBuildInterval(payloads)
+			if !ok || len(payloads) == 0 {
+				return nil
+			}
+			since := r.GeneratedAt.Sub(payloads[0].Created)
+			if since > time.Duration(factor*float64(typical)) {
+				stream.Problems = append(stream.Problems, newProblem(ProblemBuildCadenceDrop, fmt.Sprintf("No payload built for %s, though it usually builds every %s", formatAge(since), formatAge(typical))))
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error checking build cadence: %v", err)
+	}
+	return nil
+}
+
+// typicalBuildInterval returns the median interval between the payloads,
+// which are newest first, and false if there are too few of them to tell.
+func typicalBuildInterval(payloads []Payload) (time.Duration, bool) {
+	intervals := []time.Duration{}
+	for i := 1; i < len(payloads); i++ {
+		if payloads[i].Created.IsZero() || payloads[i-1].Created.IsZero() {
+			continue
+		}
+		intervals = append(intervals, payloads[i-1].Created.Sub(payloads[i].Created))
+	}
+	if len(intervals) < minCadenceIntervals {
+		return 0, false
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2], true
+}
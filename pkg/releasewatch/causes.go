@@ -0,0 +1,251 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// junitOperatorPath is where ci-operator writes the results of the steps of a
+// job run, relative to the run's directory in the job artifacts storage.
+const junitOperatorPath = "/artifacts/junit_operator.xml"
+
+var (
+	// multiStageStepRegex matches the names of the steps of a multi-stage
+	// test, e.g. "Run multi-stage test e2e-aws - e2e-aws-ipi-install-install
+	// container test", capturing the test and the step prefixed with it.
+	multiStageStepRegex = regexp.MustCompile(`^Run multi-stage test (\S+) - (\S+) container test$`)
+	// installStepRegex matches the steps that install the cluster, e.g.
+	// ipi-install-install or upi-install-gcp.
+	installStepRegex = regexp.MustCompile(`^(?:ipi|upi)-install(?:-|$)`)
+)
+
+// FailureCause is why a job run failed.
+type FailureCause string
+
+const (
+	// FailureInstall is a run that failed to install its cluster.
+	FailureInstall FailureCause = "install"
+	// FailureInfrastructure is a run that failed before or around the
+	// install, e.g. to acquire a cloud lease, import the payload or
+	// provision, or that never started.
+	FailureInfrastructure FailureCause = "infrastructure"
+	// FailureTest is a run whose tests failed on an installed cluster.
+	FailureTest FailureCause = "test"
+	// FailureUnclassified is a run whose results couldn't be read.
+	FailureUnclassified FailureCause = "unclassified"
+)
+
+// RejectionCauses counts the failed runs of the blocking jobs of rejected
+// payloads by why they failed, to tell whether the fix lies with CI infra or
+// with the product code.
+type RejectionCauses struct {
+	Install        int
+	Infrastructure int
+	Test           int
+	Unclassified   int
+}
+
+func (c *RejectionCauses) add(cause FailureCause) {
+	switch cause {
+	case FailureInstall:
+		c.Install++
+	case FailureInfrastructure:
+		c.Infrastructure++
+	case FailureTest:
+		c.Test++
+	default:
+		c.Unclassified++
+	}
+}
+
+// Total is how many failed runs were counted.
+func (c RejectionCauses) Total() int {
+	return c.Install + c.Infrastructure + c.Test + c.Unclassified
+}
+
+// Likely returns the cause of most of the classified failures, or "" if no
+// one cause has more than the others.
+func (c RejectionCauses) Likely() FailureCause {
+	switch {
+	case c.Infrastructure > c.Install && c.Infrastructure > c.Test:
+		return FailureInfrastructure
+	case c.Install > c.Infrastructure && c.Install > c.Test:
+		return FailureInstall
+	case c.Test > c.Infrastructure && c.Test > c.Install:
+		return FailureTest
+	}
+	return ""
+}
+
+// causeOwners describes where the fix for each likely cause lies.
+var causeOwners = map[FailureCause]string{
+	FailureInfrastructure: "mostly infrastructure failures, pointing at CI infra",
+	FailureInstall:        "mostly install failures, pointing at the installer or the cloud",
+	FailureTest:           "mostly test failures, pointing at the product code",
+}
+
+// String describes the causes, e.g. "7 failed blocking job runs: 4 test, 2
+// infrastructure, 1 install, mostly test failures, pointing at the product
+// code".
+func (c RejectionCauses) String() string {
+	parts := []string{}
+	for _, count := range []struct {
+		cause FailureCause
+		n     int
+	}{
+		{FailureTest, c.Test},
+		{FailureInfrastructure, c.Infrastructure},
+		{FailureInstall, c.Install},
+		{FailureUnclassified, c.Unclassified},
+	} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.cause))
+		}
+	}
+	noun := "runs"
+	if c.Total() == 1 {
+		noun = "run"
+	}
+	s := fmt.Sprintf("%d failed blocking job %s: %s", c.Total(), noun, strings.Join(parts, ", "))
+	if owner, ok := causeOwners[c.Likely()]; ok {
+		s += ", " + owner
+	}
+	return s
+}
+
+// junitResults is the part of a ci-operator junit file that has the results
+// of the steps, which are either at the top or in test suites.
+type junitResults struct {
+	Suites []junitResults `xml:"testsuite"`
+	Cases  []junitCase    `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name    string    `xml:"name,attr"`
+	Failure *struct{} `xml:"failure"`
+}
+
+// failedSteps returns the names of the steps that failed.
+func (r *junitResults) failedSteps() []string {
+	names := []string{}
+	for _, c := range r.Cases {
+		if c.Failure != nil {
+			names = append(names, c.Name)
+		}
+	}
+	for i := range r.Suites {
+		names = append(names, r.Suites[i].failedSteps()...)
+	}
+	return names
+}
+
+// classifyFailedSteps tells why a job run failed from the names of the
+// ci-operator steps that failed, such as "Run multi-stage test e2e-aws -
+// e2e-aws-ipi-install-install container test" or "Run multi-stage test
+// e2e-aws test phase".  A failed install step fails the pre phase with it,
+// so it is looked for first.  Any other failure outside of the test phase,
+// such as acquiring a lease or importing the payload, is the
+// infrastructure's.
+func classifyFailedSteps(names []string) FailureCause {
+	if len(names) == 0 {
+		return FailureUnclassified
+	}
+	for _, name := range names {
+		matches := multiStageStepRegex.FindStringSubmatch(name)
+		if matches != nil && installStepRegex.MatchString(strings.TrimPrefix(matches[2], matches[1]+"-")) {
+			return FailureInstall
+		}
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, " test phase") {
+			return FailureTest
+		}
+	}
+	return FailureInfrastructure
+}
+
+// junitURL returns the url of the ci-operator results of a Prow job run url
+// such as https://prow.ci.openshift.org/view/gs/<bucket>/logs/<job>/<id> in
+// the job artifacts storage, or "" if the url isn't one.
+func junitURL(artifactsURL, runURL string) string {
+	u, err := url.Parse(runURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/view/gs/") {
+		return ""
+	}
+	return strings.TrimSuffix(artifactsURL, "/") + strings.TrimSuffix(strings.TrimPrefix(u.Path, "/view/gs"), "/") + junitOperatorPath
+}
+
+// getFailureCause returns why a job run failed, from its ci-operator results
+// in the job artifacts storage.
+func (c *apiClient) getFailureCause(ctx context.Context, artifactsURL, runURL string) (FailureCause, error) {
+	if runURL == "" {
+		// the job was never given a Prow run to start
+		return FailureInfrastructure, nil
+	}
+	resultsURL := junitURL(artifactsURL, runURL)
+	if resultsURL == "" {
+		return FailureUnclassified, nil
+	}
+	cause, _, err := c.getCached(ctx, resultsURL, func(body []byte) (interface{}, error) {
+		results := &junitResults{}
+		if err := xml.Unmarshal(body, results); err != nil {
+			return nil, err
+		}
+		return classifyFailedSteps(results.failedSteps()), nil
+	})
+	if err != nil {
+		return FailureUnclassified, fmt.Errorf("error fetching the results of %s: %v", runURL, err)
+	}
+	return cause.(FailureCause), nil
+}
+
+// addRejectionCauses counts why the failed runs of the blocking jobs of the
+// rejected payloads of each problem failed.  Runs whose results can't be
+// fetched are counted as unclassified.
+func addRejectionCauses(ctx context.Context, client *apiClient, artifactsURL string, r *Report) {
+	type classification struct {
+		problem *Problem
+		causes  []FailureCause
+	}
+	classifications := []*classification{}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			if len(problem.failedRuns) == 0 {
+				continue
+			}
+			c := &classification{problem: problem, causes: make([]FailureCause, len(problem.failedRuns))}
+			classifications = append(classifications, c)
+			for k, runURL := range problem.failedRuns {
+				k, runURL := k, runURL
+				fetches = append(fetches, func() error {
+					cause, err := client.getFailureCause(ctx, artifactsURL, runURL)
+					if err != nil {
+						klog.Warningf("error classifying the failure of %s: %v", runURL, err)
+					}
+					c.causes[k] = cause
+					return nil
+				})
+			}
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error classifying rejected payloads: %v", err)
+		return
+	}
+	for _, c := range classifications {
+		causes := &RejectionCauses{}
+		for _, cause := range c.causes {
+			causes.add(cause)
+		}
+		c.problem.RejectionCauses = causes
+	}
+}
@@ -0,0 +1,312 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	// maxListedComponents bounds how many changed components of a changelog
+	// are listed when rendering it.
+	maxListedComponents = 10
+	// maxPullAuthorLookups bounds how many authors of pull requests are
+	// looked up in GitHub for a report, on top of those looked up before,
+	// so a large changelog doesn't use up the GitHub rate limit.
+	maxPullAuthorLookups = 100
+)
+
+// gitHubPullURLRegex matches the urls of GitHub pull requests, e.g.
+// https://github.com/openshift/etcd/pull/123.
+var gitHubPullURLRegex = regexp.MustCompile(`^https://github\.com/([^/]+/[^/]+)/pull/([0-9]+)$`)
+
+// releaseChangelog is the part of the release controller's json changelog
+// between two payloads that says what changed.
+type releaseChangelog struct {
+	// Components are the versions of the payloads' major components, e.g.
+	// Kubernetes and RHCOS.
+	Components []struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		Version     string `json:"version"`
+		From        string `json:"from"`
+	} `json:"components"`
+	NewImages     []releaseChangelogImage `json:"newImages"`
+	RemovedImages []releaseChangelogImage `json:"removedImages"`
+	UpdatedImages []releaseChangelogImage `json:"updatedImages"`
+}
+
+// releaseChangelogImage is an image of a release changelog with the commits
+// that went into it.
+type releaseChangelogImage struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	ShortCommit string `json:"shortCommit"`
+	Commits     []struct {
+		Subject  string `json:"subject"`
+		PullID   int    `json:"pullID"`
+		PullURL  string `json:"pullURL"`
+		CommitID string `json:"commitID"`
+	} `json:"commits"`
+}
+
+// pullRequests returns the pull requests of the image's commits, each once,
+// in the order the changelog lists them.  seen are the urls of the pull
+// requests already listed, e.g. for other images.
+func (image releaseChangelogImage) pullRequests(seen map[string]struct{}) []PullRequest {
+	pulls := []PullRequest{}
+	for _, commit := range image.Commits {
+		if commit.PullURL == "" {
+			continue
+		}
+		if _, ok := seen[commit.PullURL]; ok {
+			continue
+		}
+		seen[commit.PullURL] = struct{}{}
+		pull := PullRequest{URL: commit.PullURL, Number: commit.PullID, Title: commit.Subject, Component: image.Name}
+		if matches := gitHubPullURLRegex.FindStringSubmatch(commit.PullURL); matches != nil {
+			pull.Repo = matches[1]
+			pull.Number, _ = strconv.Atoi(matches[2])
+		}
+		pulls = append(pulls, pull)
+	}
+	return pulls
+}
+
+// Changelog summarizes what changed between two payloads of a stream.
+type Changelog struct {
+	From string
+	To   string
+	// Commits and PullRequests are how many of them went into the
+	// Components, the images that changed, sorted.
+	Commits      int
+	PullRequests int
+	Components   []string
+	// ChangedImages is how many of the payloads' images differ: those
+	// updated, the Components, plus those added and removed.  It measures
+	// how much change piled up without being accepted.
+	ChangedImages int
+	// Pulls are the pull requests, in the order the changelog lists them.
+	// They are only listed, and their authors looked up, if the Watcher was
+	// asked to.
+	Pulls []PullRequest
+}
+
+// PullRequest is a pull request that went into a changelog.
+type PullRequest struct {
+	// Repo is the GitHub org and repository, e.g. openshift/etcd.
+	Repo   string
+	Number int
+	URL    string
+	// Title is the subject of its first commit.
+	Title     string
+	Component string
+	// Author is the GitHub login of whoever opened it, empty if it hasn't
+	// been looked up.
+	Author string
+}
+
+// String describes the pull request, e.g. "openshift/etcd#123 by @someone:
+// Bump etcd (etcd)".
+func (p PullRequest) String() string {
+	output := fmt.Sprintf("%s#%d", p.Repo, p.Number)
+	if p.Author != "" {
+		output += " by @" + p.Author
+	}
+	return output + fmt.Sprintf(": %s (%s)", p.Title, p.Component)
+}
+
+// String describes the changelog, e.g. "11 images changed, 42 commits in 17
+// pull requests to 9 components: cluster-etcd-operator, ...".
+func (c *Changelog) String() string {
+	components := c.Components
+	more := ""
+	if len(components) > maxListedComponents {
+		more = fmt.Sprintf(" and %d more", len(components)-maxListedComponents)
+		components = components[:maxListedComponents]
+	}
+	output := fmt.Sprintf("%d commits in %d pull requests to %d components", c.Commits, c.PullRequests, len(c.Components))
+	// reports saved before the images were counted have none
+	if c.ChangedImages > 0 {
+		output = fmt.Sprintf("%d images changed, ", c.ChangedImages) + output
+	}
+	if len(components) > 0 {
+		output += ": " + strings.Join(components, ", ") + more
+	}
+	return output
+}
+
+// getReleaseChangelog returns the release controller's changelog between two
+// payloads.  The result may be shared with other callers and must not be
+// modified.
+func (c *apiClient) getReleaseChangelog(ctx context.Context, apiurl, from, to string) (*releaseChangelog, error) {
+	query := url.Values{"from": {from}, "to": {to}, "format": {"json"}}
+	changelog, _, err := c.getCached(ctx, apiurl+"/changelog?"+query.Encode(), func(body []byte) (interface{}, error) {
+		release := &releaseChangelog{}
+		if err := json.Unmarshal(body, release); err != nil {
+			return nil, err
+		}
+		return release, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the changelog from %s to %s: %v", from, to, err)
+	}
+	return changelog.(*releaseChangelog), nil
+}
+
+// getChangelog returns the changes between two payloads of a stream.
+func (c *apiClient) getChangelog(ctx context.Context, apiurl, from, to string) (*Changelog, error) {
+	release, err := c.getReleaseChangelog(ctx, apiurl, from, to)
+	if err != nil {
+		return nil, err
+	}
+	changelog := &Changelog{From: from, To: to, Components: []string{}}
+	pulls := map[string]struct{}{}
+	for _, image := range release.UpdatedImages {
+		changelog.Components = append(changelog.Components, image.Name)
+		changelog.Commits += len(image.Commits)
+		changelog.Pulls = append(changelog.Pulls, image.pullRequests(pulls)...)
+	}
+	changelog.PullRequests = len(pulls)
+	changelog.ChangedImages = len(release.UpdatedImages) + len(release.NewImages) + len(release.RemovedImages)
+	sort.Strings(changelog.Components)
+	return changelog, nil
+}
+
+// gitHubPull is the part of a GitHub pull request that says who opened it.
+type gitHubPull struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// cachedPullAuthor returns the author of a pull request if it was looked up
+// before.
+func (c *apiClient) cachedPullAuthor(repo string, number int) (string, bool) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	author, ok := c.pullAuthors[fmt.Sprintf("%s#%d", repo, number)]
+	return author, ok
+}
+
+// getPullAuthor returns the GitHub login of whoever opened a pull request.
+func (c *apiClient) getPullAuthor(ctx context.Context, gitHubAPIURL, repo string, number int) (string, error) {
+	if author, ok := c.cachedPullAuthor(repo, number); ok {
+		return author, nil
+	}
+	author, _, err := c.getCached(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", gitHubAPIURL, repo, number), func(body []byte) (interface{}, error) {
+		pull := gitHubPull{}
+		if err := json.Unmarshal(body, &pull); err != nil {
+			return nil, err
+		}
+		return pull.User.Login, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error fetching the author of %s#%d: %v", repo, number, err)
+	}
+	c.cacheLock.Lock()
+	c.pullAuthors[fmt.Sprintf("%s#%d", repo, number)] = author.(string)
+	c.cacheLock.Unlock()
+	return author.(string), nil
+}
+
+// removePulls drops the pull requests from the changelogs of the report.
+func removePulls(r *Report) {
+	for i := range r.Streams {
+		for j := range r.Streams[i].Problems {
+			if changelog := r.Streams[i].Problems[j].Changelog; changelog != nil {
+				copied := *changelog
+				copied.Pulls = nil
+				r.Streams[i].Problems[j].Changelog = &copied
+			}
+		}
+	}
+}
+
+// addPullAuthors looks up the authors of the pull requests of the changelogs
+// in GitHub, each once, and at most maxPullAuthorLookups new ones.  Pull
+// requests whose author can't be found, or isn't looked up, are listed
+// without one.
+func addPullAuthors(ctx context.Context, client *apiClient, gitHubAPIURL string, r *Report) {
+	fetches := []func() error{}
+	lookups := 0
+	for i := range r.Streams {
+		for j := range r.Streams[i].Problems {
+			changelog := r.Streams[i].Problems[j].Changelog
+			if changelog == nil {
+				continue
+			}
+			// the changelog is shared with later reports through the
+			// cache, so the authors are added to a copy
+			pulls := append([]PullRequest{}, changelog.Pulls...)
+			copied := *changelog
+			copied.Pulls = pulls
+			r.Streams[i].Problems[j].Changelog = &copied
+			for k := range pulls {
+				pull := &pulls[k]
+				if pull.Repo == "" {
+					continue
+				}
+				if author, ok := client.cachedPullAuthor(pull.Repo, pull.Number); ok {
+					pull.Author = author
+					continue
+				}
+				if lookups == maxPullAuthorLookups {
+					continue
+				}
+				lookups++
+				fetches = append(fetches, func() error {
+					author, err := client.getPullAuthor(ctx, gitHubAPIURL, pull.Repo, pull.Number)
+					if err != nil {
+						klog.Warningf("error looking up a pull request author: %v", err)
+						return nil
+					}
+					pull.Author = author
+					return nil
+				})
+			}
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error looking up pull request authors: %v", err)
+	}
+}
+
+// addChangelogs summarizes, for the streams whose accepted payload is stale,
+// what changed between the newest accepted payload and the newest built one,
+// to show how much unvalidated change is piling up.  If a changelog can't be
+// fetched the report goes without it.
+func addChangelogs(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report) {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if stream.LatestAccepted == "" || stream.LatestBuilt == "" || stream.LatestAccepted == stream.LatestBuilt {
+			continue
+		}
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			if problem.Kind != ProblemStaleAcceptedPayload {
+				continue
+			}
+			fetches = append(fetches, func() error {
+				changelog, err := client.getChangelog(ctx, releaseAPIUrl, stream.LatestAccepted, stream.LatestBuilt)
+				if err != nil {
+					klog.Warningf("error summarizing the changes to %s: %v", stream.Name, err)
+					return nil
+				}
+				problem.Changelog = changelog
+				return nil
+			})
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error summarizing unaccepted changes: %v", err)
+	}
+}
@@ -0,0 +1,463 @@
+package releasewatch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// apiCallBudget bounds the time spent on a single release controller
+	// call, including retries.
+	apiCallBudget  = 2 * time.Minute
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// apiClient makes requests to the release controller.  Transient failures
+// (connection errors, 5xx and 429 responses) are retried with jittered
+// exponential backoff until the call budget runs out, or after the delay the
+// release controller asked for with Retry-After.
+type apiClient struct {
+	client *http.Client
+	// token, or the contents of tokenFile, is sent as a bearer token with
+	// the requests to tokenHost, the host of the release controller, but not
+	// to other services such as Sippy.
+	token     string
+	tokenFile string
+	tokenHost string
+	// gitHubToken is sent as a bearer token with the requests to
+	// gitHubHost, the host of the GitHub api.
+	gitHubToken string
+	gitHubHost  string
+
+	cacheLock sync.Mutex
+	// cache holds the most recent response for each url.
+	cache map[string]*cachedResponse
+	// pullAuthors are the authors of the pull requests looked up so far, by
+	// repo#number, which never change.
+	pullAuthors map[string]string
+	// cacheDir is where responses are saved, if set.
+	cacheDir string
+	// workers bounds how many requests parallel makes at once.
+	workers int
+
+	throttleLock sync.Mutex
+	// interval is how far apart the requests to each release controller are
+	// spread, zero if they aren't.
+	interval time.Duration
+	// throttles are the state of the throttling of the requests to each
+	// release controller, by host.  The requests to other hosts aren't
+	// throttled.
+	throttles map[string]*hostThrottle
+}
+
+// hostThrottle is when the next request to a release controller can be made,
+// and when it asked for them to resume with Retry-After.
+type hostThrottle struct {
+	nextRequest time.Time
+	pausedUntil time.Time
+}
+
+// newAPIClient returns a client configured by the options, and when the
+// responses it replays were recorded if it replays a recording.
+func newAPIClient(opts Options) (*apiClient, time.Time, error) {
+	if opts.Token != "" && opts.TokenFile != "" {
+		return nil, time.Time{}, fmt.Errorf("only one of an api token and an api token file can be given")
+	}
+	if opts.RecordDir != "" && opts.ReplayDir != "" {
+		return nil, time.Time{}, fmt.Errorf("only one of a record directory and a replay directory can be given")
+	}
+	workers := opts.Concurrency
+	if workers == 0 {
+		workers = DefaultConcurrency
+	}
+	if workers < 0 {
+		return nil, time.Time{}, fmt.Errorf("the api concurrency must be at least 1")
+	}
+	if opts.RequestRate < 0 {
+		return nil, time.Time{}, fmt.Errorf("the api request rate can't be negative")
+	}
+	var interval time.Duration
+	// replayed responses cost the release controller nothing
+	if opts.RequestRate > 0 && opts.ReplayDir == "" {
+		interval = time.Duration(float64(time.Second) / opts.RequestRate)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipTLSVerify}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("error reading ca file: %v", err)
+		}
+		// trust the given CAs in addition to the system ones
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, time.Time{}, fmt.Errorf("no certificates found in ca file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if opts.Proxy != nil {
+		transport.Proxy = opts.Proxy
+	}
+
+	var roundTripper http.RoundTripper = transport
+	var recordedAt time.Time
+	switch {
+	case opts.RecordDir != "":
+		recorder, err := newRecordingTransport(opts.RecordDir, transport)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		roundTripper = recorder
+	case opts.ReplayDir != "":
+		replayer, err := newReplayTransport(opts.ReplayDir)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		roundTripper, recordedAt = replayer, replayer.recordedAt
+	}
+	tokenHost := ""
+	if u, err := url.Parse(opts.ReleaseAPIURL); err == nil {
+		tokenHost = u.Host
+	}
+	gitHubHost := ""
+	if u, err := url.Parse(opts.GitHubAPIURL); err == nil && opts.GitHubAPIURL != "" {
+		gitHubHost = u.Host
+	}
+	throttles := map[string]*hostThrottle{}
+	for _, releaseAPIURL := range append([]string{opts.ReleaseAPIURL}, opts.ReleaseAPIURLs...) {
+		if u, err := url.Parse(releaseAPIURL); err == nil {
+			throttles[u.Host] = &hostThrottle{}
+		}
+	}
+	return &apiClient{
+		client:      &http.Client{Timeout: opts.Timeout, Transport: roundTripper},
+		token:       opts.Token,
+		tokenFile:   opts.TokenFile,
+		tokenHost:   tokenHost,
+		gitHubToken: opts.GitHubToken,
+		gitHubHost:  gitHubHost,
+		cache:       map[string]*cachedResponse{},
+		pullAuthors: map[string]string{},
+		cacheDir:    opts.CacheDir,
+		workers:     workers,
+		interval:    interval,
+		throttles:   throttles,
+	}, recordedAt, nil
+}
+
+// parallel runs the fetches with at most workers of them running at once, and
+// returns the first error once they are all done.
+func (c *apiClient) parallel(ctx context.Context, fetches ...func() error) error {
+	slots := make(chan struct{}, c.workers)
+	errors := make(chan error, len(fetches))
+	wg := sync.WaitGroup{}
+	for _, fetch := range fetches {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			// the fetches that haven't started yet are skipped
+			errors <- ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(fetch func() error) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errors <- fetch()
+		}(fetch)
+	}
+	wg.Wait()
+	close(errors)
+	for err := range errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errRetryable wraps errors that are worth retrying.  retryAfter is how long
+// the server asked to wait before retrying, zero if it didn't.
+type errRetryable struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *errRetryable) Error() string {
+	return e.err.Error()
+}
+
+// cachedResponse is the parsed result of a previous request, along with the
+// validators to make the next request for the url conditional on.
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	value        interface{}
+	// fetched is when the response was downloaded.
+	fetched time.Time
+}
+
+// getCached fetches the url and returns the result of parsing the response.
+// When the release controller reports the response hasn't changed since the
+// previous request, the previous result is returned without downloading or
+// parsing it again, so callers must not modify the result.
+//
+// With a cache directory, the response is also saved to disk.  If the
+// release controller can't be reached the saved response is returned
+// instead, along with when it was fetched; the time is zero for fresh
+// results.
+func (c *apiClient) getCached(ctx context.Context, url string, parse func(body []byte) (interface{}, error)) (interface{}, time.Time, error) {
+	c.cacheLock.Lock()
+	cached := c.cache[url]
+	c.cacheLock.Unlock()
+	if cached == nil && c.cacheDir != "" {
+		cached = c.loadCachedResponse(url, parse)
+	}
+
+	res, err := c.fetch(ctx, url, cached)
+	if err != nil {
+		// being cancelled doesn't mean the release controller is down
+		if cached != nil && c.cacheDir != "" && ctx.Err() == nil {
+			klog.Warningf("using the response to %s cached at %s: %v", url, cached.fetched.Format(time.RFC3339), err)
+			return cached.value, cached.fetched, nil
+		}
+		return nil, time.Time{}, err
+	}
+	if res.notModified {
+		klog.V(4).Infof("%s is unchanged, using the cached response", url)
+		refreshed := *cached
+		refreshed.fetched = time.Now()
+		c.storeCachedResponse(url, &refreshed, nil)
+		return cached.value, time.Time{}, nil
+	}
+	value, err := parse(res.body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error decoding response from %s: %v", url, err)
+	}
+	c.storeCachedResponse(url, &cachedResponse{etag: res.etag, lastModified: res.lastModified, value: value, fetched: time.Now()}, res.body)
+	return value, time.Time{}, nil
+}
+
+// storeCachedResponse caches the response in memory and, with a cache
+// directory, on disk.  body is nil when the response was not modified.
+func (c *apiClient) storeCachedResponse(url string, cached *cachedResponse, body []byte) {
+	c.cacheLock.Lock()
+	c.cache[url] = cached
+	c.cacheLock.Unlock()
+	if c.cacheDir != "" {
+		c.saveCachedResponse(url, cached, body)
+	}
+}
+
+// fetch requests the url, retrying transient failures until the call budget
+// runs out.
+func (c *apiClient) fetch(ctx context.Context, url string, cached *cachedResponse) (*response, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiCallBudget)
+	defer cancel()
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := c.get(ctx, url, cached)
+		if err == nil {
+			return res, nil
+		}
+		retryable, ok := err.(*errRetryable)
+		if !ok || ctx.Err() != nil {
+			return nil, err
+		}
+
+		// wait between half and all of the backoff, so that clients that
+		// failed together don't retry together
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if retryable.retryAfter > 0 {
+			// the other requests hold off too, rather than each being
+			// turned away in turn
+			wait = retryable.retryAfter
+			c.pause(url, wait)
+		}
+		deadline, _ := ctx.Deadline()
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("giving up on %s after %d attempts: %v", url, attempt, err)
+		}
+		klog.V(2).Infof("attempt %d to fetch %s failed, retrying in %v: %v", attempt, url, wait.Round(time.Millisecond), err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("giving up on %s after %d attempts: %v", url, attempt, ctx.Err())
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// throttle waits until a request can be made to the url: requests to each
+// release controller are spread the interval apart, and held off while it
+// asked for them to be with Retry-After.  Requests to other services aren't
+// throttled.
+func (c *apiClient) throttle(ctx context.Context, req *http.Request) error {
+	c.throttleLock.Lock()
+	throttle, ok := c.throttles[req.URL.Host]
+	if !ok {
+		c.throttleLock.Unlock()
+		return nil
+	}
+	next := time.Now()
+	if throttle.nextRequest.After(next) {
+		next = throttle.nextRequest
+	}
+	if throttle.pausedUntil.After(next) {
+		next = throttle.pausedUntil
+	}
+	throttle.nextRequest = next.Add(c.interval)
+	c.throttleLock.Unlock()
+
+	wait := time.Until(next)
+	if wait <= 0 {
+		return nil
+	}
+	klog.V(4).Infof("waiting %v to fetch %s", wait.Round(time.Millisecond), req.URL)
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause holds off the requests to the release controller the url is on for
+// the delay, if it is on one.
+func (c *apiClient) pause(rawURL string, delay time.Duration) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	c.throttleLock.Lock()
+	defer c.throttleLock.Unlock()
+	throttle, ok := c.throttles[u.Host]
+	if !ok {
+		return
+	}
+	if until := time.Now().Add(delay); until.After(throttle.pausedUntil) {
+		throttle.pausedUntil = until
+	}
+}
+
+// parseRetryAfter returns the delay a Retry-After header asks for, given in
+// seconds or as a date, or zero if there is none.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
+// response is the result of a single request.
+type response struct {
+	body         []byte
+	notModified  bool
+	etag         string
+	lastModified string
+}
+
+// get makes a single request for the url, conditional on the cached response
+// being out of date if there is one.
+func (c *apiClient) get(ctx context.Context, url string, cached *cachedResponse) (*response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	token := c.token
+	if req.URL.Host == c.gitHubHost {
+		token = c.gitHubToken
+	} else if req.URL.Host != c.tokenHost {
+		token = ""
+	} else if c.tokenFile != "" {
+		// the file is read on every request so rotated tokens are picked up
+		data, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the api token file: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	// the update service only answers requests that accept json
+	req.Header.Set("Accept", "application/json")
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	if err := c.throttle(ctx, req); err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		err = fmt.Errorf("error fetching %s: %w", url, err)
+		if isCertificateError(err) {
+			// retrying won't fix the certificate
+			return nil, err
+		}
+		return nil, &errRetryable{err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		return &response{notModified: true}, nil
+	}
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		return nil, &errRetryable{
+			err:        fmt.Errorf("non-OK http response code from %s: %d", url, res.StatusCode),
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("non-OK http response code from %s: %d", url, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &errRetryable{err: fmt.Errorf("error reading response from %s: %v", url, err)}
+	}
+	return &response{
+		body:         body,
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+	}, nil
+}
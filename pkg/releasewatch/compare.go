@@ -0,0 +1,158 @@
+package releasewatch
+
+import (
+	"fmt"
+	"time"
+)
+
+// Comparison is how a report differs from an earlier one.
+type Comparison struct {
+	// Since is when the earlier report was generated.
+	Since time.Time
+	// Appeared are the problems that the earlier report didn't have, and
+	// Resolved the ones that it had but the report doesn't.
+	Appeared []StreamProblem
+	Resolved []StreamProblem
+	// SeverityChanges are the problems that both reports had with a
+	// different severity.
+	SeverityChanges []SeverityChange
+	// LatencyChanges are the streams whose average acceptance latency was
+	// measured in both reports.
+	LatencyChanges []LatencyChange
+}
+
+// StreamProblem is a problem of a stream.
+type StreamProblem struct {
+	Stream  string
+	Problem Problem
+}
+
+// SeverityChange is how the severity of a problem of a stream changed.
+type SeverityChange struct {
+	Stream string
+	Kind   ProblemKind
+	Before Severity
+	After  Severity
+}
+
+// LatencyChange is how the average acceptance latency of a stream changed.
+type LatencyChange struct {
+	Stream string
+	Before time.Duration
+	After  time.Duration
+}
+
+// Compare returns how the report differs from the earlier one.
+func Compare(earlier, r *Report) *Comparison {
+	c := &Comparison{Since: earlier.GeneratedAt}
+	before := map[string]*StreamReport{}
+	for i := range earlier.Streams {
+		before[earlier.Streams[i].Name] = &earlier.Streams[i]
+	}
+	after := map[string]*StreamReport{}
+	for i := range r.Streams {
+		after[r.Streams[i].Name] = &r.Streams[i]
+	}
+
+	for _, stream := range r.Streams {
+		previous := before[stream.Name]
+		for _, p := range stream.Problems {
+			if previous == nil || !previous.HasProblem(p.Kind) {
+				c.Appeared = append(c.Appeared, StreamProblem{Stream: stream.Name, Problem: p})
+				continue
+			}
+			for _, before := range previous.Problems {
+				if before.Kind == p.Kind && before.Severity != p.Severity {
+					c.SeverityChanges = append(c.SeverityChanges, SeverityChange{Stream: stream.Name, Kind: p.Kind, Before: before.Severity, After: p.Severity})
+				}
+			}
+		}
+		if previous != nil && previous.Acceptance != nil && stream.Acceptance != nil && previous.Acceptance.Measured > 0 && stream.Acceptance.Measured > 0 {
+			c.LatencyChanges = append(c.LatencyChanges, LatencyChange{Stream: stream.Name, Before: previous.Acceptance.AverageLatency, After: stream.Acceptance.AverageLatency})
+		}
+	}
+	for _, stream := range earlier.Streams {
+		current := after[stream.Name]
+		for _, p := range stream.Problems {
+			if current == nil || !current.HasProblem(p.Kind) {
+				c.Resolved = append(c.Resolved, StreamProblem{Stream: stream.Name, Problem: p})
+			}
+		}
+	}
+	return c
+}
+
+// Heading is the heading of the Lines, e.g. "Compared with
+// 2024-06-01 12:00 UTC".
+func (c *Comparison) Heading() string {
+	return "Compared with " + c.Since.UTC().Format(displayTimeFormat)
+}
+
+// ComparisonHeading is the Heading of the report's Comparison, with the time
+// in the report's time zone.
+func (r *Report) ComparisonHeading() string {
+	return "Compared with " + r.FormatTime(r.Comparison.Since)
+}
+
+// Lines describes the changes, one per line, e.g. "new: 4.16.0-0.nightly
+// stale accepted payload".
+func (c *Comparison) Lines() []string {
+	lines := []string{}
+	for _, p := range c.Appeared {
+		lines = append(lines, fmt.Sprintf("new: %s %s", p.Stream, p.Problem.Kind.Description()))
+	}
+	for _, p := range c.Resolved {
+		lines = append(lines, fmt.Sprintf("resolved: %s %s", p.Stream, p.Problem.Kind.Description()))
+	}
+	for _, change := range c.SeverityChanges {
+		lines = append(lines, fmt.Sprintf("severity: %s %s %s -> %s", change.Stream, change.Kind.Description(), change.Before, change.After))
+	}
+	for _, change := range c.LatencyChanges {
+		lines = append(lines, fmt.Sprintf("acceptance latency: %s %s -> %s", change.Stream, formatAge(change.Before), formatAge(change.After)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no changes")
+	}
+	return lines
+}
+
+// Changed returns the names of the streams that have problems that appeared,
+// resolved or changed severity.
+func (c *Comparison) Changed() map[string]bool {
+	changed := map[string]bool{}
+	for _, p := range c.Appeared {
+		changed[p.Stream] = true
+	}
+	for _, p := range c.Resolved {
+		changed[p.Stream] = true
+	}
+	for _, change := range c.SeverityChanges {
+		changed[change.Stream] = true
+	}
+	return changed
+}
+
+// OnlyChanges returns a copy of the report with only the streams whose
+// problems changed since the report it was compared with, for interim
+// updates between full reports.  The streams whose problems all resolved are
+// listed by the Comparison.  A report that wasn't compared is returned as
+// is.
+func (r *Report) OnlyChanges() *Report {
+	if r.Comparison == nil {
+		return r
+	}
+	changed := r.Comparison.Changed()
+	filtered := *r
+	filtered.Streams = nil
+	for _, stream := range r.Streams {
+		if changed[stream.Name] {
+			filtered.Streams = append(filtered.Streams, stream)
+		}
+	}
+	// the latency changes and the SLOs aren't changes of status
+	filtered.SLOs = nil
+	comparison := *r.Comparison
+	comparison.LatencyChanges = nil
+	filtered.Comparison = &comparison
+	return &filtered
+}
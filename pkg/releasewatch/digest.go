@@ -0,0 +1,118 @@
+package releasewatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProblemGroup is one kind of problem and the streams that have it, for
+// digests that list each kind once however many streams broke at once, e.g.
+// during a registry outage.
+type ProblemGroup struct {
+	Kind ProblemKind
+	// Severity is the most urgent severity of the kind of problem among the
+	// streams.
+	Severity Severity
+	Streams  []StreamReport
+}
+
+// String describes the group, e.g. "[warning] stale accepted payload in 2
+// streams: 4.16.0-0.nightly, 4.15.0-0.nightly".
+func (g ProblemGroup) String() string {
+	return fmt.Sprintf("[%s] %s: %s", g.Severity, g.Heading(), strings.Join(g.StreamNames(), ", "))
+}
+
+// Heading describes the kind of problem and how many streams have it, e.g.
+// "stale accepted payload in 2 streams".
+func (g ProblemGroup) Heading() string {
+	noun := "streams"
+	if len(g.Streams) == 1 {
+		noun = "stream"
+	}
+	return fmt.Sprintf("%s in %d %s", g.Kind.Description(), len(g.Streams), noun)
+}
+
+func (g ProblemGroup) StreamNames() []string {
+	names := []string{}
+	for _, stream := range g.Streams {
+		names = append(names, stream.Name)
+	}
+	return names
+}
+
+// ProblemGroups groups the unacknowledged problems of the report by kind,
+// the most urgent and then the most widespread first.
+func (r *Report) ProblemGroups() []ProblemGroup {
+	groups := []ProblemGroup{}
+	byKind := map[ProblemKind]int{}
+	for _, stream := range r.Streams {
+		for _, p := range stream.Problems {
+			i, ok := byKind[p.Kind]
+			if !ok {
+				i = len(groups)
+				byKind[p.Kind] = i
+				groups = append(groups, ProblemGroup{Kind: p.Kind, Severity: p.Severity})
+			}
+			if p.Severity.rank() > groups[i].Severity.rank() {
+				groups[i].Severity = p.Severity
+			}
+			groups[i].Streams = append(groups[i].Streams, stream)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Severity != groups[j].Severity {
+			return groups[i].Severity.rank() > groups[j].Severity.rank()
+		}
+		return len(groups[i].Streams) > len(groups[j].Streams)
+	})
+	return groups
+}
+
+// SeverityCounts describes how many unacknowledged problems of each severity
+// the report has, most urgent first, e.g. "2 critical, 5 warning".
+func (r *Report) SeverityCounts() string {
+	counts := map[Severity]int{}
+	for _, stream := range r.Streams {
+		for _, p := range stream.Problems {
+			counts[p.Severity]++
+		}
+	}
+	parts := []string{}
+	for i := len(severities) - 1; i >= 0; i-- {
+		if counts[severities[i]] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[severities[i]], severities[i]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Digest renders the report as a single compact text: the summary, the
+// problem counts by severity, one line per kind of problem listing the streams
+// that have it and one per job failing in several streams.
+func (r *Report) Digest() string {
+	output := ""
+	if warning := r.CacheWarning(); warning != "" {
+		output += "WARNING: " + warning + "\n\n"
+	}
+	for _, unavailable := range r.Unavailable {
+		output += "WARNING: " + unavailable.String() + "\n"
+	}
+	if len(r.Unavailable) > 0 {
+		output += "\n"
+	}
+	output += r.Summary() + "\n"
+	if counts := r.SeverityCounts(); counts != "" {
+		output += "Problems: " + counts + "\n"
+	}
+	for _, group := range r.ProblemGroups() {
+		output += "  " + group.String() + "\n"
+	}
+	for _, line := range r.CommonJobFailureLines() {
+		output += "  " + line + "\n"
+	}
+	for _, line := range r.IgnoredLines() {
+		output += line + "\n"
+	}
+	return strings.TrimSuffix(output, "\n")
+}
@@ -0,0 +1,88 @@
+package releasewatch
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// upgradeProblemKinds are the problems that count against the upgrade
+// freshness of a stream's health.
+var upgradeProblemKinds = map[ProblemKind]bool{
+	ProblemNoPatchUpgrade:        true,
+	ProblemNoMinorUpgrade:        true,
+	ProblemNoPathUpgrade:         true,
+	ProblemLostUpgradeSource:     true,
+	ProblemLowUpgradeSuccessRate: true,
+}
+
+// HealthString describes the stream's health score, e.g. "health 72/100".
+func (s *StreamReport) HealthString() string {
+	return fmt.Sprintf("health %d/100", s.Health)
+}
+
+// freshness scores how recent something that should happen every limit is:
+// 1 when it just happened, 0.5 at the limit and 0 at twice the limit.
+func freshness(age, limit time.Duration) float64 {
+	if limit <= 0 {
+		return 1
+	}
+	return math.Max(0, math.Min(1, 1-float64(age)/float64(2*limit)))
+}
+
+// addHealthScores sets the Health of each stream, from 0 to 100, as the
+// average of what is known of:
+//   - how recently it accepted a payload, against its accepted staleness
+//     limit
+//   - how many of its recent payloads were accepted, if the acceptance or the
+//     phases of its payloads were counted
+//   - how recently it built a payload, against its built staleness limit,
+//     and whether its build cadence dropped
+//   - how many kinds of upgrade problems it has, each costing half
+//
+// so dashboards can plot its trend as one number.
+func addHealthScores(r *Report, limits Limits) {
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		scores := []float64{}
+
+		accepted := 0.0
+		if ts := stream.LatestAcceptedTime(); !ts.IsZero() {
+			accepted = freshness(r.GeneratedAt.Sub(ts), limits.acceptedStaleness(stream.Name))
+		}
+		scores = append(scores, accepted)
+
+		switch {
+		case stream.Acceptance != nil && stream.Acceptance.Finished > 0:
+			scores = append(scores, float64(stream.Acceptance.Accepted)/float64(stream.Acceptance.Finished))
+		case stream.PhaseCounts != nil:
+			counts := stream.PhaseCounts.Counts
+			if finished := counts["Accepted"] + counts["Rejected"] + counts["Failed"]; finished > 0 {
+				scores = append(scores, float64(counts["Accepted"])/float64(finished))
+			}
+		}
+
+		built := 0.0
+		if ts := stream.LatestBuiltTime(); !ts.IsZero() {
+			built = freshness(r.GeneratedAt.Sub(ts), limits.builtStaleness(stream.Name))
+		}
+		upgrades := 1.0
+		upgradeKinds := map[ProblemKind]bool{}
+		for _, p := range stream.Problems {
+			if p.Kind == ProblemBuildCadenceDrop {
+				built = 0
+			}
+			if upgradeProblemKinds[p.Kind] && !upgradeKinds[p.Kind] {
+				upgradeKinds[p.Kind] = true
+				upgrades -= 0.5
+			}
+		}
+		scores = append(scores, built, math.Max(0, upgrades))
+
+		total := 0.0
+		for _, score := range scores {
+			total += score
+		}
+		stream.Health = int(math.Round(100 * total / float64(len(scores))))
+	}
+}
@@ -0,0 +1,192 @@
+package releasewatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyTimeFormat names the files of the history store, so they sort in
+// the order the reports were generated in.
+const historyTimeFormat = "20060102T150405Z"
+
+// History stores the reports of past runs in a directory, one json file per
+// report, for comparing reports over time.  Reports older than the retention
+// are removed when a report is saved.
+type History struct {
+	dir       string
+	retention time.Duration
+}
+
+// OpenHistory returns the history stored in dir, creating the directory if
+// needed.  Zero retention keeps the reports forever.
+func OpenHistory(dir string, retention time.Duration) (*History, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating the history directory %s: %v", dir, err)
+	}
+	return &History{dir: dir, retention: retention}, nil
+}
+
+// Save adds the report to the history and removes the reports older than the
+// retention.
+func (h *History) Save(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error encoding the report: %v", err)
+	}
+	name := h.path(r.GeneratedAt)
+	// written to a temporary file first so readers never see a partial report
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error saving the report to the history: %v", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("error saving the report to the history: %v", err)
+	}
+	if h.retention > 0 {
+		return h.prune(r.GeneratedAt.Add(-h.retention))
+	}
+	return nil
+}
+
+// prune removes the reports generated before the cutoff.
+func (h *History) prune(cutoff time.Time) error {
+	times, err := h.times()
+	if err != nil {
+		return err
+	}
+	for _, t := range times {
+		if !t.Before(cutoff) {
+			break
+		}
+		if err := os.Remove(h.path(t)); err != nil {
+			return fmt.Errorf("error removing an old report from the history: %v", err)
+		}
+	}
+	return nil
+}
+
+// Closest returns the saved report generated closest to t, or nil if none was
+// generated within the tolerance of it.
+func (h *History) Closest(t time.Time, tolerance time.Duration) (*Report, error) {
+	times, err := h.times()
+	if err != nil {
+		return nil, err
+	}
+	var closest time.Time
+	for _, saved := range times {
+		if distance := absDuration(saved.Sub(t)); distance <= tolerance && (closest.IsZero() || distance < absDuration(closest.Sub(t))) {
+			closest = saved
+		}
+	}
+	if closest.IsZero() {
+		return nil, nil
+	}
+	return h.load(closest)
+}
+
+// Latest returns the most recently generated saved report, or nil if none
+// was saved.
+func (h *History) Latest() (*Report, error) {
+	times, err := h.times()
+	if err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return nil, nil
+	}
+	return h.load(times[len(times)-1])
+}
+
+// Since returns the saved reports generated at or after t, oldest first.
+func (h *History) Since(t time.Time) ([]*Report, error) {
+	times, err := h.times()
+	if err != nil {
+		return nil, err
+	}
+	reports := []*Report{}
+	for _, saved := range times {
+		if saved.Before(t) {
+			continue
+		}
+		r, err := h.load(saved)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// Last returns the n most recently generated saved reports, oldest first.
+func (h *History) Last(n int) ([]*Report, error) {
+	times, err := h.times()
+	if err != nil {
+		return nil, err
+	}
+	if len(times) > n {
+		times = times[len(times)-n:]
+	}
+	reports := []*Report{}
+	for _, saved := range times {
+		r, err := h.load(saved)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// times returns when the saved reports were generated, oldest first.
+func (h *History) times() ([]time.Time, error) {
+	entries, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the history directory: %v", err)
+	}
+	times := []time.Time{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		t, err := time.Parse(historyTimeFormat, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+func (h *History) path(t time.Time) string {
+	return filepath.Join(h.dir, t.UTC().Format(historyTimeFormat)+".json")
+}
+
+func (h *History) load(t time.Time) (*Report, error) {
+	data, err := ioutil.ReadFile(h.path(t))
+	if err != nil {
+		return nil, fmt.Errorf("error reading a report from the history: %v", err)
+	}
+	r := &Report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("error decoding the report in %s: %v", h.path(t), err)
+	}
+	if err := r.checkSchemaVersion(); err != nil {
+		return nil, fmt.Errorf("error decoding the report in %s: %v", h.path(t), err)
+	}
+	return r, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,153 @@
+package releasewatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ignoreDateFormat is the format of the expiry of an ignore given as a date.
+const ignoreDateFormat = "2006-01-02"
+
+// Ignore leaves the streams matching a StreamPattern out of reports until
+// it expires, e.g. while a stream is known to be broken.  Reports list the
+// ignores that applied to them, so they don't go unnoticed.
+type Ignore struct {
+	Stream string
+	Until  time.Time
+	Reason string
+}
+
+// ParseIgnore parses an ignore such as
+//
+//	4.13.0-0.ci until=2025-09-01 reason="CI infra migration"
+//
+// The until time is a date, which the ignore expires at the start of in UTC,
+// or an RFC 3339 time.
+func ParseIgnore(value string) (Ignore, error) {
+	fields := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	ignore := Ignore{Stream: fields[0]}
+	if _, err := ParseStreamPattern(ignore.Stream); ignore.Stream == "" || err != nil {
+		return Ignore{}, fmt.Errorf("invalid ignore %q: must start with a stream", value)
+	}
+	rest := ""
+	if len(fields) > 1 {
+		rest = fields[1]
+	}
+	attrs, err := parseAttributes(rest)
+	if err != nil {
+		return Ignore{}, fmt.Errorf("invalid ignore %q: %v", value, err)
+	}
+	for _, kv := range attrs {
+		key, attr := kv[0], kv[1]
+		switch key {
+		case "until":
+			until, err := time.Parse(ignoreDateFormat, attr)
+			if err != nil {
+				if until, err = time.Parse(time.RFC3339, attr); err != nil {
+					return Ignore{}, fmt.Errorf("invalid ignore %q: until must be a date such as 2025-09-01 or an RFC 3339 time", value)
+				}
+			}
+			ignore.Until = until
+		case "reason":
+			ignore.Reason = attr
+		default:
+			return Ignore{}, fmt.Errorf("invalid ignore %q: unknown key %q", value, key)
+		}
+	}
+	if ignore.Until.IsZero() {
+		return Ignore{}, fmt.Errorf("invalid ignore %q: must have an until time, so it doesn't last forever", value)
+	}
+	return ignore, nil
+}
+
+// parseAttributes parses space separated key=value attributes, whose values
+// may be quoted, in the order they are given.
+func parseAttributes(rest string) ([][2]string, error) {
+	attrs := [][2]string{}
+	rest = strings.TrimSpace(rest)
+	for rest != "" {
+		i := strings.Index(rest, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q must be key=value", rest)
+		}
+		key := rest[:i]
+		rest = rest[i+1:]
+		var attr string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			attr, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else if j := strings.Index(rest, " "); j >= 0 {
+			attr, rest = rest[:j], rest[j:]
+		} else {
+			attr, rest = rest, ""
+		}
+		attrs = append(attrs, [2]string{key, attr})
+		rest = strings.TrimSpace(rest)
+	}
+	return attrs, nil
+}
+
+// String describes the ignore, e.g. "4.13.0-0.ci until 2025-09-01 00:00 UTC:
+// CI infra migration".
+func (i Ignore) String() string {
+	return i.format(i.Until.UTC().Format(displayTimeFormat))
+}
+
+func (i Ignore) format(until string) string {
+	s := fmt.Sprintf("%s until %s", i.Stream, until)
+	if i.Reason != "" {
+		s += ": " + i.Reason
+	}
+	return s
+}
+
+// Match returns true if the ignore applies to the stream at the given time.
+func (i Ignore) Match(stream string, now time.Time) bool {
+	pattern, err := ParseStreamPattern(i.Stream)
+	return err == nil && now.Before(i.Until) && pattern.Match(stream)
+}
+
+// ignoreStreams returns the releases without the z-stream releases that an
+// unexpired ignore applies to, and the ignores that applied.
+func ignoreStreams(releases map[string][]string, ignores []Ignore, now time.Time) (map[string][]string, []Ignore) {
+	if len(ignores) == 0 {
+		return releases, nil
+	}
+	applied := map[int]bool{}
+	kept := make(map[string][]string, len(releases))
+	for stream, payloads := range releases {
+		ignored := false
+		for i, ignore := range ignores {
+			if IsStreamName(stream) && ignore.Match(stream, now) {
+				applied[i] = true
+				ignored = true
+			}
+		}
+		if !ignored {
+			kept[stream] = payloads
+		}
+	}
+	used := []Ignore{}
+	for i, ignore := range ignores {
+		if applied[i] {
+			used = append(used, ignore)
+		}
+	}
+	return kept, used
+}
+
+// IgnoredLines describes the ignores that left streams out of the report, with
+// their expiry in the report's time zone.
+func (r *Report) IgnoredLines() []string {
+	lines := []string{}
+	for _, ignore := range r.Ignored {
+		lines = append(lines, "Ignored "+ignore.format(r.FormatTime(ignore.Until)))
+	}
+	return lines
+}
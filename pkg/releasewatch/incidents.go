@@ -0,0 +1,109 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Incident is an outage declared in an incident feed, such as the one TRT
+// publishes, e.g. an AWS quota incident.
+type Incident struct {
+	Title string    `json:"title"`
+	URL   string    `json:"url,omitempty"`
+	Start time.Time `json:"start"`
+	// End is nil while the incident is ongoing.
+	End *time.Time `json:"end,omitempty"`
+	// Streams are the streams the incident affects, given like
+	// Limits.Streams.  Empty means every stream.
+	Streams []string `json:"streams,omitempty"`
+}
+
+// getIncidents returns the incidents of the feed.  The result may be shared
+// with other callers and must not be modified.
+func (c *apiClient) getIncidents(ctx context.Context, feedURL string) ([]Incident, error) {
+	incidents, _, err := c.getCached(ctx, feedURL, func(body []byte) (interface{}, error) {
+		incidents := []Incident{}
+		if err := json.Unmarshal(body, &incidents); err != nil {
+			return nil, err
+		}
+		return incidents, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the incidents: %v", err)
+	}
+	return incidents.([]Incident), nil
+}
+
+// affects returns true if the incident affects the stream.  An incident with
+// an invalid stream pattern affects none.
+func (i Incident) affects(stream string) bool {
+	if len(i.Streams) == 0 {
+		return true
+	}
+	for _, value := range i.Streams {
+		pattern, err := ParseStreamPattern(value)
+		if err != nil {
+			klog.Warningf("invalid streams of incident %q: %v", i.Title, err)
+			return false
+		}
+		if pattern.Match(stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps returns true if the incident was ongoing at any time between
+// start and end.
+func (i Incident) overlaps(start, end time.Time) bool {
+	return !i.Start.After(end) && (i.End == nil || !i.End.Before(start))
+}
+
+// problemStart returns when the problem of the stream began as far as the
+// report can tell: when the newest payload that is too old was built for the
+// staleness problems, and otherwise when the report was generated.
+func problemStart(stream *StreamReport, problem *Problem, now time.Time) time.Time {
+	payload := ""
+	switch problem.Kind {
+	case ProblemStaleAcceptedPayload:
+		payload = stream.LatestAccepted
+	case ProblemStaleBuiltPayload:
+		payload = stream.LatestBuilt
+	}
+	if t, err := getPayloadTimestamp(payload); err == nil {
+		return t
+	}
+	return now
+}
+
+// annotateIncidents notes the declared incidents of the feed that overlap
+// each problem in its message, e.g. "... overlaps declared incident \"AWS
+// quota exhausted\"", so responders don't investigate a known outage again.
+// If the feed can't be fetched the problems are left as they are.
+func annotateIncidents(ctx context.Context, client *apiClient, feedURL string, r *Report) {
+	incidents, err := client.getIncidents(ctx, feedURL)
+	if err != nil {
+		klog.Warningf("error correlating problems with incidents: %v", err)
+		return
+	}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			start := problemStart(stream, problem, r.GeneratedAt)
+			for _, incident := range incidents {
+				if !incident.affects(stream.Name) || !incident.overlaps(start, r.GeneratedAt) {
+					continue
+				}
+				problem.Message += fmt.Sprintf("; overlaps declared incident %q", incident.Title)
+				if incident.URL != "" {
+					problem.Message += " " + incident.URL
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// maxAnalyzedRejectedPayloads bounds how many of the newest rejected payloads
+// of a stream the job results are fetched for.
+const maxAnalyzedRejectedPayloads = 5
+
+// jobStateFailed is the state of a verification job that failed.
+const jobStateFailed = "Failed"
+
+// testGridURL is the format of the url of a job's TestGrid dashboard tab,
+// given the minor version, blocking or informing, and the Prow job name.
+const testGridURL = "https://testgrid.k8s.io/redhat-openshift-ocp-release-4.%d-%s#%s"
+
+// releaseTagDetails is the part of the release controller's details of a
+// payload that has its phase, the results of its verification jobs and of the
+// upgrade jobs that upgraded to it.  Only the blocking jobs decide whether a
+// payload is accepted; the informing jobs are run for information.
+type releaseTagDetails struct {
+	Phase      string           `json:"phase"`
+	Results    jobResults       `json:"results"`
+	UpgradesTo []upgradeHistory `json:"upgradesTo"`
+}
+
+// upgradeHistory counts the upgrade job runs from one payload to another.
+type upgradeHistory struct {
+	From    string `json:"fromTag"`
+	To      string `json:"toTag"`
+	Success int    `json:"success"`
+	Failure int    `json:"failure"`
+	Total   int    `json:"total"`
+}
+
+type jobResults struct {
+	BlockingJobs  map[string]jobResult `json:"blockingJobs"`
+	InformingJobs map[string]jobResult `json:"informingJobs"`
+}
+
+type jobResult struct {
+	State string `json:"state"`
+	URL   string `json:"url"`
+	// TransitionTime is when the job reached its state.
+	TransitionTime time.Time `json:"transitionTime"`
+}
+
+// JobFailure summarizes how one verification job failed across the newest
+// rejected payloads of a stream.
+type JobFailure struct {
+	Job string
+	// Failed is how many of the analyzed payloads the job failed in, and
+	// Consecutive how many of the newest of them in a row.
+	Failed      int
+	Consecutive int
+	// Analyzed is how many rejected payloads the job results were fetched
+	// for.
+	Analyzed int
+	// RunURL is the Prow job run of the newest failure.  TestGridURL is the
+	// job's TestGrid dashboard, if the Prow job name could be found in
+	// RunURL.
+	RunURL      string
+	TestGridURL string
+	// PassPercentage is how often the job passed over the last 7 days in
+	// Runs runs, according to Sippy.  Runs is zero if that isn't known.
+	PassPercentage float64
+	Runs           int
+	// KnownIssues are the bugs that explain the failures, from
+	// Limits.KnownIssues.
+	KnownIssues []string
+}
+
+// String describes the failure, e.g. "aws-ovn-upgrade failed in the last 5
+// rejected payloads".
+func (f JobFailure) String() string {
+	return f.Job + " " + f.Description()
+}
+
+// Description is the String of the failure without the job name, e.g.
+// "failed in the last 5 rejected payloads, 34% pass over 7d".
+func (f JobFailure) Description() string {
+	description := ""
+	switch {
+	case f.Analyzed == 1:
+		description = "failed in the newest rejected payload"
+	case f.Consecutive == f.Analyzed:
+		description = fmt.Sprintf("failed in the last %d rejected payloads", f.Analyzed)
+	default:
+		description = fmt.Sprintf("failed in %d of the last %d rejected payloads", f.Failed, f.Analyzed)
+	}
+	if f.Runs > 0 {
+		description += fmt.Sprintf(", %.0f%% pass over 7d", f.PassPercentage)
+	}
+	return description + knownIssuesText(f.KnownIssues)
+}
+
+// linksText lists the links of the failure for the plain text report.
+func (f JobFailure) linksText() string {
+	output := ""
+	if f.RunURL != "" {
+		output += "      latest failure: " + f.RunURL + "\n"
+	}
+	if f.TestGridURL != "" {
+		output += "      testgrid: " + f.TestGridURL + "\n"
+	}
+	return output
+}
+
+// getPayloadDetails returns the details of a payload.  The result may be
+// shared with other callers and must not be modified.
+func (c *apiClient) getPayloadDetails(ctx context.Context, apiurl, stream, payload string) (*releaseTagDetails, error) {
+	details, _, err := c.getCached(ctx, apiurl+"/api/v1/releasestream/"+url.PathEscape(stream)+"/release/"+url.PathEscape(payload), func(body []byte) (interface{}, error) {
+		details := &releaseTagDetails{}
+		if err := json.Unmarshal(body, details); err != nil {
+			return nil, err
+		}
+		return details, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the details of %s: %v", payload, err)
+	}
+	return details.(*releaseTagDetails), nil
+}
+
+// getJobResults returns the results of the verification jobs of a payload.
+func (c *apiClient) getJobResults(ctx context.Context, apiurl, stream, payload string) (*jobResults, error) {
+	details, err := c.getPayloadDetails(ctx, apiurl, stream, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &details.Results, nil
+}
+
+// addJobFailures summarizes which blocking and which informing jobs failed in
+// the newest rejected payloads of each problem that lists them.  Payloads
+// whose details can't be fetched are left out of the summary.
+func addJobFailures(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report) {
+	type analysis struct {
+		minor   int
+		problem *Problem
+		results []*jobResults
+	}
+	analyses := []*analysis{}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			payloads := problem.RejectedPayloads
+			if len(payloads) == 0 {
+				continue
+			}
+			if len(payloads) > maxAnalyzedRejectedPayloads {
+				payloads = payloads[:maxAnalyzedRejectedPayloads]
+			}
+			a := &analysis{minor: stream.Minor, problem: problem, results: make([]*jobResults, len(payloads))}
+			analyses = append(analyses, a)
+			for k, payload := range payloads {
+				k, payload := k, payload
+				fetches = append(fetches, func() error {
+					results, err := client.getJobResults(ctx, releaseAPIUrl, stream.Name, payload)
+					if err != nil {
+						klog.Warningf("error analyzing the jobs of %s: %v", payload, err)
+						return nil
+					}
+					a.results[k] = results
+					return nil
+				})
+			}
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error analyzing rejected payloads: %v", err)
+		return
+	}
+	for _, a := range analyses {
+		blocking := make([]map[string]jobResult, len(a.results))
+		informing := make([]map[string]jobResult, len(a.results))
+		for i, results := range a.results {
+			if results == nil {
+				continue
+			}
+			// a payload without jobs of one kind still counts as analyzed
+			blocking[i], informing[i] = map[string]jobResult{}, map[string]jobResult{}
+			if results.BlockingJobs != nil {
+				blocking[i] = results.BlockingJobs
+			}
+			if results.InformingJobs != nil {
+				informing[i] = results.InformingJobs
+			}
+		}
+		a.problem.BlockingJobFailures = summarizeJobFailures(blocking, a.minor, "blocking")
+		a.problem.failedRuns = failedRuns(blocking)
+		a.problem.InformingJobFailures = summarizeJobFailures(informing, a.minor, "informing")
+	}
+}
+
+// summarizeJobFailures counts the failures of each job across the results of
+// the payloads, newest first, and sorts the jobs that failed most
+// persistently first.  Missing results are skipped.  The jobs are linked to
+// the TestGrid dashboard of the minor version and kind of job.
+func summarizeJobFailures(payloadResults []map[string]jobResult, minor int, kind string) []JobFailure {
+	analyzed := 0
+	failures := map[string]*JobFailure{}
+	order := []string{}
+	for _, results := range payloadResults {
+		if results == nil {
+			continue
+		}
+		analyzed++
+		for job, result := range results {
+			if result.State != jobStateFailed {
+				continue
+			}
+			failure, ok := failures[job]
+			if !ok {
+				failure = &JobFailure{Job: job, RunURL: result.URL}
+				if name := prowJobName(result.URL); name != "" {
+					failure.TestGridURL = fmt.Sprintf(testGridURL, minor, kind, name)
+				}
+				failures[job] = failure
+				order = append(order, job)
+			}
+			if failure.Failed == analyzed-1 {
+				failure.Consecutive++
+			}
+			failure.Failed++
+		}
+	}
+
+	summary := []JobFailure{}
+	for _, job := range order {
+		failure := failures[job]
+		failure.Analyzed = analyzed
+		summary = append(summary, *failure)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Consecutive != summary[j].Consecutive {
+			return summary[i].Consecutive > summary[j].Consecutive
+		}
+		if summary[i].Failed != summary[j].Failed {
+			return summary[i].Failed > summary[j].Failed
+		}
+		return summary[i].Job < summary[j].Job
+	})
+	return summary
+}
+
+// failedRuns returns the urls of the runs of the jobs that failed in the
+// results of the payloads, in order of the payloads and then of the jobs.
+func failedRuns(payloadResults []map[string]jobResult) []string {
+	runs := []string{}
+	for _, results := range payloadResults {
+		jobs := []string{}
+		for job, result := range results {
+			if result.State == jobStateFailed {
+				jobs = append(jobs, job)
+			}
+		}
+		sort.Strings(jobs)
+		for _, job := range jobs {
+			runs = append(runs, results[job].URL)
+		}
+	}
+	return runs
+}
+
+// prowJobName returns the name of the Prow job of a job run url such as
+// https://prow.ci.openshift.org/view/gs/origin-ci-test/logs/<job>/<id>, or ""
+// if the url isn't one.
+func prowJobName(runURL string) string {
+	u, err := url.Parse(runURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "logs" {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// sippyJob is the part of a job in the Sippy jobs api that has its recent
+// pass rate.
+type sippyJob struct {
+	Name string `json:"name"`
+	// the current period is the last 7 days
+	CurrentPassPercentage float64 `json:"current_pass_percentage"`
+	CurrentRuns           int     `json:"current_runs"`
+}
+
+// getSippyJobs returns the jobs Sippy knows of for a minor version, by Prow
+// job name.
+func (c *apiClient) getSippyJobs(ctx context.Context, sippyURL string, minor int) (map[string]sippyJob, error) {
+	jobs, _, err := c.getCached(ctx, fmt.Sprintf("%s/api/jobs?release=4.%d", sippyURL, minor), func(body []byte) (interface{}, error) {
+		list := []sippyJob{}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		jobs := map[string]sippyJob{}
+		for _, job := range list {
+			jobs[job.Name] = job
+		}
+		return jobs, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the Sippy jobs of 4.%d: %v", minor, err)
+	}
+	return jobs.(map[string]sippyJob), nil
+}
+
+// addPassRates annotates the failing blocking jobs with their pass rate over
+// the last 7 days according to Sippy, to tell a regression, which fails every
+// run, from a flake.  If Sippy doesn't know the jobs of a minor the report
+// says so.
+func addPassRates(ctx context.Context, client *apiClient, sippyURL string, r *Report) {
+	failuresByMinor := map[int][]*JobFailure{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			for k := range problem.BlockingJobFailures {
+				failuresByMinor[stream.Minor] = append(failuresByMinor[stream.Minor], &problem.BlockingJobFailures[k])
+			}
+		}
+	}
+	fetches := []func() error{}
+	for minor, failures := range failuresByMinor {
+		minor, failures := minor, failures
+		fetches = append(fetches, func() error {
+			jobs, err := client.getSippyJobs(ctx, sippyURL, minor)
+			if err != nil {
+				return err
+			}
+			for _, failure := range failures {
+				if job, ok := jobs[prowJobName(failure.RunURL)]; ok && job.CurrentRuns > 0 {
+					failure.PassPercentage = job.CurrentPassPercentage
+					failure.Runs = job.CurrentRuns
+				}
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		r.addUnavailable("the pass rates of the failing jobs from "+sippyURL, err)
+	}
+}
+
+// minCommonJobFailureStreams is how many streams a job has to be failing in
+// for CommonJobFailures to roll it up.
+const minCommonJobFailureStreams = 2
+
+// CommonJobFailure is a verification job that is failing in the newest
+// rejected payloads of several streams at once.  That usually has a single
+// cause, such as the infrastructure or a step of the step registry, worth one
+// fix rather than an investigation per stream.
+type CommonJobFailure struct {
+	Job string
+	// Blocking is set if the job blocked the acceptance of the payloads of
+	// any of the Streams, rather than just informing.
+	Blocking bool
+	Streams  []string
+	// Monitored is how many streams the report covers.
+	Monitored int
+}
+
+// String describes the failure, e.g. "aws-ovn-serial failed in 6 of 8
+// monitored streams: 4.15.0-0.nightly, ...".
+func (f CommonJobFailure) String() string {
+	job := f.Job
+	if !f.Blocking {
+		job += " (informing)"
+	}
+	return fmt.Sprintf("%s failed in %d of %d monitored streams: %s", job, len(f.Streams), f.Monitored, strings.Join(f.Streams, ", "))
+}
+
+// CommonJobFailures returns the jobs that failed in the newest rejected
+// payloads of at least minCommonJobFailureStreams streams, from the job
+// failures of their problems, the ones failing in the most streams first.
+func (r *Report) CommonJobFailures() []CommonJobFailure {
+	failures := map[string]*CommonJobFailure{}
+	for _, stream := range r.Streams {
+		seen := map[string]bool{}
+		add := func(job string, blocking bool) {
+			f, ok := failures[job]
+			if !ok {
+				f = &CommonJobFailure{Job: job, Monitored: len(r.Streams)}
+				failures[job] = f
+			}
+			f.Blocking = f.Blocking || blocking
+			if !seen[job] {
+				seen[job] = true
+				f.Streams = append(f.Streams, stream.Name)
+			}
+		}
+		for _, p := range stream.Problems {
+			for _, failure := range p.BlockingJobFailures {
+				add(failure.Job, true)
+			}
+			for _, failure := range p.InformingJobFailures {
+				add(failure.Job, false)
+			}
+		}
+	}
+	common := []CommonJobFailure{}
+	for _, f := range failures {
+		if len(f.Streams) >= minCommonJobFailureStreams {
+			common = append(common, *f)
+		}
+	}
+	sort.Slice(common, func(i, j int) bool {
+		if len(common[i].Streams) != len(common[j].Streams) {
+			return len(common[i].Streams) > len(common[j].Streams)
+		}
+		return common[i].Job < common[j].Job
+	})
+	return common
+}
+
+// CommonJobFailureLines describes the CommonJobFailures, one line each.
+func (r *Report) CommonJobFailureLines() []string {
+	lines := []string{}
+	for _, f := range r.CommonJobFailures() {
+		lines = append(lines, f.String())
+	}
+	return lines
+}
@@ -0,0 +1,82 @@
+package releasewatch
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KnownIssue is an open bug, e.g. OCPBUGS-12345, that explains the failures of
+// blocking jobs or of tests, so they aren't triaged again.  Exactly one of Job
+// and Test is set.
+type KnownIssue struct {
+	Bug string `json:"bug"`
+	// Job is the name of the jobs whose failures the bug explains, as the
+	// release controller or Prow names them, e.g. aws-ovn-upgrade, or a glob
+	// such as "*-upgrade".
+	Job string `json:"job,omitempty"`
+	// Test is part of the name of the failed tests the bug explains, matched
+	// against the Risks.
+	Test string `json:"test,omitempty"`
+}
+
+// Validate returns an error if the issue isn't valid.
+func (i KnownIssue) Validate() error {
+	if i.Bug == "" {
+		return fmt.Errorf("known issue must have a bug")
+	}
+	if (i.Job == "") == (i.Test == "") {
+		return fmt.Errorf("known issue %s must have either a job or a test", i.Bug)
+	}
+	if _, err := path.Match(i.Job, ""); err != nil {
+		return fmt.Errorf("invalid job of known issue %s: %v", i.Bug, err)
+	}
+	return nil
+}
+
+// matchesJob returns true if the issue explains the failures of the job.
+func (i KnownIssue) matchesJob(failure JobFailure) bool {
+	if i.Job == "" {
+		return false
+	}
+	for _, name := range []string{failure.Job, prowJobName(failure.RunURL)} {
+		if matched, _ := path.Match(i.Job, name); matched && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// knownIssuesText describes the bugs, e.g. ", known issue: OCPBUGS-12345".
+func knownIssuesText(bugs []string) string {
+	if len(bugs) == 0 {
+		return ""
+	}
+	return ", known issue: " + strings.Join(bugs, ", ")
+}
+
+// annotateKnownIssues notes the known issues of the failed blocking jobs and
+// the risks of each problem.
+func annotateKnownIssues(r *Report, issues []KnownIssue) {
+	for i := range r.Streams {
+		for j := range r.Streams[i].Problems {
+			problem := &r.Streams[i].Problems[j]
+			for k := range problem.BlockingJobFailures {
+				failure := &problem.BlockingJobFailures[k]
+				for _, issue := range issues {
+					if issue.matchesJob(*failure) {
+						failure.KnownIssues = append(failure.KnownIssues, issue.Bug)
+					}
+				}
+			}
+			for k := range problem.Risks {
+				risk := &problem.Risks[k]
+				for _, issue := range issues {
+					if issue.Test != "" && strings.Contains(risk.Test, issue.Test) {
+						risk.KnownIssues = append(risk.KnownIssues, issue.Bug)
+					}
+				}
+			}
+		}
+	}
+}
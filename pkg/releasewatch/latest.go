@@ -0,0 +1,47 @@
+package releasewatch
+
+import (
+	"fmt"
+)
+
+// LatestAcceptedText lists the newest accepted payload of each stream of the
+// report and how long ago it was built, as a table.
+func (r *Report) LatestAcceptedText() string {
+	rows := [][3]string{{"STREAM", "LATEST ACCEPTED", "AGE"}}
+	for _, stream := range r.Streams {
+		row := [3]string{stream.Name, "none", ""}
+		if stream.LatestAccepted != "" {
+			row[1] = stream.LatestAccepted
+			if built := stream.LatestAcceptedTime(); !built.IsZero() {
+				row[2] = formatAge(r.GeneratedAt.Sub(built))
+			}
+		}
+		rows = append(rows, row)
+	}
+	widths := [2]int{}
+	for _, row := range rows {
+		for i := range widths {
+			if len(row[i]) > widths[i] {
+				widths[i] = len(row[i])
+			}
+		}
+	}
+	output := ""
+	for _, row := range rows {
+		output += fmt.Sprintf("%-*s  %-*s  %s\n", widths[0], row[0], widths[1], row[1], row[2])
+	}
+	return output
+}
+
+// ForMinor returns a copy of the report that only includes the streams of
+// the minor version.
+func (r *Report) ForMinor(minor int) *Report {
+	filtered := *r
+	filtered.Streams = nil
+	for _, stream := range r.Streams {
+		if stream.Minor == minor {
+			filtered.Streams = append(filtered.Streams, stream)
+		}
+	}
+	return &filtered
+}
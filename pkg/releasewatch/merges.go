@@ -0,0 +1,91 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// releaseBranch returns the branch of the repos that the payloads of a minor
+// are built from, e.g. release-4.16.  The branch of the minor in development
+// is fast-forwarded from the default branch, so it is the same.
+func releaseBranch(minor int) string {
+	return fmt.Sprintf("release-4.%d", minor)
+}
+
+// hasMergesSince returns true if anything was merged to the branch of the
+// GitHub repo, e.g. openshift/origin, since the given time.
+func (c *apiClient) hasMergesSince(ctx context.Context, gitHubAPIURL, repo, branch string, since time.Time) (bool, error) {
+	query := url.Values{"sha": {branch}, "since": {since.UTC().Format(time.RFC3339)}, "per_page": {"1"}}
+	found, _, err := c.getCached(ctx, fmt.Sprintf("%s/repos/%s/commits?%s", gitHubAPIURL, repo, query.Encode()), func(body []byte) (interface{}, error) {
+		commits := []struct {
+			SHA string `json:"sha"`
+		}{}
+		if err := json.Unmarshal(body, &commits); err != nil {
+			return nil, err
+		}
+		return len(commits) > 0, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error fetching the commits to %s of %s: %v", branch, repo, err)
+	}
+	return found.(bool), nil
+}
+
+// checkMergeActivity tells a stream that isn't building because nothing
+// changed from one whose build system is broken: the stale built payload
+// problems of the streams whose release branch had changes merged to any of
+// the repos since their newest payload was built are escalated to critical.
+// If the commits can't be fetched the problems are left as they are.
+func checkMergeActivity(ctx context.Context, client *apiClient, gitHubAPIURL string, repos []string, r *Report) {
+	type check struct {
+		problem *Problem
+		branch  string
+		merged  []bool
+	}
+	checks := []*check{}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		built, err := getPayloadTimestamp(stream.LatestBuilt)
+		if err != nil {
+			continue
+		}
+		for j := range stream.Problems {
+			if stream.Problems[j].Kind != ProblemStaleBuiltPayload {
+				continue
+			}
+			c := &check{problem: &stream.Problems[j], branch: releaseBranch(stream.Minor), merged: make([]bool, len(repos))}
+			checks = append(checks, c)
+			for k, repo := range repos {
+				k, repo := k, repo
+				fetches = append(fetches, func() (err error) {
+					c.merged[k], err = client.hasMergesSince(ctx, gitHubAPIURL, repo, c.branch, built)
+					return err
+				})
+			}
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error checking merge activity: %v", err)
+		return
+	}
+	for _, c := range checks {
+		merged := []string{}
+		for k, repo := range repos {
+			if c.merged[k] {
+				merged = append(merged, repo)
+			}
+		}
+		if len(merged) == 0 {
+			continue
+		}
+		c.problem.Severity = SeverityCritical
+		c.problem.Message += fmt.Sprintf(", but %s had changes merged to %s since, so the build system may be broken", strings.Join(merged, ", "), c.branch)
+	}
+}
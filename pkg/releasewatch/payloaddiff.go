@@ -0,0 +1,145 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PayloadDiff is what changed between two payloads, as the release
+// controller's changelog reports it.
+type PayloadDiff struct {
+	From string
+	To   string
+	// Components are the versions of the payloads' major components, e.g.
+	// Kubernetes and RHCOS, that changed.
+	Components []ComponentChange
+	// NewImages and RemovedImages are the images only the newer and only the
+	// older payload has, sorted.
+	NewImages     []string
+	RemovedImages []string
+	// UpdatedImages are the images built from different commits, sorted by
+	// name.
+	UpdatedImages []ImageChange
+	// PullRequests are the pull requests that went into the updated images,
+	// in the order the changelog lists them.
+	PullRequests []PullRequest
+}
+
+// ComponentChange is how the version of a major component of the payloads
+// changed, e.g. Kubernetes 1.29.3 to 1.29.5.
+type ComponentChange struct {
+	Name string
+	From string
+	To   string
+}
+
+// ImageChange is an image built from different commits in the two payloads.
+type ImageChange struct {
+	Name string
+	// Repo is the url of the repository the image is built from.
+	Repo string
+	// Commit is the short commit the newer payload's image is built from,
+	// and Commits how many commits went into it since the older one.
+	Commit  string
+	Commits int
+}
+
+// PayloadDiff fetches what changed between two payloads, e.g.
+// 4.16.0-0.nightly-2024-06-01-123456 and 4.16.0-0.nightly-2024-06-02-123456.
+// The payloads don't have to be of the same stream.
+func (w *Watcher) PayloadDiff(ctx context.Context, from, to string) (*PayloadDiff, error) {
+	release, err := w.client.getReleaseChangelog(ctx, w.releaseAPIURLForPayload(to), from, to)
+	if err != nil {
+		return nil, err
+	}
+	diff := &PayloadDiff{
+		From:          from,
+		To:            to,
+		Components:    []ComponentChange{},
+		NewImages:     []string{},
+		RemovedImages: []string{},
+		UpdatedImages: []ImageChange{},
+		PullRequests:  []PullRequest{},
+	}
+	for _, component := range release.Components {
+		if component.From == "" || component.From == component.Version {
+			continue
+		}
+		name := component.DisplayName
+		if name == "" {
+			name = component.Name
+		}
+		diff.Components = append(diff.Components, ComponentChange{Name: name, From: component.From, To: component.Version})
+	}
+	for _, image := range release.NewImages {
+		diff.NewImages = append(diff.NewImages, image.Name)
+	}
+	for _, image := range release.RemovedImages {
+		diff.RemovedImages = append(diff.RemovedImages, image.Name)
+	}
+	pulls := map[string]struct{}{}
+	for _, image := range release.UpdatedImages {
+		diff.UpdatedImages = append(diff.UpdatedImages, ImageChange{Name: image.Name, Repo: image.Path, Commit: image.ShortCommit, Commits: len(image.Commits)})
+		diff.PullRequests = append(diff.PullRequests, image.pullRequests(pulls)...)
+	}
+	sort.Strings(diff.NewImages)
+	sort.Strings(diff.RemovedImages)
+	sort.Slice(diff.UpdatedImages, func(i, j int) bool { return diff.UpdatedImages[i].Name < diff.UpdatedImages[j].Name })
+	return diff, nil
+}
+
+// Summary describes the size of the diff, e.g. "3 components, 12 updated
+// images, 2 new images, 25 pull requests".
+func (d *PayloadDiff) Summary() string {
+	parts := []string{}
+	for _, count := range []struct {
+		n    int
+		noun string
+	}{
+		{len(d.Components), "components"},
+		{len(d.UpdatedImages), "updated images"},
+		{len(d.NewImages), "new images"},
+		{len(d.RemovedImages), "removed images"},
+		{len(d.PullRequests), "pull requests"},
+	} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.noun))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String renders the diff as text, with a section for each kind of change.
+func (d *PayloadDiff) String() string {
+	output := fmt.Sprintf("%s -> %s: %s\n", d.From, d.To, d.Summary())
+	if len(d.Components) > 0 {
+		output += "\nComponents:\n"
+		for _, component := range d.Components {
+			output += fmt.Sprintf("  %s %s -> %s\n", component.Name, component.From, component.To)
+		}
+	}
+	if len(d.UpdatedImages) > 0 {
+		output += "\nUpdated images:\n"
+		for _, image := range d.UpdatedImages {
+			output += fmt.Sprintf("  %s %s (%d commits)\n", image.Name, image.Commit, image.Commits)
+		}
+	}
+	if len(d.NewImages) > 0 {
+		output += "\nNew images: " + strings.Join(d.NewImages, ", ") + "\n"
+	}
+	if len(d.RemovedImages) > 0 {
+		output += "\nRemoved images: " + strings.Join(d.RemovedImages, ", ") + "\n"
+	}
+	if len(d.PullRequests) > 0 {
+		output += "\nPull requests:\n"
+		for _, pull := range d.PullRequests {
+			output += fmt.Sprintf("  %s %s\n", pull.String(), pull.URL)
+		}
+	}
+	return strings.TrimSuffix(output, "\n")
+}
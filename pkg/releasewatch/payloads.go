@@ -0,0 +1,156 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// maxListedRejectedPayloads bounds how many rejected payloads of a problem are
+// listed when rendering it.
+const maxListedRejectedPayloads = 5
+
+// Payload is a payload of a release stream.
+type Payload struct {
+	Name string
+	// Phase is the release controller's phase of the payload, e.g.
+	// Accepted, Rejected or Ready while it is being verified.
+	Phase   string
+	Created time.Time
+	// AcceptedAt is when the last of its blocking jobs finished, if it was
+	// accepted, and zero otherwise.
+	AcceptedAt time.Time
+}
+
+// releaseStreamTags is the response of the release controller's tags
+// endpoint for a stream.
+type releaseStreamTags struct {
+	Name string `json:"name"`
+	Tags []struct {
+		Name  string `json:"name"`
+		Phase string `json:"phase"`
+	} `json:"tags"`
+}
+
+// getStreamPayloads returns the payloads of the stream, newest first.  The
+// result may be shared with other callers and must not be modified.
+func (c *apiClient) getStreamPayloads(ctx context.Context, apiurl, stream string) ([]Payload, error) {
+	payloads, _, err := c.getCached(ctx, apiurl+"/api/v1/releasestream/"+url.PathEscape(stream)+"/tags", func(body []byte) (interface{}, error) {
+		tags := releaseStreamTags{}
+		if err := json.Unmarshal(body, &tags); err != nil {
+			return nil, err
+		}
+		payloads := []Payload{}
+		for _, tag := range tags.Tags {
+			created, _ := getPayloadTimestamp(tag.Name)
+			payloads = append(payloads, Payload{Name: tag.Name, Phase: tag.Phase, Created: created})
+		}
+		sort.SliceStable(payloads, func(i, j int) bool {
+			return payloads[i].Created.After(payloads[j].Created)
+		})
+		return payloads, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the payloads of %s: %v", stream, err)
+	}
+	return payloads.([]Payload), nil
+}
+
+// RecentPayloads fetches the count newest payloads of a stream, newest first.
+func (w *Watcher) RecentPayloads(ctx context.Context, stream string, count int) ([]Payload, error) {
+	return recentPayloads(ctx, w.client, w.releaseAPIURLFor(stream), stream, count)
+}
+
+// recentPayloads returns the count newest payloads of a stream, newest first,
+// with when the accepted ones were accepted, which takes a request for the
+// results of the jobs of each of them.
+func recentPayloads(ctx context.Context, client *apiClient, releaseAPIUrl, stream string, count int) ([]Payload, error) {
+	payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(payloads) > count {
+		payloads = payloads[:count]
+	}
+	// copied, as the payloads are shared with the other callers
+	payloads = append([]Payload{}, payloads...)
+	for i := range payloads {
+		if payloads[i].Phase != "Accepted" {
+			continue
+		}
+		results, err := client.getJobResults(ctx, releaseAPIUrl, stream, payloads[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		payloads[i].AcceptedAt = acceptedAt(results)
+	}
+	return payloads, nil
+}
+
+// addRecentPayloads fills in the most recent payloads of the streams that have
+// problems.
+func addRecentPayloads(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, count int) error {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if len(stream.Problems) == 0 {
+			continue
+		}
+		fetches = append(fetches, func() error {
+			payloads, err := recentPayloads(ctx, client, releaseAPIUrl, stream.Name, count)
+			if err != nil {
+				return err
+			}
+			stream.RecentPayloads = payloads
+			return nil
+		})
+	}
+	return client.parallel(ctx, fetches...)
+}
+
+// addRejectedPayloads lists the rejected payloads built since the newest
+// accepted payload of the streams whose accepted payload is stale, and all of
+// the rejected payloads of the streams with no accepted payloads, as the
+// payloads whose failed jobs to look into first.  If the payloads of a stream
+// can't be listed the report says so.
+func addRejectedPayloads(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report) {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			if problem.Kind != ProblemStaleAcceptedPayload && problem.Kind != ProblemNoAcceptedPayloads {
+				continue
+			}
+			// zero if there is no accepted payload
+			accepted, _ := getPayloadTimestamp(stream.LatestAccepted)
+			fetches = append(fetches, func() error {
+				payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+				if err != nil {
+					return err
+				}
+				for _, payload := range payloads {
+					if payload.Phase == "Rejected" && payload.Created.After(accepted) {
+						problem.RejectedPayloads = append(problem.RejectedPayloads, payload.Name)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		r.addUnavailable("the rejected payloads of "+releaseAPIUrl, err)
+	}
+}
+
+// ListedRejectedPayloads returns the rejected payloads of the problem to list
+// when rendering it, and how many more there are.
+func (p Problem) ListedRejectedPayloads() ([]string, int) {
+	if len(p.RejectedPayloads) <= maxListedRejectedPayloads {
+		return p.RejectedPayloads, 0
+	}
+	return p.RejectedPayloads[:maxListedRejectedPayloads], len(p.RejectedPayloads) - maxListedRejectedPayloads
+}
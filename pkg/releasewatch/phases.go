@@ -0,0 +1,81 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// phaseOrder is the order the phases of the release controller are listed in.
+// They are always listed, and any other phase follows them alphabetically.
+var phaseOrder = []string{"Accepted", "Rejected", "Ready", "Failed"}
+
+// PhaseCounts counts the payloads of a stream that were built within a
+// trailing window by their phase.
+type PhaseCounts struct {
+	Window time.Duration
+	// Counts are by the release controller's phase of the payloads, e.g.
+	// Accepted.
+	Counts map[string]int
+}
+
+// String describes the counts, e.g. "3 Accepted, 10 Rejected, 1 Ready, 0
+// Failed over the last 7.0d".
+func (p *PhaseCounts) String() string {
+	parts := []string{}
+	for _, phase := range phaseOrder {
+		parts = append(parts, fmt.Sprintf("%d %s", p.Counts[phase], phase))
+	}
+	others := []string{}
+	for phase := range p.Counts {
+		if !isListedPhase(phase) {
+			others = append(others, phase)
+		}
+	}
+	sort.Strings(others)
+	for _, phase := range others {
+		parts = append(parts, fmt.Sprintf("%d %s", p.Counts[phase], phase))
+	}
+	return strings.Join(parts, ", ") + " over the last " + formatAge(p.Window)
+}
+
+func isListedPhase(phase string) bool {
+	for _, listed := range phaseOrder {
+		if listed == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// addPhaseCounts counts the payloads built within the window of the streams
+// that have problems, or of all of them, by their phase.  Each stream takes a
+// request.
+func addPhaseCounts(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, window time.Duration, allStreams bool) error {
+	since := r.GeneratedAt.Add(-window)
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if len(stream.Problems) == 0 && !allStreams {
+			continue
+		}
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			counts := &PhaseCounts{Window: window, Counts: map[string]int{}}
+			for _, payload := range payloads {
+				if payload.Created.Before(since) {
+					continue
+				}
+				counts.Counts[payload.Phase]++
+			}
+			stream.PhaseCounts = counts
+			return nil
+		})
+	}
+	return client.parallel(ctx, fetches...)
+}
@@ -0,0 +1,204 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCincinnatiURL is the update service the published releases are
+// looked up in.
+const DefaultCincinnatiURL = "https://api.openshift.com/api/upgrades_info/v1/graph"
+
+// candidateChannel is the Cincinnati channel the accepted stable payloads are
+// published to first.
+const candidateChannel = "candidate"
+
+// stableVersionRegex matches the z-stream versions of the 4-stable stream,
+// e.g. 4.16.3, and not its release candidates.
+var stableVersionRegex = regexp.MustCompile(`^4\.([1-9][0-9]*)\.([0-9]+)$`)
+
+// stableStream returns the name of the release controller stream that the
+// stable payloads of the architecture are accepted into, e.g. 4-stable-arm64.
+func stableStream(arch string) string {
+	if arch == DefaultArch {
+		return "4-stable"
+	}
+	return "4-stable-" + arch
+}
+
+// getChannelVersions returns the versions in a Cincinnati channel, e.g.
+// candidate-4.16, for the architecture.  The result may be shared with other
+// callers and must not be modified.
+func (c *apiClient) getChannelVersions(ctx context.Context, cincinnatiURL, channel, arch string) (map[string]bool, error) {
+	query := url.Values{"channel": {channel}, "arch": {arch}}
+	versions, _, err := c.getCached(ctx, cincinnatiURL+"?"+query.Encode(), func(body []byte) (interface{}, error) {
+		graph := Graph{}
+		if err := json.Unmarshal(body, &graph); err != nil {
+			return nil, err
+		}
+		versions := map[string]bool{}
+		for _, node := range graph.Nodes {
+			versions[node.Version] = true
+		}
+		return versions, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the versions in %s: %v", channel, err)
+	}
+	return versions.(map[string]bool), nil
+}
+
+// unpublishedVersion is an accepted stable payload that isn't in a channel.
+type unpublishedVersion struct {
+	version    string
+	acceptedAt time.Time
+}
+
+// unpublishedVersions returns the stable payloads of the minor that were
+// accepted into the stream more than limit before now but aren't in the
+// channel, oldest first.  Only the versions newer than the newest one in the
+// channel are considered, since older ones were skipped on purpose.  Each of
+// them takes a request.
+func unpublishedVersions(ctx context.Context, client *apiClient, releaseAPIUrl, stream string, accepted []string, published map[string]bool, minor int, limit time.Duration, now time.Time) ([]unpublishedVersion, error) {
+	newestPublished := -1
+	candidates := map[int]string{}
+	for version := range published {
+		if m := stableVersionRegex.FindStringSubmatch(version); m != nil && m[1] == strconv.Itoa(minor) {
+			if z, _ := strconv.Atoi(m[2]); z > newestPublished {
+				newestPublished = z
+			}
+		}
+	}
+	for _, version := range accepted {
+		if m := stableVersionRegex.FindStringSubmatch(version); m != nil && m[1] == strconv.Itoa(minor) && !published[version] {
+			z, _ := strconv.Atoi(m[2])
+			candidates[z] = version
+		}
+	}
+	unpublished := []unpublishedVersion{}
+	for z, version := range candidates {
+		if z <= newestPublished {
+			continue
+		}
+		results, err := client.getJobResults(ctx, releaseAPIUrl, stream, version)
+		if err != nil {
+			return nil, err
+		}
+		acceptedAt := acceptedAt(results)
+		if acceptedAt.IsZero() || now.Sub(acceptedAt) <= limit {
+			continue
+		}
+		unpublished = append(unpublished, unpublishedVersion{version: version, acceptedAt: acceptedAt})
+	}
+	sort.Slice(unpublished, func(i, j int) bool {
+		return unpublished[i].acceptedAt.Before(unpublished[j].acceptedAt)
+	})
+	return unpublished, nil
+}
+
+// channelLag describes the stable payloads of the minor that were accepted
+// into the stream longer than the limit ago but aren't in the channel, e.g.
+// "4.16.3 was accepted 4.0d ago but isn't in fast-4.16", or returns "" if
+// there are none.
+func channelLag(ctx context.Context, client *apiClient, releaseAPIUrl, cincinnatiURL, stream, arch string, accepted []string, channel string, minor int, limit time.Duration, now time.Time) (string, error) {
+	published, err := client.getChannelVersions(ctx, cincinnatiURL, channel, arch)
+	if err != nil {
+		return "", err
+	}
+	unpublished, err := unpublishedVersions(ctx, client, releaseAPIUrl, stream, accepted, published, minor, limit, now)
+	if err != nil || len(unpublished) == 0 {
+		return "", err
+	}
+	oldest := unpublished[0]
+	lag := fmt.Sprintf("%s was accepted %s ago but isn't in %s", oldest.version, formatAge(now.Sub(oldest.acceptedAt)), channel)
+	if more := len(unpublished) - 1; more > 0 {
+		lag += fmt.Sprintf(", nor are %d newer ones", more)
+	}
+	return lag, nil
+}
+
+// checkUnpublishedPayloads flags the nightly stream of each minor whose
+// stable payloads were accepted into the 4-stable stream longer than the
+// publish limit ago, but haven't been published to the candidate channel,
+// and those whose stable payloads haven't been promoted to the other
+// channels, such as fast, within their promotion limits.  The release
+// controller doesn't record when a payload was accepted, so it is taken to be
+// when the last of its blocking jobs finished.
+func checkUnpublishedPayloads(ctx context.Context, client *apiClient, releaseAPIUrl, cincinnatiURL string, r *Report, publishLimit time.Duration, promotionLimits map[string]time.Duration) error {
+	accepted, _, err := client.getReleaseStream(ctx, releaseAPIUrl+acceptedReleasePath)
+	if err != nil {
+		return err
+	}
+	channels := []string{}
+	for channel := range promotionLimits {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if streamType(stream.Name) != "nightly" {
+			continue
+		}
+		stable := stableStream(stream.Arch)
+		fetches = append(fetches, func() error {
+			if publishLimit > 0 {
+				channel := fmt.Sprintf("%s-4.%d", candidateChannel, stream.Minor)
+				lag, err := channelLag(ctx, client, releaseAPIUrl, cincinnatiURL, stable, stream.Arch, accepted[stable], channel, stream.Minor, publishLimit, r.GeneratedAt)
+				if err != nil {
+					return err
+				}
+				if lag != "" {
+					stream.Problems = append(stream.Problems, newProblem(ProblemUnpublishedPayload, "Stable payload "+lag))
+				}
+			}
+			lags := []string{}
+			for _, channel := range channels {
+				lag, err := channelLag(ctx, client, releaseAPIUrl, cincinnatiURL, stable, stream.Arch, accepted[stable], fmt.Sprintf("%s-4.%d", channel, stream.Minor), stream.Minor, promotionLimits[channel], r.GeneratedAt)
+				if err != nil {
+					return err
+				}
+				if lag != "" {
+					lags = append(lags, lag)
+				}
+			}
+			if len(lags) > 0 {
+				stream.Problems = append(stream.Problems, newProblem(ProblemPromotionLag, "Stable payload "+strings.Join(lags, "; ")))
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error checking for unpublished payloads: %v", err)
+	}
+	return nil
+}
+
+// ParsePromotionLimits parses the limits of how long the accepted stable
+// payloads can take to be promoted to each Cincinnati channel, e.g.
+// "fast=168h" for the fast-4.N channels.
+func ParsePromotionLimits(values map[string]string) (map[string]time.Duration, error) {
+	limits := map[string]time.Duration{}
+	for channel, value := range values {
+		if !promotionChannelRegex.MatchString(channel) {
+			return nil, fmt.Errorf("invalid channel %q, expected the name of the channels without the version, e.g. fast", channel)
+		}
+		limit, err := time.ParseDuration(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q of channel %s, expected a positive duration such as 168h", value, channel)
+		}
+		limits[channel] = limit
+	}
+	return limits, nil
+}
+
+// promotionChannelRegex matches the names of the Cincinnati channels without
+// their version, e.g. fast or eus.
+var promotionChannelRegex = regexp.MustCompile(`^[a-z]+$`)
@@ -0,0 +1,131 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// regressedStatus is the highest Component Readiness status of a column that
+// is a regression: -2 is a significant regression and -3 an extreme one.
+const regressedStatus = -2
+
+// componentReadinessURL is the format of the url of the Component Readiness
+// page of a view, given the Sippy url and the view.
+const componentReadinessURL = "%s/sippy-ng/component_readiness/main?view=%s"
+
+// componentReport is the part of a Component Readiness report that has the
+// status of each component in each of its variant columns.
+type componentReport struct {
+	Rows []struct {
+		Component string `json:"component"`
+		Columns   []struct {
+			Status int `json:"status"`
+		} `json:"columns"`
+	} `json:"rows"`
+}
+
+// ComponentRegressions are the components that Component Readiness flags as
+// regressed in a minor.
+type ComponentRegressions struct {
+	Components []string
+	// URL is the Component Readiness page they are flagged on.
+	URL string
+}
+
+// String describes the regressions, e.g. "2 regressed components: Etcd,
+// Networking / ovn-kubernetes".
+func (c *ComponentRegressions) String() string {
+	noun := "components"
+	if len(c.Components) == 1 {
+		noun = "component"
+	}
+	return fmt.Sprintf("%d regressed %s: %s", len(c.Components), noun, strings.Join(c.Components, ", "))
+}
+
+// readinessView returns the Component Readiness view that compares a minor
+// with the previous one, e.g. 4.17-main.
+func readinessView(minor int) string {
+	return fmt.Sprintf("4.%d-main", minor)
+}
+
+// getRegressedComponents returns the components that Component Readiness
+// flags as regressed in the view, sorted.
+func (c *apiClient) getRegressedComponents(ctx context.Context, sippyURL, view string) ([]string, error) {
+	components, _, err := c.getCached(ctx, sippyURL+"/api/component_readiness?"+url.Values{"view": {view}}.Encode(), func(body []byte) (interface{}, error) {
+		report := componentReport{}
+		if err := json.Unmarshal(body, &report); err != nil {
+			return nil, err
+		}
+		components := []string{}
+		for _, row := range report.Rows {
+			for _, column := range row.Columns {
+				if column.Status <= regressedStatus {
+					components = append(components, row.Component)
+					break
+				}
+			}
+		}
+		sort.Strings(components)
+		return components, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the component readiness of %s: %v", view, err)
+	}
+	return components.([]string), nil
+}
+
+// addComponentRegressions lists the components that Component Readiness
+// flags as regressed with the problems of the streams of minors in
+// development, ones without z-stream releases in 4-stable yet, that aren't
+// accepting payloads, to tie them to a diagnosis.  Each of those minors takes
+// a request.  If Component Readiness can't be reached the problems go without
+// them.
+func addComponentRegressions(ctx context.Context, client *apiClient, releaseAPIUrl, sippyURL string, r *Report) {
+	accepted, _, err := client.getReleaseStream(ctx, releaseAPIUrl+acceptedReleasePath)
+	if err != nil {
+		klog.Warningf("error looking up the minors in development: %v", err)
+		return
+	}
+	problemsByMinor := map[int][]*Problem{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if newestVersion(accepted[stableStream(stream.Arch)], stableVersionRegex, stream.Minor) != "" {
+			continue
+		}
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			if problem.Kind == ProblemStaleAcceptedPayload || problem.Kind == ProblemNoAcceptedPayloads {
+				problemsByMinor[stream.Minor] = append(problemsByMinor[stream.Minor], problem)
+			}
+		}
+	}
+	fetches := []func() error{}
+	for minor, problems := range problemsByMinor {
+		minor, problems := minor, problems
+		fetches = append(fetches, func() error {
+			view := readinessView(minor)
+			components, err := client.getRegressedComponents(ctx, sippyURL, view)
+			if err != nil {
+				klog.Warningf("error fetching component regressions: %v", err)
+				return nil
+			}
+			if len(components) == 0 {
+				return nil
+			}
+			regressions := &ComponentRegressions{Components: components, URL: fmt.Sprintf(componentReadinessURL, sippyURL, view)}
+			for _, problem := range problems {
+				problem.ComponentRegressions = regressions
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error fetching component regressions: %v", err)
+	}
+}
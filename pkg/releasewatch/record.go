@@ -0,0 +1,116 @@
+package releasewatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// recordingFile holds when a recording was made, so a replay judges
+// staleness as of that time.
+const recordingFile = "recording.json"
+
+type recording struct {
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+var unsafeFixtureChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// fixtureName is the file a response to the url is recorded in, e.g.
+// api_v1_releasestreams_accepted.json.  The host is left out so a recording
+// can be replayed against any release api url.
+func fixtureName(req *http.Request) string {
+	name := req.URL.Path
+	if req.URL.RawQuery != "" {
+		name += "_" + req.URL.RawQuery
+	}
+	return strings.Trim(unsafeFixtureChars.ReplaceAllString(name, "_"), "_") + ".json"
+}
+
+// recordingTransport saves the body of every successful response in dir.
+type recordingTransport struct {
+	dir       string
+	transport http.RoundTripper
+}
+
+func newRecordingTransport(dir string, transport http.RoundTripper) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating the record directory: %v", err)
+	}
+	data, _ := json.MarshalIndent(recording{RecordedAt: time.Now().UTC()}, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(dir, recordingFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("error writing to the record directory: %v", err)
+	}
+	return &recordingTransport{dir: dir, transport: transport}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	path := filepath.Join(t.dir, fixtureName(req))
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		klog.Errorf("error recording the response to %s: %v", req.URL, err)
+	} else {
+		klog.V(2).Infof("recorded the response to %s in %s", req.URL, path)
+	}
+	return res, nil
+}
+
+// replayTransport answers requests with the responses recorded in dir
+// instead of making them.
+type replayTransport struct {
+	dir        string
+	recordedAt time.Time
+}
+
+// newReplayTransport returns a transport replaying the recording in dir.
+func newReplayTransport(dir string) (*replayTransport, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, recordingFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the replay directory: %v", err)
+	}
+	r := recording{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", filepath.Join(dir, recordingFile), err)
+	}
+	klog.V(2).Infof("replaying the responses recorded at %s", r.RecordedAt.Format(time.RFC3339))
+	return &replayTransport{dir: dir, recordedAt: r.RecordedAt}, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(t.dir, fixtureName(req))
+	status := http.StatusOK
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		klog.Errorf("no recorded response to %s: %v", req.URL, err)
+		status, body = http.StatusNotFound, []byte{}
+	}
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
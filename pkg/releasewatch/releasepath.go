@@ -0,0 +1,167 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// devPreviewVersionRegex matches the engineering candidates of the
+// 4-dev-preview stream, e.g. 4.17.0-ec.2.
+var devPreviewVersionRegex = regexp.MustCompile(`^4\.([1-9][0-9]*)\.0-ec\.([0-9]+)$`)
+
+// devPreviewStream returns the name of the release controller stream that the
+// engineering candidates of the architecture are accepted into, e.g.
+// 4-dev-preview-arm64.
+func devPreviewStream(arch string) string {
+	if arch == DefaultArch {
+		return "4-dev-preview"
+	}
+	return "4-dev-preview-" + arch
+}
+
+// newestVersion returns the newest of the versions of the minor that the
+// regex matches, ordered by the number in its second group, or "" if there
+// are none.
+func newestVersion(versions []string, regex *regexp.Regexp, minor int) string {
+	newest, newestNumber := "", -1
+	for _, version := range versions {
+		m := regex.FindStringSubmatch(version)
+		if m == nil || m[1] != strconv.Itoa(minor) {
+			continue
+		}
+		if number, _ := strconv.Atoi(m[2]); number > newestNumber {
+			newest, newestNumber = version, number
+		}
+	}
+	return newest
+}
+
+// ReleasePath is how far the releases of a nightly stream's minor have made it
+// from the release controller to customers.
+type ReleasePath struct {
+	// DevPreview is the newest engineering candidate of the minor accepted
+	// into 4-dev-preview, e.g. 4.17.0-ec.2.
+	DevPreview string
+	// Stable is the newest z-stream release of the minor accepted into
+	// 4-stable, e.g. 4.16.3.
+	Stable string
+	// Candidate is the newest z-stream release in Channel, the candidate
+	// channel of the minor, e.g. candidate-4.16.  Channel is empty if the
+	// update service wasn't looked up.
+	Candidate string
+	Channel   string
+}
+
+// String describes the path, e.g. "dev-preview none, 4-stable 4.16.3,
+// candidate-4.16 4.16.2".
+func (p *ReleasePath) String() string {
+	output := "dev-preview " + orNone(p.DevPreview) + ", 4-stable " + orNone(p.Stable)
+	if p.Channel != "" {
+		output += ", " + p.Channel + " " + orNone(p.Candidate)
+	}
+	return output
+}
+
+func orNone(version string) string {
+	if version == "" {
+		return "none"
+	}
+	return version
+}
+
+// ReleasePathLines lists the release path of each nightly stream, e.g.
+// "4.16.0-0.nightly: dev-preview none, 4-stable 4.16.3, candidate-4.16
+// 4.16.2".
+func (r *Report) ReleasePathLines() []string {
+	lines := []string{}
+	for _, stream := range r.Streams {
+		if stream.ReleasePath != nil {
+			lines = append(lines, stream.Name+": "+stream.ReleasePath.String())
+		}
+	}
+	return lines
+}
+
+// addReleasePaths looks up the release path of each nightly stream.  The
+// candidate channels are only looked up if cincinnatiURL is set, taking a
+// request per nightly stream.
+func addReleasePaths(ctx context.Context, client *apiClient, releaseAPIUrl, cincinnatiURL string, r *Report) error {
+	accepted, _, err := client.getReleaseStream(ctx, releaseAPIUrl+acceptedReleasePath)
+	if err != nil {
+		return err
+	}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if streamType(stream.Name) != "nightly" {
+			continue
+		}
+		path := &ReleasePath{
+			DevPreview: newestVersion(accepted[devPreviewStream(stream.Arch)], devPreviewVersionRegex, stream.Minor),
+			Stable:     newestVersion(accepted[stableStream(stream.Arch)], stableVersionRegex, stream.Minor),
+		}
+		stream.ReleasePath = path
+		if cincinnatiURL == "" {
+			continue
+		}
+		path.Channel = fmt.Sprintf("%s-4.%d", candidateChannel, stream.Minor)
+		fetches = append(fetches, func() error {
+			published, err := client.getChannelVersions(ctx, cincinnatiURL, path.Channel, stream.Arch)
+			if err != nil {
+				return err
+			}
+			versions := []string{}
+			for version := range published {
+				versions = append(versions, version)
+			}
+			path.Candidate = newestVersion(versions, stableVersionRegex, stream.Minor)
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error looking up the release paths: %v", err)
+	}
+	return nil
+}
+
+// checkStaleDevPreview flags the nightly stream of each minor in development,
+// one with engineering candidates but no z-stream releases yet, whose newest
+// engineering candidate was accepted into 4-dev-preview longer than the limit
+// ago.  Each of those minors takes a request.
+func checkStaleDevPreview(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, limit time.Duration) error {
+	accepted, _, err := client.getReleaseStream(ctx, releaseAPIUrl+acceptedReleasePath)
+	if err != nil {
+		return err
+	}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if streamType(stream.Name) != "nightly" {
+			continue
+		}
+		devPreview := devPreviewStream(stream.Arch)
+		newest := newestVersion(accepted[devPreview], devPreviewVersionRegex, stream.Minor)
+		if newest == "" || newestVersion(accepted[stableStream(stream.Arch)], stableVersionRegex, stream.Minor) != "" {
+			continue
+		}
+		fetches = append(fetches, func() error {
+			results, err := client.getJobResults(ctx, releaseAPIUrl, devPreview, newest)
+			if err != nil {
+				return err
+			}
+			acceptedAt := acceptedAt(results)
+			if acceptedAt.IsZero() || r.GeneratedAt.Sub(acceptedAt) <= limit {
+				return nil
+			}
+			stream.Problems = append(stream.Problems, newProblem(ProblemStaleDevPreview, fmt.Sprintf("Newest engineering candidate %s was accepted into %s %s ago", newest, devPreview, formatAge(r.GeneratedAt.Sub(acceptedAt)))))
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error checking the engineering candidates: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,867 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ProblemKind identifies the kind of a Problem.
+type ProblemKind string
+
+const (
+	ProblemNoAcceptedPayloads    ProblemKind = "NoAcceptedPayloads"
+	ProblemStaleAcceptedPayload  ProblemKind = "StaleAcceptedPayload"
+	ProblemNoBuiltPayloads       ProblemKind = "NoBuiltPayloads"
+	ProblemStaleBuiltPayload     ProblemKind = "StaleBuiltPayload"
+	ProblemNoPatchUpgrade        ProblemKind = "NoPatchUpgrade"
+	ProblemNoMinorUpgrade        ProblemKind = "NoMinorUpgrade"
+	ProblemLowAcceptanceRate     ProblemKind = "LowAcceptanceRate"
+	ProblemBuildCadenceDrop      ProblemKind = "BuildCadenceDrop"
+	ProblemStuckVerification     ProblemKind = "StuckVerification"
+	ProblemNoPathUpgrade         ProblemKind = "NoPathUpgrade"
+	ProblemLowUpgradeSuccessRate ProblemKind = "LowUpgradeSuccessRate"
+	ProblemArchSkew              ProblemKind = "ArchSkew"
+	ProblemSlowingAcceptance     ProblemKind = "SlowingAcceptance"
+	ProblemUnpublishedPayload    ProblemKind = "UnpublishedPayload"
+	ProblemPromotionLag          ProblemKind = "PromotionLag"
+	ProblemStaleDevPreview       ProblemKind = "StaleDevPreview"
+	ProblemLostUpgradeSource     ProblemKind = "LostUpgradeSource"
+	ProblemRejectionStreak       ProblemKind = "RejectionStreak"
+)
+
+// Problem is a single finding about a release stream.
+type Problem struct {
+	Kind     ProblemKind
+	Severity Severity
+	Message  string
+	// RejectedPayloads are the payloads built since the newest accepted one,
+	// if there is one, that were rejected, newest first.  They are only
+	// listed for stale accepted payloads and streams with no accepted
+	// payloads.
+	RejectedPayloads []string
+	// BlockingJobFailures summarizes the blocking jobs that failed in the
+	// newest of the rejected payloads, which is what kept them from being
+	// accepted.  InformingJobFailures are the informing jobs that failed,
+	// which didn't.
+	BlockingJobFailures  []JobFailure
+	InformingJobFailures []JobFailure
+	// RejectionCauses counts why the blocking jobs failed in those
+	// payloads, if the Options have a JobArtifactsURL to read their results
+	// from.
+	RejectionCauses *RejectionCauses
+	// Risks are the failed tests of those payloads' blocking jobs that
+	// Sippy's risk analysis judged most likely to be regressions, if the
+	// Options asked for them.
+	Risks []Risk
+	// ComponentRegressions are the components Component Readiness flags as
+	// regressed in the minor, for the stale accepted payloads and missing
+	// accepted payloads of minors in development, if the Options asked for
+	// them.
+	ComponentRegressions *ComponentRegressions
+	// failedRuns are the Prow runs of the blocking jobs that failed in those
+	// payloads, to classify.
+	failedRuns []string
+	// Changelog is what changed between the newest accepted payload and the
+	// newest built one, for stale accepted payloads.
+	Changelog *Changelog
+}
+
+func newProblem(kind ProblemKind, message string) Problem {
+	return Problem{Kind: kind, Severity: problemSeverities[kind], Message: message}
+}
+
+// AcknowledgedProblem is a problem that a user has acknowledged or snoozed,
+// or that has been silenced.
+type AcknowledgedProblem struct {
+	Problem
+	User  string
+	Until time.Time
+	// Reason is only set for silences.
+	Reason string
+}
+
+// StreamReport holds the problems found for a single release stream.
+type StreamReport struct {
+	Name  string
+	Minor int
+	// Arch is the architecture of the stream's payloads, e.g. amd64.
+	Arch string
+	// ReleaseURL is the url of the release controller the stream is on,
+	// which its links point to.
+	ReleaseURL string
+	// LatestAccepted and LatestBuilt are the newest payloads in the stream,
+	// empty if there are none.
+	LatestAccepted string
+	LatestBuilt    string
+	// LatestUpgraded is the newest payload in the stream that was
+	// successfully upgraded to, from any version, empty if there is none.
+	LatestUpgraded string
+	// Unaccepted are the payloads built since the newest accepted one, or
+	// all of them if none was accepted, that weren't accepted, newest first.
+	Unaccepted   []string
+	Problems     []Problem
+	Acknowledged []AcknowledgedProblem
+	// RecentPayloads are the newest payloads of the stream, newest first.
+	// They are only fetched for streams with problems, and only if the
+	// Watcher was asked for them.
+	RecentPayloads []Payload
+	// TagDetails are what the details of the stream's newest payloads say
+	// about its rejections and pending verifications, if the Watcher was
+	// asked to look them up.
+	TagDetails *TagDetails
+	// PhaseCounts count the stream's recent payloads by their phase.  They
+	// are only counted for streams with problems, unless the Watcher was
+	// asked to count them for all streams, and only if it was asked for
+	// them.
+	PhaseCounts *PhaseCounts
+	// ReleasePath is how far the releases of a nightly stream's minor have
+	// made it, if the Watcher was asked to look it up.
+	ReleasePath *ReleasePath
+	// Acceptance is how the stream's payloads were accepted recently, if the
+	// Watcher was asked to measure it.
+	Acceptance *AcceptanceStats
+	// UpgradeSources are the minors that the stream's payloads built within
+	// its upgrade staleness limit were successfully upgraded to from, newest
+	// first.
+	UpgradeSources []int
+	// Upgrades count the upgrades to the stream's recent payloads by the
+	// minor they upgraded from, newest minor first, if the Watcher was asked
+	// to count them.
+	Upgrades []UpgradeStats
+	// Constituents are the single architecture streams in the report that
+	// the payloads of a multi stream are assembled from, by architecture.
+	// They are empty for other streams.
+	Constituents []Constituent
+	// Health is a score of the stream's health from 0 to 100, combining how
+	// recently it accepted and built payloads, how many of them it accepted
+	// and how its upgrades are going.
+	Health int
+}
+
+// Report is the result of analyzing the release streams.  Every monitored
+// stream is included, whether or not it has problems.  Streams are sorted
+// from the newest minor version to the oldest unless the report is sorted
+// otherwise.
+type Report struct {
+	Streams     []StreamReport
+	SortOrder   SortOrder
+	OldestMinor int
+	NewestMinor int
+	// GeneratedAt is the time the streams were judged as of.
+	GeneratedAt time.Time
+	// CachedAt is set when the release controller couldn't be reached and
+	// the report was generated from cached data.  It is when the oldest of
+	// that data was fetched.
+	CachedAt time.Time
+	// Comparison is how the report differs from an earlier one, if the
+	// caller compared them.  It isn't saved in the History.
+	Comparison *Comparison `json:"-"`
+	// UpgradeWindow is how far back the Upgrades of the streams were
+	// counted, zero if they weren't.
+	UpgradeWindow time.Duration
+	// Ignored are the ignores that left streams out of the report.
+	Ignored []Ignore
+	// Location is the time zone the report shows times in, UTC if it is
+	// nil.  It isn't saved in the History.
+	Location *time.Location `json:"-"`
+	// SLOs are how the streams met their SLOs so far, if the caller
+	// measured them.  They aren't saved in the History.
+	SLOs []SLOStatus `json:"-"`
+	// Unavailable is the data that couldn't be fetched, which the report
+	// was generated without rather than not at all.
+	Unavailable []Unavailable `json:",omitempty"`
+	// SchemaVersion is the ReportSchemaVersion the report was encoded with.
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+func generateReport(ctx context.Context, client *apiClient, releaseAPIUrl string, limits Limits, now time.Time) (*Report, error) {
+	oldestMinor, newestMinor := limits.OldestMinor, limits.NewestMinor
+	var acceptedReleases, allReleases map[string][]string
+	var nightlyGraph GraphMap
+	var acceptedCachedAt, allCachedAt, graphCachedAt time.Time
+	err := client.parallel(ctx,
+		func() (err error) {
+			acceptedReleases, acceptedCachedAt, err = client.getReleaseStream(ctx, releaseAPIUrl+acceptedReleasePath)
+			return err
+		},
+		func() (err error) {
+			allReleases, allCachedAt, err = client.getReleaseStream(ctx, releaseAPIUrl+allReleasePath)
+			return err
+		},
+		func() (err error) {
+			// stable graph only includes successful edges.  nightly+prerelease include edges for any upgrade attempt that was
+			// made, regardless of whether the job passed.
+			nightlyGraph, graphCachedAt, err = client.getUpgradeGraph(ctx, releaseAPIUrl, "stable")
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	acceptedReleases, allReleases = filterStreams(acceptedReleases, limits), filterStreams(allReleases, limits)
+	acceptedReleases, _ = ignoreStreams(acceptedReleases, limits.Ignore, now)
+	allReleases, ignored := ignoreStreams(allReleases, limits.Ignore, now)
+
+	/*
+		 prereleaseGraph, err := client.getUpgradeGraph(ctx, "https://amd64.ocp.releases.ci.openshift.org", "prerelease")
+		if err != nil {
+			return "", err
+		}
+	*/
+
+	//report := checkUpgrades(nightlyGraph, acceptedReleases, acceptedStalenessLimit, oldestMinor)
+	report := checkUpgrades(nightlyGraph, allReleases, limits.upgradeStaleness, oldestMinor, newestMinor, now)
+	for stream, problems := range checkUpgradePaths(nightlyGraph, allReleases, limits.UpgradePaths, limits.upgradeStaleness, oldestMinor, newestMinor, now) {
+		report[stream] = append(report[stream], problems...)
+	}
+	sourceProblems, upgradeSources := checkUpgradeSources(nightlyGraph, allReleases, limits.UpgradePaths, limits.upgradeStaleness, oldestMinor, newestMinor, now)
+	for stream, problems := range sourceProblems {
+		report[stream] = append(report[stream], problems...)
+	}
+
+	acceptedEmpty, acceptedStale := getEmptyAndStaleStreams(acceptedReleases, limits.acceptedStaleness, oldestMinor, newestMinor, now)
+	allEmpty, allStale := getEmptyAndStaleStreams(allReleases, limits.acceptedStaleness, oldestMinor, newestMinor, now)
+
+	for stream, _ := range acceptedEmpty {
+		// if there are no accepted payloads, but the overall payloads set for the stream is not empty
+		// (and especially if the overall payloads are not stale), flag it.  If the overall stream is empty,
+		// we'll flag it further below.
+		if _, ok := allStale[stream]; !ok {
+			report[stream] = append(report[stream], newProblem(ProblemNoAcceptedPayloads, "Has no accepted payloads, but the stream contains recently built payloads"))
+		} else if _, ok := allEmpty[stream]; !ok {
+			// the stream isn't building either, which is flagged on its own
+			problem := newProblem(ProblemNoAcceptedPayloads, "Has no accepted payloads, but the stream contains built payloads")
+			problem.Severity = SeverityWarning
+			report[stream] = append(report[stream], problem)
+		}
+
+	}
+	for stream, age := range acceptedStale {
+		// if the latest accepted payload is stale, but there are non-stale payloads that have been built,
+		// flag it.  If the overall stream is stale(no recently built payloads), we'll flag it elsewhere.
+		if _, ok := allStale[stream]; !ok {
+			report[stream] = append(report[stream], newProblem(ProblemStaleAcceptedPayload, fmt.Sprintf("Most recently accepted payload was %.1f days ago, latest built payload is < %.1f days old", age.Hours()/24, limits.acceptedStaleness(stream).Hours()/24)))
+		}
+	}
+
+	for stream, _ := range allEmpty {
+		report[stream] = append(report[stream], newProblem(ProblemNoBuiltPayloads, "Has no built payloads"))
+	}
+
+	_, allVeryStale := getEmptyAndStaleStreams(allReleases, limits.builtStaleness, oldestMinor, newestMinor, now)
+
+	for stream, age := range allVeryStale {
+		problem := newProblem(ProblemStaleBuiltPayload, fmt.Sprintf("Most recently built payload was %.1f days ago", age.Hours()/24))
+		if age >= staleBuildWarningAge {
+			problem.Severity = SeverityWarning
+		}
+		report[stream] = append(report[stream], problem)
+	}
+
+	streams := []string{}
+	for stream := range allReleases {
+		if _, ok := report[stream]; ok || isMonitoredStream(stream, oldestMinor, newestMinor) {
+			streams = append(streams, stream)
+		}
+	}
+
+	sort.Strings(streams)
+	sort.SliceStable(streams, func(i, j int) bool {
+		iMatches := extractMinorRegex.FindStringSubmatch(streams[i])
+		iVersion, _ := strconv.Atoi(iMatches[1])
+		jMatches := extractMinorRegex.FindStringSubmatch(streams[j])
+		jVersion, _ := strconv.Atoi(jMatches[1])
+		// this deliberately reverses the standard sorting order so we
+		// get highest to lowest.
+		return iVersion > jVersion
+
+	})
+
+	r := &Report{
+		OldestMinor: oldestMinor,
+		NewestMinor: newestMinor,
+		GeneratedAt: now,
+		Ignored:     ignored,
+	}
+	for _, cachedAt := range []time.Time{acceptedCachedAt, allCachedAt, graphCachedAt} {
+		if !cachedAt.IsZero() && (r.CachedAt.IsZero() || cachedAt.Before(r.CachedAt)) {
+			r.CachedAt = cachedAt
+		}
+	}
+	for _, stream := range streams {
+		matches := extractMinorRegex.FindStringSubmatch(stream)
+		minor, _ := strconv.Atoi(matches[1])
+		r.Streams = append(r.Streams, StreamReport{
+			Name:           stream,
+			Minor:          minor,
+			Arch:           streamArch(stream),
+			ReleaseURL:     releaseAPIUrl,
+			LatestAccepted: latestPayload(acceptedReleases[stream]),
+			LatestBuilt:    latestPayload(allReleases[stream]),
+			LatestUpgraded: latestUpgradedPayload(nightlyGraph, allReleases[stream]),
+			Unaccepted:     unacceptedPayloads(acceptedReleases[stream], allReleases[stream]),
+			Problems:       report[stream],
+			UpgradeSources: upgradeSources[stream],
+		})
+	}
+	addRejectedPayloads(ctx, client, releaseAPIUrl, r)
+	addJobFailures(ctx, client, releaseAPIUrl, r)
+	addChangelogs(ctx, client, releaseAPIUrl, r)
+	return r, nil
+}
+
+// Styler styles a line of a rendered report by the severity it is about, e.g.
+// with terminal colors.  The severity of healthy streams is "".
+type Styler func(severity Severity, text string) string
+
+// apply styles the text, or leaves it as it is without a Styler.
+func (style Styler) apply(severity Severity, text string) string {
+	if style == nil {
+		return text
+	}
+	return style(severity, text)
+}
+
+// String renders the report as plain text.  A report covering more than one
+// architecture starts with a summary of each architecture and has a section
+// for each of them.
+func (r *Report) String() string {
+	return r.StyledString(nil)
+}
+
+// StyledString renders the report as text like String, with the header and
+// the problems of each stream and the warnings about the report's data styled
+// by their severity.
+func (r *Report) StyledString(style Styler) string {
+	output := ""
+	if warning := r.CacheWarning(); warning != "" {
+		output += style.apply(SeverityWarning, "WARNING: "+warning) + "\n\n"
+	}
+	for _, unavailable := range r.Unavailable {
+		output += style.apply(SeverityWarning, "WARNING: "+unavailable.String()) + "\n"
+	}
+	if len(r.Unavailable) > 0 {
+		output += "\n"
+	}
+
+	arches := r.Arches()
+	if len(arches) < 2 {
+		output += r.streamsText(style)
+	} else {
+		for _, arch := range arches {
+			output += fmt.Sprintf("%s: %s\n", arch, r.ForArch(arch).Summary())
+		}
+		if problems := r.CrossArchProblems(); len(problems) > 0 {
+			output += "In every architecture: " + strings.Join(problems, ", ") + "\n"
+		}
+		output += "\n"
+		for _, arch := range arches {
+			if archReport := r.ForArch(arch); archReport.HasProblems() {
+				output += fmt.Sprintf("=== %s ===\n\n", arch) + archReport.streamsText(style)
+			}
+		}
+	}
+	if lines := r.CommonJobFailureLines(); len(lines) > 0 {
+		output += "Jobs failing in several streams:\n"
+		for _, line := range lines {
+			output += "  " + line + "\n"
+		}
+	}
+	if lines := r.AcceptanceLines(); len(lines) > 0 {
+		output += r.AcceptanceHeading() + ":\n"
+		for _, line := range lines {
+			output += "  " + line + "\n"
+		}
+	}
+	if lines := r.UpgradeLines(); len(lines) > 0 {
+		output += r.UpgradeHeading() + ":\n"
+		for _, line := range lines {
+			output += "  " + line + "\n"
+		}
+	}
+	if lines := r.ReleasePathLines(); len(lines) > 0 {
+		output += "Release path:\n"
+		for _, line := range lines {
+			output += "  " + line + "\n"
+		}
+	}
+	if lines := r.SLOLines(); len(lines) > 0 {
+		output += "Service level objectives:\n"
+		for _, line := range lines {
+			output += "  " + line + "\n"
+		}
+	}
+	if r.Comparison != nil {
+		output += "\n" + r.ComparisonHeading() + ":\n"
+		for _, line := range r.Comparison.Lines() {
+			output += "  " + line + "\n"
+		}
+	}
+	output += fmt.Sprintf("\nIgnored releases older than 4.%d.z and newer than 4.%d.z\n", r.OldestMinor, r.NewestMinor)
+	for _, line := range r.IgnoredLines() {
+		output += line + "\n"
+	}
+	return output
+}
+
+// streamsText lists the problems of each stream, sectioned by minor version
+// if the streams are grouped by minor.
+func (r *Report) streamsText(style Styler) string {
+	output := ""
+	minor := 0
+	for _, stream := range r.Streams {
+		if len(stream.Problems) == 0 {
+			continue
+		}
+		if r.GroupedByMinor() && stream.Minor != minor {
+			minor = stream.Minor
+			output += fmt.Sprintf("== 4.%d ==\n\n", minor)
+		}
+		header := stream.URL() + " (" + stream.HealthString()
+		if stream.PhaseCounts != nil {
+			header += ", " + stream.PhaseCounts.String()
+		}
+		output += style.apply(stream.Severity(), header+")") + "\n"
+		for _, p := range stream.Problems {
+			output += style.apply(p.Severity, fmt.Sprintf("  - [%s] %s", p.Severity, p.Message)) + "\n"
+			if p.Changelog != nil {
+				output += "    unaccepted changes: " + p.Changelog.String() + "\n"
+				for _, pull := range p.Changelog.Pulls {
+					output += "      " + pull.String() + "\n"
+				}
+			}
+			for _, failure := range p.BlockingJobFailures {
+				output += "    " + failure.String() + "\n" + failure.linksText()
+			}
+			for _, failure := range p.InformingJobFailures {
+				output += "    informing, did not block acceptance: " + failure.String() + "\n" + failure.linksText()
+			}
+			if p.RejectionCauses != nil {
+				output += "    rejection causes: " + p.RejectionCauses.String() + "\n"
+			}
+			for _, risk := range p.Risks {
+				output += "    risk: " + risk.String() + "\n"
+			}
+			if p.ComponentRegressions != nil {
+				output += "    component readiness: " + p.ComponentRegressions.String() + " " + p.ComponentRegressions.URL + "\n"
+			}
+			rejected, more := p.ListedRejectedPayloads()
+			for _, payload := range rejected {
+				output += fmt.Sprintf("    rejected %s %s\n", payload, stream.PayloadURL(payload))
+			}
+			if more > 0 {
+				output += fmt.Sprintf("    and %d more rejected\n", more)
+			}
+		}
+		for _, c := range stream.Constituents {
+			output += "  built from " + c.String() + "\n"
+		}
+		if stream.TagDetails != nil && (stream.TagDetails.RejectionStreak > 0 || len(stream.TagDetails.Pending) > 0) {
+			output += "  Verification: " + stream.TagDetails.String() + "\n"
+		}
+		if len(stream.RecentPayloads) > 0 {
+			output += "  Recent payloads:\n"
+			for _, payload := range stream.RecentPayloads {
+				output += fmt.Sprintf("    %s %s, built %s", payload.Name, payload.Phase, r.FormatTimeAgo(payload.Created))
+				if !payload.AcceptedAt.IsZero() {
+					output += ", accepted " + r.FormatTimeAgo(payload.AcceptedAt)
+				}
+				output += "\n"
+			}
+		}
+		output += "\n"
+	}
+	return output
+}
+
+// CacheWarning describes how stale the report's data is, if it was generated
+// from cached data.
+func (r *Report) CacheWarning() string {
+	if r.CachedAt.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("The release controller could not be reached, this report uses data cached at %s", r.FormatTimeAgo(r.CachedAt))
+}
+
+// Unavailable is data that couldn't be fetched for a report, such as the
+// streams of a release controller that was down, or what one of the analyses
+// looks up.
+type Unavailable struct {
+	// What is the data, e.g. "the build cadence of
+	// https://amd64.ocp.releases.ci.openshift.org".
+	What  string
+	Error string
+}
+
+// addUnavailable records that the data couldn't be fetched, and the report is
+// generated without it.
+func (r *Report) addUnavailable(what string, err error) {
+	klog.Warningf("generating the report without %s: %v", what, err)
+	r.Unavailable = append(r.Unavailable, Unavailable{What: what, Error: err.Error()})
+}
+
+// String describes the unavailable data, e.g. "data unavailable for
+// https://arm64.ocp.releases.ci.openshift.org (error: ...)".
+func (u Unavailable) String() string {
+	return fmt.Sprintf("data unavailable for %s (error: %s)", u.What, u.Error)
+}
+
+// displayTimeFormat is how reports show times.
+const displayTimeFormat = "2006-01-02 15:04 MST"
+
+// FormatTime formats a time in the report's time zone, e.g. "2024-06-01
+// 14:00 CEST".
+func (r *Report) FormatTime(t time.Time) string {
+	location := r.Location
+	if location == nil {
+		location = time.UTC
+	}
+	return t.In(location).Format(displayTimeFormat)
+}
+
+// FormatTimeAgo formats a time in the report's time zone followed by how
+// long before the report it was, e.g. "2024-06-01 14:00 CEST (17h ago)", or
+// after it for times to come, e.g. "2024-06-04 14:00 CEST (in 2.5d)".
+func (r *Report) FormatTimeAgo(t time.Time) string {
+	age := r.GeneratedAt.Sub(t)
+	if age < 0 {
+		return fmt.Sprintf("%s (in %s)", r.FormatTime(t), formatAge(-age))
+	}
+	return fmt.Sprintf("%s (%s ago)", r.FormatTime(t), formatAge(age))
+}
+
+// Summary returns a one line description of the problems in the report, e.g.
+// "3 streams stale, 1 stream with no accepted payloads".
+func (r *Report) Summary() string {
+	if !r.HasProblems() {
+		return "All monitored release streams are healthy"
+	}
+	categories := []struct {
+		description string
+		kinds       []ProblemKind
+	}{
+		{"stale", []ProblemKind{ProblemStaleAcceptedPayload, ProblemStaleBuiltPayload}},
+		{"with no accepted payloads", []ProblemKind{ProblemNoAcceptedPayloads}},
+		{"with no built payloads", []ProblemKind{ProblemNoBuiltPayloads}},
+		{"missing recent upgrades", []ProblemKind{ProblemNoPatchUpgrade, ProblemNoMinorUpgrade, ProblemNoPathUpgrade, ProblemLostUpgradeSource}},
+		{"with failing upgrades", []ProblemKind{ProblemLowUpgradeSuccessRate}},
+		{"rejecting most payloads", []ProblemKind{ProblemLowAcceptanceRate}},
+		{"rejecting payloads in a row", []ProblemKind{ProblemRejectionStreak}},
+		{"building less often than usual", []ProblemKind{ProblemBuildCadenceDrop}},
+		{"with payloads stuck in verification", []ProblemKind{ProblemStuckVerification}},
+		{"behind another architecture", []ProblemKind{ProblemArchSkew}},
+		{"accepting more slowly", []ProblemKind{ProblemSlowingAcceptance}},
+		{"with unpublished stable payloads", []ProblemKind{ProblemUnpublishedPayload}},
+		{"with stalled channel promotions", []ProblemKind{ProblemPromotionLag}},
+		{"with stale engineering candidates", []ProblemKind{ProblemStaleDevPreview}},
+	}
+	parts := []string{}
+	for _, category := range categories {
+		count := 0
+		for _, stream := range r.Streams {
+			if stream.HasProblem(category.kinds...) {
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		noun := "streams"
+		if count == 1 {
+			noun = "stream"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s %s", count, noun, category.description))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Fingerprint identifies the state of the report, ignoring details such as
+// payload ages that change on every run.
+func (r *Report) Fingerprint() string {
+	parts := []string{}
+	for _, stream := range r.Streams {
+		for _, p := range stream.Problems {
+			parts = append(parts, stream.Name+"/"+string(p.Kind))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// HasProblems returns true if any stream has problems that have not been
+// acknowledged.
+func (r *Report) HasProblems() bool {
+	for _, stream := range r.Streams {
+		if len(stream.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasProblem returns true if the stream has a problem of any of the kinds.
+func (s *StreamReport) HasProblem(kinds ...ProblemKind) bool {
+	for _, p := range s.Problems {
+		for _, kind := range kinds {
+			if p.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasFindings returns true if any stream has problems, acknowledged or not.
+func (r *Report) HasFindings() bool {
+	for _, stream := range r.Streams {
+		if len(stream.Problems) > 0 || len(stream.Acknowledged) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isMonitoredStream returns true for the z-stream releases between the oldest
+// and newest minors.
+func isMonitoredStream(stream string, oldestMinor, newestMinor int) bool {
+	matches := zReleaseRegex.FindStringSubmatch(stream)
+	if matches == nil {
+		return false
+	}
+	v, _ := strconv.Atoi(matches[1])
+	return v >= oldestMinor && v <= newestMinor
+}
+
+// latestPayload returns the payload with the newest timestamp.
+func latestPayload(payloads []string) string {
+	latest := ""
+	var newest time.Time
+	for _, payload := range payloads {
+		ts, err := getPayloadTimestamp(payload)
+		if err != nil {
+			continue
+		}
+		if ts.After(newest) {
+			newest = ts
+			latest = payload
+		}
+	}
+	return latest
+}
+
+// getReleaseStream returns the payloads of each release stream, and when
+// they were cached if they come from the cache.  The result may be shared
+// with other callers and must not be modified.
+func (c *apiClient) getReleaseStream(ctx context.Context, url string) (map[string][]string, time.Time, error) {
+	releases, cachedAt, err := c.getCached(ctx, url, func(body []byte) (interface{}, error) {
+		releases := make(map[string][]string)
+		err := json.Unmarshal(body, &releases)
+		return releases, err
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error fetching releases: %v", err)
+	}
+	return releases.(map[string][]string), cachedAt, nil
+}
+
+// getEmptyAndStaleStreams returns the streams with no payloads, and the ones
+// with no payloads newer than their threshold along with the age of their
+// newest payload.
+func getEmptyAndStaleStreams(releases map[string][]string, threshold func(stream string) time.Duration, oldestMinor, newestMinor int, now time.Time) (map[string]struct{}, map[string]time.Duration) {
+	emptyStreams := make(map[string]struct{})
+	staleStreams := make(map[string]time.Duration)
+	releaseKeys := reflect.ValueOf(releases).MapKeys()
+	for _, k := range releaseKeys {
+		stream := k.String()
+
+		matches := zReleaseRegex.FindStringSubmatch(stream)
+
+		if matches == nil {
+			//fmt.Printf("ignoring non z-stream release %s\n", stream)
+			continue
+		}
+		if v, _ := strconv.Atoi(matches[1]); v < oldestMinor {
+			klog.V(4).Infof("ignoring release %s because it is older than the oldest desired minor %d\n", stream, oldestMinor)
+			continue
+		}
+		if v, _ := strconv.Atoi(matches[1]); v > newestMinor {
+			klog.V(4).Infof("ignoring release %s because it is newer than the newest desired minor %d\n", stream, newestMinor)
+			continue
+		}
+		if len(releases[stream]) == 0 {
+			emptyStreams[stream] = struct{}{}
+			continue
+		}
+		freshPayload := false
+		limit := threshold(stream)
+		var newest time.Time
+		for _, payload := range releases[stream] {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Errorf(err.Error())
+				continue
+			}
+			delta := now.Sub(ts)
+			if delta.Minutes() < limit.Minutes() {
+				//fmt.Printf("Release %s in stream %s is %d minutes old!\n", r, stream, delta)
+				freshPayload = true
+			}
+			if ts.After(newest) {
+				newest = ts
+			}
+		}
+		if !freshPayload {
+			//fmt.Printf("Release stream %s does not have a recent payload: "+releaseStreamURL+"\n", stream, stream)
+			staleStreams[stream] = now.Sub(newest)
+		}
+	}
+	return emptyStreams, staleStreams
+}
+
+func getPayloadTimestamp(payload string) (time.Time, error) {
+	m := extractDateRegex.FindStringSubmatch(payload)
+	if m == nil || len(m) != 7 {
+		return time.Time{}, fmt.Errorf("error: could not extract date from payload %s", payload)
+	}
+	// the release controller names payloads after when they were built in
+	// UTC
+	payloadTime, err := time.ParseInLocation("2006-01-02-150405", m[0], time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error: failed to parse time string %s: %v", m[0], err)
+	}
+	//fmt.Printf("%v\n", t)
+	return payloadTime, nil
+
+}
+
+type GraphNode struct {
+	Version string `json:"version"`
+	Payload string `json:"payload"`
+	From    int
+}
+
+type GraphEdge [2]int
+
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphMap map[string][]string
+
+// getUpgradeGraph returns the versions each version in the channel's graph
+// upgrades from, and when the graph was cached if it comes from the cache.
+// The result may be shared with other callers and must not be modified.
+func (c *apiClient) getUpgradeGraph(ctx context.Context, apiurl, channel string) (GraphMap, time.Time, error) {
+	url := apiurl + "/graph?channel=" + channel
+	graphMap, cachedAt, err := c.getCached(ctx, url, func(body []byte) (interface{}, error) {
+		graph := Graph{}
+		if err := json.Unmarshal(body, &graph); err != nil {
+			return nil, err
+		}
+		return buildGraphMap(graph), nil
+	})
+	if err != nil {
+		return GraphMap{}, time.Time{}, fmt.Errorf("error fetching upgrade graph: %v", err)
+	}
+	return graphMap.(GraphMap), cachedAt, nil
+}
+
+func buildGraphMap(graph Graph) GraphMap {
+	graphMap := GraphMap{}
+	for _, edge := range graph.Edges {
+		from := edge[0]
+		to := edge[1]
+		graph.Nodes[to].From = from
+		if _, ok := graphMap[graph.Nodes[to].Version]; !ok {
+			graphMap[graph.Nodes[to].Version] = []string{graph.Nodes[from].Version}
+		} else {
+			graphMap[graph.Nodes[to].Version] = append(graphMap[graph.Nodes[to].Version], graph.Nodes[from].Version)
+		}
+	}
+	return graphMap
+}
+
+func checkUpgrades(graph GraphMap, releases map[string][]string, threshold func(stream string) time.Duration, oldestMinor, newestMinor int, now time.Time) map[string][]Problem {
+	report := make(map[string][]Problem)
+	for release, payloads := range releases {
+
+		matches := zReleaseRegex.FindStringSubmatch(release)
+
+		if matches == nil {
+			//fmt.Printf("ignoring non z-stream release %s\n", stream)
+			continue
+		}
+		if v, _ := strconv.Atoi(matches[1]); v < oldestMinor {
+			klog.V(4).Infof("ignoring release %s because it is older than the oldest desired minor %d\n", release, oldestMinor)
+			continue
+		}
+		if v, _ := strconv.Atoi(matches[1]); v > newestMinor {
+			klog.V(4).Infof("ignoring release %s because it is newer than the newest desired minor %d\n", release, newestMinor)
+			continue
+		}
+
+		stalenessThreshold := threshold(release)
+		foundPatch := false
+		// lastMinor is the newest payload that was upgraded to from the
+		// previous minor, however old it is.
+		var lastMinor time.Time
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			age := now.Sub(ts)
+			fresh := age.Minutes() <= stalenessThreshold.Minutes()
+			if !fresh && !ts.After(lastMinor) {
+				continue
+			}
+			toMatches := extractMinorRegex.FindStringSubmatch(payload)
+			if toMatches == nil {
+				continue
+			}
+			toVersion, _ := strconv.Atoi(toMatches[1])
+
+			for _, from := range graph[payload] {
+
+				fromMatches := extractMinorRegex.FindStringSubmatch(from)
+
+				if fromMatches == nil {
+					klog.V(4).Infof("Ignoring upgrade to %s from %s because the minor version could not be determined\n", payload, from)
+					continue
+				}
+				fromVersion, _ := strconv.Atoi(fromMatches[1])
+
+				klog.V(4).Infof("Accepted payload %s upgrades from %s\n", payload, from)
+				if toVersion == fromVersion && fresh {
+					foundPatch = true
+				}
+				if toVersion == fromVersion+1 && ts.After(lastMinor) {
+					lastMinor = ts
+				}
+			}
+		}
+
+		if !foundPatch {
+			report[release] = append(report[release], newProblem(ProblemNoPatchUpgrade, "Does not have a recent valid patch level upgrade"))
+		}
+		// only an upgrade from the previous minor, 4.(y-1) to 4.y, counts as a
+		// minor level upgrade
+		toVersion, _ := strconv.Atoi(matches[1])
+		switch {
+		case lastMinor.IsZero():
+			report[release] = append(report[release], newProblem(ProblemNoMinorUpgrade, fmt.Sprintf("Does not have a recent valid minor level upgrade from 4.%d", toVersion-1)))
+		case now.Sub(lastMinor) > stalenessThreshold:
+			report[release] = append(report[release], newProblem(ProblemNoMinorUpgrade, fmt.Sprintf("Does not have a recent valid minor level upgrade from 4.%d, the newest was to a payload built %.1f days ago", toVersion-1, now.Sub(lastMinor).Hours()/24)))
+		}
+	}
+	return report
+}
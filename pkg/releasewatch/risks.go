@@ -0,0 +1,190 @@
+package releasewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// maxRiskAnalyzedRuns bounds how many failed job runs of a problem the risk
+// analysis is fetched for.  The runs of the newest rejected payloads come
+// first.
+const maxRiskAnalyzedRuns = 10
+
+// minRiskLevel is the lowest of Sippy's risk levels that is listed: Low is 1,
+// Medium 50 and High 100.
+const minRiskLevel = 50
+
+// riskAnalysis is the part of Sippy's risk analysis of a job run that has the
+// assessed risk of each of its failed tests.
+type riskAnalysis struct {
+	Tests []struct {
+		Name string         `json:"Name"`
+		Risk riskAssessment `json:"Risk"`
+	} `json:"Tests"`
+}
+
+type riskAssessment struct {
+	Level struct {
+		Name  string `json:"Name"`
+		Level int    `json:"Level"`
+	} `json:"Level"`
+	Reasons []string `json:"Reasons"`
+}
+
+// Risk is a failed test that Sippy's risk analysis of the failed blocking job
+// runs of rejected payloads judged to be a likely regression, rather than a
+// flake.
+type Risk struct {
+	Test string
+	// Level is the name of the highest level the test was assessed at, e.g.
+	// High, and Reason the first reason given for it.
+	Level  string
+	Reason string
+	// Runs is how many of the analyzed runs assessed the test.
+	Runs int
+	// KnownIssues are the bugs that explain the failures, from
+	// Limits.KnownIssues.
+	KnownIssues []string
+	level       int
+}
+
+// String describes the risk, e.g. "High: [sig-network] pods should ... in 3
+// runs, This test has passed 99.50% of 2000 runs on release 4.16".
+func (r Risk) String() string {
+	noun := "runs"
+	if r.Runs == 1 {
+		noun = "run"
+	}
+	output := fmt.Sprintf("%s: %s in %d %s", r.Level, r.Test, r.Runs, noun)
+	if r.Reason != "" {
+		output += ", " + r.Reason
+	}
+	return output + knownIssuesText(r.KnownIssues)
+}
+
+// prowJobRunID returns the id of the run of a job run url such as
+// https://prow.ci.openshift.org/view/gs/origin-ci-test/logs/<job>/<id>, or ""
+// if the url isn't one.
+func prowJobRunID(runURL string) string {
+	if prowJobName(runURL) == "" {
+		return ""
+	}
+	u, _ := url.Parse(runURL)
+	return path.Base(strings.TrimSuffix(u.Path, "/"))
+}
+
+// getRiskAnalysis returns Sippy's risk analysis of a job run.  The result
+// may be shared with other callers and must not be modified.
+func (c *apiClient) getRiskAnalysis(ctx context.Context, sippyURL, runID string) (*riskAnalysis, error) {
+	analysis, _, err := c.getCached(ctx, sippyURL+"/api/jobs/runs/risk_analysis?"+url.Values{"prow_job_run_id": {runID}}.Encode(), func(body []byte) (interface{}, error) {
+		analysis := &riskAnalysis{}
+		if err := json.Unmarshal(body, analysis); err != nil {
+			return nil, err
+		}
+		return analysis, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the risk analysis of run %s: %v", runID, err)
+	}
+	return analysis.(*riskAnalysis), nil
+}
+
+// topRisks combines the risk analyses of the runs into the top risks, the
+// tests assessed at the highest levels in the most runs first.
+func topRisks(analyses []*riskAnalysis, top int) []Risk {
+	byTest := map[string]*Risk{}
+	for _, analysis := range analyses {
+		if analysis == nil {
+			continue
+		}
+		for _, test := range analysis.Tests {
+			if test.Risk.Level.Level < minRiskLevel {
+				continue
+			}
+			risk, ok := byTest[test.Name]
+			if !ok {
+				risk = &Risk{Test: test.Name}
+				byTest[test.Name] = risk
+			}
+			risk.Runs++
+			if test.Risk.Level.Level > risk.level {
+				risk.level, risk.Level, risk.Reason = test.Risk.Level.Level, test.Risk.Level.Name, ""
+				if len(test.Risk.Reasons) > 0 {
+					risk.Reason = test.Risk.Reasons[0]
+				}
+			}
+		}
+	}
+	risks := []Risk{}
+	for _, risk := range byTest {
+		risks = append(risks, *risk)
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].level != risks[j].level {
+			return risks[i].level > risks[j].level
+		}
+		if risks[i].Runs != risks[j].Runs {
+			return risks[i].Runs > risks[j].Runs
+		}
+		return risks[i].Test < risks[j].Test
+	})
+	if len(risks) > top {
+		risks = risks[:top]
+	}
+	return risks
+}
+
+// addRisks lists the top risks that Sippy assessed in the failed blocking job
+// runs of the rejected payloads of each problem, as a first hypothesis of why
+// they were rejected.  Runs whose analysis can't be fetched are left out.
+func addRisks(ctx context.Context, client *apiClient, sippyURL string, r *Report, top int) {
+	type assessment struct {
+		problem  *Problem
+		analyses []*riskAnalysis
+	}
+	assessments := []*assessment{}
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		for j := range stream.Problems {
+			problem := &stream.Problems[j]
+			runIDs := []string{}
+			for _, runURL := range problem.failedRuns {
+				if id := prowJobRunID(runURL); id != "" && len(runIDs) < maxRiskAnalyzedRuns {
+					runIDs = append(runIDs, id)
+				}
+			}
+			if len(runIDs) == 0 {
+				continue
+			}
+			a := &assessment{problem: problem, analyses: make([]*riskAnalysis, len(runIDs))}
+			assessments = append(assessments, a)
+			for k, runID := range runIDs {
+				k, runID := k, runID
+				fetches = append(fetches, func() error {
+					analysis, err := client.getRiskAnalysis(ctx, sippyURL, runID)
+					if err != nil {
+						klog.Warningf("error fetching risk analysis: %v", err)
+						return nil
+					}
+					a.analyses[k] = analysis
+					return nil
+				})
+			}
+		}
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		klog.Warningf("error fetching risk analyses: %v", err)
+		return
+	}
+	for _, a := range assessments {
+		a.problem.Risks = topRisks(a.analyses, top)
+	}
+}
@@ -0,0 +1,123 @@
+package releasewatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ReportSchemaVersion is the version of the JSON encoding of the Report, which
+// is saved in its schemaVersion field.  Within a version fields are only ever
+// added: existing fields keep their names, types and meaning.  Removing or
+// renaming a field, or changing its type or meaning, bumps the version.
+// Reports encoded before the field existed have a schemaVersion of 0 and
+// follow version 1.
+const ReportSchemaVersion = 1
+
+// reportJSON is the Report without its MarshalJSON method.
+type reportJSON Report
+
+// MarshalJSON encodes the report with the current ReportSchemaVersion.
+func (r Report) MarshalJSON() ([]byte, error) {
+	r.SchemaVersion = ReportSchemaVersion
+	return json.Marshal(reportJSON(r))
+}
+
+// checkSchemaVersion returns an error if the report was encoded with a schema
+// version newer than this package understands.
+func (r *Report) checkSchemaVersion() error {
+	if r.SchemaVersion > ReportSchemaVersion {
+		return fmt.Errorf("the report has schema version %d, newer than the supported version %d", r.SchemaVersion, ReportSchemaVersion)
+	}
+	return nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// ReportSchema returns the JSON Schema of the JSON encoding of the Report, as
+// of ReportSchemaVersion.  Times are RFC 3339 strings and durations are
+// integer nanoseconds.
+func ReportSchema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	schema := typeSchema(reflect.TypeOf(Report{}), defs)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "release-watcher report"
+	schema["$defs"] = defs
+	// the report itself is inlined rather than referenced
+	report := defs["Report"].(map[string]interface{})
+	delete(defs, "Report")
+	for key, value := range report {
+		schema[key] = value
+	}
+	delete(schema, "$ref")
+	schema["properties"].(map[string]interface{})["schemaVersion"] = map[string]interface{}{"const": ReportSchemaVersion}
+	return schema
+}
+
+// typeSchema returns the schema of the JSON encoding of a type.  Structs are
+// added to the definitions by name and referenced.
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{"anyOf": []interface{}{typeSchema(t.Elem(), defs), map[string]interface{}{"type": "null"}}}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		def := map[string]interface{}{"type": "object"}
+		// added before the fields so recursive types terminate
+		defs[t.Name()] = def
+		// no field is required, as reports encoded before a field was
+		// added lack it
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if name, ok := jsonFieldName(field); ok {
+				properties[name] = typeSchema(field.Type, defs)
+			}
+		}
+		def["properties"] = properties
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// jsonFieldName returns the name of the field in the JSON encoding, or false
+// if it isn't encoded.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
@@ -0,0 +1,83 @@
+package releasewatch
+
+import (
+	"fmt"
+	"time"
+)
+
+// staleBuildWarningAge is how long a stream can go without building before
+// it is a warning rather than information.
+const staleBuildWarningAge = 7 * 24 * time.Hour
+
+// Severity is how urgently a problem needs attention.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// severities are in increasing order of urgency.
+var severities = []Severity{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// problemSeverities are the severities of each kind of problem.  Stale built
+// payloads are escalated to warnings once the stream hasn't built for
+// staleBuildWarningAge.
+var problemSeverities = map[ProblemKind]Severity{
+	// the stream builds, but nothing it builds is accepted
+	ProblemNoAcceptedPayloads:    SeverityCritical,
+	ProblemStaleAcceptedPayload:  SeverityWarning,
+	ProblemNoBuiltPayloads:       SeverityWarning,
+	ProblemStaleBuiltPayload:     SeverityInfo,
+	ProblemNoPatchUpgrade:        SeverityWarning,
+	ProblemNoMinorUpgrade:        SeverityWarning,
+	ProblemLowAcceptanceRate:     SeverityWarning,
+	ProblemBuildCadenceDrop:      SeverityWarning,
+	ProblemStuckVerification:     SeverityWarning,
+	ProblemNoPathUpgrade:         SeverityWarning,
+	ProblemLowUpgradeSuccessRate: SeverityWarning,
+	ProblemArchSkew:              SeverityWarning,
+	ProblemSlowingAcceptance:     SeverityWarning,
+	ProblemUnpublishedPayload:    SeverityWarning,
+	ProblemPromotionLag:          SeverityWarning,
+	ProblemStaleDevPreview:       SeverityWarning,
+	ProblemLostUpgradeSource:     SeverityWarning,
+	ProblemRejectionStreak:       SeverityWarning,
+}
+
+// ParseSeverity returns the severity with the given name.
+func ParseSeverity(name string) (Severity, error) {
+	for _, severity := range severities {
+		if string(severity) == name {
+			return severity, nil
+		}
+	}
+	return "", fmt.Errorf("invalid severity %q, expected one of %v", name, severities)
+}
+
+func (s Severity) rank() int {
+	for i, severity := range severities {
+		if severity == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// AtLeast returns true if the severity is as urgent as min or more.
+func (s Severity) AtLeast(min Severity) bool {
+	return s.rank() >= min.rank()
+}
+
+// Severity returns the most urgent severity of the stream's unacknowledged
+// problems, or an empty severity if it has none.
+func (s *StreamReport) Severity() Severity {
+	var highest Severity
+	for _, p := range s.Problems {
+		if highest == "" || p.Severity.AtLeast(highest) {
+			highest = p.Severity
+		}
+	}
+	return highest
+}
@@ -0,0 +1,247 @@
+package releasewatch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLOPeriod is the calendar period the compliance with an SLO is measured
+// over, in UTC.
+type SLOPeriod string
+
+const (
+	SLOQuarter SLOPeriod = "quarter"
+	SLOMonth   SLOPeriod = "month"
+)
+
+// bounds returns the start and end of the period that t is in.
+func (p SLOPeriod) bounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if p == SLOMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	month := time.Month((int(t.Month())-1)/3*3 + 1)
+	start := time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 3, 0)
+}
+
+// MaxLength is the length of the longest period, which is how long the
+// reports need to be kept in the History to measure an SLO over all of it.
+func (p SLOPeriod) MaxLength() time.Duration {
+	if p == SLOMonth {
+		return 31 * 24 * time.Hour
+	}
+	return 92 * 24 * time.Hour
+}
+
+// SLO is a service level objective for the streams matching a StreamPattern:
+// an accepted payload at least Every, on at least Target of the days of each
+// Period.
+type SLO struct {
+	Stream string
+	Every  time.Duration
+	Target float64
+	Period SLOPeriod
+}
+
+// ParseSLO parses an SLO such as
+//
+//	4.16.0-0.nightly every=24h target=95% period=quarter
+//
+// The target is a percentage or a fraction, and the period is a quarter,
+// which is the default, or a month.
+func ParseSLO(value string) (SLO, error) {
+	fields := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	slo := SLO{Stream: fields[0], Period: SLOQuarter}
+	if _, err := ParseStreamPattern(slo.Stream); slo.Stream == "" || err != nil {
+		return SLO{}, fmt.Errorf("invalid slo %q: must start with a stream", value)
+	}
+	rest := ""
+	if len(fields) > 1 {
+		rest = fields[1]
+	}
+	attrs, err := parseAttributes(rest)
+	if err != nil {
+		return SLO{}, fmt.Errorf("invalid slo %q: %v", value, err)
+	}
+	for _, kv := range attrs {
+		key, attr := kv[0], kv[1]
+		switch key {
+		case "every":
+			every, err := time.ParseDuration(attr)
+			if err != nil || every <= 0 {
+				return SLO{}, fmt.Errorf("invalid slo %q: every must be a positive duration such as 24h", value)
+			}
+			slo.Every = every
+		case "target":
+			target, err := strconv.ParseFloat(strings.TrimSuffix(attr, "%"), 64)
+			if err == nil && strings.HasSuffix(attr, "%") {
+				target /= 100
+			}
+			if err != nil || target <= 0 || target > 1 {
+				return SLO{}, fmt.Errorf("invalid slo %q: target must be a percentage such as 95%%", value)
+			}
+			slo.Target = target
+		case "period":
+			switch period := SLOPeriod(attr); period {
+			case SLOQuarter, SLOMonth:
+				slo.Period = period
+			default:
+				return SLO{}, fmt.Errorf("invalid slo %q: period must be quarter or month", value)
+			}
+		default:
+			return SLO{}, fmt.Errorf("invalid slo %q: unknown key %q", value, key)
+		}
+	}
+	if slo.Every == 0 || slo.Target == 0 {
+		return SLO{}, fmt.Errorf("invalid slo %q: must have an every duration and a target", value)
+	}
+	return slo, nil
+}
+
+// String describes the objective, e.g. "accepted at least every 24h on 95%
+// of days per quarter".
+func (s SLO) String() string {
+	return fmt.Sprintf("accepted at least every %s on %s of days per %s", formatAge(s.Every), formatPercent(s.Target), s.Period)
+}
+
+// SLOStatus is how a stream has met an SLO so far in the current period.
+type SLOStatus struct {
+	Stream string
+	SLO    SLO
+	// Days is how many days of the period so far the reports measured, and
+	// Met is how many of them the stream had an accepted payload at least
+	// every SLO.Every in.
+	Days int
+	Met  int
+	// Budget is how many days of the whole period are allowed to miss the
+	// objective.
+	Budget float64
+}
+
+// Compliance is the fraction of the measured days that met the objective.
+func (s SLOStatus) Compliance() float64 {
+	if s.Days == 0 {
+		return 1
+	}
+	return float64(s.Met) / float64(s.Days)
+}
+
+// BudgetLeft is how many days of the error budget haven't been spent, which
+// is negative once more days missed than the budget allows.
+func (s SLOStatus) BudgetLeft() float64 {
+	return s.Budget - float64(s.Days-s.Met)
+}
+
+// String describes the status, e.g. "4.16.0-0.nightly accepted at least every
+// 24h on 95% of days per quarter: 58/60 days met (96.7%), 2.6 of 4.6 days of
+// error budget left".
+func (s SLOStatus) String() string {
+	output := fmt.Sprintf("%s %s: %d/%d days met (%.1f%%), ", s.Stream, s.SLO, s.Met, s.Days, s.Compliance()*100)
+	if left := s.BudgetLeft(); left < 0 {
+		output += fmt.Sprintf("error budget of %.1f days spent, %.1f days over", s.Budget, -left)
+	} else {
+		output += fmt.Sprintf("%.1f of %.1f days of error budget left", left, s.Budget)
+	}
+	return output
+}
+
+func formatPercent(fraction float64) string {
+	return strconv.FormatFloat(fraction*100, 'f', -1, 64) + "%"
+}
+
+// AddSLOStatus measures how the streams of the report matching each SLO met
+// it so far in the current period, from the newest accepted payloads of the
+// earlier reports and the report itself.  A day is measured if a report was
+// generated on it, and met if the stream never went longer than the SLO's
+// interval without an accepted payload during it.
+func (r *Report) AddSLOStatus(slos []SLO, earlier []*Report) {
+	r.SLOs = nil
+	for _, slo := range slos {
+		pattern, err := ParseStreamPattern(slo.Stream)
+		if err != nil {
+			continue
+		}
+		start, end := slo.Period.bounds(r.GeneratedAt)
+		for _, stream := range r.Streams {
+			if !pattern.Match(stream.Name) {
+				continue
+			}
+			r.SLOs = append(r.SLOs, sloStatus(stream.Name, slo, append(earlier, r), start, end, r.GeneratedAt))
+		}
+	}
+}
+
+// sloStatus measures how the stream met the SLO between the start of the
+// period and now.
+func sloStatus(stream string, slo SLO, reports []*Report, start, end, now time.Time) SLOStatus {
+	status := SLOStatus{Stream: stream, SLO: slo, Budget: (1 - slo.Target) * end.Sub(start).Hours() / 24}
+	measured := map[time.Time]bool{}
+	seen := map[time.Time]bool{}
+	accepted := []time.Time{}
+	for _, report := range reports {
+		if report.GeneratedAt.Before(start) || report.GeneratedAt.After(now) {
+			continue
+		}
+		for _, s := range report.Streams {
+			if s.Name != stream {
+				continue
+			}
+			measured[report.GeneratedAt.UTC().Truncate(24*time.Hour)] = true
+			if created, err := getPayloadTimestamp(s.LatestAccepted); err == nil && !seen[created] {
+				seen[created] = true
+				accepted = append(accepted, created)
+			}
+		}
+	}
+	sort.Slice(accepted, func(i, j int) bool { return accepted[i].Before(accepted[j]) })
+	// the stretches of time the stream went without an accepted payload
+	// for longer than the interval.  Before the first one seen is unknown,
+	// unless none was ever seen.
+	type gap struct{ from, to time.Time }
+	gaps := []gap{}
+	if len(accepted) == 0 {
+		gaps = append(gaps, gap{start, now})
+	}
+	for i, a := range accepted {
+		to := now
+		if i+1 < len(accepted) {
+			to = accepted[i+1]
+		}
+		if from := a.Add(slo.Every); from.Before(to) {
+			gaps = append(gaps, gap{from, to})
+		}
+	}
+	for day := range measured {
+		status.Days++
+		dayEnd := day.Add(24 * time.Hour)
+		if dayEnd.After(now) {
+			dayEnd = now
+		}
+		met := true
+		for _, g := range gaps {
+			if g.from.Before(dayEnd) && g.to.After(day) {
+				met = false
+				break
+			}
+		}
+		if met {
+			status.Met++
+		}
+	}
+	return status
+}
+
+// SLOLines describes the SLOStatus of each stream the report measured one
+// for.
+func (r *Report) SLOLines() []string {
+	lines := []string{}
+	for _, status := range r.SLOs {
+		lines = append(lines, status.String())
+	}
+	return lines
+}
@@ -0,0 +1,89 @@
+package releasewatch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SortOrder is the order of the streams in a report.
+type SortOrder string
+
+const (
+	// SortByMinor orders the streams from the newest minor version to the
+	// oldest, with the streams of each minor grouped together.  It is the
+	// order reports are generated in.
+	SortByMinor SortOrder = "minor"
+	// SortBySeverity orders the streams with the most urgent problems first.
+	SortBySeverity SortOrder = "severity"
+	// SortByAcceptedAge orders the streams that have gone the longest
+	// without accepting a payload first.
+	SortByAcceptedAge SortOrder = "accepted-age"
+)
+
+var sortOrders = []SortOrder{SortByMinor, SortBySeverity, SortByAcceptedAge}
+
+// ParseSortOrder returns the sort order with the given name.
+func ParseSortOrder(name string) (SortOrder, error) {
+	for _, order := range sortOrders {
+		if string(order) == name {
+			return order, nil
+		}
+	}
+	return "", fmt.Errorf("invalid sort order %q, expected one of %v", name, sortOrders)
+}
+
+// GroupedByMinor returns true if the streams of each minor version are
+// together in the report, so it can be sectioned by minor version.
+func (r *Report) GroupedByMinor() bool {
+	return r.SortOrder == "" || r.SortOrder == SortByMinor
+}
+
+// Sort orders the report's streams.  Streams that are equal in the order
+// are left in the order of their minor versions.
+func (r *Report) Sort(order SortOrder) {
+	r.SortOrder = order
+	switch order {
+	case SortBySeverity:
+		sort.SliceStable(r.Streams, func(i, j int) bool {
+			return r.Streams[i].severityRank() > r.Streams[j].severityRank()
+		})
+	case SortByAcceptedAge:
+		sort.SliceStable(r.Streams, func(i, j int) bool {
+			return r.Streams[i].LatestAcceptedTime().Before(r.Streams[j].LatestAcceptedTime())
+		})
+	default:
+		sort.SliceStable(r.Streams, func(i, j int) bool {
+			return r.Streams[i].Minor > r.Streams[j].Minor
+		})
+	}
+}
+
+// severityRank ranks the stream by its most urgent problem, with streams
+// without problems last.
+func (s *StreamReport) severityRank() int {
+	if severity := s.Severity(); severity != "" {
+		return severity.rank()
+	}
+	return -1
+}
+
+// LatestAcceptedTime is when the newest accepted payload of the stream was
+// built, or zero if it has none.
+func (s *StreamReport) LatestAcceptedTime() time.Time {
+	if s.LatestAccepted == "" {
+		return time.Time{}
+	}
+	ts, _ := getPayloadTimestamp(s.LatestAccepted)
+	return ts
+}
+
+// LatestBuiltTime is when the newest payload of the stream was built, or zero
+// if it has none.
+func (s *StreamReport) LatestBuiltTime() time.Time {
+	if s.LatestBuilt == "" {
+		return time.Time{}
+	}
+	ts, _ := getPayloadTimestamp(s.LatestBuilt)
+	return ts
+}
@@ -0,0 +1,148 @@
+package releasewatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxHistogramBar is the longest bar of the payload age histograms.  Longer
+// bars are scaled down to it.
+const maxHistogramBar = 40
+
+// ageBuckets are the upper bounds of the buckets of the payload age
+// histograms.  The last bucket has no upper bound.
+var ageBuckets = []struct {
+	label string
+	below time.Duration
+}{
+	{"<6h", 6 * time.Hour},
+	{"6-12h", 12 * time.Hour},
+	{"12-24h", 24 * time.Hour},
+	{"1-2d", 48 * time.Hour},
+	{"2-4d", 96 * time.Hour},
+	{"4-7d", 168 * time.Hour},
+	{">7d", 0},
+}
+
+// PayloadAgeStats describes the ages of the unaccepted payloads of a stream,
+// the payloads built since its newest accepted one that weren't accepted, to
+// tell how backed up it is.
+type PayloadAgeStats struct {
+	Stream string
+	// Ages are the ages of the payloads, youngest first.
+	Ages   []time.Duration
+	Min    time.Duration
+	Median time.Duration
+	Max    time.Duration
+}
+
+// unacceptedPayloads returns the payloads built after the newest accepted
+// one, or all of them if none was accepted, that weren't accepted, newest
+// first.
+func unacceptedPayloads(accepted, all []string) []string {
+	isAccepted := map[string]bool{}
+	for _, payload := range accepted {
+		isAccepted[payload] = true
+	}
+	var newestAccepted time.Time
+	if latest := latestPayload(accepted); latest != "" {
+		newestAccepted, _ = getPayloadTimestamp(latest)
+	}
+	type built struct {
+		name    string
+		created time.Time
+	}
+	unaccepted := []built{}
+	for _, payload := range all {
+		created, err := getPayloadTimestamp(payload)
+		if err != nil || isAccepted[payload] || !created.After(newestAccepted) {
+			continue
+		}
+		unaccepted = append(unaccepted, built{payload, created})
+	}
+	sort.Slice(unaccepted, func(i, j int) bool { return unaccepted[i].created.After(unaccepted[j].created) })
+	names := []string{}
+	for _, payload := range unaccepted {
+		names = append(names, payload.name)
+	}
+	return names
+}
+
+// PayloadAgeStats describes the ages of the unaccepted payloads of each
+// stream of the report, in the order of the streams.
+func (r *Report) PayloadAgeStats() []PayloadAgeStats {
+	stats := []PayloadAgeStats{}
+	for _, stream := range r.Streams {
+		s := PayloadAgeStats{Stream: stream.Name}
+		for _, payload := range stream.Unaccepted {
+			if created, err := getPayloadTimestamp(payload); err == nil {
+				s.Ages = append(s.Ages, r.GeneratedAt.Sub(created))
+			}
+		}
+		sort.Slice(s.Ages, func(i, j int) bool { return s.Ages[i] < s.Ages[j] })
+		if n := len(s.Ages); n > 0 {
+			s.Min, s.Max = s.Ages[0], s.Ages[n-1]
+			s.Median = s.Ages[n/2]
+			if n%2 == 0 {
+				s.Median = (s.Ages[n/2-1] + s.Ages[n/2]) / 2
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// Histogram renders the ages as one bar per bucket of ages, e.g.
+//
+//	<6h    ### 3
+//	1-2d   # 1
+//
+// leaving out the empty buckets.
+func (s PayloadAgeStats) Histogram() []string {
+	counts := make([]int, len(ageBuckets))
+	for _, age := range s.Ages {
+		for i, bucket := range ageBuckets {
+			if bucket.below == 0 || age < bucket.below {
+				counts[i]++
+				break
+			}
+		}
+	}
+	lines := []string{}
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		bar := count
+		if len(s.Ages) > maxHistogramBar {
+			// keep a bar for every non-empty bucket
+			bar = (count*maxHistogramBar + len(s.Ages) - 1) / len(s.Ages)
+		}
+		lines = append(lines, fmt.Sprintf("%-6s %s %d", ageBuckets[i].label, strings.Repeat("#", bar), count))
+	}
+	return lines
+}
+
+// StatsText renders the ages of the unaccepted payloads of each stream as
+// plain text, with their minimum, median and maximum and a histogram.
+func (r *Report) StatsText() string {
+	output := "Unaccepted payloads, built since the newest accepted payload of each stream:\n\n"
+	caughtUp := []string{}
+	for _, s := range r.PayloadAgeStats() {
+		if len(s.Ages) == 0 {
+			caughtUp = append(caughtUp, s.Stream)
+			continue
+		}
+		output += fmt.Sprintf("%s: %d unaccepted, min %s, median %s, max %s\n", s.Stream, len(s.Ages), formatAge(s.Min), formatAge(s.Median), formatAge(s.Max))
+		for _, line := range s.Histogram() {
+			output += "  " + line + "\n"
+		}
+		output += "\n"
+	}
+	if len(caughtUp) > 0 {
+		output += "No unaccepted payloads: " + strings.Join(caughtUp, ", ") + "\n"
+	}
+	return output
+}
@@ -0,0 +1,117 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// payloadNameRegex matches the names of the payloads of the z-stream release
+// streams, e.g. 4.16.0-0.nightly-2024-06-01-123456, capturing the stream.
+var payloadNameRegex = regexp.MustCompile(`^(4\.[1-9][0-9]*\.0-0\.(?:ci|nightly)(?:-[a-z0-9]+)?)-[0-9]{4}-[0-9]{2}-[0-9]{2}-[0-9]{6}$`)
+
+// PayloadStatus is the state of a single payload as the release controller
+// reports it.
+type PayloadStatus struct {
+	Name   string
+	Stream string
+	Arch   string
+	// ReleaseURL is the url of the release controller the payload is on.
+	ReleaseURL string
+	// Phase is the release controller's phase of the payload, e.g.
+	// Accepted, Rejected or Ready while it is being verified.
+	Phase string
+	// Built is when the payload was built.  AcceptedAt is when the last of
+	// its blocking jobs finished, if it was accepted, and zero otherwise.
+	Built      time.Time
+	AcceptedAt time.Time
+	// BlockingJobs and InformingJobs are the results of its verification
+	// jobs, sorted by job.
+	BlockingJobs  []JobStatus
+	InformingJobs []JobStatus
+	// CheckedAt is when the status was fetched, which the times are shown
+	// relative to, in Location, UTC if it is nil.
+	CheckedAt time.Time
+	Location  *time.Location
+}
+
+// JobStatus is the result of one verification job of a payload.
+type JobStatus struct {
+	Job string
+	// State is e.g. Succeeded, Failed or Pending.
+	State string
+	URL   string
+}
+
+// URL returns the url of the release controller page of the payload.
+func (s *PayloadStatus) URL() string {
+	return releasePageURL(s.ReleaseURL, s.Arch, "/releasestream/"+s.Stream+"/release/"+s.Name)
+}
+
+// String describes the payload, e.g. "4.16.0-0.nightly-2024-06-01-123456 is
+// Accepted", followed by when it was built and accepted and a line per job.
+func (s *PayloadStatus) String() string {
+	times := &Report{GeneratedAt: s.CheckedAt, Location: s.Location}
+	output := fmt.Sprintf("%s is %s\n", s.Name, s.Phase)
+	output += "built " + times.FormatTimeAgo(s.Built) + "\n"
+	if !s.AcceptedAt.IsZero() {
+		output += "accepted " + times.FormatTimeAgo(s.AcceptedAt) + "\n"
+	}
+	for _, jobs := range []struct {
+		kind string
+		jobs []JobStatus
+	}{{"blocking", s.BlockingJobs}, {"informing", s.InformingJobs}} {
+		if len(jobs.jobs) == 0 {
+			continue
+		}
+		output += jobs.kind + " jobs:\n"
+		for _, job := range jobs.jobs {
+			output += fmt.Sprintf("  %s %s %s\n", job.Job, job.State, job.URL)
+		}
+	}
+	return output
+}
+
+// jobStatuses lists the results of the jobs, sorted by job.
+func jobStatuses(results map[string]jobResult) []JobStatus {
+	statuses := []JobStatus{}
+	for job, result := range results {
+		statuses = append(statuses, JobStatus{Job: job, State: result.State, URL: result.URL})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Job < statuses[j].Job
+	})
+	return statuses
+}
+
+// PayloadStatus fetches the current state of a payload of a z-stream release
+// stream, given by its name, e.g. 4.16.0-0.nightly-2024-06-01-123456.
+func (w *Watcher) PayloadStatus(ctx context.Context, payload string) (*PayloadStatus, error) {
+	m := payloadNameRegex.FindStringSubmatch(payload)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not the name of a payload of a ci or nightly stream, e.g. 4.16.0-0.nightly-2024-06-01-123456", payload)
+	}
+	releaseAPIURL := w.releaseAPIURLFor(m[1])
+	details, err := w.client.getPayloadDetails(ctx, releaseAPIURL, m[1], payload)
+	if err != nil {
+		return nil, err
+	}
+	built, _ := getPayloadTimestamp(payload)
+	status := &PayloadStatus{
+		Name:          payload,
+		Stream:        m[1],
+		Arch:          streamArch(m[1]),
+		ReleaseURL:    releaseAPIURL,
+		Phase:         details.Phase,
+		Built:         built,
+		BlockingJobs:  jobStatuses(details.Results.BlockingJobs),
+		InformingJobs: jobStatuses(details.Results.InformingJobs),
+		CheckedAt:     w.now(),
+	}
+	if details.Phase == "Accepted" {
+		status.AcceptedAt = acceptedAt(&details.Results)
+	}
+	return status, nil
+}
@@ -0,0 +1,149 @@
+package releasewatch
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// StreamTypes are the types of the z-stream release streams, named after the
+// version in the stream names, e.g. "nightly" for 4.16.0-0.nightly.
+var StreamTypes = []string{"ci", "nightly"}
+
+func streamType(stream string) string {
+	if matches := zReleaseRegex.FindStringSubmatch(stream); matches != nil {
+		return matches[2]
+	}
+	return ""
+}
+
+// StreamPattern matches the names of release streams.  It is either a glob,
+// e.g. "4.*.0-0.nightly" or an exact name such as "4.16.0-0.nightly", or a
+// regular expression between slashes, e.g. "/^4\.1[0-9]\.0-0\.ci$/".
+type StreamPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// ParseStreamPattern parses a glob or a regular expression between slashes.
+func ParseStreamPattern(value string) (StreamPattern, error) {
+	if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		regex, err := regexp.Compile(value[1 : len(value)-1])
+		if err != nil {
+			return StreamPattern{}, fmt.Errorf("invalid stream pattern %q: %v", value, err)
+		}
+		return StreamPattern{regex: regex}, nil
+	}
+	if _, err := path.Match(value, ""); err != nil {
+		return StreamPattern{}, fmt.Errorf("invalid stream pattern %q: %v", value, err)
+	}
+	return StreamPattern{glob: value}, nil
+}
+
+func (p StreamPattern) String() string {
+	if p.regex != nil {
+		return "/" + p.regex.String() + "/"
+	}
+	return p.glob
+}
+
+// Match returns true if the stream name matches the pattern.
+func (p StreamPattern) Match(stream string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(stream)
+	}
+	matched, _ := path.Match(p.glob, stream)
+	return matched
+}
+
+// isIncludedStream returns true for the streams of the included types, or of
+// any type if there are none, that match one of the included patterns, or any
+// stream if there are none, and none of the excluded ones.
+func isIncludedStream(stream string, limits Limits) bool {
+	if len(limits.StreamTypes) > 0 {
+		included := false
+		for _, t := range limits.StreamTypes {
+			if t == streamType(stream) {
+				included = true
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, p := range limits.ExcludeStreams {
+		if p.Match(stream) {
+			return false
+		}
+	}
+	if len(limits.Streams) == 0 {
+		return true
+	}
+	for _, p := range limits.Streams {
+		if p.Match(stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterStreams returns the releases without the z-stream releases that
+// aren't included by the limits.  The other releases, such as 4-stable, are
+// kept since the upgrades to the streams are checked against them.
+func filterStreams(releases map[string][]string, limits Limits) map[string][]string {
+	if len(limits.Streams) == 0 && len(limits.ExcludeStreams) == 0 && len(limits.StreamTypes) == 0 {
+		return releases
+	}
+	filtered := make(map[string][]string, len(releases))
+	for stream, payloads := range releases {
+		if !IsStreamName(stream) || isIncludedStream(stream, limits) {
+			filtered[stream] = payloads
+		}
+	}
+	return filtered
+}
+
+// StreamLimits overrides the staleness limits of the streams whose names
+// match a regular expression, e.g. to expect less of the streams of older
+// minors.  Zero limits are left as they are.
+type StreamLimits struct {
+	Match             *regexp.Regexp
+	AcceptedStaleness time.Duration
+	BuiltStaleness    time.Duration
+	UpgradeStaleness  time.Duration
+}
+
+// forStream returns the limits with the staleness limits of the first of the
+// stream limits that match the stream.
+func (l Limits) forStream(stream string) Limits {
+	for _, s := range l.StreamLimits {
+		if s.Match == nil || !s.Match.MatchString(stream) {
+			continue
+		}
+		if s.AcceptedStaleness > 0 {
+			l.AcceptedStaleness = s.AcceptedStaleness
+		}
+		if s.BuiltStaleness > 0 {
+			l.BuiltStaleness = s.BuiltStaleness
+		}
+		if s.UpgradeStaleness > 0 {
+			l.UpgradeStaleness = s.UpgradeStaleness
+		}
+		break
+	}
+	return l
+}
+
+func (l Limits) acceptedStaleness(stream string) time.Duration {
+	return l.forStream(stream).AcceptedStaleness
+}
+
+func (l Limits) builtStaleness(stream string) time.Duration {
+	return l.forStream(stream).BuiltStaleness
+}
+
+func (l Limits) upgradeStaleness(stream string) time.Duration {
+	return l.forStream(stream).UpgradeStaleness
+}
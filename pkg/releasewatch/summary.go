@@ -0,0 +1,83 @@
+package releasewatch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// severityGlyphs mark the status of each minor version in the compact
+// summary.
+var severityGlyphs = map[Severity]string{
+	"":               "✅",
+	SeverityInfo:     "ℹ️",
+	SeverityWarning:  "⚠️",
+	SeverityCritical: "🔴",
+}
+
+// CompactSummary describes the report in one line per minor version, newest
+// first, e.g. "⚠️ 4.16 accepted 2.5d ago".  Each line has a glyph for the most
+// urgent problem of the minor's streams and the age of its newest accepted
+// payload.  Reports covering more than one architecture have one line per
+// minor version and architecture.
+func (r *Report) CompactSummary() string {
+	type group struct {
+		minor    int
+		arch     string
+		severity Severity
+		accepted time.Time
+	}
+	multiArch := len(r.Arches()) > 1
+	groups := []*group{}
+	byKey := map[string]*group{}
+	sorted := *r
+	sorted.Streams = append([]StreamReport{}, r.Streams...)
+	sorted.Sort(SortByMinor)
+	for _, stream := range sorted.Streams {
+		key := fmt.Sprintf("%d/%s", stream.Minor, stream.Arch)
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{minor: stream.Minor, arch: stream.Arch}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		if severity := stream.Severity(); severity != "" && (g.severity == "" || severity.AtLeast(g.severity)) {
+			g.severity = severity
+		}
+		if accepted := stream.LatestAcceptedTime(); accepted.After(g.accepted) {
+			g.accepted = accepted
+		}
+	}
+
+	lines := []string{}
+	for _, g := range groups {
+		name := fmt.Sprintf("4.%d", g.minor)
+		if multiArch {
+			name += " " + g.arch
+		}
+		accepted := "nothing accepted"
+		if !g.accepted.IsZero() {
+			accepted = "accepted " + formatAge(r.GeneratedAt.Sub(g.accepted)) + " ago"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", severityGlyphs[g.severity], name, accepted))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatAge formats how long before the report was generated a time was,
+// e.g. "5h" or "2.5d".
+func (r *Report) FormatAge(t time.Time) string {
+	return formatAge(r.GeneratedAt.Sub(t))
+}
+
+// formatAge formats a duration briefly, e.g. 45m, 20h or 2.5d.
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%.1fd", age.Hours()/24)
+	}
+}
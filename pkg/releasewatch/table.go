@@ -0,0 +1,88 @@
+package releasewatch
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TableText renders the report as a fixed-width table with a row per stream:
+// its architecture, its newest built and accepted payloads and the newest
+// one that was upgraded to, each with how long ago it was built, and its
+// status, which is easier to scan in a terminal than the problems.
+func (r *Report) TableText() string {
+	return r.StyledTableText(nil)
+}
+
+// StyledTableText renders the report as a table like TableText, with the row
+// of each stream styled by its severity.
+func (r *Report) StyledTableText(style Styler) string {
+	age := func(payload string) string {
+		if payload == "" {
+			return ""
+		}
+		built, err := getPayloadTimestamp(payload)
+		if err != nil {
+			return ""
+		}
+		return formatAge(r.GeneratedAt.Sub(built))
+	}
+	none := func(payload string) string {
+		if payload == "" {
+			return "none"
+		}
+		return payload
+	}
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tARCH\tBUILT\tAGE\tACCEPTED\tAGE\tUPGRADED\tAGE\tSTATUS")
+	for _, stream := range r.Streams {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", stream.Name, stream.Arch,
+			none(stream.LatestBuilt), age(stream.LatestBuilt),
+			none(stream.LatestAccepted), age(stream.LatestAccepted),
+			none(stream.LatestUpgraded), age(stream.LatestUpgraded),
+			stream.Status())
+	}
+	w.Flush()
+	if style == nil {
+		return buf.String()
+	}
+	// the rows are styled once they are aligned, so the styling doesn't count
+	// towards the width of the columns; the header is the first line
+	lines := strings.Split(buf.String(), "\n")
+	for i, stream := range r.Streams {
+		lines[i+1] = style.apply(stream.Severity(), lines[i+1])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Status describes the stream's most urgent problem and how many it has,
+// e.g. "critical, 2 problems", or "healthy".
+func (s *StreamReport) Status() string {
+	switch len(s.Problems) {
+	case 0:
+		return "healthy"
+	case 1:
+		return fmt.Sprintf("%s, 1 problem", s.Severity())
+	default:
+		return fmt.Sprintf("%s, %d problems", s.Severity(), len(s.Problems))
+	}
+}
+
+// latestUpgradedPayload returns the newest of the payloads that the graph
+// has a successful upgrade to, from any version, or "" if there is none.
+func latestUpgradedPayload(graph GraphMap, payloads []string) string {
+	latest := ""
+	var newest time.Time
+	for _, payload := range payloads {
+		if len(graph[payload]) == 0 {
+			continue
+		}
+		if ts, err := getPayloadTimestamp(payload); err == nil && ts.After(newest) {
+			newest, latest = ts, payload
+		}
+	}
+	return latest
+}
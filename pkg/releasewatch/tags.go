@@ -0,0 +1,144 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxAnalyzedPendingPayloads bounds how many of the newest payloads being
+// verified of a stream the details are fetched for.
+const maxAnalyzedPendingPayloads = 3
+
+// TagDetails is what the release controller's per-payload details say about
+// the newest payloads of a stream, beyond how old they are.
+type TagDetails struct {
+	// RejectionStreak is how many of the newest verified payloads in a row
+	// were rejected or failed, and StreakSince the oldest of them, empty if
+	// there are none.  Payloads still being verified don't break a streak.
+	RejectionStreak int
+	StreakSince     string
+	// Pending are the payloads being verified, newest first, up to
+	// maxAnalyzedPendingPayloads of them.
+	Pending []PendingVerification
+	// LatestAcceptedAt is when the newest accepted payload finished its
+	// blocking jobs, zero if it has none or the details couldn't be fetched.
+	LatestAcceptedAt time.Time
+}
+
+// PendingVerification is a payload that is being verified, in the Ready
+// phase.
+type PendingVerification struct {
+	Name    string
+	Created time.Time
+	// BlockingJobs is how many blocking jobs verify the payload, Finished
+	// how many of them are done, and Failed the ones that failed already, in
+	// which case the payload will be rejected.
+	BlockingJobs int
+	Finished     int
+	Failed       []string
+}
+
+// String describes the pending verification, e.g. "4.16.0-0.nightly-...:
+// 5/8 blocking jobs finished, aws-ovn-upgrade failed".
+func (p PendingVerification) String() string {
+	output := fmt.Sprintf("%s: %d/%d blocking jobs finished", p.Name, p.Finished, p.BlockingJobs)
+	if len(p.Failed) > 0 {
+		output += ", " + strings.Join(p.Failed, ", ") + " failed"
+	}
+	return output
+}
+
+// String describes the details, e.g. "6 rejected in a row, 1 pending
+// (4.16.0-0.nightly-...: 5/8 blocking jobs finished)".
+func (t *TagDetails) String() string {
+	parts := []string{}
+	if t.RejectionStreak > 0 {
+		parts = append(parts, fmt.Sprintf("%d rejected in a row since %s", t.RejectionStreak, t.StreakSince))
+	}
+	if len(t.Pending) > 0 {
+		pending := []string{}
+		for _, p := range t.Pending {
+			pending = append(pending, p.String())
+		}
+		parts = append(parts, fmt.Sprintf("%d pending (%s)", len(t.Pending), strings.Join(pending, "; ")))
+	}
+	if len(parts) == 0 {
+		return "no rejections or pending verifications"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// addTagDetails looks up the details of the newest payloads of each stream:
+// how many were rejected in a row, how far the verification of the pending
+// ones got, and when the newest accepted one was accepted.  The streams
+// rejecting at least streakLimit payloads in a row are flagged, unless it is
+// zero.  Each stream takes a request, plus one per pending payload and one
+// for the newest accepted payload.
+func addTagDetails(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, streakLimit int) error {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			details := &TagDetails{}
+			streakBroken := false
+			for _, payload := range payloads {
+				switch payload.Phase {
+				case "Ready":
+					if len(details.Pending) < maxAnalyzedPendingPayloads {
+						details.Pending = append(details.Pending, PendingVerification{Name: payload.Name, Created: payload.Created})
+					}
+				case "Rejected", "Failed":
+					if !streakBroken {
+						details.RejectionStreak++
+						details.StreakSince = payload.Name
+					}
+				default:
+					streakBroken = true
+				}
+			}
+			for j := range details.Pending {
+				pending := &details.Pending[j]
+				results, err := client.getJobResults(ctx, releaseAPIUrl, stream.Name, pending.Name)
+				if err != nil {
+					// the payload may have been verified since
+					continue
+				}
+				pending.BlockingJobs = len(results.BlockingJobs)
+				for _, job := range jobStatuses(results.BlockingJobs) {
+					switch job.State {
+					case "Succeeded":
+						pending.Finished++
+					case jobStateFailed:
+						pending.Finished++
+						pending.Failed = append(pending.Failed, job.Job)
+					}
+				}
+			}
+			if stream.LatestAccepted != "" {
+				if results, err := client.getJobResults(ctx, releaseAPIUrl, stream.Name, stream.LatestAccepted); err == nil {
+					details.LatestAcceptedAt = acceptedAt(results)
+				}
+			}
+			stream.TagDetails = details
+
+			if streakLimit > 0 && details.RejectionStreak >= streakLimit {
+				message := fmt.Sprintf("Rejected its newest %d payloads in a row, since %s", details.RejectionStreak, details.StreakSince)
+				if built, err := getPayloadTimestamp(details.StreakSince); err == nil {
+					message += fmt.Sprintf(" built %s ago", formatAge(r.GeneratedAt.Sub(built)))
+				}
+				stream.Problems = append(stream.Problems, newProblem(ProblemRejectionStreak, message))
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error fetching the payload details: %v", err)
+	}
+	return nil
+}
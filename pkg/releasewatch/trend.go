@@ -0,0 +1,143 @@
+package releasewatch
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// AcceptanceTrendWindow is how far back the time to accept trends of the
+	// streams go.
+	AcceptanceTrendWindow = 30 * 24 * time.Hour
+	// minTrendReports and minTrendSpan are how many reports, spanning how
+	// long, need to have measured the time to accept of a stream for its
+	// trend to mean anything.
+	minTrendReports = 5
+	minTrendSpan    = 7 * 24 * time.Hour
+	// steadyTrendChange is the largest change of a trend that is described
+	// as steady.
+	steadyTrendChange = 0.1
+)
+
+// AcceptanceTrend is how the average time to accept of a stream, the latency
+// from a payload being built to it being accepted, changed over the reports
+// that measured it.
+type AcceptanceTrend struct {
+	// Reports is how many reports measured the latency, over Span.
+	Reports int
+	Span    time.Duration
+	// Start and End are the latency fitted to the reports at the oldest and
+	// the newest of them.
+	Start time.Duration
+	End   time.Duration
+	// Change is how much the fitted latency rose over the span, or fell if
+	// it is negative, as a fraction of the average latency.
+	Change float64
+}
+
+// String describes the trend, e.g. "rising from 5h to 9h over 24.0d (+60%)"
+// or "steady at 5h over 24.0d".
+func (t *AcceptanceTrend) String() string {
+	if math.Abs(t.Change) < steadyTrendChange {
+		return fmt.Sprintf("steady at %s over %s", formatAge((t.Start+t.End)/2), formatAge(t.Span))
+	}
+	direction := "rising"
+	if t.Change < 0 {
+		direction = "falling"
+	}
+	return fmt.Sprintf("%s from %s to %s over %s (%+.0f%%)", direction, formatAge(t.Start), formatAge(t.End), formatAge(t.Span), t.Change*100)
+}
+
+// acceptanceTrend fits a line to the average latencies measured at the given
+// times by least squares, or returns nil if there are too few of them.
+func acceptanceTrend(times []time.Time, latencies []time.Duration) *AcceptanceTrend {
+	if len(times) < minTrendReports {
+		return nil
+	}
+	oldest, newest := times[0], times[0]
+	for _, t := range times {
+		if t.Before(oldest) {
+			oldest = t
+		}
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	span := newest.Sub(oldest)
+	if span < minTrendSpan {
+		return nil
+	}
+	// x is in hours since the oldest report and y in hours of latency
+	n := float64(len(times))
+	var sumX, sumY, sumXX, sumXY float64
+	for i, t := range times {
+		x, y := t.Sub(oldest).Hours(), latencies[i].Hours()
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept := (sumY - slope*sumX) / n
+	mean := sumY / n
+	fitted := func(x float64) time.Duration {
+		if y := intercept + slope*x; y > 0 {
+			return time.Duration(y * float64(time.Hour))
+		}
+		return 0
+	}
+	trend := &AcceptanceTrend{
+		Reports: len(times),
+		Span:    span,
+		Start:   fitted(0),
+		End:     fitted(span.Hours()),
+	}
+	if mean > 0 {
+		trend.Change = slope * span.Hours() / mean
+	}
+	return trend
+}
+
+// AddAcceptanceTrends sets the time to accept trends of the streams of the
+// report whose acceptance was measured, from the earlier reports generated
+// within the AcceptanceTrendWindow before it and the report itself.
+func (r *Report) AddAcceptanceTrends(earlier []*Report) {
+	since := r.GeneratedAt.Add(-AcceptanceTrendWindow)
+	times := map[string][]time.Time{}
+	latencies := map[string][]time.Duration{}
+	for _, report := range append(earlier, r) {
+		if report.GeneratedAt.Before(since) || report.GeneratedAt.After(r.GeneratedAt) {
+			continue
+		}
+		for _, stream := range report.Streams {
+			if stream.Acceptance != nil && stream.Acceptance.Measured > 0 {
+				times[stream.Name] = append(times[stream.Name], report.GeneratedAt)
+				latencies[stream.Name] = append(latencies[stream.Name], stream.Acceptance.AverageLatency)
+			}
+		}
+	}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if stream.Acceptance != nil {
+			stream.Acceptance.Trend = acceptanceTrend(times[stream.Name], latencies[stream.Name])
+		}
+	}
+}
+
+// CheckAcceptanceTrends flags the streams whose time to accept rose by more
+// than the given fraction of its average over their trend, surfacing a slow
+// degradation before it hits a staleness limit.
+func (r *Report) CheckAcceptanceTrends(maxChange float64) {
+	if maxChange <= 0 {
+		return
+	}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		if stream.Acceptance == nil || stream.Acceptance.Trend == nil || stream.Acceptance.Trend.Change <= maxChange {
+			continue
+		}
+		trend := stream.Acceptance.Trend
+		stream.Problems = append(stream.Problems, newProblem(ProblemSlowingAcceptance, fmt.Sprintf("Time to accept rose from %s to %s over the last %s (%+.0f%%), more than the limit of %+.0f%%", formatAge(trend.Start), formatAge(trend.End), formatAge(trend.Span), trend.Change*100, maxChange*100)))
+	}
+}
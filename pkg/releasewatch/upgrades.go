@@ -0,0 +1,257 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpgradePath is an upgrade between two minor versions to watch, such as an
+// EUS upgrade from 4.14 to 4.16.
+type UpgradePath struct {
+	From int
+	To   int
+}
+
+func (p UpgradePath) String() string {
+	return fmt.Sprintf("4.%d:4.%d", p.From, p.To)
+}
+
+// upgradePathRegex matches upgrade paths, e.g. 4.14:4.16, capturing the
+// minors.
+var upgradePathRegex = regexp.MustCompile(`^4\.([1-9][0-9]*):4\.([1-9][0-9]*)$`)
+
+// ParseUpgradePath parses an upgrade path formatted like "4.14:4.16".
+func ParseUpgradePath(value string) (UpgradePath, error) {
+	matches := upgradePathRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return UpgradePath{}, fmt.Errorf("invalid upgrade path %q, expected a value like \"4.14:4.16\"", value)
+	}
+	minors := [2]int{}
+	for i := range minors {
+		minors[i], _ = strconv.Atoi(matches[i+1])
+	}
+	if minors[0] >= minors[1] {
+		return UpgradePath{}, fmt.Errorf("invalid upgrade path %q, the upgrade must be to a newer minor", value)
+	}
+	return UpgradePath{From: minors[0], To: minors[1]}, nil
+}
+
+// checkUpgradePaths flags the streams of the minor each path upgrades to that
+// have no payload built within their staleness threshold that was upgraded to
+// from the path's minor.
+func checkUpgradePaths(graph GraphMap, releases map[string][]string, paths []UpgradePath, threshold func(stream string) time.Duration, oldestMinor, newestMinor int, now time.Time) map[string][]Problem {
+	report := make(map[string][]Problem)
+	for release, payloads := range releases {
+		matches := zReleaseRegex.FindStringSubmatch(release)
+		if matches == nil || !isMonitoredStream(release, oldestMinor, newestMinor) {
+			continue
+		}
+		minor, _ := strconv.Atoi(matches[1])
+		for _, path := range paths {
+			if path.To != minor {
+				continue
+			}
+			found := false
+			for _, payload := range payloads {
+				ts, err := getPayloadTimestamp(payload)
+				if err != nil || now.Sub(ts) > threshold(release) {
+					continue
+				}
+				for _, from := range graph[payload] {
+					fromMatches := extractMinorRegex.FindStringSubmatch(from)
+					if fromMatches == nil {
+						continue
+					}
+					if fromMinor, _ := strconv.Atoi(fromMatches[1]); fromMinor == path.From {
+						found = true
+						break
+					}
+				}
+				if found {
+					break
+				}
+			}
+			if !found {
+				report[release] = append(report[release], newProblem(ProblemNoPathUpgrade, fmt.Sprintf("Does not have a recent valid upgrade on the watched 4.%d to 4.%d path", path.From, path.To)))
+			}
+		}
+	}
+	return report
+}
+
+// checkUpgradeSources returns the minors each stream's payloads built within
+// its staleness threshold were upgraded to from, and flags the streams that
+// older payloads still listed by the release controller were upgraded to
+// from a minor that none of the recent ones were.  That catches a stream
+// losing one of several upgrade sources, e.g. 4.14 to 4.16 while 4.15 to 4.16
+// keeps working, which the checks for any recent upgrade miss.  The
+// stream's own minor, the previous one and those of watched paths to it are
+// left to the checks of their own.
+func checkUpgradeSources(graph GraphMap, releases map[string][]string, paths []UpgradePath, threshold func(stream string) time.Duration, oldestMinor, newestMinor int, now time.Time) (map[string][]Problem, map[string][]int) {
+	report := make(map[string][]Problem)
+	sources := make(map[string][]int)
+	for release, payloads := range releases {
+		matches := zReleaseRegex.FindStringSubmatch(release)
+		if matches == nil || !isMonitoredStream(release, oldestMinor, newestMinor) {
+			continue
+		}
+		minor, _ := strconv.Atoi(matches[1])
+		// recent are the minors upgraded from to recent payloads, and
+		// newest when each minor was last upgraded from, to any payload
+		recent := map[int]bool{}
+		newest := map[int]time.Time{}
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				continue
+			}
+			for _, from := range graph[payload] {
+				fromMatches := extractMinorRegex.FindStringSubmatch(from)
+				if fromMatches == nil {
+					continue
+				}
+				fromMinor, _ := strconv.Atoi(fromMatches[1])
+				if now.Sub(ts) <= threshold(release) {
+					recent[fromMinor] = true
+				}
+				if ts.After(newest[fromMinor]) {
+					newest[fromMinor] = ts
+				}
+			}
+		}
+		for fromMinor := range recent {
+			sources[release] = append(sources[release], fromMinor)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(sources[release])))
+
+		covered := map[int]bool{minor: true, minor - 1: true}
+		for _, path := range paths {
+			if path.To == minor {
+				covered[path.From] = true
+			}
+		}
+		lost := []int{}
+		for fromMinor := range newest {
+			if !recent[fromMinor] && !covered[fromMinor] {
+				lost = append(lost, fromMinor)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(lost)))
+		for _, fromMinor := range lost {
+			message := fmt.Sprintf("Does not have a recent valid upgrade from 4.%d, the newest was to a payload built %s ago", fromMinor, formatAge(now.Sub(newest[fromMinor])))
+			if len(sources[release]) > 0 {
+				message += fmt.Sprintf(", while recent payloads were upgraded to from %s", formatMinors(sources[release]))
+			}
+			report[release] = append(report[release], newProblem(ProblemLostUpgradeSource, message))
+		}
+	}
+	return report, sources
+}
+
+// formatMinors formats minors like "4.15, 4.14".
+func formatMinors(minors []int) string {
+	formatted := []string{}
+	for _, minor := range minors {
+		formatted = append(formatted, fmt.Sprintf("4.%d", minor))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// UpgradeStats counts the upgrade job runs from one minor to the payloads of
+// a stream that were built within the upgrade window.
+type UpgradeStats struct {
+	FromMinor int
+	Success   int
+	Total     int
+}
+
+// String describes the stats, e.g. "from 4.13: 12/20 succeeded".
+func (s UpgradeStats) String() string {
+	return fmt.Sprintf("from 4.%d: %d/%d succeeded", s.FromMinor, s.Success, s.Total)
+}
+
+// UpgradeLines describes the upgrade stats of each stream, e.g.
+// "4.16.0-0.nightly from 4.15: 12/20 succeeded".
+func (r *Report) UpgradeLines() []string {
+	lines := []string{}
+	for _, stream := range r.Streams {
+		for _, stats := range stream.Upgrades {
+			lines = append(lines, stream.Name+" "+stats.String())
+		}
+	}
+	return lines
+}
+
+// UpgradeHeading is the heading of the UpgradeLines, e.g. "Upgrade success
+// over the last 7.0d".
+func (r *Report) UpgradeHeading() string {
+	return "Upgrade success over the last " + formatAge(r.UpgradeWindow)
+}
+
+// addUpgradeStats counts the upgrade job runs to the payloads of each stream
+// built within the window, by the minor they upgraded from, and flags the
+// upgrades that succeeded less often than the minimum rate.  Each payload
+// takes a request.
+func addUpgradeStats(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, window time.Duration, minRate float64) error {
+	r.UpgradeWindow = window
+	since := r.GeneratedAt.Add(-window)
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			byMinor := map[int]*UpgradeStats{}
+			// the payloads are fetched one at a time, to not hold more than
+			// one of the parallel slots per stream
+			for _, payload := range payloads {
+				if !payload.Created.After(since) {
+					continue
+				}
+				details, err := client.getPayloadDetails(ctx, releaseAPIUrl, stream.Name, payload.Name)
+				if err != nil {
+					return err
+				}
+				for _, upgrade := range details.UpgradesTo {
+					matches := extractMinorRegex.FindStringSubmatch(upgrade.From)
+					if matches == nil {
+						continue
+					}
+					minor, _ := strconv.Atoi(matches[1])
+					if byMinor[minor] == nil {
+						byMinor[minor] = &UpgradeStats{FromMinor: minor}
+					}
+					byMinor[minor].Success += upgrade.Success
+					byMinor[minor].Total += upgrade.Total
+				}
+			}
+			for _, stats := range byMinor {
+				stream.Upgrades = append(stream.Upgrades, *stats)
+			}
+			sort.Slice(stream.Upgrades, func(i, j int) bool { return stream.Upgrades[i].FromMinor > stream.Upgrades[j].FromMinor })
+			if minRate <= 0 {
+				return nil
+			}
+			for _, stats := range stream.Upgrades {
+				if stats.Total == 0 {
+					continue
+				}
+				if rate := float64(stats.Success) / float64(stats.Total); rate < minRate {
+					stream.Problems = append(stream.Problems, newProblem(ProblemLowUpgradeSuccessRate, fmt.Sprintf("Only %d of the %d upgrades from 4.%d in the last %s succeeded (%.0f%%), below the minimum upgrade success rate of %.0f%%", stats.Success, stats.Total, stats.FromMinor, formatAge(window), rate*100, minRate*100)))
+				}
+			}
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error counting upgrades: %v", err)
+	}
+	return nil
+}
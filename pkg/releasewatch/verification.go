@@ -0,0 +1,46 @@
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+)
+
+// checkStuckVerification flags the streams with payloads that have been
+// waiting to be verified, in the Ready phase, for longer than the limit,
+// which usually means their verification jobs are stuck or the release
+// controller is wedged.  Each stream takes a request.
+func checkStuckVerification(ctx context.Context, client *apiClient, releaseAPIUrl string, r *Report, limits Limits) error {
+	fetches := []func() error{}
+	for i := range r.Streams {
+		stream := &r.Streams[i]
+		fetches = append(fetches, func() error {
+			payloads, err := client.getStreamPayloads(ctx, releaseAPIUrl, stream.Name)
+			if err != nil {
+				return err
+			}
+			stuck := []string{}
+			oldest := Payload{}
+			for _, payload := range payloads {
+				if payload.Phase != "Ready" || payload.Created.IsZero() || r.GeneratedAt.Sub(payload.Created) <= limits.VerificationStaleness {
+					continue
+				}
+				stuck = append(stuck, payload.Name)
+				// the payloads are newest first
+				oldest = payload
+			}
+			if len(stuck) == 0 {
+				return nil
+			}
+			message := fmt.Sprintf("%s has been waiting to be verified for %s", oldest.Name, formatAge(r.GeneratedAt.Sub(oldest.Created)))
+			if len(stuck) > 1 {
+				message = fmt.Sprintf("%d payloads have been waiting to be verified for more than %s, the oldest, %s, for %s", len(stuck), formatAge(limits.VerificationStaleness), oldest.Name, formatAge(r.GeneratedAt.Sub(oldest.Created)))
+			}
+			stream.Problems = append(stream.Problems, newProblem(ProblemStuckVerification, message))
+			return nil
+		})
+	}
+	if err := client.parallel(ctx, fetches...); err != nil {
+		return fmt.Errorf("error checking for stuck verification: %v", err)
+	}
+	return nil
+}
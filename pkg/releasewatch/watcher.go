@@ -0,0 +1,532 @@
+// Package releasewatch analyzes the release streams of an OpenShift release
+// controller and reports the streams whose payloads are not being built,
+// accepted or upgraded to regularly.
+//
+// A Watcher is created once and used to generate any number of reports:
+//
+//	watcher, err := releasewatch.New(releasewatch.Options{
+//		ReleaseAPIURL: releasewatch.DefaultReleaseAPIURL,
+//		Limits:        releasewatch.DefaultLimits,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	report, err := watcher.GenerateReport(ctx)
+package releasewatch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	DefaultReleaseAPIURL = "https://amd64.ocp.releases.ci.openshift.org"
+	DefaultSippyURL      = "https://sippy.dptools.openshift.org"
+	DefaultGitHubAPIURL  = "https://api.github.com"
+	// DefaultJobArtifactsURL is the storage of the artifacts of the Prow
+	// job runs of the OpenShift CI.
+	DefaultJobArtifactsURL = "https://storage.googleapis.com"
+	// DefaultConcurrency is how many requests are made to the release
+	// controller at once if Options.Concurrency isn't set.
+	DefaultConcurrency = 4
+
+	acceptedReleasePath = "/api/v1/releasestreams/accepted"
+	allReleasePath      = "/api/v1/releasestreams/all"
+	// releaseControllerURL is the format of the url of the release
+	// controller of an architecture.
+	releaseControllerURL = "https://%s.ocp.releases.ci.openshift.org"
+)
+
+var (
+	// match these two formats, with the architecture of the streams of the
+	// other architectures, e.g. 4.NNN.0-0.nightly-arm64, but not the names
+	// of their payloads:
+	// 4.NNN.0-0.ci
+	// 4.NNN.0-0.nightly
+	zReleaseRegex     = regexp.MustCompile(`^4\.([1-9][0-9]*)\.0-0\.(ci|nightly)(?:-[a-z0-9]+)?$`)
+	extractMinorRegex = regexp.MustCompile(`4\.([1-9][0-9]*)\.[0-9]+`)
+	// YYYY-MM-DD-HHMMSS
+	extractDateRegex = regexp.MustCompile(`([0-9]{4})-([0-9]{2})-([0-9]{2})-([0-9]{2})([0-9]{2})([0-9]{2})$`)
+)
+
+// IsStreamName returns true for the names of the z-stream release streams,
+// e.g. 4.16.0-0.nightly.
+func IsStreamName(name string) bool {
+	return zReleaseRegex.MatchString(name)
+}
+
+// Limits are what the release streams are judged by.
+type Limits struct {
+	// OldestMinor and NewestMinor are the range of minor versions to
+	// analyze, e.g. 9 for 4.9.
+	OldestMinor int
+	NewestMinor int
+	// AcceptedStaleness, BuiltStaleness and UpgradeStaleness are how old
+	// the newest accepted payload, built payload and successful upgrade of a
+	// stream can be before it is considered stale.
+	AcceptedStaleness time.Duration
+	BuiltStaleness    time.Duration
+	UpgradeStaleness  time.Duration
+	// MinAcceptanceRate is the fraction of the payloads built within the
+	// acceptance window, between 0 and 1, that a stream must accept.  Zero
+	// means any rate is fine.  It needs Options.AcceptanceWindow.
+	MinAcceptanceRate float64
+	// BuildCadenceFactor flags the streams that have gone this many times
+	// their typical interval between builds without a build.  Zero means
+	// the build cadence isn't checked.
+	BuildCadenceFactor float64
+	// VerificationStaleness is how long a payload can wait to be verified
+	// before it is considered stuck.  Zero means it isn't checked.
+	VerificationStaleness time.Duration
+	// UpgradePaths are upgrades between minors, such as EUS upgrades, that
+	// must have succeeded within UpgradeStaleness.
+	UpgradePaths []UpgradePath
+	// MinUpgradeSuccessRate is the fraction of the upgrades from each minor
+	// to the payloads built within the upgrade window, between 0 and 1, that
+	// must succeed.  Zero means any rate is fine.  It needs
+	// Options.UpgradeWindow.
+	MinUpgradeSuccessRate float64
+	// ArchSkew is how much older the newest accepted payload of a stream can
+	// be than that of the same stream in another architecture.  Zero means
+	// it isn't checked.
+	ArchSkew time.Duration
+	// PublishStaleness is how long a stable payload can go from being
+	// accepted to being published to its candidate channel before the
+	// nightly stream of its minor is flagged.  Zero means it isn't checked.
+	// It needs Options.CincinnatiURL.
+	PublishStaleness time.Duration
+	// PromotionLimits are how long a stable payload can go from being
+	// accepted to being promoted to other Cincinnati channels, by the name of
+	// the channels without the version, e.g. "fast" for fast-4.16.  They
+	// need Options.CincinnatiURL.
+	PromotionLimits map[string]time.Duration
+	// RejectionStreak is how many of a stream's newest payloads in a row can
+	// be rejected before it is flagged, even if its newest accepted payload
+	// isn't stale yet.  Zero means it isn't checked.  It needs
+	// Options.TagDetails.
+	RejectionStreak int
+	// DevPreviewStaleness is how long ago the newest engineering candidate of
+	// a minor in development can have been accepted into 4-dev-preview
+	// before the nightly stream of the minor is flagged.  Zero means it isn't
+	// checked.
+	DevPreviewStaleness time.Duration
+	// Streams, if set, are the only streams to analyze, and ExcludeStreams
+	// are streams not to analyze, e.g. an experiment that never accepts
+	// payloads.  Either way the streams must be within the minor range.
+	Streams        []StreamPattern
+	ExcludeStreams []StreamPattern
+	// StreamTypes, if set, are the only types of stream to analyze, from
+	// StreamTypes, e.g. "nightly".
+	StreamTypes []string
+	// Ignore leaves streams out of the reports until the ignores expire.
+	Ignore []Ignore
+	// StreamLimits override the staleness limits of some streams.  The first
+	// that matches a stream applies to it.
+	StreamLimits []StreamLimits
+	// KnownIssues are the open bugs to note next to the failed blocking jobs
+	// and risks they explain.
+	KnownIssues []KnownIssue
+}
+
+// DefaultLimits are the limits used by the release-watcher command unless
+// told otherwise.
+var DefaultLimits = Limits{
+	OldestMinor:       9,
+	NewestMinor:       12,
+	AcceptedStaleness: 24 * time.Hour,
+	BuiltStaleness:    72 * time.Hour,
+	UpgradeStaleness:  72 * time.Hour,
+}
+
+// Options configures a Watcher.  Only ReleaseAPIURL and Limits are required.
+type Options struct {
+	// ReleaseAPIURL is the url of the release controller.
+	ReleaseAPIURL string
+	Limits        Limits
+	// ReleaseAPIURLs are the urls of more release controllers, such as those
+	// of the other architectures or OKD's, whose streams are merged into the
+	// reports as MergeReports does.  Each of them takes the requests
+	// ReleaseAPIURL does, but without the Token.
+	ReleaseAPIURLs []string
+
+	// Timeout bounds each request to the release controller.  Zero means no
+	// timeout.
+	Timeout time.Duration
+	// Proxy returns the proxy for each request.  It defaults to the proxy
+	// set by the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	Proxy func(*http.Request) (*url.URL, error)
+	// CAFile is a PEM file of CA certificates to trust in addition to the
+	// system ones.
+	CAFile                string
+	InsecureSkipTLSVerify bool
+	// Token, or the contents of TokenFile, is sent as a bearer token to the
+	// host of ReleaseAPIURL.  The file is re-read for every request, so the
+	// token can be rotated.
+	Token     string
+	TokenFile string
+	// CacheDir is where the most recent responses are saved, to generate
+	// reports from when the release controller can't be reached.
+	CacheDir string
+	// Concurrency is how many requests are made at once.
+	Concurrency int
+	// RequestRate is how many requests a second are made to the release
+	// controller at most, across all of the reports, so the per-stream
+	// queries of a large report are spread out.  Zero means no limit.  The
+	// release controller asking to slow down with Retry-After holds off all
+	// of the requests to it regardless.
+	RequestRate float64
+	// RecordDir is where to save every response, to replay them later with
+	// ReplayDir.  ReplayDir is a previous RecordDir to generate reports from
+	// instead of making requests.  Staleness is judged as of when the
+	// responses were recorded.
+	RecordDir string
+	ReplayDir string
+	// RecentPayloads is how many of the newest payloads of each stream with
+	// problems to include in reports, with their phase.  Each stream takes an
+	// extra request.
+	RecentPayloads int
+	// SippyURL is the url of a Sippy instance to look up the recent pass
+	// rate of failing blocking jobs in, e.g. DefaultSippyURL.  Leave it empty
+	// to not look them up.
+	SippyURL string
+	// TopRisks is how many of the failed tests that Sippy's risk analysis of
+	// the failed blocking job runs of rejected payloads judged the most
+	// likely regressions to list with each problem.  It needs SippyURL.
+	// Each failed job run takes a request.
+	TopRisks int
+	// ComponentReadiness lists the components that Sippy's Component
+	// Readiness flags as regressed with the problems of the minors in
+	// development that aren't accepting payloads.  It needs SippyURL.
+	ComponentReadiness bool
+	// JobArtifactsURL is the url of the storage of the artifacts of Prow job
+	// runs, e.g. DefaultJobArtifactsURL, to read the ci-operator results of
+	// the failed blocking jobs of rejected payloads from, to classify why
+	// they failed.  Leave it empty to not classify them.  Each failed job
+	// run takes a request.
+	JobArtifactsURL string
+	// ListPullRequests includes the pull requests that went into the
+	// unaccepted payloads of stale streams in the changelogs of reports.
+	// GitHubAPIURL is the GitHub api to look up their authors in, e.g.
+	// DefaultGitHubAPIURL.  Leave it empty to not look them up.  Each pull
+	// request takes a request the first time, up to maxPullAuthorLookups a
+	// report.  GitHubToken is sent to it, as GitHub only allows a few
+	// requests an hour without one.
+	ListPullRequests bool
+	GitHubAPIURL     string
+	GitHubToken      string
+	// MergeCheckRepos are GitHub repos, e.g. openshift/origin, whose release
+	// branch of each stream that hasn't built recently is checked for changes
+	// merged since its newest payload was built.  The stale built payload
+	// problems of the streams that had some are escalated to critical, since
+	// the build system is probably broken.  It needs GitHubAPIURL.  Each stream
+	// takes a request per repo.
+	MergeCheckRepos []string
+	// AcceptanceWindow is how far back to measure the latency from a
+	// payload being built to it being accepted, per stream.  Zero means it
+	// isn't measured.  Each accepted payload takes a request.
+	AcceptanceWindow time.Duration
+	// UpgradeWindow is how far back to count the upgrade job runs to the
+	// payloads of each stream, by the minor they upgraded from.  Zero means
+	// they aren't counted.  Each payload takes a request.
+	UpgradeWindow time.Duration
+	// PhaseWindow is how far back to count the payloads of each stream with
+	// problems by their phase.  Zero means they aren't counted.  Each stream
+	// takes a request.
+	PhaseWindow time.Duration
+	// PhaseCountsForAllStreams counts the payloads of every stream by their
+	// phase, rather than only those of the streams with problems, e.g. to
+	// export them as metrics.
+	PhaseCountsForAllStreams bool
+	// TagDetails looks up the details of the newest payloads of each stream,
+	// rather than judging it by the ages of its newest payloads alone: how
+	// many were rejected in a row, how far the verification of the pending
+	// ones got, and when the newest accepted one finished verifying.  Each
+	// stream takes a request, plus one per pending payload and one for the
+	// newest accepted payload.
+	TagDetails bool
+	// CincinnatiURL is the update service to look up the published releases
+	// in, e.g. DefaultCincinnatiURL.  Each nightly stream takes a request
+	// per channel checked by Limits.PublishStaleness and
+	// Limits.PromotionLimits, plus one per accepted stable payload that isn't
+	// in the channel.
+	CincinnatiURL string
+	// ReleasePaths lists how far the releases of each nightly stream's minor
+	// have made it, from 4-dev-preview and 4-stable to the candidate channel
+	// of CincinnatiURL.
+	ReleasePaths bool
+	// IncidentFeedURL is a JSON list of declared incidents, such as TRT's,
+	// that the problems they overlap are annotated with.  Leave it empty to
+	// not correlate them.
+	IncidentFeedURL string
+}
+
+// Watcher generates reports about the release streams.  It is safe for
+// concurrent use.
+type Watcher struct {
+	releaseAPIURLs   []string
+	limits           Limits
+	recentPayloads   int
+	sippyURL         string
+	topRisks         int
+	readiness        bool
+	jobArtifactsURL  string
+	listPulls        bool
+	acceptanceWindow time.Duration
+	upgradeWindow    time.Duration
+	phaseWindow      time.Duration
+	phaseAllStreams  bool
+	tagDetails       bool
+	gitHubAPIURL     string
+	mergeCheckRepos  []string
+	cincinnatiURL    string
+	releasePaths     bool
+	incidentFeedURL  string
+	client           *apiClient
+	// now is the time reports are generated at.
+	now func() time.Time
+
+	// streamURLs are the urls of the release controllers the streams of the
+	// last report were on, to tell which one to ask about a stream.
+	streamURLsLock sync.Mutex
+	streamURLs     map[string]string
+}
+
+// New returns a Watcher configured by the options.
+func New(opts Options) (*Watcher, error) {
+	if opts.Limits.MinAcceptanceRate > 0 && opts.AcceptanceWindow <= 0 {
+		return nil, fmt.Errorf("a minimum acceptance rate needs an acceptance window")
+	}
+	if opts.Limits.MinUpgradeSuccessRate > 0 && opts.UpgradeWindow <= 0 {
+		return nil, fmt.Errorf("a minimum upgrade success rate needs an upgrade window")
+	}
+	if opts.Limits.RejectionStreak > 0 && !opts.TagDetails {
+		return nil, fmt.Errorf("a rejection streak limit needs the tag details")
+	}
+	client, recordedAt, err := newAPIClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	releaseAPIURLs := []string{opts.ReleaseAPIURL}
+	for _, releaseAPIURL := range opts.ReleaseAPIURLs {
+		for _, known := range releaseAPIURLs {
+			if releaseAPIURL == known {
+				return nil, fmt.Errorf("release controller %s is given more than once", releaseAPIURL)
+			}
+		}
+		releaseAPIURLs = append(releaseAPIURLs, releaseAPIURL)
+	}
+	w := &Watcher{
+		releaseAPIURLs:   releaseAPIURLs,
+		limits:           opts.Limits,
+		recentPayloads:   opts.RecentPayloads,
+		sippyURL:         opts.SippyURL,
+		topRisks:         opts.TopRisks,
+		readiness:        opts.ComponentReadiness,
+		jobArtifactsURL:  opts.JobArtifactsURL,
+		listPulls:        opts.ListPullRequests,
+		acceptanceWindow: opts.AcceptanceWindow,
+		upgradeWindow:    opts.UpgradeWindow,
+		phaseWindow:      opts.PhaseWindow,
+		phaseAllStreams:  opts.PhaseCountsForAllStreams,
+		tagDetails:       opts.TagDetails,
+		gitHubAPIURL:     opts.GitHubAPIURL,
+		mergeCheckRepos:  opts.MergeCheckRepos,
+		cincinnatiURL:    opts.CincinnatiURL,
+		releasePaths:     opts.ReleasePaths,
+		incidentFeedURL:  opts.IncidentFeedURL,
+		client:           client,
+		now:              time.Now,
+	}
+	if !recordedAt.IsZero() {
+		w.now = func() time.Time { return recordedAt }
+	}
+	return w, nil
+}
+
+// GenerateReport fetches the release streams and analyzes them.  What can't be
+// fetched, be it a release controller or what an analysis looks up, is left
+// out and listed in the report's Unavailable, so an outage of one doesn't
+// leave no report at all.  It fails if none of the release controllers can be
+// reached.
+func (w *Watcher) GenerateReport(ctx context.Context) (*Report, error) {
+	return w.GenerateReportWithLimits(ctx, w.limits)
+}
+
+// GenerateReportWithLimits is like GenerateReport but judges the streams by
+// the given limits instead of the ones the Watcher was created with, for
+// callers whose limits change over time.
+func (w *Watcher) GenerateReportWithLimits(ctx context.Context, limits Limits) (*Report, error) {
+	now := w.now()
+	reports := []*Report{}
+	unavailable := []Unavailable{}
+	var firstErr error
+	for _, releaseAPIURL := range w.releaseAPIURLs {
+		r, err := w.generateReleaseReport(ctx, releaseAPIURL, limits, now)
+		if err != nil {
+			// the report covers the other release controllers
+			klog.Warningf("generating the report without %s: %v", releaseAPIURL, err)
+			unavailable = append(unavailable, Unavailable{What: releaseAPIURL, Error: err.Error()})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		reports = append(reports, r)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, firstErr
+	}
+	r := reports[0]
+	if len(reports) > 1 {
+		r = MergeReports(reports...)
+	}
+	r.Unavailable = append(r.Unavailable, unavailable...)
+	w.recordStreamURLs(r)
+	if limits.ArchSkew > 0 {
+		r.CheckArchSkew(limits.ArchSkew)
+	}
+	if w.sippyURL != "" {
+		addPassRates(ctx, w.client, w.sippyURL, r)
+		if w.topRisks > 0 {
+			addRisks(ctx, w.client, w.sippyURL, r, w.topRisks)
+		}
+	}
+	if w.jobArtifactsURL != "" {
+		addRejectionCauses(ctx, w.client, w.jobArtifactsURL, r)
+	}
+	if len(w.mergeCheckRepos) > 0 && w.gitHubAPIURL != "" {
+		checkMergeActivity(ctx, w.client, w.gitHubAPIURL, w.mergeCheckRepos, r)
+	}
+	if w.incidentFeedURL != "" {
+		annotateIncidents(ctx, w.client, w.incidentFeedURL, r)
+	}
+	if len(limits.KnownIssues) > 0 {
+		annotateKnownIssues(r, limits.KnownIssues)
+	}
+	if !w.listPulls {
+		removePulls(r)
+	} else if w.gitHubAPIURL != "" {
+		addPullAuthors(ctx, w.client, w.gitHubAPIURL, r)
+	}
+	addHealthScores(r, limits)
+	correlateMultiStreams(r)
+	return r, nil
+}
+
+// generateReleaseReport generates the report of the streams of one release
+// controller, with what is looked up in it.
+func (w *Watcher) generateReleaseReport(ctx context.Context, releaseAPIURL string, limits Limits, now time.Time) (*Report, error) {
+	r, err := generateReport(ctx, w.client, releaseAPIURL, limits, now)
+	if err != nil {
+		return nil, err
+	}
+	if w.recentPayloads > 0 {
+		if err := addRecentPayloads(ctx, w.client, releaseAPIURL, r, w.recentPayloads); err != nil {
+			r.addUnavailable("the recent payloads of "+releaseAPIURL, err)
+		}
+	}
+	if w.phaseWindow > 0 {
+		if err := addPhaseCounts(ctx, w.client, releaseAPIURL, r, w.phaseWindow, w.phaseAllStreams); err != nil {
+			r.addUnavailable("the payload phase counts of "+releaseAPIURL, err)
+		}
+	}
+	if w.acceptanceWindow > 0 {
+		if err := addAcceptanceStats(ctx, w.client, releaseAPIURL, r, w.acceptanceWindow); err != nil {
+			r.addUnavailable("the acceptance latency of "+releaseAPIURL, err)
+		}
+		checkAcceptanceRates(r, limits.MinAcceptanceRate)
+	}
+	if w.upgradeWindow > 0 {
+		if err := addUpgradeStats(ctx, w.client, releaseAPIURL, r, w.upgradeWindow, limits.MinUpgradeSuccessRate); err != nil {
+			r.addUnavailable("the upgrade counts of "+releaseAPIURL, err)
+		}
+	}
+	if w.tagDetails {
+		if err := addTagDetails(ctx, w.client, releaseAPIURL, r, limits.RejectionStreak); err != nil {
+			r.addUnavailable("the payload details of "+releaseAPIURL, err)
+		}
+	}
+	if limits.BuildCadenceFactor > 0 {
+		if err := checkBuildCadence(ctx, w.client, releaseAPIURL, r, limits.BuildCadenceFactor); err != nil {
+			r.addUnavailable("the build cadence of "+releaseAPIURL, err)
+		}
+	}
+	if limits.VerificationStaleness > 0 {
+		if err := checkStuckVerification(ctx, w.client, releaseAPIURL, r, limits); err != nil {
+			r.addUnavailable("the verification times of "+releaseAPIURL, err)
+		}
+	}
+	if (limits.PublishStaleness > 0 || len(limits.PromotionLimits) > 0) && w.cincinnatiURL != "" {
+		if err := checkUnpublishedPayloads(ctx, w.client, releaseAPIURL, w.cincinnatiURL, r, limits.PublishStaleness, limits.PromotionLimits); err != nil {
+			r.addUnavailable("the published payloads of "+releaseAPIURL, err)
+		}
+	}
+	if limits.DevPreviewStaleness > 0 {
+		if err := checkStaleDevPreview(ctx, w.client, releaseAPIURL, r, limits.DevPreviewStaleness); err != nil {
+			r.addUnavailable("the engineering candidates of "+releaseAPIURL, err)
+		}
+	}
+	if w.releasePaths {
+		if err := addReleasePaths(ctx, w.client, releaseAPIURL, w.cincinnatiURL, r); err != nil {
+			r.addUnavailable("the release paths of "+releaseAPIURL, err)
+		}
+	}
+	if w.sippyURL != "" && w.readiness {
+		addComponentRegressions(ctx, w.client, releaseAPIURL, w.sippyURL, r)
+	}
+	return r, nil
+}
+
+// recordStreamURLs records the release controllers the streams of the report
+// are on.
+func (w *Watcher) recordStreamURLs(r *Report) {
+	streamURLs := map[string]string{}
+	for _, stream := range r.Streams {
+		streamURLs[stream.Name] = stream.ReleaseURL
+	}
+	w.streamURLsLock.Lock()
+	defer w.streamURLsLock.Unlock()
+	w.streamURLs = streamURLs
+}
+
+// releaseAPIURLFor returns the url of the release controller the stream is
+// on: the one it was on in the last report, or else the one of its
+// architecture, or else the first one.
+func (w *Watcher) releaseAPIURLFor(stream string) string {
+	if len(w.releaseAPIURLs) == 1 {
+		return w.releaseAPIURLs[0]
+	}
+	w.streamURLsLock.Lock()
+	releaseAPIURL, ok := w.streamURLs[stream]
+	w.streamURLsLock.Unlock()
+	if ok {
+		return releaseAPIURL
+	}
+	if archURL, err := ReleaseAPIURLForArch(streamArch(stream)); err == nil {
+		for _, releaseAPIURL := range w.releaseAPIURLs {
+			if releaseAPIURL == archURL {
+				return releaseAPIURL
+			}
+		}
+	}
+	return w.releaseAPIURLs[0]
+}
+
+// releaseAPIURLForPayload returns the url of the release controller the
+// stream of the payload is on.
+func (w *Watcher) releaseAPIURLForPayload(payload string) string {
+	if m := payloadNameRegex.FindStringSubmatch(payload); m != nil {
+		return w.releaseAPIURLFor(m[1])
+	}
+	return w.releaseAPIURLs[0]
+}
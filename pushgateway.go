@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+var pushgatewayClient = &http.Client{Timeout: 30 * time.Second}
+
+// The names of the metrics of the report, which the rules of
+// "generate alert-rules" are built on.
+const (
+	acceptedAgeMetric   = "release_watcher_stream_accepted_age_seconds"
+	sinceAcceptedMetric = "release_watcher_stream_seconds_since_accepted"
+	builtAgeMetric      = "release_watcher_stream_built_age_seconds"
+	problemsMetric      = "release_watcher_stream_problems"
+	acceptanceMetric    = "release_watcher_stream_acceptance_ratio"
+	acceptance7dMetric  = "release_watcher_stream_acceptance_ratio_7d"
+	rejectedMetric      = "release_watcher_stream_rejected_payloads"
+	pendingMetric       = "release_watcher_stream_pending_payloads"
+	severityMetric      = "release_watcher_stream_severity"
+	healthMetric        = "release_watcher_stream_health_score"
+	lastReportMetric    = "release_watcher_last_report_timestamp_seconds"
+)
+
+// metricsPhaseWindow is the --phase-window of the reports the metrics are
+// made of unless it is set, so the rejected and pending payloads and the 7
+// day acceptance ratio are always there.
+const metricsPhaseWindow = 7 * 24 * time.Hour
+
+// labelEscaper escapes label values in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// severityValues are the values of the severity metric, so dashboards can
+// color a stream by its most urgent problem.  A stream without problems is 0.
+var severityValues = map[releasewatch.Severity]int{
+	releasewatch.SeverityInfo:     1,
+	releasewatch.SeverityWarning:  2,
+	releasewatch.SeverityCritical: 3,
+}
+
+// reportMetrics renders the report as gauges in the Prometheus text format:
+// the age of each stream's newest accepted and built payloads and how long ago
+// the accepted one was accepted, how many problems of each severity it has and
+// how urgent the most urgent one is, its health score, the fraction of its
+// payloads that were accepted, if --acceptance-window measured it, how many of
+// the payloads it built within the --phase-window were rejected and are still
+// being verified, and the fraction of them that were accepted if the window
+// is 7 days, if they were counted, and when the report was generated.
+func reportMetrics(report *releasewatch.Report) string {
+	var accepted, sinceAccepted, built, problems, severity, health, acceptance, acceptance7d, rejected, pending strings.Builder
+	for _, stream := range report.Streams {
+		labels := fmt.Sprintf(`stream="%s",minor="4.%d",arch="%s"`, labelEscaper.Replace(stream.Name), stream.Minor, labelEscaper.Replace(stream.Arch))
+		if ts := stream.LatestAcceptedTime(); !ts.IsZero() {
+			fmt.Fprintf(&accepted, "%s{%s} %g\n", acceptedAgeMetric, labels, report.GeneratedAt.Sub(ts).Seconds())
+		}
+		if stream.TagDetails != nil && !stream.TagDetails.LatestAcceptedAt.IsZero() {
+			fmt.Fprintf(&sinceAccepted, "%s{%s} %g\n", sinceAcceptedMetric, labels, report.GeneratedAt.Sub(stream.TagDetails.LatestAcceptedAt).Seconds())
+		}
+		if ts := stream.LatestBuiltTime(); !ts.IsZero() {
+			fmt.Fprintf(&built, "%s{%s} %g\n", builtAgeMetric, labels, report.GeneratedAt.Sub(ts).Seconds())
+		}
+		if stream.Acceptance != nil && stream.Acceptance.Finished > 0 {
+			fmt.Fprintf(&acceptance, "%s{%s} %g\n", acceptanceMetric, labels, float64(stream.Acceptance.Accepted)/float64(stream.Acceptance.Finished))
+		}
+		if stream.PhaseCounts != nil {
+			fmt.Fprintf(&rejected, "%s{%s} %d\n", rejectedMetric, labels, stream.PhaseCounts.Counts["Rejected"])
+			fmt.Fprintf(&pending, "%s{%s} %d\n", pendingMetric, labels, stream.PhaseCounts.Counts["Ready"])
+			counts := stream.PhaseCounts.Counts
+			if finished := counts["Accepted"] + counts["Rejected"] + counts["Failed"]; finished > 0 && stream.PhaseCounts.Window == metricsPhaseWindow {
+				fmt.Fprintf(&acceptance7d, "%s{%s} %g\n", acceptance7dMetric, labels, float64(counts["Accepted"])/float64(finished))
+			}
+		}
+		counts := severityCounts(stream)
+		for _, s := range severities {
+			fmt.Fprintf(&problems, "%s{%s,severity=\"%s\"} %d\n", problemsMetric, labels, s, counts[s])
+		}
+		fmt.Fprintf(&severity, "%s{%s} %d\n", severityMetric, labels, severityValues[stream.Severity()])
+		fmt.Fprintf(&health, "%s{%s} %d\n", healthMetric, labels, stream.Health)
+	}
+	return "# HELP " + acceptedAgeMetric + " How long ago the newest accepted payload of the stream was built.\n" +
+		"# TYPE " + acceptedAgeMetric + " gauge\n" + accepted.String() +
+		"# HELP " + sinceAcceptedMetric + " How long ago the newest accepted payload of the stream was accepted.\n" +
+		"# TYPE " + sinceAcceptedMetric + " gauge\n" + sinceAccepted.String() +
+		"# HELP " + builtAgeMetric + " How long ago the newest payload of the stream was built.\n" +
+		"# TYPE " + builtAgeMetric + " gauge\n" + built.String() +
+		"# HELP " + problemsMetric + " How many problems of the severity the stream has.\n" +
+		"# TYPE " + problemsMetric + " gauge\n" + problems.String() +
+		"# HELP " + severityMetric + " How urgent the most urgent problem of the stream is: 0 none, 1 info, 2 warning, 3 critical.\n" +
+		"# TYPE " + severityMetric + " gauge\n" + severity.String() +
+		"# HELP " + healthMetric + " The health score of the stream, from 0 to 100.\n" +
+		"# TYPE " + healthMetric + " gauge\n" + health.String() +
+		"# HELP " + acceptanceMetric + " The fraction of the payloads of the stream built within the acceptance window that were accepted.\n" +
+		"# TYPE " + acceptanceMetric + " gauge\n" + acceptance.String() +
+		"# HELP " + acceptance7dMetric + " The fraction of the payloads of the stream built within the last 7 days and verified that were accepted.\n" +
+		"# TYPE " + acceptance7dMetric + " gauge\n" + acceptance7d.String() +
+		"# HELP " + rejectedMetric + " How many of the payloads of the stream built within the phase window were rejected.\n" +
+		"# TYPE " + rejectedMetric + " gauge\n" + rejected.String() +
+		"# HELP " + pendingMetric + " How many of the payloads of the stream built within the phase window are still being verified.\n" +
+		"# TYPE " + pendingMetric + " gauge\n" + pending.String() +
+		"# HELP " + lastReportMetric + " When the report was generated, to alert on runs that stopped.\n" +
+		"# TYPE " + lastReportMetric + " gauge\n" +
+		fmt.Sprintf("%s %d\n", lastReportMetric, report.GeneratedAt.Unix())
+}
+
+// createMetricsHandler serves the metrics of the cached report for Prometheus
+// to scrape, or 503 until there is one.
+func createMetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reportCacheMutex.Lock()
+		report := cachedReport
+		reportCacheMutex.Unlock()
+		if report == nil {
+			http.Error(w, "no report yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, reportMetrics(report))
+	}
+}
+
+// pushMetrics replaces the metrics of the --pushgateway-job in the
+// --pushgateway-url with those of the report, so streams that are no longer
+// reported on drop out.
+func (o *options) pushMetrics(report *releasewatch.Report) error {
+	pushURL := strings.TrimSuffix(o.pushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(o.pushgatewayJob)
+	req, err := http.NewRequest(http.MethodPut, pushURL, strings.NewReader(reportMetrics(report)))
+	if err != nil {
+		return fmt.Errorf("error pushing metrics to %s: %v", pushURL, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := pushgatewayClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error pushing metrics to %s: %v", pushURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error pushing metrics to %s: %s: %s", pushURL, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
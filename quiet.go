@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// quietTimeFormat is how the bot shows the start and end of quiet periods.
+const quietTimeFormat = "2006-01-02 15:04 MST"
+
+// maintenanceChanged wakes the report loop when a maintenance window is
+// changed, so reports held by one that was ended early are delivered then.
+var maintenanceChanged = make(chan struct{}, 1)
+
+// MaintenanceWindow holds the scheduled reports, alerts and notifications
+// between Start and End.  A zero Start means the window starts immediately.
+type MaintenanceWindow struct {
+	Start     time.Time `json:"start,omitempty"`
+	End       time.Time `json:"end"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+}
+
+func validateMaintenanceWindows(windows []MaintenanceWindow) error {
+	for i, window := range windows {
+		if window.End.IsZero() || (!window.Start.IsZero() && !window.End.After(window.Start)) {
+			return fmt.Errorf("maintenance window %d must have an end after its start", i)
+		}
+	}
+	return nil
+}
+
+func (w MaintenanceWindow) covers(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// heldFindings are the problems found while reports were held, to post as a
+// catch-up digest when the quiet period ends.
+type heldFindings struct {
+	Since    time.Time     `json:"since"`
+	Reason   string        `json:"reason"`
+	Problems []heldProblem `json:"problems,omitempty"`
+}
+
+type heldProblem struct {
+	Stream    string                   `json:"stream"`
+	URL       string                   `json:"url"`
+	Kind      releasewatch.ProblemKind `json:"kind"`
+	Severity  releasewatch.Severity    `json:"severity"`
+	Message   string                   `json:"message"`
+	FirstSeen time.Time                `json:"firstSeen"`
+}
+
+// quietHours is a daily range of minutes past midnight.  The end is before
+// the start for ranges that span midnight, such as 22:00-07:00.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours parses a range such as 22:00-07:00.
+func parseQuietHours(value string) (*quietHours, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%q must be a range of times of day such as 22:00-07:00", value)
+	}
+	minutes := []int{}
+	for _, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("%q must be a range of times of day such as 22:00-07:00", value)
+		}
+		minutes = append(minutes, t.Hour()*60+t.Minute())
+	}
+	if minutes[0] == minutes[1] {
+		return nil, fmt.Errorf("%q must not start and end at the same time", value)
+	}
+	return &quietHours{start: minutes[0], end: minutes[1]}, nil
+}
+
+func (q *quietHours) covers(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+// endAfter returns when the quiet hours covering t end.
+func (q *quietHours) endAfter(t time.Time) time.Time {
+	end := time.Date(t.Year(), t.Month(), t.Day(), q.end/60, q.end%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// quietLocation returns the time zone of the quiet hours.  The flag is
+// checked by validate.
+func (o *options) quietLocation() *time.Location {
+	location, err := time.LoadLocation(o.quietHoursTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// quietPeriod returns why reports are held at the given time, if they are,
+// and when the quiet hours and maintenance windows covering it end.
+func (o *options) quietPeriod(now time.Time) (string, time.Time, bool) {
+	var hours *quietHours
+	if o.quietHours != "" {
+		hours, _ = parseQuietHours(o.quietHours)
+	}
+	stateMutex.Lock()
+	windows := append([]MaintenanceWindow{}, state.MaintenanceWindows...)
+	stateMutex.Unlock()
+
+	reason := ""
+	until := now.In(o.quietLocation())
+	// the quiet hours and windows can overlap, so keep extending the end
+	// until nothing covers it
+	for extended := true; extended; {
+		extended = false
+		if hours != nil && hours.covers(until) {
+			if reason == "" {
+				reason = fmt.Sprintf("quiet hours (%s)", o.quietHours)
+			}
+			until = hours.endAfter(until)
+			extended = true
+		}
+		for _, window := range windows {
+			if window.covers(until) {
+				if reason == "" {
+					reason = fmt.Sprintf("maintenance window (%s)", window.Reason)
+				}
+				until = window.End.In(until.Location())
+				extended = true
+			}
+		}
+	}
+	return reason, until, reason != ""
+}
+
+// holdFindings records the unacknowledged problems of a report that wasn't
+// posted because of the quiet period.
+func (o *options) holdFindings(report *releasewatch.Report, reason string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if state.Held == nil {
+		state.Held = &heldFindings{Since: report.GeneratedAt, Reason: reason}
+	}
+	held := map[string]int{}
+	for i, p := range state.Held.Problems {
+		held[problemKey(p.Stream, p.Kind)] = i
+	}
+	for _, stream := range report.Streams {
+		for _, p := range stream.Problems {
+			if i, ok := held[problemKey(stream.Name, p.Kind)]; ok {
+				state.Held.Problems[i].Severity = p.Severity
+				state.Held.Problems[i].Message = p.Message
+				continue
+			}
+			state.Held.Problems = append(state.Held.Problems, heldProblem{
+				Stream:    stream.Name,
+				URL:       stream.URL(),
+				Kind:      p.Kind,
+				Severity:  p.Severity,
+				Message:   p.Message,
+				FirstSeen: report.GeneratedAt,
+			})
+		}
+	}
+	o.saveState()
+}
+
+// isHoldingFindings returns true if findings are waiting to be delivered.
+func isHoldingFindings() bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return state.Held != nil
+}
+
+// postCatchUpDigests posts the problems found during the quiet period that
+// just ended to each channel the report is routed to, noting which of them
+// have since been resolved.
+func (o *options) postCatchUpDigests(report *releasewatch.Report) {
+	stateMutex.Lock()
+	held := state.Held
+	state.Held = nil
+	o.saveState()
+	stateMutex.Unlock()
+	if held == nil {
+		return
+	}
+	for channel, channelReport := range o.routeReport(report) {
+		if text := o.catchUpDigest(held, channelReport); text != "" {
+			if _, err := postMessage(PostMessage{Channel: channel, Text: text}); err != nil {
+				klog.Errorf("error posting catch-up digest: %v", err)
+			}
+		}
+	}
+}
+
+// catchUpDigest describes the held problems of the streams in the report, or
+// returns "" if there are none.
+func (o *options) catchUpDigest(held *heldFindings, report *releasewatch.Report) string {
+	streams := map[string]releasewatch.StreamReport{}
+	for _, stream := range report.Streams {
+		streams[stream.Name] = stream
+	}
+	lines := []string{}
+	for _, p := range held.Problems {
+		stream, ok := streams[p.Stream]
+		if !ok {
+			continue
+		}
+		status := "resolved"
+		if stream.HasProblem(p.Kind) {
+			status = "still open"
+		}
+		for _, ack := range stream.Acknowledged {
+			if ack.Kind == p.Kind {
+				status = "acknowledged"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("• <%s|%s> [%s] %s (found %s, %s)", p.URL, p.Stream, p.Severity, p.Message, p.FirstSeen.In(o.quietLocation()).Format(quietTimeFormat), status))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("*Catch-up after the %s*, held since %s:\n%s", held.Reason, held.Since.In(o.quietLocation()).Format(quietTimeFormat), strings.Join(lines, "\n"))
+}
+
+// handleMaintenanceCommand starts a maintenance window, e.g. "maintenance 2h
+// mirror outage", or ends the current ones with "maintenance end".
+func (o *options) handleMaintenanceCommand(user string, args []string) string {
+	if !o.isAdmin(user) {
+		return "Sorry, you are not allowed to start maintenance windows"
+	}
+	if len(args) == 0 {
+		return "Usage: maintenance <duration> [reason], e.g. maintenance 2h mirror outage, or maintenance end"
+	}
+	now := time.Now()
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	active := []MaintenanceWindow{}
+	for _, window := range state.MaintenanceWindows {
+		if now.Before(window.End) {
+			active = append(active, window)
+		}
+	}
+	if args[0] == "end" {
+		for i := range active {
+			if active[i].covers(now) {
+				active[i].End = now
+			}
+		}
+		state.MaintenanceWindows = active
+		o.saveState()
+		notifyMaintenanceChanged()
+		return "Ended the maintenance windows.  Any held reports will be posted shortly."
+	}
+	duration, err := time.ParseDuration(args[0])
+	if err != nil || duration <= 0 {
+		return fmt.Sprintf("Sorry, %q is not a duration such as 2h", args[0])
+	}
+	window := MaintenanceWindow{Start: now, End: now.Add(duration), Reason: strings.Join(args[1:], " "), CreatedBy: user}
+	if window.Reason == "" {
+		window.Reason = "started by <@" + user + ">"
+	}
+	state.MaintenanceWindows = append(active, window)
+	o.saveState()
+	klog.V(2).Infof("user %s started a maintenance window until %s: %s", user, window.End.Format(time.RFC3339), window.Reason)
+	return fmt.Sprintf("Holding reports, alerts and notifications until %s.  A catch-up digest will be posted when the window ends.", window.End.In(o.quietLocation()).Format(quietTimeFormat))
+}
+
+func notifyMaintenanceChanged() {
+	select {
+	case maintenanceChanged <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// releaseWatchGroup and releaseWatchVersion are the api group and version
+	// of the ReleaseWatch custom resources, as resources/releasewatch-crd.yaml
+	// defines them.
+	releaseWatchGroup   = "releasewatcher.openshift.io"
+	releaseWatchVersion = "v1alpha1"
+	// releaseWatchSyncInterval is how often the ReleaseWatches are read.
+	releaseWatchSyncInterval = time.Minute
+)
+
+// watchTenants are the tenants of the ReleaseWatch custom resources.  Guarded
+// by stateMutex.
+var watchTenants = []Tenant{}
+
+// ReleaseWatch is a ReleaseWatch custom resource, with which a team watches
+// the release streams it cares about from its own namespace, as a tenant of
+// the bot.
+type ReleaseWatch struct {
+	Metadata ObjectMeta       `json:"metadata"`
+	Spec     releaseWatchSpec `json:"spec"`
+}
+
+// releaseWatchSpec is the spec of a ReleaseWatch.  It has the settings of a
+// tenant of the config file.
+type releaseWatchSpec struct {
+	Channel                string            `json:"channel"`
+	ReleaseAPIURL          string            `json:"releaseAPIURL,omitempty"`
+	Arch                   string            `json:"arch,omitempty"`
+	OldestMinor            int               `json:"oldestMinor,omitempty"`
+	NewestMinor            int               `json:"newestMinor,omitempty"`
+	Streams                []string          `json:"streams,omitempty"`
+	ExcludeStreams         []string          `json:"excludeStreams,omitempty"`
+	StreamTypes            []string          `json:"streamTypes,omitempty"`
+	AcceptedStalenessLimit string            `json:"acceptedStalenessLimit,omitempty"`
+	BuiltStalenessLimit    string            `json:"builtStalenessLimit,omitempty"`
+	UpgradeStalenessLimit  string            `json:"upgradeStalenessLimit,omitempty"`
+	SlackAlias             string            `json:"slackAlias,omitempty"`
+	SlackAliasMap          map[string]string `json:"slackAliasMap,omitempty"`
+}
+
+type releaseWatchList struct {
+	Items []ReleaseWatch `json:"items"`
+}
+
+// tenantRule returns the spec as the tenant of a config file.
+func (s releaseWatchSpec) tenantRule() tenantRule {
+	return tenantRule{
+		Channel:                s.Channel,
+		ReleaseAPIURL:          s.ReleaseAPIURL,
+		Arch:                   s.Arch,
+		OldestMinor:            s.OldestMinor,
+		NewestMinor:            s.NewestMinor,
+		Streams:                s.Streams,
+		ExcludeStreams:         s.ExcludeStreams,
+		StreamTypes:            s.StreamTypes,
+		AcceptedStalenessLimit: s.AcceptedStalenessLimit,
+		BuiltStalenessLimit:    s.BuiltStalenessLimit,
+		UpgradeStalenessLimit:  s.UpgradeStalenessLimit,
+		SlackAlias:             s.SlackAlias,
+		SlackAliasMap:          s.SlackAliasMap,
+	}
+}
+
+// releaseWatchTenants returns the tenants of the ReleaseWatches.  An invalid
+// ReleaseWatch, or one for a channel that already has a tenant, is skipped
+// with an error logged, so a team can't break the others' watches.
+func releaseWatchTenants(watches []ReleaseWatch, configured []Tenant) []Tenant {
+	channels := map[string]bool{}
+	for _, tenant := range configured {
+		channels[tenant.Channel] = true
+	}
+	tenants := []Tenant{}
+	for _, watch := range watches {
+		name := watch.Metadata.Namespace + "/" + watch.Metadata.Name
+		parsed, err := parseTenants([]tenantRule{watch.Spec.tenantRule()})
+		if err != nil {
+			klog.Errorf("ignoring ReleaseWatch %s: %v", name, err)
+			continue
+		}
+		if channels[watch.Spec.Channel] {
+			klog.Errorf("ignoring ReleaseWatch %s: channel %s already has a tenant", name, watch.Spec.Channel)
+			continue
+		}
+		channels[watch.Spec.Channel] = true
+		tenants = append(tenants, parsed...)
+	}
+	return tenants
+}
+
+// runReleaseWatchLoop reads the ReleaseWatches of the --release-watch-namespace,
+// or of all namespaces, every releaseWatchSyncInterval and makes them the
+// tenants of the bot along with those of the config file.  If they can't be
+// read, the tenants read last are kept.
+func (o *options) runReleaseWatchLoop(ctx context.Context) {
+	client, err := newInClusterClient()
+	if err != nil {
+		klog.Fatalf("error setting up the ReleaseWatches: %v", err)
+	}
+	path := fmt.Sprintf("/apis/%s/%s/releasewatches", releaseWatchGroup, releaseWatchVersion)
+	if o.releaseWatchNamespace != "" {
+		path = fmt.Sprintf("/apis/%s/%s/namespaces/%s/releasewatches", releaseWatchGroup, releaseWatchVersion, o.releaseWatchNamespace)
+	}
+	ticker := time.NewTicker(releaseWatchSyncInterval)
+	defer ticker.Stop()
+	for {
+		list := releaseWatchList{}
+		if _, err := client.do("GET", path, nil, &list); err != nil {
+			klog.Errorf("error reading the ReleaseWatches: %v", err)
+		} else {
+			tenants := releaseWatchTenants(list.Items, currentConfigTenants())
+			stateMutex.Lock()
+			changed := !reflect.DeepEqual(tenants, watchTenants)
+			watchTenants = tenants
+			stateMutex.Unlock()
+			if changed {
+				channels := []string{}
+				for _, tenant := range tenants {
+					channels = append(channels, tenant.Channel)
+				}
+				klog.Infof("the ReleaseWatches report to %d channels: %s", len(channels), strings.Join(channels, ", "))
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+var (
+	// reportCacheMutex guards cachedReport, reportCachedAt and
+	// reportCacheGeneration.
+	reportCacheMutex sync.Mutex
+	// cachedReport is the bot's report as of the last refresh, at
+	// reportCachedAt, nil until the first one or after the settings changed.
+	cachedReport   *releasewatch.Report
+	reportCachedAt time.Time
+	// reportCacheGeneration counts the invalidations, so a refresh that
+	// started before the settings changed doesn't cache its report.
+	reportCacheGeneration int
+	// reportCacheInvalidated wakes the refresh loop to refill the cache
+	// right away after it was invalidated.
+	reportCacheInvalidated = make(chan struct{}, 1)
+)
+
+// runReportCacheLoop refreshes the cached report every --report-cache-interval,
+// so commands are answered from it instead of crawling the release
+// controllers.
+func (o *options) runReportCacheLoop(ctx context.Context) {
+	ticker := time.NewTicker(o.reportCacheInterval)
+	defer ticker.Stop()
+	for {
+		o.refreshReportCache(ctx)
+		select {
+		case <-ticker.C:
+		case <-reportCacheInvalidated:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshReportCache generates the report and caches it.  If it fails the
+// previous report is kept until it expires.  The refreshes aren't saved in
+// the --history-dir, so --only-changes compares with the previous report that
+// was posted.
+func (o *options) refreshReportCache(ctx context.Context) {
+	reportCacheMutex.Lock()
+	generation := reportCacheGeneration
+	reportCacheMutex.Unlock()
+	report, err := o.generateCurrentReport(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		klog.Errorf("error refreshing the cached report: %v", err)
+		return
+	}
+	reportCacheMutex.Lock()
+	defer reportCacheMutex.Unlock()
+	if generation == reportCacheGeneration {
+		cachedReport, reportCachedAt = report, time.Now()
+	}
+}
+
+// invalidateReportCache drops the cached report, e.g. when the limits it was
+// judged by changed, and refreshes it.
+func invalidateReportCache() {
+	reportCacheMutex.Lock()
+	cachedReport = nil
+	reportCacheGeneration++
+	reportCacheMutex.Unlock()
+	select {
+	case reportCacheInvalidated <- struct{}{}:
+	default:
+		// a refresh is already on its way
+	}
+}
+
+// cachedOrCurrentReport returns a copy of the cached report, or generates the
+// report, without recording it, if there is no cached one or it missed two
+// refreshes in a row.  The
+// copy's streams can be changed, e.g. by applyAcks, without changing the
+// cached report.
+func (o *options) cachedOrCurrentReport(ctx context.Context) (*releasewatch.Report, error) {
+	if o.reportCacheInterval > 0 {
+		reportCacheMutex.Lock()
+		report, cachedAt := cachedReport, reportCachedAt
+		reportCacheMutex.Unlock()
+		if report != nil && time.Since(cachedAt) < 2*o.reportCacheInterval {
+			copied := *report
+			copied.Streams = append([]releasewatch.StreamReport{}, report.Streams...)
+			return &copied, nil
+		}
+	}
+	return o.generateCurrentReport(ctx)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+const (
+	// defaultHistoryReports is how many of the reports saved in
+	// --history-dir the history endpoint and command return by default, and
+	// maxHistoryReports the most they return.
+	defaultHistoryReports = 5
+	maxHistoryReports     = 48
+)
+
+// HistoryResponse is the body of the history endpoint.
+type HistoryResponse struct {
+	Reports []*releasewatch.Report `json:"reports"`
+}
+
+// parseHistoryCount parses how many reports to return, defaultHistoryReports
+// if it isn't given.
+func parseHistoryCount(value string) (int, error) {
+	if value == "" {
+		return defaultHistoryReports, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxHistoryReports {
+		return 0, fmt.Errorf("%q is not a number of reports between 1 and %d", value, maxHistoryReports)
+	}
+	return n, nil
+}
+
+// recentReports returns the last n reports saved in --history-dir, oldest
+// first, shown in the --display-timezone.
+func (o *options) recentReports(n int) ([]*releasewatch.Report, error) {
+	if o.history == nil {
+		return nil, fmt.Errorf("reports are only kept with --history-dir")
+	}
+	reports, err := o.history.Last(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		report.Location = o.displayLocation()
+	}
+	return reports, nil
+}
+
+// createHistoryHandler serves GET /history, the last ?n= generated reports,
+// oldest first, so someone joining an incident can see how it evolved.  They
+// are returned as JSON, or with ?format=text as the text reports, each
+// headed by when it was generated.
+func (o *options) createHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, AdminError{Error: "method not allowed"})
+			return
+		}
+		n, err := parseHistoryCount(r.URL.Query().Get("n"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+			return
+		}
+		reports, err := o.recentReports(n)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, AdminError{Error: err.Error()})
+			return
+		}
+		if r.URL.Query().Get("format") != "text" {
+			writeJSON(w, http.StatusOK, HistoryResponse{Reports: reports})
+			return
+		}
+		w.Header().Set("Content-type", "text/plain; charset=utf-8")
+		for _, report := range reports {
+			fmt.Fprintf(w, "=== Generated %s ===\n%s\n\n", report.FormatTime(report.GeneratedAt), report.String())
+		}
+	}
+}
+
+// handleHistoryCommand answers "history [n]" with a summary of each of the
+// last n generated reports, oldest first.
+func (o *options) handleHistoryCommand(args []string) string {
+	if len(args) > 1 {
+		return "Usage: history [number of reports], e.g. history 10"
+	}
+	value := ""
+	if len(args) == 1 {
+		value = args[0]
+	}
+	n, err := parseHistoryCount(value)
+	if err != nil {
+		return fmt.Sprintf("Sorry, %v", err)
+	}
+	reports, err := o.recentReports(n)
+	if err != nil {
+		return fmt.Sprintf("Sorry, an error occurred reading the report history: %v", err)
+	}
+	if len(reports) == 0 {
+		return "No reports have been saved yet"
+	}
+	lines := []string{}
+	for _, report := range reports {
+		lines = append(lines, fmt.Sprintf("*%s*: %s\n```%s```", report.FormatTime(report.GeneratedAt), report.Summary(), report.CompactSummary()))
+	}
+	return strings.Join(lines, "\n")
+}
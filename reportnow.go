@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// reportRequested wakes the report loop to post the scheduled report right
+// away, out of schedule.
+var reportRequested = make(chan struct{}, 1)
+
+// canReportNow returns true if the user may post the scheduled report right
+// away: the admins and the --report-now-users.
+func (o *options) canReportNow(user string) bool {
+	return o.isAllowed(user, o.reportNowUsers)
+}
+
+// isAllowed returns true if the user is an admin or one of the allowed users,
+// given either as users or as user groups the user is a member of.
+func (o *options) isAllowed(user string, allowed []string) bool {
+	if o.isAdmin(user) || containsString(allowed, user) {
+		return true
+	}
+	for _, id := range allowed {
+		if !strings.HasPrefix(id, "S") {
+			continue
+		}
+		members, err := userGroupMembers(id)
+		if err != nil {
+			klog.Errorf("error looking up the members of user group %s: %v", id, err)
+			continue
+		}
+		if containsString(members, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// userGroupMembers returns the users of a Slack user group, e.g. S0123.
+func userGroupMembers(group string) ([]string, error) {
+	resp, err := callSlack("usergroups.users.list", map[string]string{"usergroup": group})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// handleReportNowCommand posts the scheduled report to all of its channels
+// right away, e.g. after an infra fix lands.
+func (o *options) handleReportNowCommand(user string) string {
+	if !o.canReportNow(user) {
+		return "Sorry, you are not allowed to post the report out of schedule"
+	}
+	klog.V(2).Infof("user %s asked for the scheduled report to be posted now", user)
+	select {
+	case reportRequested <- struct{}{}:
+	default:
+		// a report is already on its way
+	}
+	return "Posting a fresh report to the report channels shortly"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+)
+
+// parseMinor parses a minor version given as either "4.16" or "16".
+func parseMinor(version string) (int, error) {
+	minor, err := strconv.Atoi(strings.TrimPrefix(version, "4."))
+	if err != nil {
+		return 0, fmt.Errorf("invalid minor version %q, expected a value like \"4.16\"", version)
+	}
+	return minor, nil
+}
+
+// parseChannelMap converts a mapping of minor versions to channels into a
+// mapping of channels to the set of minors routed to them.
+func parseChannelMap(channelMap map[string]string) (map[string]map[int]struct{}, error) {
+	channels := map[string]map[int]struct{}{}
+	for version, channel := range channelMap {
+		minor, err := parseMinor(version)
+		if err != nil {
+			return nil, err
+		}
+		if channels[channel] == nil {
+			channels[channel] = map[int]struct{}{}
+		}
+		channels[channel][minor] = struct{}{}
+	}
+	return channels, nil
+}
+
+// validateAliasMap checks that every key of the alias map is either a minor
+// version or a stream name.
+func validateAliasMap(aliasMap map[string]string) error {
+	for key := range aliasMap {
+		if isStreamName(key) {
+			continue
+		}
+		if _, err := parseMinor(key); err != nil {
+			return fmt.Errorf("%q is neither a minor version nor a release stream", key)
+		}
+	}
+	return nil
+}
+
+func isStreamName(name string) bool {
+	return releasewatch.IsStreamName(name)
+}
+
+// SeverityRoute sends the problems of one severity to the given channels,
+// tagging Mention, and optionally to PagerDuty or only to the log.
+type SeverityRoute struct {
+	Severity releasewatch.Severity `json:"severity"`
+	Channels []string              `json:"channels,omitempty"`
+	// Mention is who to tag in the channels.  Nobody is tagged if it is
+	// empty.
+	Mention string `json:"mention,omitempty"`
+	// PagerDuty triggers an incident for each problem, using the routing
+	// key in the PAGERDUTY_ROUTING_KEY environment variable.
+	PagerDuty bool `json:"pagerduty,omitempty"`
+	// Log logs the problems.
+	Log bool `json:"log,omitempty"`
+}
+
+func validateSeverityRoutes(routes []SeverityRoute) error {
+	for i, route := range routes {
+		if _, err := releasewatch.ParseSeverity(string(route.Severity)); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if len(route.Channels) == 0 && !route.PagerDuty && !route.Log {
+			return fmt.Errorf("route %d must have channels, pagerduty or log", i)
+		}
+		if route.PagerDuty && os.Getenv(pagerDutyRoutingKeyEnv) == "" {
+			return fmt.Errorf("route %d uses pagerduty, but %s is not set", i, pagerDutyRoutingKeyEnv)
+		}
+	}
+	return nil
+}
+
+// currentSeverityRoutes returns a copy of the severity routes.
+func currentSeverityRoutes() []SeverityRoute {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]SeverityRoute{}, severityRoutes...)
+}
+
+// channelMentions returns who to tag for the problems in the report posted to
+// the channel: the people of the channel's tenant, the mention of the severity
+// route the channel belongs to, or the owners of the streams.
+func (o *options) channelMentions(channel string, report *releasewatch.Report) string {
+	if tenant, ok := channelTenant(channel); ok && tenant.hasMentions() {
+		return ownerMentions(report, tenant.SlackAliasMap, tenant.SlackAlias)
+	}
+	for _, route := range currentSeverityRoutes() {
+		for _, c := range route.Channels {
+			if c == channel {
+				return route.Mention
+			}
+		}
+	}
+	return o.mentions(report)
+}
+
+// mentions returns who to tag for the unacknowledged problems in the report.
+// Owners are looked up by stream name, then by minor version, falling back to
+// the slack alias.
+func (o *options) mentions(report *releasewatch.Report) string {
+	o.settingsLock.RLock()
+	defer o.settingsLock.RUnlock()
+	return ownerMentions(report, o.slackAliasMap, o.slackAlias)
+}
+
+// ownerMentions returns who to tag for the problems in the report, given the
+// owners by stream name or minor version and who to tag for the rest.
+func ownerMentions(report *releasewatch.Report, aliasMap map[string]string, fallback string) string {
+	seen := map[string]struct{}{}
+	mentions := []string{}
+	for _, stream := range report.Streams {
+		if len(stream.Problems) == 0 {
+			continue
+		}
+		alias, ok := aliasMap[stream.Name]
+		if !ok {
+			alias, ok = aliasMap[fmt.Sprintf("4.%d", stream.Minor)]
+		}
+		if !ok {
+			alias = fallback
+		}
+		if _, dupe := seen[alias]; alias == "" || dupe {
+			continue
+		}
+		seen[alias] = struct{}{}
+		mentions = append(mentions, alias)
+	}
+	return strings.Join(mentions, " ")
+}
+
+// routeReport returns the report each channel should receive.  The report
+// channel receives the roll-up of every stream, and each channel in the
+// channel map receives only the streams of the minors routed to it.  A
+// channel routed to in several ways receives everything each of them routes
+// to it.  The channels of tenants are left out.
+func (o *options) routeReport(report *releasewatch.Report) map[string]*releasewatch.Report {
+	reports := map[string]*releasewatch.Report{}
+	add := func(channel string, routed *releasewatch.Report) {
+		if existing, ok := reports[channel]; ok {
+			routed = mergeRouted(report, existing, routed)
+		}
+		reports[channel] = routed
+	}
+	if o.reportChannel != "" {
+		add(o.reportChannel, report)
+	}
+	// the map is validated whenever it is set
+	o.settingsLock.RLock()
+	channels, _ := parseChannelMap(o.channelMap)
+	o.settingsLock.RUnlock()
+	for channel, minors := range channels {
+		add(channel, forMinors(report, minors))
+	}
+	for _, route := range currentSeverityRoutes() {
+		for _, channel := range route.Channels {
+			add(channel, forSeverity(report, route.Severity))
+		}
+	}
+	// the tenants' channels get reports of their own
+	for _, tenant := range currentTenants() {
+		delete(reports, tenant.Channel)
+	}
+	return reports
+}
+
+// mergeRouted returns a copy of the report with the streams and the problems
+// of either of two of its routed copies, in the order of the report.
+func mergeRouted(r, a, b *releasewatch.Report) *releasewatch.Report {
+	routed := map[string][]releasewatch.StreamReport{}
+	for _, stream := range append(append([]releasewatch.StreamReport{}, a.Streams...), b.Streams...) {
+		routed[stream.URL()] = append(routed[stream.URL()], stream)
+	}
+	merged := *r
+	merged.Streams = nil
+	for _, stream := range r.Streams {
+		copies, ok := routed[stream.URL()]
+		if !ok {
+			continue
+		}
+		problems := map[string]struct{}{}
+		for _, c := range copies {
+			for _, p := range c.Problems {
+				problems[string(p.Kind)+" "+p.Message] = struct{}{}
+			}
+			for _, p := range c.Acknowledged {
+				problems[string(p.Kind)+" "+p.Message] = struct{}{}
+			}
+		}
+		kept := stream
+		kept.Problems = []releasewatch.Problem{}
+		for _, p := range stream.Problems {
+			if _, ok := problems[string(p.Kind)+" "+p.Message]; ok {
+				kept.Problems = append(kept.Problems, p)
+			}
+		}
+		kept.Acknowledged = []releasewatch.AcknowledgedProblem{}
+		for _, p := range stream.Acknowledged {
+			if _, ok := problems[string(p.Kind)+" "+p.Message]; ok {
+				kept.Acknowledged = append(kept.Acknowledged, p)
+			}
+		}
+		merged.Streams = append(merged.Streams, kept)
+	}
+	return &merged
+}
+
+// forMinors returns a copy of the report that only includes the streams of
+// the given minors.
+func forMinors(r *releasewatch.Report, minors map[int]struct{}) *releasewatch.Report {
+	filtered := *r
+	filtered.Streams = nil
+	for _, stream := range r.Streams {
+		if _, ok := minors[stream.Minor]; ok {
+			filtered.Streams = append(filtered.Streams, stream)
+		}
+	}
+	return &filtered
+}
+
+// forSeverity returns a copy of the report that only includes the problems of
+// the given severity.
+func forSeverity(r *releasewatch.Report, severity releasewatch.Severity) *releasewatch.Report {
+	filtered := *r
+	filtered.Streams = nil
+	for _, stream := range r.Streams {
+		problems := []releasewatch.Problem{}
+		for _, p := range stream.Problems {
+			if p.Severity == severity {
+				problems = append(problems, p)
+			}
+		}
+		acknowledged := []releasewatch.AcknowledgedProblem{}
+		for _, p := range stream.Acknowledged {
+			if p.Severity == severity {
+				acknowledged = append(acknowledged, p)
+			}
+		}
+		stream.Problems, stream.Acknowledged = problems, acknowledged
+		filtered.Streams = append(filtered.Streams, stream)
+	}
+	return &filtered
+}
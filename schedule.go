@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// runReportLoop posts the report to the report channel, and to any channels
+// in the channel map, every report interval.  In update-in-place mode the
+// previously posted report is edited when the state of the streams changes,
+// and left alone otherwise.
+func (o *options) runReportLoop(ctx context.Context) {
+	// the most recently posted report, by channel
+	last := map[string]*postedReport{}
+	stateMutex.Lock()
+	for channel, posted := range state.Reports {
+		last[channel] = posted
+	}
+	lastReportTime := state.LastReportTime
+	stateMutex.Unlock()
+	if wait := time.Until(lastReportTime.Add(o.reportInterval)); wait > 0 {
+		klog.V(2).Infof("the report was last posted at %s, waiting %v to post it again", lastReportTime.Format(time.RFC3339), wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	ticker := time.NewTicker(o.reportInterval)
+	defer ticker.Stop()
+	for {
+		if !startWork() {
+			return
+		}
+		o.postScheduledReports(ctx, last)
+		finishWork()
+		if !o.waitForReport(ctx, ticker) {
+			return
+		}
+	}
+}
+
+// waitForReport waits for the next scheduled report, or for the end of the
+// quiet period if reports are being held, and returns false if the context
+// is cancelled first.
+func (o *options) waitForReport(ctx context.Context, ticker *time.Ticker) bool {
+	for {
+		var resume <-chan time.Time
+		if _, until, quiet := o.quietPeriod(time.Now()); quiet {
+			resume = time.After(time.Until(until))
+		}
+		select {
+		case <-ticker.C:
+			return true
+		case <-reportRequested:
+			return true
+		case <-resume:
+			return true
+		case <-maintenanceChanged:
+			// deliver the held reports right away if a window ended early
+			if _, _, quiet := o.quietPeriod(time.Now()); !quiet && isHoldingFindings() {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (o *options) postScheduledReports(ctx context.Context, last map[string]*postedReport) {
+	// the channel map and severity routes can be changed at runtime, so check
+	// for somewhere to post or publish to on every run
+	if len(o.routeReport(&releasewatch.Report{})) == 0 && len(currentSeverityRoutes()) == 0 && len(currentWorkspaces()) == 0 && len(currentTenants()) == 0 && len(currentEscalations()) == 0 && o.slackWebhookURL == "" && o.uploadURL == "" && o.archiveRepo == "" && o.gitHubIssuesRepo == "" && o.jiraURL == "" && o.alertmanagerURL == "" {
+		return
+	}
+	report, err := o.currentReport(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		klog.Errorf("error generating scheduled report: %v", err)
+		return
+	}
+	o.applyAcks(report)
+	if !o.isLeader() {
+		klog.V(4).Infof("not the leader, not posting the scheduled report")
+		return
+	}
+	if reason, until, quiet := o.quietPeriod(time.Now()); quiet {
+		klog.V(2).Infof("holding the scheduled report during the %s until %s", reason, until.Format(time.RFC3339))
+		o.holdFindings(report, reason)
+		o.uploadReport(report)
+		o.archiveReport(ctx, report)
+		return
+	}
+
+	o.postCatchUpDigests(report)
+	for channel, channelReport := range o.routeReport(report) {
+		last[channel] = o.postScheduledReport(channel, channelReport, last[channel])
+	}
+	o.postTenantReports(ctx, last)
+	if o.slackWebhookURL != "" {
+		if err := o.postWebhookReport(report); err != nil {
+			klog.Errorf("error posting scheduled report to the slack incoming webhook: %v", err)
+		}
+	}
+	o.postWorkspaceReports(report)
+	o.sendSeverityAlerts(report)
+	o.sendAlertmanagerAlerts(report)
+	o.escalateProblems(report)
+	o.fileGitHubIssues(report)
+	o.fileJiraTickets(report)
+	o.uploadReport(report)
+	o.archiveReport(ctx, report)
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state.Reports = map[string]*postedReport{}
+	for channel, posted := range last {
+		state.Reports[channel] = posted
+	}
+	state.LastReportTime = time.Now()
+	o.saveState()
+}
+
+func (o *options) postScheduledReport(channel string, report *releasewatch.Report, last *postedReport) *postedReport {
+	if o.updateInPlace && last != nil {
+		if last.Fingerprint == report.Fingerprint() {
+			klog.V(4).Infof("report state is unchanged, not updating the posted report")
+			return last
+		}
+		updated, err := o.updateReport(channel, last, report)
+		if err != nil {
+			klog.Errorf("error updating posted report: %v", err)
+		}
+		return updated
+	}
+
+	posted, err := o.postReport(channel, report)
+	if err != nil {
+		klog.Errorf("error posting scheduled report: %v", err)
+		if posted == nil {
+			return last
+		}
+	}
+	if o.pinReport {
+		o.repin(last, posted)
+	}
+	return posted
+}
+
+// repin pins the newly posted report and unpins the previous one, so only the
+// current report is pinned in the channel.
+func (o *options) repin(last, posted *postedReport) {
+	if err := pinMessage(posted.Channel, posted.SummaryTS); err != nil {
+		klog.Errorf("error pinning report: %v", err)
+		return
+	}
+	if last == nil {
+		return
+	}
+	if err := unpinMessage(last.Channel, last.SummaryTS); err != nil {
+		klog.Errorf("error unpinning previous report: %v", err)
+	}
+}
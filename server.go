@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -41,6 +41,25 @@ type Event struct {
 	User    string `json:"user"`
 	Channel string `json:"channel"`
 	TS      string `json:"ts"`
+	EventTS string `json:"event_ts"`
+
+	// reaction event fields
+	Reaction string    `json:"reaction"`
+	Item     EventItem `json:"item"`
+}
+
+type EventItem struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+type SlashCommand struct {
+	Command   string `json:"command"`
+	Text      string `json:"text"`
+	ChannelID string `json:"channel_id"`
+	UserID    string `json:"user_id"`
+	TriggerID string `json:"trigger_id"`
 }
 
 type VerificationResponse struct {
@@ -48,22 +67,77 @@ type VerificationResponse struct {
 }
 
 type PostMessage struct {
-	Token   string `json:"token"`
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
+	Token    string  `json:"token,omitempty"`
+	Channel  string  `json:"channel"`
+	Text     string  `json:"text"`
+	Blocks   []Block `json:"blocks,omitempty"`
+	ThreadTS string  `json:"thread_ts,omitempty"`
 }
 
-func (o *options) serve() {
+// serve runs the bot until ctx is cancelled.
+func (o *options) serve(ctx context.Context) {
 	rand.Seed(time.Now().UTC().UnixNano())
 	auth_token = os.Getenv("TOKEN")
-	http.HandleFunc("/", o.createHandler())  // set router
-	err := http.ListenAndServe(":8080", nil) // set listen port
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	go o.runReportLoop(ctx)
+	if o.configFile != "" {
+		go o.reloadConfigOnSignal()
+		go o.watchConfigFile()
+	}
+	http.HandleFunc("/", o.createHandler(ctx)) // set router
+	http.HandleFunc("/slack/interactive", o.createInteractionHandler())
+	http.HandleFunc("/slack/commands", o.createCommandHandler(ctx))
+	http.HandleFunc("/admin/config", o.createAdminConfigHandler(os.Getenv("ADMIN_TOKEN")))
+	http.HandleFunc("/readyz", o.createReadyHandler())
+	http.HandleFunc("/history", o.createHistoryHandler())
+	http.HandleFunc("/metrics", createMetricsHandler())
+	http.HandleFunc("/badge/", o.createBadgeHandler())
+	http.HandleFunc("/webhook", o.createWebhookHandler(ctx, os.Getenv("WEBHOOK_TOKEN")))
+	http.HandleFunc("/github/webhook", o.createGitHubWebhookHandler(os.Getenv("GITHUB_WEBHOOK_SECRET")))
+	if o.reportCacheInterval > 0 {
+		go o.runReportCacheLoop(ctx)
+	}
+	go o.runSubscriptionLoop(ctx)
+	go o.runDigestLoop(ctx)
+	if o.mergeBuildLimit > 0 {
+		go o.runMergeCheckLoop(ctx)
+	}
+	if o.releaseWatches {
+		go o.runReleaseWatchLoop(ctx)
+	}
+	startLeading := func() {
+		if o.socketMode {
+			go o.runSocketMode(ctx, os.Getenv(slackAppTokenEnv))
+		}
+	}
+	if o.leaderElection {
+		go o.runLeaderElection(startLeading)
+	} else {
+		startLeading()
+	}
+	server := &http.Server{Addr: ":8080"} // set listen port
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+	o.waitForShutdown(ctx, server)
+}
+
+// createReadyHandler reports the bot ready only while it is the leader, so a
+// readiness probe on it keeps Slack's requests away from standby replicas.
+func (o *options) createReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !o.isLeader() {
+			http.Error(w, "standby", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
 	}
 }
 
-func (o *options) createHandler() http.HandlerFunc {
+// the handlers run the work they start with the bot's context rather than the
+// request's, so it isn't cancelled when Slack stops waiting for a response.
+func (o *options) createHandler(ctx context.Context) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -89,62 +163,176 @@ func (o *options) createHandler() http.HandlerFunc {
 		}
 
 		if req.Type == "event_callback" {
-
-			mutex.Lock()
-			if _, found := msgCache[req.Event.TS]; found {
-				klog.V(4).Infof("ignoring dupe event: %#v\n", req.Event)
-				w.WriteHeader(http.StatusOK)
-				mutex.Unlock()
+			if err := o.handleEvent(ctx, req.Event); err != nil {
+				klog.Errorf("error handling event: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			msgCache[req.Event.TS] = struct{}{}
-			mutex.Unlock()
-			klog.V(4).Infof("saw message event: %#v\n", req.Event)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
 
-			msg := PostMessage{}
-			msg.Channel = req.Event.Channel
+// createCommandHandler handles slash commands.  Slack expects a response
+// within a few seconds which is less time than a report can take, so the
+// command is run in the background and its output posted to the channel.
+func (o *options) createCommandHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		command := SlashCommand{
+			Command:   r.FormValue("command"),
+			Text:      r.FormValue("text"),
+			ChannelID: r.FormValue("channel_id"),
+			UserID:    r.FormValue("user_id"),
+			TriggerID: r.FormValue("trigger_id"),
+		}
+		goWork(func() { o.handleSlashCommand(ctx, command) })
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-			switch {
-			case strings.Contains(req.Event.Text, "help"):
-				msg.Text = fmt.Sprintf(`help - help
-report - Generates human reports about which release streams do not have recently built or recently accepted payloads, based on the release info found at https://amd64.ocp.releases.ci.openshift.org/
-Current arguments:
-  Accepted payloads must be newer than %0.1f hours
-  Payloads must have been built within the last %0.1f hours
-  Ignoring releases older than 4.%d`, o.acceptedStalenessLimit.Hours(), o.builtStalenessLimit.Hours(), o.oldestMinor)
-			case strings.Contains(req.Event.Text, "report"):
-				msg.Text, err = generateReport(o.releaseAPIUrl, o.acceptedStalenessLimit, o.builtStalenessLimit, o.upgradeStalenessLimit, o.oldestMinor, o.newestMinor)
-				if err != nil {
-					msg.Text = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
-				}
-			default:
-				msg.Text = fmt.Sprintf("Sorry, I couldn't process that request: %s", req.Event.Text)
-			}
+// handleSlashCommand processes a slash command, whether it was delivered over
+// HTTP or Socket Mode.
+func (o *options) handleSlashCommand(ctx context.Context, command SlashCommand) {
+	klog.V(4).Infof("saw slash command: %#v\n", command)
+	var err error
+	if strings.TrimSpace(command.Text) == "config" {
+		err = o.openConfigModal(command)
+	} else {
+		err = o.handleCommand(ctx, command.ChannelID, command.UserID, command.Text)
+	}
+	if err != nil {
+		klog.Errorf("error handling slash command: %v", err)
+	}
+}
 
-			// never output our own name, so we don't trigger ourselves
-			//fmt.Printf("original response: %s\n", msg.Text)
-			msg.Text = strings.Replace(msg.Text, "@UE23Q9BFY", "OCP Payload Reporter", -1)
-			//fmt.Printf("replaced response: %s\n", msg.Text)
+// handleEvent processes a Slack event, whether it was delivered over HTTP or
+// Socket Mode.
+func (o *options) handleEvent(ctx context.Context, event Event) error {
+	// reaction events don't have a ts of their own
+	id := event.TS
+	if id == "" {
+		id = event.Type + "/" + event.EventTS
+	}
+	mutex.Lock()
+	if _, found := msgCache[id]; found {
+		klog.V(4).Infof("ignoring dupe event: %#v\n", event)
+		mutex.Unlock()
+		return nil
+	}
+	msgCache[id] = struct{}{}
+	mutex.Unlock()
 
-			msgJson, _ := json.Marshal(msg)
+	if event.Type == "reaction_added" {
+		klog.V(4).Infof("saw reaction event: %#v\n", event)
+		o.handleReaction(event)
+		return nil
+	}
+	klog.V(4).Infof("saw message event: %#v\n", event)
+	return o.handleCommand(ctx, event.Channel, event.User, event.Text)
+}
 
-			fmt.Printf("msg response json: %s\n", msgJson)
-			req, err := http.NewRequest("POST", "https://slack.com/api/chat.postMessage", bytes.NewBuffer(msgJson))
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth_token))
+// handleCommand runs the bot command in text and posts the response to the
+// channel.
+func (o *options) handleCommand(ctx context.Context, channel, user, text string) error {
+	msg := PostMessage{}
+	msg.Channel = channel
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Printf("error posting chat message: %v", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			}
-			//fmt.Printf("chat message response: %#v\n", resp)
-			resp.Body.Close()
+	words := []string{}
+	for _, word := range strings.Fields(text) {
+		// skip mentions of the bot
+		if !strings.HasPrefix(word, "<@") {
+			words = append(words, word)
+		}
+	}
+	command := ""
+	if len(words) > 0 {
+		command = words[0]
+	}
 
-			w.WriteHeader(http.StatusOK)
-			//respJson, _ := json.Marshal(resp)
-			//io.WriteString(w, string(respJson))
+	switch {
+	case command == "subscribe" || command == "unsubscribe" || command == "subscriptions":
+		msg.Text = o.handleSubscriptionCommand(user, command, words[1:])
+	case command == "digest":
+		msg.Text = o.handleDigestCommand(user, words[1:])
+	case command == "history":
+		msg.Text = o.handleHistoryCommand(words[1:])
+	case command == "maintenance":
+		msg.Text = o.handleMaintenanceCommand(user, words[1:])
+	case command == "payload":
+		if len(words) != 2 {
+			msg.Text = "Usage: payload <name>, e.g. payload 4.16.0-0.nightly-2024-06-01-123456"
+			break
 		}
+		status, err := o.watcher.PayloadStatus(ctx, words[1])
+		if err != nil {
+			msg.Text = fmt.Sprintf("Sorry, an error occurred looking up the payload: %v", err)
+			break
+		}
+		status.Location = o.displayLocation()
+		msg.Text = fmt.Sprintf("<%s|%s>\n```%s```", status.URL(), status.Name, status.String())
+	case command == "latest":
+		report, err := o.channelReport(ctx, channel)
+		if err != nil {
+			msg.Text = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
+			break
+		}
+		for _, arg := range words[1:] {
+			if minor, err := parseMinor(arg); err == nil {
+				report = report.ForMinor(minor)
+			} else {
+				report = report.ForArch(arg)
+			}
+		}
+		if len(report.Streams) == 0 {
+			msg.Text = "No monitored streams match " + strings.Join(words[1:], " ")
+			break
+		}
+		msg.Text = "```" + report.LatestAcceptedText() + "```"
+	case command == "report" && len(words) == 2 && words[1] == "now":
+		msg.Text = o.handleReportNowCommand(user)
+	case command == "stats":
+		report, err := o.channelReport(ctx, channel)
+		if err != nil {
+			msg.Text = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
+			break
+		}
+		msg.Text = "```" + report.StatsText() + "```"
+	case strings.Contains(text, "help"):
+		limits := o.channelLimits(channel)
+		msg.Text = fmt.Sprintf(`help - help
+report now - (admins and --report-now-users only) Post the scheduled report to all of its channels right away
+report - Generates human reports about which release streams do not have recently built or recently accepted payloads, based on the release info found at https://amd64.ocp.releases.ci.openshift.org/
+subscribe <minor> <ci|nightly> - Get a direct message whenever the state of the stream changes
+unsubscribe <minor> <ci|nightly> - Stop getting direct messages about the stream
+subscriptions - List the streams you are subscribed to
+digest <HH:MM> [timezone] - Get a daily direct message with the status of the streams you are subscribed to, e.g. digest 08:30 Europe/Berlin.  digest off stops it.
+latest [minor] [arch] - List the newest accepted payload of each stream and its age, e.g. latest 4.16 arm64
+payload <name> - Show the phase, verification job results and acceptance time of a payload, e.g. payload 4.16.0-0.nightly-2024-06-01-123456
+history [n] - Summarize the last n generated reports, 5 by default, to see how the streams got where they are
+stats - Show how many payloads each stream built since its newest accepted one that weren't accepted, and how old they are
+maintenance <duration> [reason] - (admins only) Hold the scheduled reports, alerts and notifications, e.g. maintenance 2h mirror outage, and post a catch-up digest afterwards.  maintenance end ends it early.
+config - (slash command only) Adjust the staleness limits and monitored minors
+Current arguments:
+  Accepted payloads must be newer than %0.1f hours
+  Payloads must have been built within the last %0.1f hours
+  Ignoring releases older than 4.%d`, limits.AcceptedStaleness.Hours(), limits.BuiltStaleness.Hours(), limits.OldestMinor)
+	case strings.Contains(text, "report"):
+		report, err := o.channelReport(ctx, channel)
+		if err != nil {
+			msg.Text = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
+			break
+		}
+		o.applyAcks(report)
+		if _, err := o.postReport(msg.Channel, report); err != nil {
+			return fmt.Errorf("error posting report: %v", err)
+		}
+		return nil
+	default:
+		msg.Text = fmt.Sprintf("Sorry, I couldn't process that request: %s", text)
+	}
+
+	if _, err := postMessage(msg); err != nil {
+		return fmt.Errorf("error posting chat message: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+var (
+	// work tracks the background work, such as posting a report or
+	// responding to a command, that shutdown waits for.  Once shuttingDown is
+	// set no new work is started.
+	workMutex    = &sync.Mutex{}
+	work         = &sync.WaitGroup{}
+	shuttingDown bool
+)
+
+// startWork registers a piece of work with shutdown, and returns false if the
+// bot is shutting down and the work should not be started.  finishWork must
+// be called when work that was started is done.
+func startWork() bool {
+	workMutex.Lock()
+	defer workMutex.Unlock()
+	if shuttingDown {
+		return false
+	}
+	work.Add(1)
+	return true
+}
+
+func finishWork() {
+	work.Done()
+}
+
+// goWork runs f in the background unless the bot is shutting down.
+func goWork(f func()) {
+	if !startWork() {
+		klog.V(2).Infof("shutting down, dropping background work")
+		return
+	}
+	go func() {
+		defer finishWork()
+		f()
+	}()
+}
+
+// waitForShutdown blocks until ctx is cancelled when the process is asked to
+// stop with SIGTERM or SIGINT, then stops the HTTP server, waits for
+// in-flight work to finish and saves the state.  Everything has to be done
+// within the shutdown timeout.  Report generation is cancelled along with
+// ctx, but messages that are being posted are allowed to finish.
+func (o *options) waitForShutdown(ctx context.Context, server *http.Server) {
+	<-ctx.Done()
+	klog.Infof("shutting down")
+	start := time.Now()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	// requests that are being handled may still start background work, so
+	// new work is only refused once they are done.
+	if err := server.Shutdown(drainCtx); err != nil {
+		klog.Errorf("error waiting for http requests to finish: %v", err)
+	}
+	workMutex.Lock()
+	shuttingDown = true
+	workMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-drainCtx.Done():
+		klog.Errorf("gave up waiting for in-flight work after %v", o.shutdownTimeout)
+	}
+
+	stateMutex.Lock()
+	o.saveState()
+	stateMutex.Unlock()
+	klog.Infof("shut down in %v", time.Since(start).Round(time.Millisecond))
+}
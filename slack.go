@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+const (
+	slackAPIUrl = "https://slack.com/api/"
+	// botUserID is the Slack user the bot posts as.
+	botUserID = "UE23Q9BFY"
+
+	// maxSlackAttempts is how many times a rate limited call is tried.
+	maxSlackAttempts    = 5
+	channelSendInterval = time.Second
+
+	// webhookMethod is what the incoming webhook posts are rate limited and
+	// logged as.
+	webhookMethod = "incoming-webhook"
+)
+
+var (
+	slackClient = &http.Client{}
+
+	slackRateMutex = &sync.Mutex{}
+	// methodBackoff is when each method may be called again after Slack
+	// rate limited it.
+	methodBackoff = map[string]time.Time{}
+	// channelNextSend is when the next message may be sent to each channel.
+	channelNextSend = map[string]time.Time{}
+
+	// slackDryRun logs the calls that would change something in Slack instead
+	// of making them.
+	slackDryRun = false
+	// readOnlySlackMethods are still called in a dry run.
+	readOnlySlackMethods = map[string]bool{"apps.connections.open": true, "usergroups.users.list": true}
+)
+
+// SlackResponse holds the fields we care about from Slack Web API responses.
+type SlackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+	// URL is returned by apps.connections.open
+	URL string `json:"url"`
+	// Users is returned by usergroups.users.list
+	Users []string `json:"users"`
+}
+
+// callSlack POSTs the payload as JSON to the given Slack Web API method using
+// the bot token.
+func callSlack(method string, payload interface{}) (*SlackResponse, error) {
+	return callSlackWithToken(auth_token, method, payload)
+}
+
+// callSlackWithToken calls the method, waiting and retrying when Slack rate
+// limits us.
+func callSlackWithToken(token, method string, payload interface{}) (*SlackResponse, error) {
+	body, _ := json.Marshal(payload)
+	klog.V(4).Infof("%s request json: %s\n", method, body)
+	if slackDryRun && !readOnlySlackMethods[method] {
+		return dryRunSlackCall(method, body), nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		waitForMethod(method)
+		slackResp, retryAfter, err := doSlackCall(token, method, body)
+		if retryAfter == 0 {
+			return slackResp, err
+		}
+		if attempt == maxSlackAttempts {
+			return nil, fmt.Errorf("slack method %s is still rate limited after %d attempts", method, attempt)
+		}
+		klog.V(2).Infof("slack rate limited %s, retrying in %v", method, retryAfter)
+		backOffMethod(method, retryAfter)
+	}
+}
+
+// doSlackCall makes a single call to the method.  If Slack rate limited the
+// call it returns how long to wait before retrying.
+func doSlackCall(token, method string, body []byte) (*SlackResponse, time.Duration, error) {
+	req, err := http.NewRequest("POST", slackAPIUrl+method, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := slackClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error calling slack method %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || seconds < 1 {
+			seconds = 1
+		}
+		return nil, time.Duration(seconds) * time.Second, nil
+	}
+
+	slackResp := &SlackResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(slackResp); err != nil {
+		return nil, 0, fmt.Errorf("error decoding slack %s response: %v", method, err)
+	}
+	if !slackResp.OK {
+		return slackResp, 0, fmt.Errorf("slack method %s failed: %s", method, slackResp.Error)
+	}
+	return slackResp, 0, nil
+}
+
+// dryRunSlackCall logs what the call would have sent and returns a successful
+// response to it.
+func dryRunSlackCall(method string, body []byte) *SlackResponse {
+	msg := struct {
+		Channel  string `json:"channel"`
+		User     string `json:"user"`
+		ThreadTS string `json:"thread_ts"`
+		TS       string `json:"ts"`
+		Text     string `json:"text"`
+	}{}
+	json.Unmarshal(body, &msg)
+	target := msg.Channel
+	if msg.User != "" {
+		target += " for user " + msg.User
+	}
+	if msg.ThreadTS != "" {
+		target += " in thread " + msg.ThreadTS
+	}
+	if msg.TS != "" {
+		target += " message " + msg.TS
+	}
+	klog.Infof("dry run, not calling %s on %s:\n%s\npayload: %s", method, target, msg.Text, body)
+	ts := msg.TS
+	if ts == "" {
+		// a made up timestamp, so later updates of the message can be logged too
+		now := time.Now()
+		ts = fmt.Sprintf("%d.%06d", now.Unix(), now.Nanosecond()/1000)
+	}
+	return &SlackResponse{OK: true, Channel: msg.Channel, TS: ts}
+}
+
+// WebhookMessage is the payload of a Slack incoming webhook.
+type WebhookMessage struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// postWebhook posts the message to a Slack incoming webhook, waiting and
+// retrying when Slack rate limits us.  A webhook posts to the one channel it
+// was created for, and its messages can't be threaded, updated or pinned.
+func postWebhook(webhookURL string, msg WebhookMessage) error {
+	body, _ := json.Marshal(msg)
+	klog.V(4).Infof("%s request json: %s\n", webhookMethod, body)
+	if slackDryRun {
+		dryRunSlackCall(webhookMethod, body)
+		return nil
+	}
+
+	waitForChannel(webhookURL)
+	for attempt := 1; ; attempt++ {
+		waitForMethod(webhookMethod)
+		retryAfter, err := doWebhookCall(webhookURL, body)
+		if retryAfter == 0 {
+			return err
+		}
+		if attempt == maxSlackAttempts {
+			return fmt.Errorf("slack incoming webhook is still rate limited after %d attempts", attempt)
+		}
+		klog.V(2).Infof("slack rate limited the incoming webhook, retrying in %v", retryAfter)
+		backOffMethod(webhookMethod, retryAfter)
+	}
+}
+
+// doWebhookCall makes a single post to the webhook.  If Slack rate limited
+// the post it returns how long to wait before retrying.  Webhooks answer with
+// plain text, "ok" or an error such as "invalid_blocks".
+func doWebhookCall(webhookURL string, body []byte) (time.Duration, error) {
+	resp, err := slackClient.Post(webhookURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return 0, fmt.Errorf("error calling the slack incoming webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || seconds < 1 {
+			seconds = 1
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("slack incoming webhook failed: %s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	return 0, nil
+}
+
+// waitForMethod waits out any back off Slack asked for on the method.
+func waitForMethod(method string) {
+	slackRateMutex.Lock()
+	until := methodBackoff[method]
+	slackRateMutex.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+func backOffMethod(method string, retryAfter time.Duration) {
+	slackRateMutex.Lock()
+	defer slackRateMutex.Unlock()
+	if until := time.Now().Add(retryAfter); until.After(methodBackoff[method]) {
+		methodBackoff[method] = until
+	}
+}
+
+// waitForChannel queues a message to the channel behind the messages already
+// waiting to be sent to it, and blocks until it is the message's turn.  Slack
+// allows about one message per second to each channel.
+func waitForChannel(channel string) {
+	slackRateMutex.Lock()
+	now := time.Now()
+	for c, next := range channelNextSend {
+		if next.Before(now) {
+			delete(channelNextSend, c)
+		}
+	}
+	next := now
+	if reserved, ok := channelNextSend[channel]; ok {
+		next = reserved
+	}
+	channelNextSend[channel] = next.Add(channelSendInterval)
+	slackRateMutex.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+func postMessage(msg PostMessage) (*SlackResponse, error) {
+	return postMessageWithToken(auth_token, msg)
+}
+
+// postMessageWithToken posts the message as the bot the token belongs to,
+// which may be in another workspace.
+func postMessageWithToken(token string, msg PostMessage) (*SlackResponse, error) {
+	// never output our own name, so we don't trigger ourselves
+	msg.Text = strings.Replace(msg.Text, "@"+botUserID, "OCP Payload Reporter", -1)
+	waitForChannel(msg.Channel)
+	return callSlackWithToken(token, "chat.postMessage", msg)
+}
+
+// EphemeralMessage is the payload for chat.postEphemeral.
+type EphemeralMessage struct {
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+	Text    string `json:"text"`
+}
+
+// postEphemeral posts a message only the user can see.
+func postEphemeral(channel, user, text string) error {
+	_, err := callSlack("chat.postEphemeral", EphemeralMessage{Channel: channel, User: user, Text: text})
+	return err
+}
+
+// UpdateMessage is the payload for chat.update.
+type UpdateMessage struct {
+	Channel string  `json:"channel"`
+	TS      string  `json:"ts"`
+	Text    string  `json:"text"`
+	Blocks  []Block `json:"blocks"`
+}
+
+func updateMessage(msg UpdateMessage) (*SlackResponse, error) {
+	waitForChannel(msg.Channel)
+	return callSlack("chat.update", msg)
+}
+
+// PinItem is the payload for pins.add and pins.remove.
+type PinItem struct {
+	Channel   string `json:"channel"`
+	Timestamp string `json:"timestamp"`
+}
+
+func pinMessage(channel, ts string) error {
+	_, err := callSlack("pins.add", PinItem{Channel: channel, Timestamp: ts})
+	return err
+}
+
+func unpinMessage(channel, ts string) error {
+	_, err := callSlack("pins.remove", PinItem{Channel: channel, Timestamp: ts})
+	return err
+}
+
+// postedReport records the Slack messages a report was posted as, so they can
+// be updated later.
+type postedReport struct {
+	Channel   string `json:"channel"`
+	SummaryTS string `json:"summaryTS"`
+	// DetailsTS is the thread reply holding the per-stream details, if any.
+	DetailsTS   string `json:"detailsTS,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// postReport posts the report to the channel.  When threadDetails is set, only
+// the summary, or the digest when digest is set, is posted to the channel and
+// the per-stream breakdown is posted as a reply in the summary's thread.
+func (o *options) postReport(channel string, report *releasewatch.Report) (*postedReport, error) {
+	return o.postReportWithToken(auth_token, channel, report, o.channelMentions(channel, report))
+}
+
+// postReportWithToken posts the report to the channel as the bot the token
+// belongs to, tagging the mentions.
+func (o *options) postReportWithToken(token, channel string, report *releasewatch.Report, mentions string) (*postedReport, error) {
+	summary := reportSummary(report, mentions)
+	if !o.threadDetails || !report.HasFindings() {
+		resp, err := postMessageWithToken(token, PostMessage{
+			Channel: channel,
+			// the text is used for notifications and by clients that can't render blocks
+			Text:   summary,
+			Blocks: o.fullReportBlocks(report, mentions),
+		})
+		if err != nil {
+			return nil, err
+		}
+		rememberReportMessage(resp.TS, report)
+		return &postedReport{Channel: resp.Channel, SummaryTS: resp.TS, Fingerprint: report.Fingerprint()}, nil
+	}
+
+	var blocks []Block
+	if o.digest {
+		blocks = digestBlocks(report, mentions)
+	}
+	resp, err := postMessageWithToken(token, PostMessage{
+		Channel: channel,
+		Text:    summary,
+		Blocks:  blocks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	posted := &postedReport{Channel: resp.Channel, SummaryTS: resp.TS, Fingerprint: report.Fingerprint()}
+	rememberReportMessage(posted.SummaryTS, report)
+	resp, err = postMessageWithToken(token, PostMessage{
+		Channel:  channel,
+		ThreadTS: posted.SummaryTS,
+		Text:     summary,
+		Blocks:   o.detailsBlocks(report, mentions),
+	})
+	if err != nil {
+		return posted, err
+	}
+	posted.DetailsTS = resp.TS
+	rememberReportMessage(posted.DetailsTS, report)
+	return posted, nil
+}
+
+// updateReport edits the messages of a previously posted report to the
+// channel in place so they reflect the new report.
+func (o *options) updateReport(channel string, posted *postedReport, report *releasewatch.Report) (*postedReport, error) {
+	mentions := o.channelMentions(channel, report)
+	summary := reportSummary(report, mentions)
+	updated := *posted
+	updated.Fingerprint = report.Fingerprint()
+	rememberReportMessage(posted.SummaryTS, report)
+	if posted.DetailsTS != "" {
+		rememberReportMessage(posted.DetailsTS, report)
+	}
+
+	if !o.threadDetails {
+		_, err := updateMessage(UpdateMessage{
+			Channel: posted.Channel,
+			TS:      posted.SummaryTS,
+			Text:    summary,
+			Blocks:  o.fullReportBlocks(report, mentions),
+		})
+		if err != nil {
+			return posted, err
+		}
+		return &updated, nil
+	}
+
+	// an empty block list would leave the previous blocks in place, so the
+	// summary is always rendered as a single section.
+	blocks := []Block{{Type: "section", Text: markdownText(summary)}}
+	if o.digest {
+		blocks = digestBlocks(report, mentions)
+	}
+	if _, err := updateMessage(UpdateMessage{
+		Channel: posted.Channel,
+		TS:      posted.SummaryTS,
+		Text:    summary,
+		Blocks:  blocks,
+	}); err != nil {
+		return posted, err
+	}
+	if posted.DetailsTS != "" {
+		_, err := updateMessage(UpdateMessage{
+			Channel: posted.Channel,
+			TS:      posted.DetailsTS,
+			Text:    summary,
+			Blocks:  o.detailsBlocks(report, mentions),
+		})
+		if err != nil {
+			return posted, err
+		}
+		return &updated, nil
+	}
+	if !report.HasFindings() {
+		return &updated, nil
+	}
+	resp, err := postMessage(PostMessage{
+		Channel:  posted.Channel,
+		ThreadTS: posted.SummaryTS,
+		Text:     summary,
+		Blocks:   o.detailsBlocks(report, mentions),
+	})
+	if err != nil {
+		return posted, err
+	}
+	updated.DetailsTS = resp.TS
+	rememberReportMessage(updated.DetailsTS, report)
+	return &updated, nil
+}
+
+// postWebhookReport posts the report to the --slack-webhook-url as a single
+// message, since the details can't be threaded under a summary.
+func (o *options) postWebhookReport(report *releasewatch.Report) error {
+	mentions := o.mentions(report)
+	return postWebhook(o.slackWebhookURL, WebhookMessage{
+		Text:   reportSummary(report, mentions),
+		Blocks: o.fullReportBlocks(report, mentions),
+	})
+}
+
+// postReportOutput posts what the report command printed to the
+// --slack-webhook-url: the summary and the stats as text, and the report or
+// its digest as blocks.
+func (o *options) postReportOutput(report *releasewatch.Report, output string) error {
+	switch {
+	case o.summary:
+		return postWebhook(o.slackWebhookURL, WebhookMessage{Text: output})
+	case o.stats:
+		return postWebhook(o.slackWebhookURL, WebhookMessage{Text: "```\n" + output + "```"})
+	}
+	return o.postWebhookReport(report)
+}
+
+// fullReportBlocks renders the report posted to the channel when its details
+// aren't posted in a thread: the digest when digest is set, or else the
+// per-stream report, or the --message-template instead of either.
+func (o *options) fullReportBlocks(report *releasewatch.Report, mentions string) []Block {
+	if blocks := o.templatedReportBlocks(report, mentions); blocks != nil {
+		return blocks
+	}
+	if o.digest {
+		return digestBlocks(report, mentions)
+	}
+	return reportBlocks(report, mentions)
+}
+
+// detailsBlocks renders the per-stream details posted in the thread of the
+// summary, which tags the mentions, or the --message-template instead.
+func (o *options) detailsBlocks(report *releasewatch.Report, mentions string) []Block {
+	if blocks := o.templatedReportBlocks(report, mentions); blocks != nil {
+		return blocks
+	}
+	return reportBlocks(report, "")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"k8s.io/klog"
+)
+
+// slackAppTokenEnv holds the app-level token to open the Socket Mode
+// connections with.
+const slackAppTokenEnv = "SLACK_APP_TOKEN"
+
+// socketModeReconnectDelay is how long to wait before reconnecting after the
+// Socket Mode connection fails.
+const socketModeReconnectDelay = 10 * time.Second
+
+var socketModeDialer = websocket.DefaultDialer
+
+// SocketModeEnvelope wraps every message Slack sends over a Socket Mode
+// connection.
+type SocketModeEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+type SocketModeAck struct {
+	EnvelopeID string      `json:"envelope_id"`
+	Payload    interface{} `json:"payload,omitempty"`
+}
+
+// runSocketMode receives events, interactions and slash commands over a Slack
+// Socket Mode websocket, so the bot does not need a public HTTP endpoint.  It
+// reconnects whenever Slack closes the connection.
+func (o *options) runSocketMode(ctx context.Context, appToken string) {
+	for ctx.Err() == nil {
+		if err := o.socketModeSession(ctx, appToken); err != nil {
+			klog.Errorf("socket mode connection failed: %v", err)
+			select {
+			case <-time.After(socketModeReconnectDelay):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// socketModeSession handles a single Socket Mode connection until Slack asks
+// us to disconnect, the connection fails or ctx is cancelled.
+func (o *options) socketModeSession(ctx context.Context, appToken string) error {
+	resp, err := callSlackWithToken(appToken, "apps.connections.open", struct{}{})
+	if err != nil {
+		return err
+	}
+	conn, _, err := socketModeDialer.Dial(resp.URL, nil)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", resp.URL, err)
+	}
+	defer conn.Close()
+	// closing the connection unblocks the read below
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		envelope := SocketModeEnvelope{}
+		if err := conn.ReadJSON(&envelope); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error reading from socket mode connection: %v", err)
+		}
+		klog.V(4).Infof("saw socket mode envelope: %s %s\n", envelope.Type, envelope.EnvelopeID)
+
+		// Slack redelivers envelopes that aren't acknowledged promptly, so
+		// acknowledge before doing any work.  Interactions are the exception
+		// because their response is sent with the acknowledgement, but they
+		// are handled quickly.
+		if envelope.Type == "interactive" {
+			payload := InteractionPayload{}
+			if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+				klog.Errorf("error decoding interaction: %v", err)
+			}
+			ack := SocketModeAck{EnvelopeID: envelope.EnvelopeID, Payload: o.handleInteraction(payload)}
+			if err := conn.WriteJSON(ack); err != nil {
+				return fmt.Errorf("error acknowledging envelope %s: %v", envelope.EnvelopeID, err)
+			}
+			continue
+		}
+		if envelope.EnvelopeID != "" {
+			if err := conn.WriteJSON(SocketModeAck{EnvelopeID: envelope.EnvelopeID}); err != nil {
+				return fmt.Errorf("error acknowledging envelope %s: %v", envelope.EnvelopeID, err)
+			}
+		}
+
+		switch envelope.Type {
+		case "hello":
+		case "disconnect":
+			klog.V(2).Infof("slack requested a socket mode disconnect: %s", envelope.Reason)
+			return nil
+		case "events_api":
+			req := Request{}
+			if err := json.Unmarshal(envelope.Payload, &req); err != nil {
+				klog.Errorf("error decoding event: %v", err)
+				continue
+			}
+			goWork(func() {
+				if err := o.handleEvent(ctx, req.Event); err != nil {
+					klog.Errorf("error handling event: %v", err)
+				}
+			})
+		case "slash_commands":
+			command := SlashCommand{}
+			if err := json.Unmarshal(envelope.Payload, &command); err != nil {
+				klog.Errorf("error decoding slash command: %v", err)
+				continue
+			}
+			goWork(func() { o.handleSlashCommand(ctx, command) })
+		default:
+			klog.V(4).Infof("ignoring socket mode envelope of type %s", envelope.Type)
+		}
+	}
+}
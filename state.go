@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// botState is the bot state that is persisted to the state file so it
+// survives restarts.
+type botState struct {
+	// Subscriptions maps a release stream to the users subscribed to it.
+	Subscriptions map[string][]string `json:"subscriptions,omitempty"`
+	// Settings are the settings changed at runtime, by id.  They override
+	// the flags.
+	Settings map[string]string `json:"settings,omitempty"`
+	Silences []Silence         `json:"silences,omitempty"`
+	// Acks are the acknowledged and snoozed problems, by problem key.
+	Acks map[string]acknowledgement `json:"acks,omitempty"`
+	// Reports are the most recently posted scheduled reports, by channel,
+	// and LastReportTime is when they were posted, so a restart neither
+	// posts the report early nor stops updating the posted reports in
+	// place.
+	Reports        map[string]*postedReport `json:"reports,omitempty"`
+	LastReportTime time.Time                `json:"lastReportTime,omitempty"`
+	// CriticalSince is when each stream with a critical problem got one, and
+	// GitHubIssues are the numbers of the issues opened for them.
+	CriticalSince map[string]time.Time `json:"criticalSince,omitempty"`
+	GitHubIssues  map[string]int       `json:"gitHubIssues,omitempty"`
+	// JiraTickets are the keys of the tickets created for the streams with
+	// no accepted payloads.
+	JiraTickets map[string]string `json:"jiraTickets,omitempty"`
+	// MaintenanceWindows hold the scheduled reports, and Held are the
+	// findings to post when the quiet period they are held for ends.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+	Held               *heldFindings       `json:"held,omitempty"`
+	// Digests are the users' daily digests, by user.
+	Digests map[string]userDigest `json:"digests,omitempty"`
+	// MergeChecks are the merges to release branches that haven't been
+	// checked to have been built yet.
+	MergeChecks []mergeCheck `json:"mergeChecks,omitempty"`
+	// AlertedAt is when each stream was last alerted about, for its
+	// --alert-cooldowns.
+	AlertedAt map[string]time.Time `json:"alertedAt,omitempty"`
+	// Escalations are how far the problems were escalated, by problem key.
+	Escalations map[string]problemEscalation `json:"escalations,omitempty"`
+	// PagerDutyIncidents are the keys of the problems the severity routes
+	// triggered incidents for, so they are resolved once the problems go
+	// away even if the bot restarted in between.
+	PagerDutyIncidents []string `json:"pagerDutyIncidents,omitempty"`
+}
+
+var (
+	stateMutex = &sync.Mutex{}
+	state      = &botState{Subscriptions: map[string][]string{}}
+)
+
+// loadState reads the state file, if one is configured and exists.
+func (o *options) loadState() error {
+	if o.stateFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(o.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state file %s: %v", o.stateFile, err)
+	}
+
+	loaded := &botState{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		return fmt.Errorf("error decoding state file %s: %v", o.stateFile, err)
+	}
+	if loaded.Subscriptions == nil {
+		loaded.Subscriptions = map[string][]string{}
+	}
+	stateMutex.Lock()
+	state = loaded
+	stateMutex.Unlock()
+
+	ackMutex.Lock()
+	for key, ack := range loaded.Acks {
+		acks[key] = ack
+	}
+	ackMutex.Unlock()
+
+	if len(loaded.Settings) > 0 {
+		o.settingsLock.Lock()
+		defer o.settingsLock.Unlock()
+		if errors := o.setSettings(loaded.Settings); len(errors) > 0 {
+			return fmt.Errorf("invalid settings in state file %s: %v", o.stateFile, errors)
+		}
+	}
+	return nil
+}
+
+// saveState writes the state file.  The state is written to a temporary file
+// that is renamed into place, so a crash never leaves a partial state file.
+// Callers must hold the state mutex.
+func (o *options) saveState() {
+	if o.stateFile == "" {
+		return
+	}
+	if o.dryRun {
+		// the state would refer to messages that were never posted
+		klog.V(2).Infof("dry run, not saving the state")
+		return
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		klog.Errorf("error encoding state: %v", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(o.stateFile), ".state-")
+	if err != nil {
+		klog.Errorf("error saving state: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		klog.Errorf("error saving state: %v", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		klog.Errorf("error saving state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), o.stateFile); err != nil {
+		klog.Errorf("error saving state: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// maxStatsDPacket keeps the packets within the MTU of most networks.
+const maxStatsDPacket = 1432
+
+// severities are the severities problems are counted by.
+var severities = []releasewatch.Severity{releasewatch.SeverityCritical, releasewatch.SeverityWarning, releasewatch.SeverityInfo}
+
+// sendStatsDMetrics sends gauges of the age of each stream's newest accepted
+// and built payloads, of how many problems of each severity it has and of its
+// health score, to the --statsd-address.  With --statsd-tags the stream, minor
+// and arch are DogStatsD tags, otherwise the stream is part of the metric
+// name, e.g. release_watcher.4_16_0-0_nightly.accepted_age_seconds.
+func (o *options) sendStatsDMetrics(report *releasewatch.Report) {
+	if o.statsdAddress == "" {
+		return
+	}
+	lines := []string{}
+	gauge := func(stream releasewatch.StreamReport, name string, value float64, tags ...string) {
+		if !o.statsdTags {
+			// the tags other than the stream's own are part of the name too
+			for _, tag := range tags {
+				name += "." + strings.SplitN(tag, ":", 2)[1]
+			}
+			lines = append(lines, fmt.Sprintf("%s%s.%s:%g|g", o.statsdPrefix, strings.ReplaceAll(stream.Name, ".", "_"), name, value))
+			return
+		}
+		tags = append([]string{"stream:" + stream.Name, fmt.Sprintf("minor:4.%d", stream.Minor), "arch:" + stream.Arch}, tags...)
+		lines = append(lines, fmt.Sprintf("%s%s:%g|g|#%s", o.statsdPrefix, name, value, strings.Join(tags, ",")))
+	}
+	for _, stream := range report.Streams {
+		if accepted := stream.LatestAcceptedTime(); !accepted.IsZero() {
+			gauge(stream, "accepted_age_seconds", report.GeneratedAt.Sub(accepted).Seconds())
+		}
+		if built := stream.LatestBuiltTime(); !built.IsZero() {
+			gauge(stream, "built_age_seconds", report.GeneratedAt.Sub(built).Seconds())
+		}
+		counts := severityCounts(stream)
+		for _, severity := range severities {
+			gauge(stream, "problems", float64(counts[severity]), "severity:"+string(severity))
+		}
+		gauge(stream, "health_score", float64(stream.Health))
+	}
+	if err := sendStatsD(o.statsdAddress, lines); err != nil {
+		klog.Errorf("error sending metrics to %s: %v", o.statsdAddress, err)
+	}
+}
+
+// severityCounts returns how many problems of each severity the stream has.
+func severityCounts(stream releasewatch.StreamReport) map[releasewatch.Severity]int {
+	counts := map[releasewatch.Severity]int{}
+	for _, p := range stream.Problems {
+		counts[p.Severity]++
+	}
+	return counts
+}
+
+// sendStatsD sends the metric lines over UDP, as many to a packet as fit.
+func sendStatsD(address string, lines []string) error {
+	conn, err := net.DialTimeout("udp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	packet := ""
+	for _, line := range lines {
+		if packet != "" && len(packet)+1+len(line) > maxStatsDPacket {
+			if _, err := conn.Write([]byte(packet)); err != nil {
+				return err
+			}
+			packet = ""
+		}
+		if packet != "" {
+			packet += "\n"
+		}
+		packet += line
+	}
+	if packet == "" {
+		return nil
+	}
+	_, err = conn.Write([]byte(packet))
+	return err
+}
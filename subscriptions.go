@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// parseStreamArgs converts the arguments of a subscription command, either a
+// full stream name ("4.16.0-0.nightly") or a minor and stream type
+// ("4.16 nightly"), into a stream name.
+func parseStreamArgs(args []string) (string, error) {
+	switch {
+	case len(args) == 1 && isStreamName(args[0]):
+		return args[0], nil
+	case len(args) == 2:
+		minor, err := parseMinor(args[0])
+		if err != nil {
+			return "", err
+		}
+		if args[1] != "ci" && args[1] != "nightly" {
+			return "", fmt.Errorf("unknown stream type %q, expected \"ci\" or \"nightly\"", args[1])
+		}
+		return fmt.Sprintf("4.%d.0-0.%s", minor, args[1]), nil
+	}
+	return "", fmt.Errorf("expected a stream like \"4.16 nightly\" or \"4.16.0-0.nightly\"")
+}
+
+// handleSubscriptionCommand handles the subscribe, unsubscribe and
+// subscriptions commands and returns the reply to post.
+func (o *options) handleSubscriptionCommand(user, command string, args []string) string {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if command == "subscriptions" {
+		streams := []string{}
+		for stream, users := range state.Subscriptions {
+			if containsString(users, user) {
+				streams = append(streams, stream)
+			}
+		}
+		if len(streams) == 0 {
+			return "You are not subscribed to any streams"
+		}
+		sort.Strings(streams)
+		return "You are subscribed to: " + strings.Join(streams, ", ")
+	}
+
+	stream, err := parseStreamArgs(args)
+	if err != nil {
+		return fmt.Sprintf("Sorry, I couldn't process that request: %v", err)
+	}
+	users := state.Subscriptions[stream]
+	if command == "subscribe" {
+		if !containsString(users, user) {
+			state.Subscriptions[stream] = append(users, user)
+			o.saveState()
+		}
+		return fmt.Sprintf("You will get a direct message when the state of %s changes", stream)
+	}
+
+	remaining := []string{}
+	for _, u := range users {
+		if u != user {
+			remaining = append(remaining, u)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(state.Subscriptions, stream)
+	} else {
+		state.Subscriptions[stream] = remaining
+	}
+	o.saveState()
+	return fmt.Sprintf("You are no longer subscribed to %s", stream)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// runSubscriptionLoop checks the monitored streams every poll interval and
+// sends a direct message to the subscribers of each stream whose state has
+// changed.
+func (o *options) runSubscriptionLoop(ctx context.Context) {
+	var last map[string]releasewatch.StreamReport
+	held := map[string]bool{}
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+	for {
+		stateMutex.Lock()
+		subscribed := len(state.Subscriptions) > 0
+		stateMutex.Unlock()
+		if !subscribed {
+			last = nil
+		} else if report, err := o.generateCurrentReport(ctx); ctx.Err() != nil {
+			return
+		} else if err != nil {
+			klog.Errorf("error generating report for subscriptions: %v", err)
+		} else {
+			current := map[string]releasewatch.StreamReport{}
+			for _, stream := range report.Streams {
+				current[stream.Name] = stream
+			}
+			// the first report only establishes the state to compare against.
+			// During quiet periods the state before them is kept, so the
+			// changes are sent when they end.
+			_, _, quiet := o.quietPeriod(time.Now())
+			if last != nil && !quiet && o.isLeader() && startWork() {
+				held = o.notifySubscribers(last, current)
+				finishWork()
+			}
+			if last == nil || !quiet {
+				// the streams whose alerts are held keep the state they
+				// were last alerted about in
+				for stream := range held {
+					if before, ok := last[stream]; ok {
+						current[stream] = before
+					} else {
+						delete(current, stream)
+					}
+				}
+				last = current
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notifySubscribers sends each subscriber a direct message with the changes of
+// their streams, and returns the streams whose alerts are held for their
+// --alert-cooldowns.
+func (o *options) notifySubscribers(last, current map[string]releasewatch.StreamReport) map[string]bool {
+	stateMutex.Lock()
+	subscriptions := map[string][]string{}
+	for stream, users := range state.Subscriptions {
+		subscriptions[stream] = append([]string{}, users...)
+	}
+	stateMutex.Unlock()
+
+	// each user gets a single message covering all of their streams that
+	// changed, rather than one message per stream
+	now := time.Now()
+	messages := map[string][]string{}
+	held := map[string]bool{}
+	alerted := []string{}
+	for stream, users := range subscriptions {
+		changes := streamChanges(last[stream], current[stream])
+		if len(changes) == 0 {
+			continue
+		}
+		if o.alertHeld(stream, last[stream], current[stream], now) {
+			held[stream] = true
+			continue
+		}
+		alerted = append(alerted, stream)
+		report, ok := current[stream]
+		if !ok {
+			report = last[stream]
+		}
+		text := fmt.Sprintf("<%s|%s> changed:\n%s", report.URL(), stream, strings.Join(changes, "\n"))
+		for _, user := range users {
+			messages[user] = append(messages[user], text)
+		}
+	}
+	for user, texts := range messages {
+		sort.Strings(texts)
+		// posting to a user ID delivers a direct message from the bot
+		if _, err := postMessage(PostMessage{Channel: user, Text: strings.Join(texts, "\n\n")}); err != nil {
+			klog.Errorf("error sending subscription message to %s: %v", user, err)
+		}
+	}
+	o.recordAlerts(alerted, now)
+	return held
+}
+
+// streamChanges describes how a stream changed between two reports.
+func streamChanges(before, after releasewatch.StreamReport) []string {
+	changes := []string{}
+	if after.LatestAccepted != "" && after.LatestAccepted != before.LatestAccepted {
+		changes = append(changes, fmt.Sprintf("• New accepted payload %s", after.LatestAccepted))
+	}
+	for _, p := range after.Problems {
+		if !before.HasProblem(p.Kind) {
+			changes = append(changes, fmt.Sprintf("• New %s problem: %s", p.Severity, p.Message))
+		}
+	}
+	for _, p := range before.Problems {
+		if !after.HasProblem(p.Kind) {
+			changes = append(changes, "• Recovered: "+p.Message)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// messageTemplateFuncs are the functions the --message-template can use on
+// top of the methods of the report.
+var messageTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+var (
+	messageTemplateLock sync.Mutex
+	// messageTemplate is the --message-template as parsed when the file was
+	// last modified, at messageTemplateModTime.
+	messageTemplate        *template.Template
+	messageTemplateModTime time.Time
+)
+
+// messageTemplateData is what the --message-template is executed with.
+type messageTemplateData struct {
+	// Report is the structured report, with the fields of the JSON reports.
+	Report *releasewatch.Report
+	// Summary is the one line summary of the report the bot posts, tagging
+	// the Mentions.
+	Summary  string
+	Mentions string
+}
+
+// parseMessageTemplate reads and parses the --message-template.
+func parseMessageTemplate(path string) (*template.Template, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message template %s: %v", path, err)
+	}
+	tmpl, err := template.New(path).Funcs(messageTemplateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template %s: %v", path, err)
+	}
+	return tmpl, nil
+}
+
+// loadMessageTemplate returns the --message-template, parsing it again only
+// if the file was modified since it was last parsed, so it can be changed
+// without restarting the bot.
+func (o *options) loadMessageTemplate() (*template.Template, error) {
+	info, err := os.Stat(o.messageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message template %s: %v", o.messageTemplate, err)
+	}
+	messageTemplateLock.Lock()
+	defer messageTemplateLock.Unlock()
+	if messageTemplate != nil && info.ModTime().Equal(messageTemplateModTime) {
+		return messageTemplate, nil
+	}
+	tmpl, err := parseMessageTemplate(o.messageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	messageTemplate, messageTemplateModTime = tmpl, info.ModTime()
+	return tmpl, nil
+}
+
+// messageTemplateBlocks renders the report with the --message-template, as
+// Slack mrkdwn packed into as few sections as fit it.
+func (o *options) messageTemplateBlocks(report *releasewatch.Report, mentions string) ([]Block, error) {
+	tmpl, err := o.loadMessageTemplate()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	data := messageTemplateData{Report: report, Summary: reportSummary(report, mentions), Mentions: mentions}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("error rendering message template %s: %v", o.messageTemplate, err)
+	}
+	blocks := []Block{}
+	text := ""
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		line = truncateBytes(line, maxSectionLength)
+		if text != "" && len(text)+len(line)+1 > maxSectionLength {
+			blocks = append(blocks, Block{Type: "section", Text: markdownText(text)})
+			text = ""
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+	}
+	if strings.TrimSpace(text) != "" {
+		blocks = append(blocks, Block{Type: "section", Text: markdownText(text)})
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("message template %s rendered an empty message", o.messageTemplate)
+	}
+	return blocks, nil
+}
+
+// templatedReportBlocks returns the blocks of the --message-template, or nil
+// if there is none or it fails, in which case the report is posted as usual.
+func (o *options) templatedReportBlocks(report *releasewatch.Report, mentions string) []Block {
+	if o.messageTemplate == "" {
+		return nil
+	}
+	blocks, err := o.messageTemplateBlocks(report, mentions)
+	if err != nil {
+		klog.Errorf("posting the report without its template: %v", err)
+		return nil
+	}
+	return blocks
+}
+
+// truncateBytes cuts the text to at most max bytes, without splitting a
+// character.
+func truncateBytes(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	for max > 0 && !utf8.RuneStart(text[max]) {
+		max--
+	}
+	return text[:max]
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// maxTenantWatchers bounds how many release controllers other than the bot's
+// the tenants and the ReleaseWatches, which anyone who can create one can
+// point anywhere, can have watched.
+const maxTenantWatchers = 20
+
+var (
+	tenantWatchersMutex sync.Mutex
+	// tenantWatchers are the watchers of the tenants' release controllers,
+	// by url.
+	tenantWatchers = map[string]*releasewatch.Watcher{}
+)
+
+// Tenant is a channel of a team that shares the bot and gets a report of its
+// own, judged by its own minor range, stream filters and staleness limits and
+// tagging its own people.  The settings a tenant doesn't set are the bot's.
+type Tenant struct {
+	Channel string
+	// ReleaseAPIURL is the release controller the tenant watches, the bot's
+	// when empty.
+	ReleaseAPIURL string
+	// OldestMinor and NewestMinor are the bot's when zero.
+	OldestMinor       int
+	NewestMinor       int
+	Streams           []releasewatch.StreamPattern
+	ExcludeStreams    []releasewatch.StreamPattern
+	StreamTypes       []string
+	AcceptedStaleness time.Duration
+	BuiltStaleness    time.Duration
+	UpgradeStaleness  time.Duration
+	SlackAlias        string
+	SlackAliasMap     map[string]string
+}
+
+// tenantRule is an entry of the tenants of the config file.
+type tenantRule struct {
+	Channel                string            `json:"channel"`
+	ReleaseAPIURL          string            `json:"release-api-url,omitempty"`
+	Arch                   string            `json:"arch,omitempty"`
+	OldestMinor            int               `json:"oldest-minor,omitempty"`
+	NewestMinor            int               `json:"newest-minor,omitempty"`
+	Streams                []string          `json:"streams,omitempty"`
+	ExcludeStreams         []string          `json:"exclude-streams,omitempty"`
+	StreamTypes            []string          `json:"stream-types,omitempty"`
+	AcceptedStalenessLimit string            `json:"accepted-staleness-limit,omitempty"`
+	BuiltStalenessLimit    string            `json:"built-staleness-limit,omitempty"`
+	UpgradeStalenessLimit  string            `json:"upgrade-staleness-limit,omitempty"`
+	SlackAlias             string            `json:"slack-alias,omitempty"`
+	SlackAliasMap          map[string]string `json:"slack-alias-map,omitempty"`
+}
+
+// parseTenants parses the tenants of the config file.
+func parseTenants(rules []tenantRule) ([]Tenant, error) {
+	tenants := []Tenant{}
+	channels := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Channel == "" {
+			return nil, fmt.Errorf("tenant %d must have a channel", i)
+		}
+		if channels[rule.Channel] {
+			return nil, fmt.Errorf("channel %s has more than one tenant", rule.Channel)
+		}
+		channels[rule.Channel] = true
+		t := Tenant{
+			Channel:       rule.Channel,
+			OldestMinor:   rule.OldestMinor,
+			NewestMinor:   rule.NewestMinor,
+			StreamTypes:   rule.StreamTypes,
+			SlackAlias:    rule.SlackAlias,
+			SlackAliasMap: rule.SlackAliasMap,
+		}
+		if rule.Arch != "" {
+			if rule.ReleaseAPIURL != "" {
+				return nil, fmt.Errorf("tenant %s can only have one of arch and release-api-url", rule.Channel)
+			}
+			apiURL, err := releasewatch.ReleaseAPIURLForArch(rule.Arch)
+			if err != nil {
+				return nil, fmt.Errorf("invalid arch of tenant %s: %v", rule.Channel, err)
+			}
+			t.ReleaseAPIURL = apiURL
+		} else if rule.ReleaseAPIURL != "" {
+			if u, err := url.Parse(rule.ReleaseAPIURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("invalid release-api-url of tenant %s: %q is not a url", rule.Channel, rule.ReleaseAPIURL)
+			}
+			t.ReleaseAPIURL = strings.TrimSuffix(rule.ReleaseAPIURL, "/")
+		}
+		if t.OldestMinor < 0 || t.NewestMinor < 0 {
+			return nil, fmt.Errorf("the minors of tenant %s must be positive", rule.Channel)
+		}
+		if t.OldestMinor > 0 && t.NewestMinor > 0 && t.OldestMinor > t.NewestMinor {
+			return nil, fmt.Errorf("oldest-minor 4.%d of tenant %s is newer than its newest-minor 4.%d", t.OldestMinor, rule.Channel, t.NewestMinor)
+		}
+		for _, value := range rule.Streams {
+			pattern, err := releasewatch.ParseStreamPattern(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid streams of tenant %s: %v", rule.Channel, err)
+			}
+			t.Streams = append(t.Streams, pattern)
+		}
+		for _, value := range rule.ExcludeStreams {
+			pattern, err := releasewatch.ParseStreamPattern(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude-streams of tenant %s: %v", rule.Channel, err)
+			}
+			t.ExcludeStreams = append(t.ExcludeStreams, pattern)
+		}
+		for _, streamType := range rule.StreamTypes {
+			if !isStreamType(streamType) {
+				return nil, fmt.Errorf("invalid stream-types of tenant %s: %q is not one of %s", rule.Channel, streamType, strings.Join(releasewatch.StreamTypes, ", "))
+			}
+		}
+		var err error
+		if t.AcceptedStaleness, err = parseLimit(rule.AcceptedStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid accepted-staleness-limit of tenant %s: %v", rule.Channel, err)
+		}
+		if t.BuiltStaleness, err = parseLimit(rule.BuiltStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid built-staleness-limit of tenant %s: %v", rule.Channel, err)
+		}
+		if t.UpgradeStaleness, err = parseLimit(rule.UpgradeStalenessLimit); err != nil {
+			return nil, fmt.Errorf("invalid upgrade-staleness-limit of tenant %s: %v", rule.Channel, err)
+		}
+		if err := validateAliasMap(t.SlackAliasMap); err != nil {
+			return nil, fmt.Errorf("invalid slack-alias-map of tenant %s: %v", rule.Channel, err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+// limits returns the bot's limits with the tenant's settings applied.
+func (t Tenant) limits(base releasewatch.Limits) releasewatch.Limits {
+	limits := base
+	if t.OldestMinor > 0 {
+		limits.OldestMinor = t.OldestMinor
+	}
+	if t.NewestMinor > 0 {
+		limits.NewestMinor = t.NewestMinor
+	}
+	if len(t.Streams) > 0 {
+		limits.Streams = t.Streams
+	}
+	if len(t.ExcludeStreams) > 0 {
+		limits.ExcludeStreams = t.ExcludeStreams
+	}
+	if len(t.StreamTypes) > 0 {
+		limits.StreamTypes = t.StreamTypes
+	}
+	if t.AcceptedStaleness > 0 {
+		limits.AcceptedStaleness = t.AcceptedStaleness
+	}
+	if t.BuiltStaleness > 0 {
+		limits.BuiltStaleness = t.BuiltStaleness
+	}
+	if t.UpgradeStaleness > 0 {
+		limits.UpgradeStaleness = t.UpgradeStaleness
+	}
+	return limits
+}
+
+// hasMentions returns true if the tenant tags people of its own rather than
+// the bot's.
+func (t Tenant) hasMentions() bool {
+	return t.SlackAlias != "" || len(t.SlackAliasMap) > 0
+}
+
+// currentTenants returns a copy of the tenants of the config file and of the
+// ReleaseWatches.
+func currentTenants() []Tenant {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append(append([]Tenant{}, configTenants...), watchTenants...)
+}
+
+// currentConfigTenants returns a copy of the tenants of the config file.
+func currentConfigTenants() []Tenant {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]Tenant{}, configTenants...)
+}
+
+// channelTenant returns the tenant of the channel, if it has one.
+func channelTenant(channel string) (Tenant, bool) {
+	for _, tenant := range currentTenants() {
+		if tenant.Channel == channel {
+			return tenant, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// channelLimits returns the limits the reports for the channel are judged by.
+func (o *options) channelLimits(channel string) releasewatch.Limits {
+	o.settingsLock.RLock()
+	limits := o.limits()
+	o.settingsLock.RUnlock()
+	if tenant, ok := channelTenant(channel); ok {
+		return tenant.limits(limits)
+	}
+	return limits
+}
+
+// channelReport generates the report for the channel: the tenant's report if
+// the channel has a tenant, or else the bot's, from the cache if there is one.
+func (o *options) channelReport(ctx context.Context, channel string) (*releasewatch.Report, error) {
+	tenant, ok := channelTenant(channel)
+	if !ok {
+		return o.cachedOrCurrentReport(ctx)
+	}
+	return o.tenantReport(ctx, tenant)
+}
+
+// tenantWatcher returns the watcher of the release controller the tenant
+// watches.  The watchers of other release controllers than the bot's are
+// kept, so each of them spreads out its own requests, up to
+// maxTenantWatchers of them.  The api token is only sent to the hosts of the
+// bot's own release controllers.
+func (o *options) tenantWatcher(tenant Tenant) (*releasewatch.Watcher, error) {
+	if tenant.ReleaseAPIURL == "" {
+		return o.watcher, nil
+	}
+	tenantWatchersMutex.Lock()
+	defer tenantWatchersMutex.Unlock()
+	if watcher, ok := tenantWatchers[tenant.ReleaseAPIURL]; ok {
+		return watcher, nil
+	}
+	if len(tenantWatchers) >= maxTenantWatchers {
+		return nil, fmt.Errorf("can't watch %s for %s: already watching %d other release controllers", tenant.ReleaseAPIURL, tenant.Channel, maxTenantWatchers)
+	}
+	options, err := o.watcherOptions(tenant.ReleaseAPIURL)
+	if err != nil {
+		return nil, fmt.Errorf("error watching %s for %s: %v", tenant.ReleaseAPIURL, tenant.Channel, err)
+	}
+	if !o.isReleaseAPIHost(tenant.ReleaseAPIURL) {
+		options.Token, options.TokenFile = "", ""
+	}
+	watcher, err := releasewatch.New(options)
+	if err != nil {
+		return nil, fmt.Errorf("error watching %s for %s: %v", tenant.ReleaseAPIURL, tenant.Channel, err)
+	}
+	tenantWatchers[tenant.ReleaseAPIURL] = watcher
+	return watcher, nil
+}
+
+// isReleaseAPIHost returns true if the url is on the host of one of the
+// release controllers the bot watches.
+func (o *options) isReleaseAPIHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	releaseAPIURLs, _ := o.configuredReleaseAPIURLs()
+	for _, releaseAPIURL := range releaseAPIURLs {
+		if configured, err := url.Parse(releaseAPIURL); err == nil && configured.Host == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantReport generates the report of the tenant.  Unlike the bot's reports
+// it isn't saved in the history or sent as metrics, which the tenants would
+// otherwise overwrite.
+func (o *options) tenantReport(ctx context.Context, tenant Tenant) (*releasewatch.Report, error) {
+	o.settingsLock.RLock()
+	limits := tenant.limits(o.limits())
+	o.settingsLock.RUnlock()
+	watcher, err := o.tenantWatcher(tenant)
+	if err != nil {
+		return nil, err
+	}
+	report, err := watcher.GenerateReportWithLimits(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+	order, _ := releasewatch.ParseSortOrder(o.sortOrder)
+	report.Sort(order)
+	report.Location = o.displayLocation()
+	return report, nil
+}
+
+// postTenantReports posts the report of each tenant to its channel.
+func (o *options) postTenantReports(ctx context.Context, last map[string]*postedReport) {
+	for _, tenant := range currentTenants() {
+		report, err := o.tenantReport(ctx, tenant)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			klog.Errorf("error generating scheduled report for %s: %v", tenant.Channel, err)
+			continue
+		}
+		o.applyAcks(report)
+		last[tenant.Channel] = o.postScheduledReport(tenant.Channel, report, last[tenant.Channel])
+	}
+}
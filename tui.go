@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// The escape sequences the dashboard draws with.
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	cursorHide   = "\x1b[?25l"
+	cursorShow   = "\x1b[?25h"
+	clearScreen  = "\x1b[H\x1b[2J"
+	styleReset   = "\x1b[0m"
+	styleBold    = "\x1b[1m"
+	styleReverse = "\x1b[7m"
+)
+
+// severityColors are the colors of the streams by the severity of their most
+// urgent problem.  Streams without problems are green.
+var severityColors = map[releasewatch.Severity]string{
+	releasewatch.SeverityCritical: "\x1b[31m",
+	releasewatch.SeverityWarning:  "\x1b[33m",
+	releasewatch.SeverityInfo:     "\x1b[36m",
+	"":                            "\x1b[32m",
+}
+
+// The keys the dashboard acts on, with the arrow keys and the control keys
+// given names.
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyEnter     = "enter"
+	keyEscape    = "escape"
+	keyBackspace = "backspace"
+	keyInterrupt = "interrupt"
+)
+
+// tui is the state of the terminal dashboard.
+type tui struct {
+	o *options
+
+	// mutex guards the fields below, which the refreshes and payload
+	// fetches update in the background.
+	mutex      sync.Mutex
+	report     *releasewatch.Report
+	reportErr  error
+	refreshing bool
+	// selected is the name of the selected stream, and offset the first row
+	// shown of the list or of the stream's details.
+	selected string
+	offset   int
+	// detail is true when the selected stream's details are shown, with its
+	// recent payloads once they are fetched.
+	detail      bool
+	payloads    []releasewatch.Payload
+	payloadsErr error
+	// redraw is signalled when the background work changed the state.
+	redraw chan struct{}
+}
+
+// parseKeys splits what was read from the terminal into keys.  Escape
+// sequences of the arrow keys are named, as are the control keys the
+// dashboard uses; other keys are their own text.
+func parseKeys(input []byte) []string {
+	keys := []string{}
+	for len(input) > 0 {
+		if len(input) >= 3 && input[0] == 0x1b && (input[1] == '[' || input[1] == 'O') {
+			switch input[2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			case 'C':
+				keys = append(keys, keyRight)
+			case 'D':
+				keys = append(keys, keyLeft)
+			}
+			input = input[3:]
+			continue
+		}
+		switch input[0] {
+		case 0x1b:
+			keys = append(keys, keyEscape)
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+		case 0x7f, 0x08:
+			keys = append(keys, keyBackspace)
+		case 0x03:
+			keys = append(keys, keyInterrupt)
+		default:
+			keys = append(keys, string(input[0]))
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// runTUI shows the streams in a dashboard that refreshes every
+// --refresh-interval until q is pressed.
+func (o *options) runTUI(ctx context.Context) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.refreshInterval <= 0 {
+		return fmt.Errorf("invalid refresh-interval %s: must be positive", o.refreshInterval)
+	}
+	if o.verbosePayloads < 1 {
+		return fmt.Errorf("payloads must be at least 1")
+	}
+	fd := int(os.Stdin.Fd())
+	if _, _, err := terminalSize(fd); err != nil {
+		return fmt.Errorf("the tui needs a terminal: %v", err)
+	}
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("error setting up the terminal: %v", err)
+	}
+	defer restore()
+	// the log would scribble over the dashboard, so it only goes to files
+	for name, value := range map[string]string{"logtostderr": "false", "alsologtostderr": "false", "stderrthreshold": "FATAL"} {
+		flag.CommandLine.Set(name, value)
+	}
+	defer klog.Flush()
+	fmt.Print(altScreenOn + cursorHide)
+	defer fmt.Print(cursorShow + altScreenOff)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := &tui{o: o, redraw: make(chan struct{}, 1)}
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				select {
+				case keys <- key:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+	ticker := time.NewTicker(o.refreshInterval)
+	defer ticker.Stop()
+
+	t.refresh(ctx)
+	for {
+		t.draw(fd)
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handleKey(ctx, key) {
+				return nil
+			}
+		case <-t.redraw:
+		case <-resized:
+		case <-ticker.C:
+			t.refresh(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// notify asks for the dashboard to be redrawn.
+func (t *tui) notify() {
+	select {
+	case t.redraw <- struct{}{}:
+	default:
+		// a redraw is already on its way
+	}
+}
+
+// refresh generates the report in the background, unless it is already
+// being generated.
+func (t *tui) refresh(ctx context.Context) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.refreshing {
+		return
+	}
+	t.refreshing = true
+	go func() {
+		report, err := t.o.currentReport(ctx)
+		t.mutex.Lock()
+		t.refreshing = false
+		if err != nil {
+			t.reportErr = err
+		} else {
+			t.report, t.reportErr = report, nil
+			if t.selected == "" && len(report.Streams) > 0 {
+				t.selected = report.Streams[0].Name
+			}
+		}
+		t.mutex.Unlock()
+		t.notify()
+	}()
+}
+
+// fetchPayloads fetches the recent payloads of the selected stream in the
+// background, for its details.
+func (t *tui) fetchPayloads(ctx context.Context, stream string) {
+	go func() {
+		payloads, err := t.o.watcher.RecentPayloads(ctx, stream, t.o.verbosePayloads)
+		t.mutex.Lock()
+		// the details may have been left, or another stream's entered,
+		// since
+		if t.detail && t.selected == stream {
+			t.payloads, t.payloadsErr = payloads, err
+		}
+		t.mutex.Unlock()
+		t.notify()
+	}()
+}
+
+// handleKey acts on a key, returning false when the dashboard should quit.
+func (t *tui) handleKey(ctx context.Context, key string) bool {
+	switch key {
+	case "q", keyInterrupt:
+		return false
+	case "r":
+		t.refresh(ctx)
+		return true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.detail {
+		switch key {
+		case keyEscape, keyBackspace, keyLeft, "h":
+			t.detail, t.offset = false, 0
+		case keyDown, "j":
+			t.offset++
+		case keyUp, "k":
+			if t.offset > 0 {
+				t.offset--
+			}
+		}
+		return true
+	}
+	if t.report == nil || len(t.report.Streams) == 0 {
+		return true
+	}
+	index := t.selectedIndex()
+	switch key {
+	case keyDown, "j":
+		if index < len(t.report.Streams)-1 {
+			index++
+		}
+	case keyUp, "k":
+		if index > 0 {
+			index--
+		}
+	case "g":
+		index = 0
+	case "G":
+		index = len(t.report.Streams) - 1
+	case keyEnter, keyRight, "l":
+		t.detail, t.offset = true, 0
+		t.payloads, t.payloadsErr = nil, nil
+		t.fetchPayloads(ctx, t.report.Streams[index].Name)
+	}
+	t.selected = t.report.Streams[index].Name
+	return true
+}
+
+// selectedIndex returns the index of the selected stream in the report, or
+// 0 if it is no longer in it.  The mutex must be held.
+func (t *tui) selectedIndex() int {
+	for i, stream := range t.report.Streams {
+		if stream.Name == t.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// draw redraws the whole dashboard for the size of the terminal.
+func (t *tui) draw(fd int) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	header := []string{styleBold + truncate("release-watcher", width) + styleReset, t.status(width)}
+	footer := "j/k: move  enter: details  r: refresh  q: quit"
+	var body []string
+	if t.detail {
+		footer = "j/k: scroll  esc: back  r: refresh  q: quit"
+		body = t.detailLines(width)
+	} else {
+		body = t.streamLines(width, height-len(header)-2)
+	}
+	rows := height - len(header) - 2
+	if t.detail {
+		// the details scroll freely, up to their last page
+		if max := len(body) - rows; t.offset > max {
+			t.offset = max
+		}
+		if t.offset < 0 {
+			t.offset = 0
+		}
+		body = body[t.offset:]
+	}
+	if len(body) > rows {
+		body = body[:rows]
+	}
+
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	for _, line := range header {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+	for _, line := range body {
+		b.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H%s", height, truncate(footer, width))
+	fmt.Print(b.String())
+}
+
+// status describes when the report was generated and whether it is being
+// refreshed or the last refresh failed.  The mutex must be held.
+func (t *tui) status(width int) string {
+	parts := []string{}
+	if t.report == nil {
+		parts = append(parts, "generating the report...")
+	} else {
+		parts = append(parts, fmt.Sprintf("generated %s", t.report.FormatTimeAgo(t.report.GeneratedAt)))
+		if counts := t.report.SeverityCounts(); counts != "" {
+			parts = append(parts, counts)
+		}
+		if t.refreshing {
+			parts = append(parts, "refreshing...")
+		}
+	}
+	status := truncate(strings.Join(parts, " | "), width)
+	if t.reportErr != nil {
+		status = severityColors[releasewatch.SeverityCritical] + truncate(fmt.Sprintf("error refreshing the report: %v", t.reportErr), width) + styleReset
+	}
+	return status
+}
+
+// streamLines returns a row per stream, colored by the severity of its most
+// urgent problem, scrolled so the selected stream is within the rows.  The
+// mutex must be held.
+func (t *tui) streamLines(width, rows int) []string {
+	if t.report == nil {
+		return nil
+	}
+	if len(t.report.Streams) == 0 {
+		return []string{"No streams are monitored."}
+	}
+	nameWidth := len("STREAM")
+	for _, stream := range t.report.Streams {
+		if len(stream.Name) > nameWidth {
+			nameWidth = len(stream.Name)
+		}
+	}
+	format := fmt.Sprintf("%%-%ds  %%-9s  %%-9s  %%s", nameWidth)
+	lines := []string{styleBold + truncate(fmt.Sprintf(format, "STREAM", "ACCEPTED", "BUILT", "PROBLEMS"), width) + styleReset}
+
+	rows--
+	index := t.selectedIndex()
+	if index < t.offset {
+		t.offset = index
+	}
+	if rows > 0 && index >= t.offset+rows {
+		t.offset = index - rows + 1
+	}
+	for i := t.offset; i < len(t.report.Streams); i++ {
+		stream := t.report.Streams[i]
+		problems := []string{}
+		for _, p := range stream.Problems {
+			problems = append(problems, p.Kind.Description())
+		}
+		line := truncate(fmt.Sprintf(format, stream.Name, t.age(stream.LatestAcceptedTime()), t.age(stream.LatestBuiltTime()), strings.Join(problems, ", ")), width)
+		style := severityColors[stream.Severity()]
+		if i == index {
+			style += styleReverse
+			// the selection spans the whole row
+			line += strings.Repeat(" ", width-len([]rune(line)))
+		}
+		lines = append(lines, style+line+styleReset)
+	}
+	return lines
+}
+
+// detailLines returns the selected stream's problems and recent payloads.
+// The mutex must be held.
+func (t *tui) detailLines(width int) []string {
+	var stream *releasewatch.StreamReport
+	if t.report != nil {
+		for i := range t.report.Streams {
+			if t.report.Streams[i].Name == t.selected {
+				stream = &t.report.Streams[i]
+			}
+		}
+	}
+	if stream == nil {
+		return []string{fmt.Sprintf("%s is no longer in the report.", t.selected)}
+	}
+
+	lines := []string{
+		severityColors[stream.Severity()] + styleBold + truncate(stream.Name, width) + styleReset,
+		truncate(stream.URL(), width),
+		truncate(fmt.Sprintf("Newest accepted: %s (%s)", orNone(stream.LatestAccepted), t.age(stream.LatestAcceptedTime())), width),
+		truncate(fmt.Sprintf("Newest built: %s (%s)", orNone(stream.LatestBuilt), t.age(stream.LatestBuiltTime())), width),
+		"",
+	}
+	if len(stream.Problems) == 0 {
+		lines = append(lines, "No problems.")
+	}
+	for _, p := range stream.Problems {
+		lines = append(lines, severityColors[p.Severity]+truncate(fmt.Sprintf("[%s] %s", p.Severity, p.Message), width)+styleReset)
+	}
+	for _, ack := range stream.Acknowledged {
+		lines = append(lines, truncate(fmt.Sprintf("[acknowledged] %s", ack.Message), width))
+	}
+
+	lines = append(lines, "", styleBold+"Recent payloads"+styleReset)
+	switch {
+	case t.payloadsErr != nil:
+		lines = append(lines, severityColors[releasewatch.SeverityCritical]+truncate(fmt.Sprintf("error fetching the payloads: %v", t.payloadsErr), width)+styleReset)
+	case t.payloads == nil:
+		lines = append(lines, "fetching...")
+	case len(t.payloads) == 0:
+		lines = append(lines, "none")
+	}
+	for _, payload := range t.payloads {
+		line := fmt.Sprintf("%-40s  %-9s  built %s", payload.Name, payload.Phase, t.age(payload.Created))
+		if !payload.AcceptedAt.IsZero() {
+			line += ", accepted " + t.age(payload.AcceptedAt)
+		}
+		lines = append(lines, truncate(line, width))
+	}
+	return lines
+}
+
+// age formats how long before the report a time was, e.g. "5h ago", or
+// "never" if it is zero.  The mutex must be held.
+func (t *tui) age(ts time.Time) string {
+	if ts.IsZero() {
+		return "never"
+	}
+	return t.report.FormatAge(ts) + " ago"
+}
+
+// orNone returns the payload, or "none" if it is empty.
+func orNone(payload string) string {
+	if payload == "" {
+		return "none"
+	}
+	return payload
+}
+
+// truncate cuts the text to the width of the terminal.
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func makeRaw(fd int) (func(), error) {
+	return nil, fmt.Errorf("the tui needs a Linux or macOS terminal")
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, fmt.Errorf("the tui needs a Linux or macOS terminal")
+}
+
+func notifyResize(c chan<- os.Signal) {}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+const (
+	// uploadTimeFormat names the uploaded reports, so they sort in the order
+	// they were generated in.
+	uploadTimeFormat = "20060102T150405Z"
+	// the credentials for the upload bucket.  For a GCS bucket they are an
+	// HMAC key of a service account.
+	uploadAccessKeyEnv    = "AWS_ACCESS_KEY_ID"
+	uploadSecretKeyEnv    = "AWS_SECRET_ACCESS_KEY"
+	uploadSessionTokenEnv = "AWS_SESSION_TOKEN"
+)
+
+var uploadClient = &http.Client{Timeout: 30 * time.Second}
+
+// uploadTarget is where --upload-url uploads the reports to.
+type uploadTarget struct {
+	// endpoint is the S3 compatible api of the bucket, e.g.
+	// https://storage.googleapis.com for GCS.
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+}
+
+// parseUploadURL parses an upload url such as s3://bucket/prefix or
+// gs://bucket/prefix.  The endpoint overrides the one of the scheme, e.g.
+// for MinIO.
+func parseUploadURL(value, endpoint, region string) (*uploadTarget, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid upload url %q, expected a url like s3://bucket/prefix or gs://bucket/prefix", value)
+	}
+	target := &uploadTarget{bucket: u.Host, prefix: strings.Trim(u.Path, "/"), region: region}
+	switch u.Scheme {
+	case "s3":
+		target.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	case "gs":
+		target.endpoint = "https://storage.googleapis.com"
+	default:
+		return nil, fmt.Errorf("invalid upload url %q, the scheme must be s3 or gs", value)
+	}
+	if endpoint != "" {
+		target.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	return target, nil
+}
+
+// key returns the object key of a file in the target's prefix.
+func (t *uploadTarget) key(name string) string {
+	if t.prefix == "" {
+		return name
+	}
+	return t.prefix + "/" + name
+}
+
+// uploadReport uploads the report as text, JSON and HTML, each under a key
+// named after when it was generated, e.g. 20240501T101010Z.json, and as
+// latest.json and so on, so the newest report is always at the same key.
+func (o *options) uploadReport(report *releasewatch.Report) {
+	if o.uploadURL == "" {
+		return
+	}
+	// the url is validated with the other flags
+	target, _ := parseUploadURL(o.uploadURL, o.uploadEndpoint, o.uploadRegion)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		klog.Errorf("error encoding the report to upload: %v", err)
+		return
+	}
+	text := report.String()
+	formats := []struct {
+		extension   string
+		contentType string
+		data        []byte
+	}{
+		{"txt", "text/plain; charset=utf-8", []byte(text)},
+		{"json", "application/json", data},
+		{"html", "text/html; charset=utf-8", []byte(reportHTML(text))},
+	}
+	timestamp := report.GeneratedAt.UTC().Format(uploadTimeFormat)
+	for _, format := range formats {
+		for _, name := range []string{timestamp, "latest"} {
+			key := target.key(name + "." + format.extension)
+			if err := target.put(key, format.contentType, format.data); err != nil {
+				klog.Errorf("error uploading the report: %v", err)
+				return
+			}
+		}
+	}
+	klog.V(2).Infof("uploaded the report to %s", o.uploadURL)
+}
+
+// reportHTML is the text of the report as a standalone page.
+func reportHTML(text string) string {
+	return "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>OCP Payload Report</title></head>\n<body><pre>\n" + html.EscapeString(text) + "</pre></body>\n</html>\n"
+}
+
+// put uploads an object, signing the request with AWS Signature Version 4,
+// which both S3 and the XML api of GCS accept.
+func (t *uploadTarget) put(key, contentType string, data []byte) error {
+	if slackDryRun {
+		klog.Infof("dry run, not uploading %s to %s", key, t.bucket)
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPut, t.endpoint+"/"+t.bucket+"/"+uriEncode(key), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", key, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	signRequest(req, data, t.region, time.Now())
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error uploading %s: %s: %s", key, resp.Status, body)
+	}
+	return nil
+}
+
+// signRequest adds the AWS Signature Version 4 authorization of the request
+// to its headers, using the credentials in the environment.
+func signRequest(req *http.Request, body []byte, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if token := os.Getenv(uploadSessionTokenEnv); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+os.Getenv(uploadSecretKeyEnv)), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", os.Getenv(uploadAccessKeyEnv), scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes an object key the way Signature Version 4 expects, which
+// is everything but the unreserved characters and the slashes.
+func uriEncode(key string) string {
+	encoded := ""
+	for _, b := range []byte(key) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', strings.IndexByte("-_.~/", b) >= 0:
+			encoded += string(b)
+		default:
+			encoded += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return encoded
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+var (
+	// webhookMutex guards webhookStates and webhookInFlight.
+	webhookMutex sync.Mutex
+	// webhookStates are the streams as of their last analysis triggered by
+	// a webhook event, by name.
+	webhookStates = map[string]webhookState{}
+	// webhookInFlight are the streams being analyzed, so a burst of events
+	// for a stream doesn't start an analysis per event.
+	webhookInFlight = map[string]bool{}
+)
+
+// webhookState is a stream as of an analysis.
+type webhookState struct {
+	Stream     releasewatch.StreamReport
+	AnalyzedAt time.Time
+}
+
+// WebhookEvent is the body of the webhook endpoint, e.g. {"stream":
+// "4.16.0-0.nightly", "event": "payload accepted"}.  The event is only
+// logged.
+type WebhookEvent struct {
+	Stream string `json:"stream"`
+	Event  string `json:"event,omitempty"`
+}
+
+// createWebhookHandler serves POST /webhook, which external automation, such
+// as a release controller notification or a Prow job, calls to have a stream
+// analyzed right away instead of at the next poll.  Requests must carry the
+// webhook token as a bearer token; the endpoint is disabled when no token is
+// configured.  The analysis runs after the response.
+func (o *options) createWebhookHandler(ctx context.Context, webhookToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if webhookToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(webhookToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, AdminError{Error: "unauthorized"})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, AdminError{Error: "method not allowed"})
+			return
+		}
+		event := WebhookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			writeJSON(w, http.StatusBadRequest, AdminError{Error: fmt.Sprintf("error decoding event: %v", err)})
+			return
+		}
+		if !o.isMonitoredStream(event.Stream) {
+			writeJSON(w, http.StatusBadRequest, AdminError{Error: fmt.Sprintf("%q is not a monitored stream", event.Stream)})
+			return
+		}
+		klog.V(2).Infof("webhook event for %s: %s", event.Stream, event.Event)
+		webhookMutex.Lock()
+		inFlight := webhookInFlight[event.Stream]
+		webhookInFlight[event.Stream] = true
+		webhookMutex.Unlock()
+		if !inFlight {
+			goWork(func() {
+				defer func() {
+					webhookMutex.Lock()
+					delete(webhookInFlight, event.Stream)
+					webhookMutex.Unlock()
+				}()
+				o.analyzeStream(ctx, event.Stream)
+			})
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// isMonitoredStream returns true if the stream matches the --streams, if any,
+// and none of the --exclude-streams.  The other limits are applied by the
+// analysis.
+func (o *options) isMonitoredStream(stream string) bool {
+	if stream == "" || strings.ContainsAny(stream, "*?[]/") {
+		return false
+	}
+	o.settingsLock.RLock()
+	limits := o.limits()
+	o.settingsLock.RUnlock()
+	for _, pattern := range limits.ExcludeStreams {
+		if pattern.Match(stream) {
+			return false
+		}
+	}
+	if len(limits.Streams) == 0 {
+		return true
+	}
+	for _, pattern := range limits.Streams {
+		if pattern.Match(stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeStream analyzes the stream alone and, if its state changed since it
+// was last analyzed or since the cached report, alerts its subscribers and
+// the channels its reports are posted to.
+func (o *options) analyzeStream(ctx context.Context, name string) {
+	o.settingsLock.RLock()
+	limits := o.limits()
+	o.settingsLock.RUnlock()
+	// the name is checked by isMonitoredStream
+	pattern, _ := releasewatch.ParseStreamPattern(name)
+	limits.Streams = []releasewatch.StreamPattern{pattern}
+	report, err := o.watcher.GenerateReportWithLimits(ctx, limits)
+	if err != nil {
+		if ctx.Err() == nil {
+			klog.Errorf("error analyzing %s for a webhook event: %v", name, err)
+		}
+		return
+	}
+	// acks aren't applied, as they would be forgotten for the streams left
+	// out, so the changes are those the subscribers get
+	var current releasewatch.StreamReport
+	found := false
+	for _, stream := range report.Streams {
+		if stream.Name == name {
+			current, found = stream, true
+			break
+		}
+	}
+	if !found {
+		klog.V(2).Infof("%s isn't monitored, not alerting on its webhook event", name)
+		return
+	}
+
+	before, ok := o.previousStreamState(name)
+	if ok && len(streamChanges(before, current)) > 0 && o.alertHeld(name, before, current, time.Now()) {
+		// the state it was last alerted about in is kept
+		return
+	}
+	webhookMutex.Lock()
+	webhookStates[name] = webhookState{Stream: current, AnalyzedAt: time.Now()}
+	webhookMutex.Unlock()
+	if !ok {
+		return
+	}
+	changes := streamChanges(before, current)
+	if len(changes) == 0 {
+		return
+	}
+	if _, _, quiet := o.quietPeriod(time.Now()); quiet || !o.isLeader() {
+		return
+	}
+	o.notifySubscribers(map[string]releasewatch.StreamReport{name: before}, map[string]releasewatch.StreamReport{name: current})
+	text := fmt.Sprintf("<%s|%s> changed:\n%s", current.URL(), name, strings.Join(changes, "\n"))
+	channels := []string{}
+	for channel, routed := range o.routeReport(report) {
+		if len(routed.Streams) > 0 {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		if _, err := postMessage(PostMessage{Channel: channel, Text: text}); err != nil {
+			klog.Errorf("error posting the change of %s to %s: %v", name, channel, err)
+		}
+	}
+	o.recordAlerts([]string{name}, time.Now())
+}
+
+// previousStreamState returns the stream as of the newer of its last
+// webhook analysis and the cached report, or false if it is in neither.
+func (o *options) previousStreamState(name string) (releasewatch.StreamReport, bool) {
+	webhookMutex.Lock()
+	previous, ok := webhookStates[name]
+	webhookMutex.Unlock()
+
+	reportCacheMutex.Lock()
+	report, cachedAt := cachedReport, reportCachedAt
+	reportCacheMutex.Unlock()
+	if report == nil || (ok && previous.AnalyzedAt.After(cachedAt)) {
+		return previous.Stream, ok
+	}
+	for _, stream := range report.Streams {
+		if stream.Name == name {
+			return stream, true
+		}
+	}
+	return previous.Stream, ok
+}
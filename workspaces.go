@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bparees/release-watcher/pkg/releasewatch"
+	"k8s.io/klog"
+)
+
+// Workspace is another Slack workspace, such as a partner-facing one, that
+// the scheduled report is posted to with a bot token and mentions of its own.
+type Workspace struct {
+	Name string `json:"name"`
+	// TokenEnv is the environment variable holding the bot token of the
+	// workspace, so the token is kept out of the config file.
+	TokenEnv string   `json:"token-env"`
+	Channels []string `json:"channels"`
+	// SlackAlias and SlackAliasMap are who to tag in the workspace, as
+	// --slack-alias and --slack-alias-map are for the bot's own workspace.
+	SlackAlias    string            `json:"slack-alias,omitempty"`
+	SlackAliasMap map[string]string `json:"slack-alias-map,omitempty"`
+}
+
+func validateWorkspaces(workspaces []Workspace) error {
+	names := map[string]bool{}
+	for i, workspace := range workspaces {
+		if workspace.Name == "" {
+			return fmt.Errorf("workspace %d must have a name", i)
+		}
+		if names[workspace.Name] {
+			return fmt.Errorf("workspace %q is given more than once", workspace.Name)
+		}
+		names[workspace.Name] = true
+		if len(workspace.Channels) == 0 {
+			return fmt.Errorf("workspace %q must have channels", workspace.Name)
+		}
+		if workspace.TokenEnv == "" {
+			return fmt.Errorf("workspace %q must have a token-env", workspace.Name)
+		}
+		if os.Getenv(workspace.TokenEnv) == "" {
+			return fmt.Errorf("workspace %q uses the token in %s, but it is not set", workspace.Name, workspace.TokenEnv)
+		}
+		if err := validateAliasMap(workspace.SlackAliasMap); err != nil {
+			return fmt.Errorf("invalid slack-alias-map of workspace %q: %v", workspace.Name, err)
+		}
+	}
+	return nil
+}
+
+// currentWorkspaces returns a copy of the workspaces of the config file.
+func currentWorkspaces() []Workspace {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]Workspace{}, configWorkspaces...)
+}
+
+// postWorkspaceReports posts the report to the channels of every other
+// workspace, tagging the owners of the streams in that workspace.  The
+// messages are posted anew every time, since only the bot's own workspace
+// has its posted reports tracked for updating, pinning and acknowledging.
+func (o *options) postWorkspaceReports(report *releasewatch.Report) {
+	for _, workspace := range currentWorkspaces() {
+		token := os.Getenv(workspace.TokenEnv)
+		mentions := ownerMentions(report, workspace.SlackAliasMap, workspace.SlackAlias)
+		for _, channel := range workspace.Channels {
+			if _, err := o.postReportWithToken(token, channel, report, mentions); err != nil {
+				klog.Errorf("error posting scheduled report to %s in workspace %s: %v", channel, workspace.Name, err)
+			}
+		}
+	}
+}